@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"io"
 	golog "log"
@@ -15,13 +16,19 @@ import (
 	log "github.com/sirupsen/logrus"
 	"gopkg.in/natefinch/lumberjack.v2"
 
+	"github.com/weeveiot/weeve-agent/internal/api"
+	"github.com/weeveiot/weeve-agent/internal/cli"
 	"github.com/weeveiot/weeve-agent/internal/com"
 	"github.com/weeveiot/weeve-agent/internal/config"
+	"github.com/weeveiot/weeve-agent/internal/containerd"
 	"github.com/weeveiot/weeve-agent/internal/docker"
 	"github.com/weeveiot/weeve-agent/internal/edgeapp"
 	"github.com/weeveiot/weeve-agent/internal/handler"
 	"github.com/weeveiot/weeve-agent/internal/manifest"
+	"github.com/weeveiot/weeve-agent/internal/metrics"
 	"github.com/weeveiot/weeve-agent/internal/model"
+	"github.com/weeveiot/weeve-agent/internal/podman"
+	"github.com/weeveiot/weeve-agent/internal/runtime"
 	"github.com/weeveiot/weeve-agent/internal/secret"
 )
 
@@ -56,7 +63,19 @@ func main() {
 		log.Fatal("Initialization of node keypair failed! CAUSE --> ", err)
 	}
 
-	docker.SetupDockerClient()
+	containerRuntime, err := newContainerRuntime()
+	if err != nil {
+		log.Fatal("Container runtime initialization failed! CAUSE --> ", err)
+	}
+	edgeapp.SetRuntime(containerRuntime)
+
+	if config.Params.MetricsAddr != "" {
+		err := metrics.Register(edgeapp.StatusCollector())
+		if err != nil {
+			log.Fatal("Registering the edge app metrics failed! CAUSE --> ", err)
+		}
+		go serveMetrics()
+	}
 
 	if localManifest != "" {
 		err := edgeapp.ReadDeployManifestLocal(localManifest)
@@ -92,13 +111,21 @@ func main() {
 	signal.Notify(done, os.Interrupt, syscall.SIGTERM)
 
 	// Start threads to send status messages
+	go reconcileEdgeApps()
 	go monitorEdgeAppStatus()
 	go sendHeartbeat()
-	go sendEdgeAppLogs()
+	go collectEdgeAppLogs()
+	if config.Params.APISocket != "" {
+		go serveAPI()
+	}
 
 	log.Info("Weeve-agent started and running...")
 	// Cleanup on ending the process
 	<-done
+	err = manifest.WriteLogCursors()
+	if err != nil {
+		log.Error("Failed to write the log cursors to file! CAUSE --> ", err)
+	}
 	err = com.DisconnectNode()
 	if err != nil {
 		log.Fatal("Disconnection of node failed! CAUSE --> ", err)
@@ -109,15 +136,23 @@ func parseCLIoptions() (bool, string, bool) {
 	var opt model.Params
 
 	parser := flags.NewParser(&opt, flags.Default)
+	cli.AddCommands(parser, &opt)
 	_, err := parser.Parse()
 	if err != nil {
 		e, ok := err.(*flags.Error)
 		if ok && e.Type == flags.ErrHelp {
 			os.Exit(0)
 		}
+		// a failing subcommand has printed its error already
+		if !ok && parser.Active != nil {
+			os.Exit(1)
+		}
 		parser.WriteHelp(os.Stderr)
 		os.Exit(1)
 	}
+	if parser.Active != nil {
+		os.Exit(0)
+	}
 
 	if opt.Version {
 		fmt.Println("weeve agent -", model.Version)
@@ -165,6 +200,19 @@ func setupLogging(toStdout bool) {
 	log.Info("Logging level set to ", log.GetLevel())
 }
 
+func newContainerRuntime() (runtime.Runtime, error) {
+	log.Info("Using container runtime ", config.Params.Runtime)
+
+	switch config.Params.Runtime {
+	case config.RuntimePodman:
+		return podman.NewRuntime(config.Params.RuntimeSock)
+	case config.RuntimeContainerd:
+		return containerd.NewRuntime(config.Params.RuntimeSock)
+	default:
+		return docker.NewRuntime(config.Params.RuntimeSock)
+	}
+}
+
 func setSubscriptionHandlers() map[string]mqtt.MessageHandler {
 	subscriptions := make(map[string]mqtt.MessageHandler)
 
@@ -178,28 +226,50 @@ func setSubscriptionHandlers() map[string]mqtt.MessageHandler {
 func monitorEdgeAppStatus() {
 	log.Debug("Start monitering edge app status...")
 
-	edgeApps, err := edgeapp.GetEdgeAppStatus()
+	resyncInterval := time.Second * time.Duration(config.Params.StatusResync)
+	for {
+		err := edgeapp.MonitorEdgeAppStatus(context.Background(), resyncInterval, func(edgeApps []com.EdgeAppMsg) {
+			err := edgeapp.SendEdgeAppStatus(edgeApps)
+			if err != nil {
+				log.Error("SendEdgeAppStatus failed! CAUSE --> ", err)
+			}
+		})
+		if err != nil {
+			log.Error("Edge app status monitoring failed! CAUSE --> ", err)
+		}
+
+		time.Sleep(time.Second * time.Duration(5))
+	}
+}
+
+func serveMetrics() {
+	log.Info("Serving metrics on ", config.Params.MetricsAddr)
+
+	err := metrics.Serve(config.Params.MetricsAddr)
 	if err != nil {
-		log.Error("GetEdgeAppStatus failed! CAUSE --> ", err)
+		log.Error("Serving metrics failed! CAUSE --> ", err)
 	}
+}
+
+func serveAPI() {
+	log.Info("Serving the local API on ", config.Params.APISocket)
+
+	err := api.Serve(config.Params.APISocket)
+	if err != nil {
+		log.Error("Serving the local API failed! CAUSE --> ", err)
+	}
+}
+
+func reconcileEdgeApps() {
+	log.Debug("Start reconciling edge apps...")
 
 	for {
-		time.Sleep(time.Second * time.Duration(5))
-		latestEdgeApps, statusChange, err := edgeapp.CompareEdgeAppStatus(edgeApps)
+		err := handler.ReconcileEdgeApps()
 		if err != nil {
-			log.Error("CompareEdgeAppStatus failed! CAUSE --> ", err)
-			continue
+			log.Error("Reconciliation of edge apps failed! CAUSE --> ", err)
 		}
-		log.Debug("Latest edge app status: ", latestEdgeApps)
 
-		if statusChange {
-			err := edgeapp.SendStatus()
-			if err != nil {
-				log.Error("SendStatus failed! CAUSE --> ", err)
-				continue
-			}
-			edgeApps = latestEdgeApps
-		}
+		time.Sleep(time.Second * time.Duration(config.Params.ReconcileInvl))
 	}
 }
 
@@ -216,19 +286,9 @@ func sendHeartbeat() {
 	}
 }
 
-func sendEdgeAppLogs() {
-	log.Debug("Start sending edge app logs...")
+func collectEdgeAppLogs() {
+	log.Debug("Start collecting edge app logs...")
 
-	for {
-		knownManifests := manifest.GetKnownManifests()
-		until := time.Now().UTC().Format(time.RFC3339Nano)
-
-		for _, manif := range knownManifests {
-			if manif.Status != model.EdgeAppUndeployed {
-				edgeapp.SendEdgeAppLogs(*manif, until)
-			}
-		}
-
-		time.Sleep(time.Second * time.Duration(config.Params.LogSendInvl))
-	}
+	maxDelay := time.Second * time.Duration(config.Params.LogSendInvl)
+	edgeapp.CollectEdgeAppLogs(context.Background(), maxDelay, config.Params.LogBatchSize*1024, com.SendEdgeAppLogs)
 }
@@ -0,0 +1,257 @@
+// Package api serves the local control API of the agent on a Unix socket.
+//
+// The API is meant for technicians on site and speaks JSON over HTTP. Commands go through the same command queues
+// as the commands of the manager, so they never race each other. Only the owner of the socket can connect.
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/weeveiot/weeve-agent/internal/config"
+	"github.com/weeveiot/weeve-agent/internal/edgeapp"
+	"github.com/weeveiot/weeve-agent/internal/handler"
+	"github.com/weeveiot/weeve-agent/internal/manifest"
+	"github.com/weeveiot/weeve-agent/internal/model"
+	traceutility "github.com/weeveiot/weeve-agent/internal/utility/trace"
+)
+
+const socketPermissions = 0600
+
+// commands maps the actions of the API to the orchestration commands, deployments are left to the manager
+var commands = map[string]string{
+	"stop":     edgeapp.CMDStop,
+	"resume":   edgeapp.CMDResume,
+	"undeploy": edgeapp.CMDUndeploy,
+	"remove":   edgeapp.CMDRemove,
+}
+
+type errorMsg struct {
+	Error string `json:"error"`
+}
+
+// Serve serves the API on the Unix socket at socketPath. It only returns if the listener fails.
+func Serve(socketPath string) error {
+	// a socket left behind by a previous run would make the listener fail
+	err := os.Remove(socketPath)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return traceutility.Wrap(err)
+	}
+
+	// the socket is created in a private directory and only moved into place once nobody else can connect to it
+	privateDir, err := os.MkdirTemp(filepath.Dir(socketPath), ".weeve-agent-api-")
+	if err != nil {
+		return traceutility.Wrap(err)
+	}
+	defer os.RemoveAll(privateDir)
+	privatePath := filepath.Join(privateDir, "api.sock")
+
+	listener, err := net.Listen("unix", privatePath)
+	if err != nil {
+		return traceutility.Wrap(err)
+	}
+	defer listener.Close()
+	listener.(*net.UnixListener).SetUnlinkOnClose(false)
+
+	err = os.Chmod(privatePath, socketPermissions)
+	if err != nil {
+		return traceutility.Wrap(err)
+	}
+	err = os.Rename(privatePath, socketPath)
+	if err != nil {
+		return traceutility.Wrap(err)
+	}
+	defer os.Remove(socketPath)
+	err = os.Remove(privateDir)
+	if err != nil {
+		return traceutility.Wrap(err)
+	}
+
+	err = http.Serve(listener, Handler())
+	if err != nil {
+		return traceutility.Wrap(err)
+	}
+	return nil
+}
+
+// Handler routes the requests of the API:
+//
+//	GET  /edgeapps                   status of all edge apps
+//	GET  /edgeapps/<id>/manifest     manifest of the edge app, without secrets
+//	GET  /edgeapps/<id>/logs         logs of the modules, optionally ?since=<RFC3339>&until=<RFC3339>, or streamed
+//	                                 with ?follow=1 as one JSON object per line
+//	POST /edgeapps/<id>/<action>     stop, resume, undeploy or remove the edge app
+//	GET  /status                     status message of the node
+//	POST /status                     send the status message to the manager right away
+//	GET  /config                     config of the agent, without passwords
+func Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/edgeapps", handleEdgeApps)
+	mux.HandleFunc("/edgeapps/", handleEdgeApp)
+	mux.HandleFunc("/status", handleStatus)
+	mux.HandleFunc("/config", handleConfig)
+	return mux
+}
+
+func handleEdgeApps(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+		return
+	}
+
+	edgeApps, err := edgeapp.GetEdgeAppStatus()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, edgeApps)
+}
+
+func handleEdgeApp(w http.ResponseWriter, r *http.Request) {
+	id, action, found := strings.Cut(strings.TrimPrefix(r.URL.Path, "/edgeapps/"), "/")
+	if !found || id == "" || action == "" {
+		writeError(w, http.StatusNotFound, errors.New("not found"))
+		return
+	}
+
+	manifestUniqueID := model.ManifestUniqueID{ID: id}
+	record := manifest.GetKnownManifest(manifestUniqueID)
+	if record == nil {
+		writeError(w, http.StatusNotFound, errors.New("edge app "+id+" is not known"))
+		return
+	}
+
+	switch action {
+	case "manifest", "logs":
+		if r.Method != http.MethodGet {
+			writeError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+			return
+		}
+		if action == "manifest" {
+			writeJSON(w, http.StatusOK, manifest.Redact(record.Manifest))
+		} else {
+			handleLogs(w, r, *record)
+		}
+		return
+	}
+
+	command, known := commands[action]
+	if !known {
+		writeError(w, http.StatusNotFound, errors.New("not found"))
+		return
+	}
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+		return
+	}
+
+	log.Info("Received ", command, " of edge app ", id, " on the local API")
+	err := handler.ProcessLocalCommand(command, manifestUniqueID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func handleLogs(w http.ResponseWriter, r *http.Request, record manifest.ManifestRecord) {
+	if r.URL.Query().Get("follow") == "1" {
+		followLogs(w, r, record)
+		return
+	}
+
+	until := r.URL.Query().Get("until")
+	if until == "" {
+		until = time.Now().UTC().Format(time.RFC3339Nano)
+	}
+	since := r.URL.Query().Get("since")
+
+	logs, err := edgeapp.GetEdgeAppLogs(record.Manifest, since, until)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, logs)
+}
+
+// followLogs streams the log messages as one JSON object per line, until the modules stopped or the client went away
+func followLogs(w http.ResponseWriter, r *http.Request, record manifest.ManifestRecord) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, errors.New("streaming is not supported"))
+		return
+	}
+
+	msgs, err := edgeapp.FollowEdgeAppLogs(r.Context(), record.Manifest, r.URL.Query().Get("since"))
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	encoder := json.NewEncoder(w)
+	for msg := range msgs {
+		err = encoder.Encode(msg)
+		if err != nil {
+			log.Debug("Client of the log stream went away! CAUSE --> ", err)
+			return
+		}
+		flusher.Flush()
+	}
+}
+
+func handleStatus(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		msg, err := edgeapp.GetStatusMessage()
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, msg)
+
+	case http.MethodPost:
+		err := edgeapp.SendStatus()
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		writeError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+	}
+}
+
+func handleConfig(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+		return
+	}
+
+	writeJSON(w, http.StatusOK, config.Redact(config.Params))
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	err := json.NewEncoder(w).Encode(body)
+	if err != nil {
+		log.Error("Writing the API response failed! CAUSE --> ", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, errorMsg{Error: err.Error()})
+}
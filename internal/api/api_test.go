@@ -0,0 +1,216 @@
+package api_test
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/weeveiot/weeve-agent/internal/api"
+	"github.com/weeveiot/weeve-agent/internal/com"
+	"github.com/weeveiot/weeve-agent/internal/config"
+	"github.com/weeveiot/weeve-agent/internal/edgeapp"
+	"github.com/weeveiot/weeve-agent/internal/manifest"
+	"github.com/weeveiot/weeve-agent/internal/model"
+	"github.com/weeveiot/weeve-agent/internal/testutil"
+)
+
+func TestMain(m *testing.M) {
+	testutil.Main(m, nil)
+}
+
+func TestEdgeApps(t *testing.T) {
+	assert := assert.New(t)
+	man, _ := testutil.Deploy(t)
+
+	server := httptest.NewServer(api.Handler())
+	defer server.Close()
+
+	var edgeApps []com.EdgeAppMsg
+	resp := request(t, http.MethodGet, server.URL+"/edgeapps", &edgeApps)
+	assert.Equal(http.StatusOK, resp.StatusCode)
+	assert.Len(edgeApps, 1)
+	assert.Equal(man.UniqueID.ID, edgeApps[0].ManifestID)
+	assert.Equal(model.EdgeAppRunning, edgeApps[0].Status)
+
+	var shown manifest.Manifest
+	resp = request(t, http.MethodGet, server.URL+"/edgeapps/"+man.UniqueID.ID+"/manifest", &shown)
+	assert.Equal(http.StatusOK, resp.StatusCode)
+	assert.Equal(man.ManifestName, shown.ManifestName)
+	assert.Len(shown.Modules, len(man.Modules))
+
+	resp = request(t, http.MethodGet, server.URL+"/edgeapps/unknown/manifest", nil)
+	assert.Equal(http.StatusNotFound, resp.StatusCode)
+}
+
+func TestCommands(t *testing.T) {
+	assert := assert.New(t)
+	man, _ := testutil.Deploy(t)
+
+	server := httptest.NewServer(api.Handler())
+	defer server.Close()
+	url := server.URL + "/edgeapps/" + man.UniqueID.ID
+
+	resp := request(t, http.MethodPost, url+"/stop", nil)
+	assert.Equal(http.StatusNoContent, resp.StatusCode)
+	assert.Equal(model.EdgeAppStopped, manifest.GetKnownManifest(man.UniqueID).Status)
+
+	resp = request(t, http.MethodPost, url+"/resume", nil)
+	assert.Equal(http.StatusNoContent, resp.StatusCode)
+	assert.Equal(model.EdgeAppRunning, manifest.GetKnownManifest(man.UniqueID).Status)
+
+	resp = request(t, http.MethodGet, url+"/stop", nil)
+	assert.Equal(http.StatusMethodNotAllowed, resp.StatusCode)
+	resp = request(t, http.MethodPost, url+"/deploy", nil)
+	assert.Equal(http.StatusNotFound, resp.StatusCode)
+
+	resp = request(t, http.MethodPost, url+"/remove", nil)
+	assert.Equal(http.StatusNoContent, resp.StatusCode)
+	assert.Nil(manifest.GetKnownManifest(man.UniqueID))
+}
+
+func TestLogs(t *testing.T) {
+	assert := assert.New(t)
+	man, _ := testutil.Deploy(t)
+
+	server := httptest.NewServer(api.Handler())
+	defer server.Close()
+
+	var logs []com.EdgeAppLogMsg
+	resp := request(t, http.MethodGet, server.URL+"/edgeapps/"+man.UniqueID.ID+"/logs?since=", &logs)
+	assert.Equal(http.StatusOK, resp.StatusCode)
+}
+
+func TestLogsFollow(t *testing.T) {
+	assert := assert.New(t)
+	man, rt := testutil.Deploy(t)
+	containers, err := rt.ReadEdgeAppContainers(man.UniqueID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	containerID := containers[0].ID
+	err = rt.AppendLogs(containerID, "before")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := httptest.NewServer(api.Handler())
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/edgeapps/" + man.UniqueID.ID + "/logs?follow=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	assert.Equal(http.StatusOK, resp.StatusCode)
+	assert.Equal("application/x-ndjson", resp.Header.Get("Content-Type"))
+
+	decoder := json.NewDecoder(resp.Body)
+	var logMsg com.EdgeAppLogMsg
+	assert.NoError(decoder.Decode(&logMsg))
+	assert.Equal("before", logMsg.Message)
+
+	// the lines written later are streamed as well
+	err = rt.AppendLogs(containerID, "after")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.NoError(decoder.Decode(&logMsg))
+	assert.Equal("after", logMsg.Message)
+	assert.Equal(containerID, logMsg.ContainerID)
+
+	// the stream ends once all modules stopped
+	err = edgeapp.StopEdgeApp(man.UniqueID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for err == nil {
+		err = decoder.Decode(&logMsg)
+	}
+	assert.Equal(io.EOF, err)
+}
+
+func TestServe(t *testing.T) {
+	assert := assert.New(t)
+	testutil.Deploy(t)
+
+	socketPath := filepath.Join(t.TempDir(), "agent.sock")
+	go api.Serve(socketPath)
+
+	var info os.FileInfo
+	assert.Eventually(func() bool {
+		var err error
+		info, err = os.Stat(socketPath)
+		return err == nil
+	}, time.Second, 10*time.Millisecond)
+	// the socket never shows with the permissions of the umask
+	assert.Equal(os.FileMode(0600), info.Mode().Perm())
+	entries, err := os.ReadDir(filepath.Dir(socketPath))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Len(entries, 1)
+
+	client := http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", socketPath)
+		},
+	}}
+	resp, err := client.Get("http://agent/edgeapps")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	assert.Equal(http.StatusOK, resp.StatusCode)
+}
+
+func request(t *testing.T, method string, url string, body interface{}) *http.Response {
+	req, err := http.NewRequest(method, url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if body != nil {
+		err = json.NewDecoder(resp.Body).Decode(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return resp
+}
+
+func TestStatusAndConfig(t *testing.T) {
+	assert := assert.New(t)
+	man, _ := testutil.Deploy(t)
+	config.Params.ClientKeyPassword = "secret"
+	defer func() { config.Params.ClientKeyPassword = "" }()
+
+	server := httptest.NewServer(api.Handler())
+	defer server.Close()
+
+	var status com.StatusMsg
+	resp := request(t, http.MethodGet, server.URL+"/status", &status)
+	assert.Equal(http.StatusOK, resp.StatusCode)
+	assert.Equal(model.Version, status.AgentVersion)
+	assert.Len(status.EdgeApplications, 1)
+	assert.Equal(man.UniqueID.ID, status.EdgeApplications[0].ManifestID)
+
+	var params config.ParamStruct
+	resp = request(t, http.MethodGet, server.URL+"/config", &params)
+	assert.Equal(http.StatusOK, resp.StatusCode)
+	assert.Equal("REDACTED", params.ClientKeyPassword)
+	assert.Empty(params.Password)
+}
@@ -0,0 +1,272 @@
+// Package cli implements the subcommands of the agent binary to inspect and control the running agent.
+//
+// Except for the manifest validation, the subcommands talk to the local API of the running agent, so they never
+// start a second MQTT client or touch the container runtime themselves.
+package cli
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/jessevdk/go-flags"
+
+	"github.com/weeveiot/weeve-agent/internal/com"
+	"github.com/weeveiot/weeve-agent/internal/config"
+	"github.com/weeveiot/weeve-agent/internal/manifest"
+	"github.com/weeveiot/weeve-agent/internal/model"
+)
+
+var output io.Writer = os.Stdout
+
+// dryRunNetworkName stands in for the name of the network of the edge app, which the runtime assigns on deployment
+const dryRunNetworkName = "dryrun_000"
+
+// SetOutput sets where the subcommands print to, stdout by default
+func SetOutput(w io.Writer) {
+	output = w
+}
+
+// commands share the options of the agent, so that e.g. --apisocket and --config work for them as well
+type commands struct {
+	opt *model.Params
+}
+
+func (c commands) client() (*client, error) {
+	socketPath := c.opt.APISocket
+	if socketPath == "" && c.opt.ConfigPath != "" {
+		// the agent may be started with the socket in its config file
+		params, err := config.ReadFile(c.opt.ConfigPath)
+		if err != nil {
+			return nil, err
+		}
+		socketPath = params.APISocket
+	}
+	if socketPath == "" {
+		socketPath = config.Params.APISocket
+	}
+	if socketPath == "" {
+		return nil, errors.New("the local API is off, give the --apisocket the agent serves it on")
+	}
+	return newClient(socketPath), nil
+}
+
+type edgeAppArgs struct {
+	Args struct {
+		ID string `positional-arg-name:"id" description:"Manifest ID of the edge app"`
+	} `positional-args:"yes" required:"yes"`
+}
+
+type statusCommand struct {
+	commands
+}
+
+type appsListCommand struct {
+	commands
+}
+
+type appsLogsCommand struct {
+	commands
+	edgeAppArgs
+	Follow bool   `long:"follow" short:"f" description:"Keep printing new log lines"`
+	Since  string `long:"since" description:"Only print log lines since this time (RFC 3339)"`
+}
+
+type appsActionCommand struct {
+	commands
+	edgeAppArgs
+	action string
+}
+
+type manifestValidateCommand struct {
+	DryRun bool `long:"dryrun" description:"Print the configs of the containers that would be created"`
+	Args   struct {
+		File string `positional-arg-name:"file" description:"Path to the .json manifest file"`
+	} `positional-args:"yes" required:"yes"`
+}
+
+type configShowCommand struct {
+	commands
+}
+
+// AddCommands adds the subcommands to the parser of the agent. Without a subcommand the agent starts as usual.
+func AddCommands(parser *flags.Parser, opt *model.Params) {
+	parser.SubcommandsOptional = true
+	c := commands{opt: opt}
+
+	mustAddCommand(parser.AddCommand("status", "Show the status of the node", "", &statusCommand{c}))
+
+	apps := mustAddCommand(parser.AddCommand("apps", "Inspect and control the edge apps", "", &struct{}{}))
+	mustAddCommand(apps.AddCommand("list", "List the edge apps and their status", "", &appsListCommand{c}))
+	mustAddCommand(apps.AddCommand("logs", "Print the logs of the modules of an edge app", "", &appsLogsCommand{commands: c}))
+	for _, action := range []string{"stop", "resume", "undeploy", "remove"} {
+		description := strings.ToUpper(action[:1]) + action[1:] + " an edge app"
+		mustAddCommand(apps.AddCommand(action, description, "", &appsActionCommand{commands: c, action: action}))
+	}
+
+	manif := mustAddCommand(parser.AddCommand("manifest", "Work with manifest files", "", &struct{}{}))
+	mustAddCommand(manif.AddCommand("validate", "Check a manifest file without deploying it", "", &manifestValidateCommand{}))
+
+	conf := mustAddCommand(parser.AddCommand("config", "Inspect the config of the agent", "", &struct{}{}))
+	mustAddCommand(conf.AddCommand("show", "Show the config the agent runs with", "", &configShowCommand{c}))
+}
+
+// mustAddCommand panics on errors, which can only be programming errors in the definitions of the commands
+func mustAddCommand(command *flags.Command, err error) *flags.Command {
+	if err != nil {
+		panic(err)
+	}
+	return command
+}
+
+func (c *statusCommand) Execute(args []string) error {
+	client, err := c.client()
+	if err != nil {
+		return err
+	}
+	var msg com.StatusMsg
+	err = client.get("/status", &msg)
+	if err != nil {
+		return err
+	}
+	return printJSON(msg)
+}
+
+func (c *appsListCommand) Execute(args []string) error {
+	client, err := c.client()
+	if err != nil {
+		return err
+	}
+	var edgeApps []com.EdgeAppMsg
+	err = client.get("/edgeapps", &edgeApps)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(output, 0, 0, 3, ' ', 0)
+	fmt.Fprintln(w, "ID\tSTATUS\tMODULES RUNNING")
+	for _, edgeApp := range edgeApps {
+		running := 0
+		for _, container := range edgeApp.Containers {
+			if container.Status == model.ModuleRunning {
+				running++
+			}
+		}
+		fmt.Fprintf(w, "%s\t%s\t%d/%d\n", edgeApp.ManifestID, edgeApp.Status, running, len(edgeApp.Containers))
+	}
+	return w.Flush()
+}
+
+func (c *appsLogsCommand) Execute(args []string) error {
+	client, err := c.client()
+	if err != nil {
+		return err
+	}
+	path := "/edgeapps/" + url.PathEscape(c.Args.ID) + "/logs?"
+
+	if !c.Follow {
+		var logs []com.EdgeAppLogMsg
+		err = client.get(path+url.Values{"since": {c.Since}}.Encode(), &logs)
+		if err != nil {
+			return err
+		}
+		for _, logMsg := range logs {
+			printLog(logMsg)
+		}
+		return nil
+	}
+
+	// the agent streams the messages until the modules stopped
+	body, err := client.stream(path + url.Values{"since": {c.Since}, "follow": {"1"}}.Encode())
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	decoder := json.NewDecoder(body)
+	for {
+		var logMsg com.EdgeAppLogMsg
+		err = decoder.Decode(&logMsg)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		printLog(logMsg)
+	}
+}
+
+func (c *appsActionCommand) Execute(args []string) error {
+	client, err := c.client()
+	if err != nil {
+		return err
+	}
+	err = client.post("/edgeapps/" + url.PathEscape(c.Args.ID) + "/" + c.action)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(output, "Edge app %s: %s done\n", c.Args.ID, c.action)
+	return nil
+}
+
+func (c *manifestValidateCommand) Execute(args []string) error {
+	payload, err := os.ReadFile(c.Args.File)
+	if err != nil {
+		return err
+	}
+	man, warnings, err := manifest.Validate(payload)
+	for _, warning := range warnings {
+		fmt.Fprintf(output, "warning: %s: %s\n", warning.Path, warning.Message)
+	}
+	var validationErr *manifest.ValidationError
+	if errors.As(err, &validationErr) {
+		for _, problem := range validationErr.Problems {
+			if problem.Path == "" {
+				fmt.Fprintln(output, problem.Message)
+			} else {
+				fmt.Fprintf(output, "%s: %s\n", problem.Path, problem.Message)
+			}
+		}
+		return fmt.Errorf("manifest %s has %d problem(s)", c.Args.File, len(validationErr.Problems))
+	}
+	if err != nil {
+		return err
+	}
+
+	if c.DryRun {
+		man.UpdateManifest(dryRunNetworkName)
+		return printJSON(manifest.Redact(man).Modules)
+	}
+	fmt.Fprintf(output, "Manifest %s is valid\n", c.Args.File)
+	return nil
+}
+
+func (c *configShowCommand) Execute(args []string) error {
+	client, err := c.client()
+	if err != nil {
+		return err
+	}
+	var params config.ParamStruct
+	err = client.get("/config", &params)
+	if err != nil {
+		return err
+	}
+	return printJSON(params)
+}
+
+func printLog(logMsg com.EdgeAppLogMsg) {
+	fmt.Fprintf(output, "%s %s %s %s\n", logMsg.Time.Format(time.RFC3339), logMsg.ModuleName, logMsg.Level, logMsg.Message)
+}
+
+func printJSON(body interface{}) error {
+	encoder := json.NewEncoder(output)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(body)
+}
@@ -0,0 +1,171 @@
+package cli_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jessevdk/go-flags"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/weeveiot/weeve-agent/internal/api"
+	"github.com/weeveiot/weeve-agent/internal/cli"
+	"github.com/weeveiot/weeve-agent/internal/config"
+	"github.com/weeveiot/weeve-agent/internal/manifest"
+	"github.com/weeveiot/weeve-agent/internal/model"
+	"github.com/weeveiot/weeve-agent/internal/testutil"
+)
+
+var socketPath string
+
+func TestMain(m *testing.M) {
+	testutil.Main(m, func(workDir string) {
+		socketPath = filepath.Join(workDir, "agent.sock")
+		go api.Serve(socketPath)
+		for i := 0; i < 100; i++ {
+			if _, err := os.Stat(socketPath); err == nil {
+				break
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	})
+}
+
+func TestAppsList(t *testing.T) {
+	assert := assert.New(t)
+	man, _ := testutil.Deploy(t)
+
+	output, err := run("apps", "list")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Contains(output, "ID")
+	assert.Regexp(fmt.Sprintf(`%s +Running +%d/%d`, man.UniqueID.ID, len(man.Modules), len(man.Modules)), output)
+}
+
+func TestAppsActions(t *testing.T) {
+	assert := assert.New(t)
+	man, _ := testutil.Deploy(t)
+
+	output, err := run("apps", "stop", man.UniqueID.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal("Edge app "+man.UniqueID.ID+": stop done\n", output)
+	assert.Equal(model.EdgeAppStopped, manifest.GetKnownManifest(man.UniqueID).Status)
+
+	_, err = run("apps", "resume", man.UniqueID.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(model.EdgeAppRunning, manifest.GetKnownManifest(man.UniqueID).Status)
+
+	_, err = run("apps", "stop", "unknown")
+	assert.EqualError(err, "edge app unknown is not known")
+}
+
+func TestAppsLogsFollow(t *testing.T) {
+	assert := assert.New(t)
+	man, rt := testutil.Deploy(t)
+	containers, err := rt.ReadEdgeAppContainers(man.UniqueID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = rt.AppendLogs(containers[0].ID, "first", "second")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = run("apps", "stop", man.UniqueID.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the agent ends the stream once the modules stopped
+	output, err := run("apps", "logs", "--follow", man.UniqueID.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Regexp(" first\n.* second\n$", output)
+}
+
+func TestConfigFile(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "config.json")
+	err := os.WriteFile(configPath, []byte(fmt.Sprintf(`{"APISocket": %q}`, socketPath)), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = run("--apisocket", "", "--config", configPath, "status")
+	assert.NoError(t, err)
+}
+
+func TestConfigShow(t *testing.T) {
+	assert := assert.New(t)
+	config.Params.Password = "secret"
+	defer func() { config.Params.Password = "" }()
+
+	output, err := run("config", "show")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Contains(output, `"Password": "REDACTED"`)
+	assert.NotContains(output, "secret")
+}
+
+func TestManifestValidate(t *testing.T) {
+	assert := assert.New(t)
+
+	output, err := run("manifest", "validate", filepath.Join(testutil.TestdataDir, "test_manifest.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Contains(output, "is valid")
+
+	output, err = run("manifest", "validate", filepath.Join(testutil.TestdataDir, "unittests", "failManyProblems.json"))
+	assert.ErrorContains(err, "has 6 problem(s)")
+	assert.Contains(output, "modules[1].ports[0].host: host port 1883 is already bound by modules[0].ports[0]\n")
+
+	output, err = run("manifest", "validate", "--dryrun", filepath.Join(testutil.TestdataDir, "test_manifest.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var modules []manifest.ContainerConfig
+	err = json.Unmarshal([]byte(output), &modules)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Len(modules, 4)
+	assert.Equal("weevenetwork/mqtt-ingress:v1.0.0", modules[0].ImageNameFull)
+	// the containers are wired up like on deployment
+	assert.Equal("dryrun_000", modules[0].NetworkName)
+	assert.NotEmpty(modules[0].ContainerName)
+	assert.Contains(modules[0].EnvArgs, "INGRESS_HOST="+modules[0].ContainerName)
+	assert.Contains(modules[0].EnvArgs, "EGRESS_URLS=http://"+modules[1].ContainerName+":80/")
+}
+
+func TestNoAgent(t *testing.T) {
+	_, err := run("--apisocket", filepath.Join(t.TempDir(), "missing.sock"), "status")
+	assert.ErrorContains(t, err, "is the agent running?")
+}
+
+func TestNoAPISocket(t *testing.T) {
+	// the local API is off by default
+	_, err := run("--apisocket", "", "status")
+	assert.ErrorContains(t, err, "the local API is off")
+}
+
+// run parses the args like the agent does and returns what the subcommand printed
+func run(args ...string) (string, error) {
+	var output bytes.Buffer
+	cli.SetOutput(&output)
+
+	opt := model.Params{APISocket: socketPath}
+	parser := flags.NewParser(&opt, flags.HelpFlag)
+	cli.AddCommands(parser, &opt)
+	_, err := parser.ParseArgs(args)
+	return output.String(), err
+}
@@ -0,0 +1,90 @@
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+
+	traceutility "github.com/weeveiot/weeve-agent/internal/utility/trace"
+)
+
+// the host is ignored, the requests always go to the socket
+const apiURL = "http://agent"
+
+// client talks to the local API of the running agent
+type client struct {
+	http http.Client
+}
+
+type errorMsg struct {
+	Error string `json:"error"`
+}
+
+func newClient(socketPath string) *client {
+	return &client{http: http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", socketPath)
+		},
+	}}}
+}
+
+// get decodes the response to path into body
+func (c *client) get(path string, body interface{}) error {
+	return c.do(http.MethodGet, path, body)
+}
+
+func (c *client) post(path string) error {
+	return c.do(http.MethodPost, path, nil)
+}
+
+// stream returns the body of the response to path, which the agent keeps writing to
+func (c *client) stream(path string) (io.ReadCloser, error) {
+	resp, err := c.send(http.MethodGet, path)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+func (c *client) do(method string, path string, body interface{}) error {
+	resp, err := c.send(method, path)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if body != nil {
+		err = json.NewDecoder(resp.Body).Decode(body)
+		if err != nil {
+			return traceutility.Wrap(err)
+		}
+	}
+	return nil
+}
+
+// send returns the response to the request, or the error the agent responded with
+func (c *client) send(method string, path string) (*http.Response, error) {
+	req, err := http.NewRequest(method, apiURL+path, nil)
+	if err != nil {
+		return nil, traceutility.Wrap(err)
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("is the agent running? %w", err)
+	}
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		defer resp.Body.Close()
+		var msg errorMsg
+		err = json.NewDecoder(resp.Body).Decode(&msg)
+		if err != nil || msg.Error == "" {
+			return nil, fmt.Errorf("agent responded with %s", resp.Status)
+		}
+		return nil, errors.New(msg.Error)
+	}
+	return resp, nil
+}
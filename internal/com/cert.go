@@ -0,0 +1,167 @@
+package com
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"os"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	traceutility "github.com/weeveiot/weeve-agent/internal/utility/trace"
+)
+
+// certExpiryWarning is how long before the expiry of the client certificate the agent starts warning about it
+const certExpiryWarning = 30 * 24 * time.Hour
+
+// CertReloader provides the client certificate for the TLS handshakes with the broker.
+// The certificate files are checked on every handshake and loaded again when they changed,
+// so that a renewed certificate is used from the next (re)connection without restarting the agent.
+type CertReloader struct {
+	certPath    string
+	keyPath     string
+	keyPassword string
+
+	mutex   sync.Mutex
+	cert    *tls.Certificate
+	modTime time.Time
+	expiry  time.Time
+}
+
+var clientCert *CertReloader
+
+func NewCertReloader(certPath string, keyPath string, keyPassword string) (*CertReloader, error) {
+	reloader := &CertReloader{certPath: certPath, keyPath: keyPath, keyPassword: keyPassword}
+
+	err := reloader.reloadIfChanged()
+	if err != nil {
+		return nil, traceutility.Wrap(err)
+	}
+
+	return reloader, nil
+}
+
+// GetClientCertificate implements tls.Config.GetClientCertificate
+func (c *CertReloader) GetClientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	err := c.reloadIfChanged()
+	if err != nil {
+		// keep using the certificate that worked so far
+		mqttLogger.Error("Failed to reload the client certificate! CAUSE --> ", err)
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.cert, nil
+}
+
+func (c *CertReloader) Expiry() time.Time {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.expiry
+}
+
+func (c *CertReloader) reloadIfChanged() error {
+	modTime, err := latestModTime(c.certPath, c.keyPath)
+	if err != nil {
+		return traceutility.Wrap(err)
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.cert != nil && !modTime.After(c.modTime) {
+		return nil
+	}
+
+	cert, err := loadClientCert(c.certPath, c.keyPath, c.keyPassword)
+	if err != nil {
+		return traceutility.Wrap(err)
+	}
+
+	c.cert = cert
+	c.modTime = modTime
+	c.expiry = cert.Leaf.NotAfter
+	log.Infoln("Loaded client certificate", cert.Leaf.Subject, "valid until", c.expiry)
+	if time.Until(c.expiry) < certExpiryWarning {
+		log.Warningln("Client certificate expires on", c.expiry)
+	}
+
+	return nil
+}
+
+// loadClientCert reads a PEM certificate and its key, which may be encrypted with the given password
+func loadClientCert(certPath string, keyPath string, keyPassword string) (*tls.Certificate, error) {
+	certPEM, err := os.ReadFile(certPath)
+	if err != nil {
+		return nil, traceutility.Wrap(err)
+	}
+
+	keyPEM, err := os.ReadFile(keyPath)
+	if err != nil {
+		return nil, traceutility.Wrap(err)
+	}
+
+	keyBlock, _ := pem.Decode(keyPEM)
+	if keyBlock == nil {
+		return nil, errors.New("no PEM data found in the client key")
+	}
+	encrypted := keyBlock.Type == "ENCRYPTED PRIVATE KEY" || x509.IsEncryptedPEMBlock(keyBlock)
+	if encrypted && keyPassword == "" {
+		return nil, errors.New("the client key is encrypted, but no password is given")
+	}
+	switch {
+	case keyBlock.Type == "ENCRYPTED PRIVATE KEY":
+		// PKCS#8 encryption, as written e.g. by `openssl pkcs8 -topk8 -v2 aes256`
+		der, err := decryptPKCS8(keyBlock.Bytes, []byte(keyPassword))
+		if err != nil {
+			return nil, traceutility.Wrap(err)
+		}
+		keyPEM = pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+	case encrypted:
+		// legacy PEM encryption, as written e.g. by `openssl rsa -aes256`. Go deprecated it, as it doesn't detect
+		// tampering, but it is still accepted so that existing keys keep working.
+		log.Warningln("The client key uses the deprecated legacy PEM encryption, convert it with `openssl pkcs8 -topk8 -v2 aes256`")
+		der, err := x509.DecryptPEMBlock(keyBlock, []byte(keyPassword)) //nolint:staticcheck
+		if err != nil {
+			return nil, traceutility.Wrap(err)
+		}
+		keyPEM = pem.EncodeToMemory(&pem.Block{Type: keyBlock.Type, Bytes: der})
+	}
+
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return nil, traceutility.Wrap(err)
+	}
+
+	cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return nil, traceutility.Wrap(err)
+	}
+
+	return &cert, nil
+}
+
+func latestModTime(paths ...string) (time.Time, error) {
+	var latest time.Time
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return time.Time{}, traceutility.Wrap(err)
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
+}
+
+// ClientCertExpiry returns when the client certificate used for the broker expires, if one is configured
+func ClientCertExpiry() (time.Time, bool) {
+	if clientCert == nil {
+		return time.Time{}, false
+	}
+	return clientCert.Expiry(), true
+}
@@ -0,0 +1,138 @@
+package com_test
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/weeveiot/weeve-agent/internal/com"
+)
+
+const keyPassword = "weeve"
+
+// writeClientCert writes a self-signed certificate for the key of the test data that expires at notAfter
+func writeClientCert(t *testing.T, path string, notAfter time.Time) {
+	keyPEM, err := os.ReadFile("../../testdata/unittests/clientKey.pem")
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, _ := pem.Decode(keyPEM)
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer := key.(crypto.Signer)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(notAfter.Unix()),
+		Subject:      pkix.Name{CommonName: "weeve-agent-test"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     notAfter,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, signer.Public(), signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// touch moves the modification time of the file forward, as a file written within the same tick may keep it
+func touch(t *testing.T, path string, modTime time.Time) {
+	err := os.Chtimes(path, modTime, modTime)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCertReloader(t *testing.T) {
+	assert := assert.New(t)
+	certPath := filepath.Join(t.TempDir(), "client.crt")
+	expiry := time.Now().Add(365 * 24 * time.Hour).Truncate(time.Second).UTC()
+	writeClientCert(t, certPath, expiry)
+
+	reloader, err := com.NewCertReloader(certPath, "../../testdata/unittests/clientKey.pem", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(expiry, reloader.Expiry().UTC())
+	cert, err := reloader.GetClientCertificate(nil)
+	assert.NoError(err)
+	assert.Equal(expiry, cert.Leaf.NotAfter.UTC())
+
+	// a renewed certificate is used from the next handshake on
+	renewed := expiry.Add(365 * 24 * time.Hour)
+	writeClientCert(t, certPath, renewed)
+	touch(t, certPath, time.Now().Add(time.Minute))
+
+	cert, err = reloader.GetClientCertificate(nil)
+	assert.NoError(err)
+	assert.Equal(renewed, cert.Leaf.NotAfter.UTC())
+	assert.Equal(renewed, reloader.Expiry().UTC())
+
+	// a broken certificate is logged, the last good one is kept
+	err = os.WriteFile(certPath, []byte("not a certificate"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	touch(t, certPath, time.Now().Add(2*time.Minute))
+
+	cert, err = reloader.GetClientCertificate(nil)
+	assert.NoError(err)
+	assert.Equal(renewed, cert.Leaf.NotAfter.UTC())
+}
+
+func TestCertReloader_Expired(t *testing.T) {
+	assert := assert.New(t)
+	certPath := filepath.Join(t.TempDir(), "client.crt")
+	expiry := time.Now().Add(-time.Minute).Truncate(time.Second).UTC()
+	writeClientCert(t, certPath, expiry)
+
+	// the broker decides whether to accept an expired certificate, the agent reports the expiry
+	reloader, err := com.NewCertReloader(certPath, "../../testdata/unittests/clientKey.pem", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(expiry, reloader.Expiry().UTC())
+	assert.True(reloader.Expiry().Before(time.Now()))
+}
+
+func TestCertReloader_EncryptedKey(t *testing.T) {
+	certPath := filepath.Join(t.TempDir(), "client.crt")
+	writeClientCert(t, certPath, time.Now().Add(time.Hour))
+
+	for _, keyFile := range []string{"clientKeyPKCS8.pem", "clientKeyPKCS8SHA1.pem", "clientKeyLegacy.pem"} {
+		t.Run(keyFile, func(t *testing.T) {
+			assert := assert.New(t)
+			keyPath := filepath.Join("../../testdata/unittests", keyFile)
+
+			reloader, err := com.NewCertReloader(certPath, keyPath, keyPassword)
+			if assert.NoError(err) {
+				cert, err := reloader.GetClientCertificate(nil)
+				assert.NoError(err)
+				assert.NotNil(cert.PrivateKey)
+			}
+
+			_, err = com.NewCertReloader(certPath, keyPath, "wrong")
+			assert.Error(err)
+
+			_, err = com.NewCertReloader(certPath, keyPath, "")
+			if assert.Error(err) {
+				assert.Contains(err.Error(), "no password is given")
+			}
+		})
+	}
+}
@@ -0,0 +1,225 @@
+package com
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/klauspost/compress/zstd"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/weeveiot/weeve-agent/internal/config"
+	traceutility "github.com/weeveiot/weeve-agent/internal/utility/trace"
+)
+
+const (
+	// chunkEnvelopeSize is the room left in a chunk for the fields of the envelope
+	chunkEnvelopeSize = 256
+	// a chunk that fails to publish is tried again a few times with a growing delay, before it is queued in the outbox
+	chunkAttempts   = 3
+	truncatedSuffix = " [truncated]"
+)
+
+var chunkRetryInterval = time.Second
+
+// LogUploadError tells how many of the log messages were sent before an upload failed, the rest weren't
+type LogUploadError struct {
+	Sent int
+	Err  error
+}
+
+func (e *LogUploadError) Error() string {
+	return fmt.Sprintf("%d log messages sent, then: %v", e.Sent, e.Err)
+}
+
+func (e *LogUploadError) Unwrap() error {
+	return e.Err
+}
+
+// SendEdgeAppLogs sends the log messages in chunks of at most logchunksize, compressed with logencoding. A chunk that
+// can't be published is tried again on its own; if it keeps failing, it is queued in the outbox like the chunks after
+// it. Only if a chunk can't be queued either, the upload stops and returns a *LogUploadError.
+func SendEdgeAppLogs(msgs []EdgeAppLogMsg) error {
+	if len(msgs) == 0 {
+		return nil
+	}
+
+	chunks, err := ChunkEdgeAppLogs(msgs, config.Params.LogChunkSize*1024, config.Params.LogEncoding)
+	if err != nil {
+		return traceutility.Wrap(err)
+	}
+
+	edgeAppLogsTopic := topicAppLogs + "/" + config.Params.NodeId
+	sent := 0
+	for _, chunk := range chunks {
+		log.Debugln("Sending edge app logs >>", "Topic:", edgeAppLogsTopic, ">> Upload:", chunk.UploadID, "chunk", chunk.Seq, "of", chunk.Chunks, "with", chunk.Count, "messages")
+
+		err = publishRetrying(edgeAppLogsTopic, chunk, false, 0, chunkAttempts, chunkRetryInterval)
+		if err != nil {
+			return &LogUploadError{Sent: sent, Err: traceutility.Wrap(err)}
+		}
+		sent += chunk.Count
+	}
+
+	return nil
+}
+
+// ChunkEdgeAppLogs splits the log messages into chunks whose JSON has at most maxSize bytes, in order and with the
+// same upload ID. The messages of a chunk are compressed with the encoding, unless that doesn't make them smaller.
+// Messages too long for a chunk of their own are truncated.
+func ChunkEdgeAppLogs(msgs []EdgeAppLogMsg, maxSize int, encoding string) ([]EdgeAppLogsMsg, error) {
+	uploadID, err := newUploadID()
+	if err != nil {
+		return nil, traceutility.Wrap(err)
+	}
+
+	// the messages are joined into JSON arrays with up to maxLogsSize bytes
+	maxLogsSize := maxSize - chunkEnvelopeSize
+	var groups [][]EdgeAppLogMsg
+	var group []EdgeAppLogMsg
+	groupSize := len("[]")
+	for _, msg := range msgs {
+		msg, size := truncateLogMsg(msg, maxLogsSize-len("[]"))
+		if len(group) > 0 && groupSize+len(",")+size > maxLogsSize {
+			groups = append(groups, group)
+			group, groupSize = nil, len("[]")
+		}
+		if len(group) > 0 {
+			groupSize += len(",")
+		}
+		group = append(group, msg)
+		groupSize += size
+	}
+	groups = append(groups, group)
+
+	var chunks []EdgeAppLogsMsg
+	for i, group := range groups {
+		chunk := EdgeAppLogsMsg{UploadID: uploadID, Seq: i + 1, Chunks: len(groups), Count: len(group), Logs: group}
+		if encoding != "" && encoding != config.LogEncodingNone {
+			compressed, err := compressLogs(group, encoding)
+			if err != nil {
+				return nil, traceutility.Wrap(err)
+			}
+			plain, err := json.Marshal(group)
+			if err != nil {
+				return nil, traceutility.Wrap(err)
+			}
+			// base64 makes the compressed logs a third larger
+			if (len(compressed)+2)/3*4 < len(plain) {
+				chunk.ContentEncoding = encoding
+				chunk.Logs = nil
+				chunk.Data = compressed
+			}
+		}
+		chunks = append(chunks, chunk)
+	}
+	return chunks, nil
+}
+
+// Decode returns the log messages of the chunk
+func (m EdgeAppLogsMsg) Decode() ([]EdgeAppLogMsg, error) {
+	if m.ContentEncoding == "" {
+		return m.Logs, nil
+	}
+
+	var reader io.Reader
+	switch m.ContentEncoding {
+	case config.LogEncodingGzip:
+		gzipReader, err := gzip.NewReader(bytes.NewReader(m.Data))
+		if err != nil {
+			return nil, traceutility.Wrap(err)
+		}
+		defer gzipReader.Close()
+		reader = gzipReader
+	case config.LogEncodingZstd:
+		zstdReader, err := zstd.NewReader(bytes.NewReader(m.Data))
+		if err != nil {
+			return nil, traceutility.Wrap(err)
+		}
+		defer zstdReader.Close()
+		reader = zstdReader
+	default:
+		return nil, fmt.Errorf("unknown content encoding %q", m.ContentEncoding)
+	}
+
+	var msgs []EdgeAppLogMsg
+	err := json.NewDecoder(reader).Decode(&msgs)
+	if err != nil {
+		return nil, traceutility.Wrap(err)
+	}
+	return msgs, nil
+}
+
+func compressLogs(msgs []EdgeAppLogMsg, encoding string) ([]byte, error) {
+	var buffer bytes.Buffer
+	var writer io.WriteCloser
+	switch encoding {
+	case config.LogEncodingGzip:
+		writer = gzip.NewWriter(&buffer)
+	case config.LogEncodingZstd:
+		zstdWriter, err := zstd.NewWriter(&buffer)
+		if err != nil {
+			return nil, traceutility.Wrap(err)
+		}
+		writer = zstdWriter
+	default:
+		return nil, fmt.Errorf("unknown content encoding %q", encoding)
+	}
+
+	err := json.NewEncoder(writer).Encode(msgs)
+	if err != nil {
+		writer.Close()
+		return nil, traceutility.Wrap(err)
+	}
+	err = writer.Close()
+	if err != nil {
+		return nil, traceutility.Wrap(err)
+	}
+	return buffer.Bytes(), nil
+}
+
+// truncateLogMsg shortens the message text until the JSON of the message has at most maxSize bytes and returns the size
+func truncateLogMsg(msg EdgeAppLogMsg, maxSize int) (EdgeAppLogMsg, int) {
+	size := jsonSize(msg)
+	for size > maxSize && msg.Message != "" {
+		text := strings.TrimSuffix(msg.Message, truncatedSuffix)
+		// escaping makes the JSON of the text larger than the text, so the text may have to be cut more than once
+		keep := len(text) - (size - maxSize) - len(truncatedSuffix)
+		if keep < 0 {
+			keep = 0
+		}
+		for keep > 0 && !utf8.RuneStart(text[keep]) {
+			keep--
+		}
+		msg.Message = text[:keep] + truncatedSuffix
+		if keep == 0 {
+			msg.Message = ""
+		}
+		size = jsonSize(msg)
+	}
+	return msg, size
+}
+
+func jsonSize(msg EdgeAppLogMsg) int {
+	encoded, err := json.Marshal(msg)
+	if err != nil {
+		return len(msg.Message)
+	}
+	return len(encoded)
+}
+
+func newUploadID() (string, error) {
+	id := make([]byte, 8)
+	_, err := rand.Read(id)
+	if err != nil {
+		return "", traceutility.Wrap(err)
+	}
+	return hex.EncodeToString(id), nil
+}
@@ -0,0 +1,121 @@
+package com_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/weeveiot/weeve-agent/internal/com"
+	"github.com/weeveiot/weeve-agent/internal/config"
+)
+
+func logMsgs(count int) []com.EdgeAppLogMsg {
+	var msgs []com.EdgeAppLogMsg
+	for i := 0; i < count; i++ {
+		msgs = append(msgs, com.EdgeAppLogMsg{
+			ManifestID:  "6389a1d3c4f5e6a7b8c9d0e1",
+			ContainerID: "a1b2c3d4e5f6",
+			ModuleName:  "weevenetwork/mqtt-ingress",
+			Time:        time.Date(2023, 5, 1, 12, 0, i, 0, time.UTC),
+			Stream:      "stdout",
+			Level:       "INFO",
+			Filename:    "main.py",
+			Message:     fmt.Sprintf("received message %d from sensor", i),
+		})
+	}
+	return msgs
+}
+
+func TestChunkEdgeAppLogs(t *testing.T) {
+	msgs := logMsgs(200)
+
+	for _, encoding := range []string{config.LogEncodingNone, config.LogEncodingGzip, config.LogEncodingZstd} {
+		t.Run(encoding, func(t *testing.T) {
+			assert := assert.New(t)
+
+			chunks, err := com.ChunkEdgeAppLogs(msgs, 4096, encoding)
+			if err != nil {
+				t.Fatal(err)
+			}
+			assert.Greater(len(chunks), 1)
+
+			var decoded []com.EdgeAppLogMsg
+			for i, chunk := range chunks {
+				payload, err := json.Marshal(chunk)
+				if err != nil {
+					t.Fatal(err)
+				}
+				assert.LessOrEqual(len(payload), 4096)
+				assert.Equal(chunks[0].UploadID, chunk.UploadID)
+				assert.Equal(i+1, chunk.Seq)
+				assert.Equal(len(chunks), chunk.Chunks)
+				if encoding == config.LogEncodingNone {
+					assert.Empty(chunk.ContentEncoding)
+				} else {
+					assert.Equal(encoding, chunk.ContentEncoding)
+					assert.Nil(chunk.Logs)
+				}
+
+				var received com.EdgeAppLogsMsg
+				err = json.Unmarshal(payload, &received)
+				if err != nil {
+					t.Fatal(err)
+				}
+				logs, err := received.Decode()
+				if err != nil {
+					t.Fatal(err)
+				}
+				assert.Len(logs, chunk.Count)
+				decoded = append(decoded, logs...)
+			}
+			assert.Equal(msgs, decoded)
+		})
+	}
+}
+
+func TestChunkEdgeAppLogs_Truncate(t *testing.T) {
+	assert := assert.New(t)
+	msgs := logMsgs(3)
+	msgs[1].Message = strings.Repeat("ü", 4096)
+
+	chunks, err := com.ChunkEdgeAppLogs(msgs, 2048, config.LogEncodingNone)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var decoded []com.EdgeAppLogMsg
+	for _, chunk := range chunks {
+		payload, err := json.Marshal(chunk)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.LessOrEqual(len(payload), 2048)
+		decoded = append(decoded, chunk.Logs...)
+	}
+	if !assert.Len(decoded, 3) {
+		return
+	}
+	assert.Equal(msgs[0], decoded[0])
+	assert.Equal(msgs[2], decoded[2])
+	assert.True(strings.HasPrefix(decoded[1].Message, "üü"))
+	assert.True(strings.HasSuffix(decoded[1].Message, " [truncated]"))
+}
+
+func TestChunkEdgeAppLogs_Incompressible(t *testing.T) {
+	assert := assert.New(t)
+
+	// compressing a single short message doesn't make it smaller, it is sent plain
+	chunks, err := com.ChunkEdgeAppLogs(logMsgs(1), 4096, config.LogEncodingGzip)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !assert.Len(chunks, 1) {
+		return
+	}
+	assert.Empty(chunks[0].ContentEncoding)
+	assert.Len(chunks[0].Logs, 1)
+}
@@ -4,14 +4,18 @@ import (
 	"crypto/tls"
 	"crypto/x509"
 	"encoding/json"
+	"errors"
 	"io"
 	"os"
+	"strings"
 	"time"
 
 	mqtt "github.com/eclipse/paho.mqtt.golang"
 	log "github.com/sirupsen/logrus"
 
 	"github.com/weeveiot/weeve-agent/internal/config"
+	"github.com/weeveiot/weeve-agent/internal/metrics"
+	"github.com/weeveiot/weeve-agent/internal/outbox"
 	traceutility "github.com/weeveiot/weeve-agent/internal/utility/trace"
 )
 
@@ -21,13 +25,17 @@ const (
 	topicAgentLogs     = "agentlogs"
 	topicAppLogs       = "applogs"
 	topicNodePublicKey = "nodePublicKey"
+	topicCommandStatus = "commandstatus"
 	TopicOrgPrivateKey = "orgKey"
 	TopicNodeDelete    = "delete"
 )
 
+const publishTimeout = time.Second
+
 var mqttLogger log.Logger
 var client mqtt.Client
 var subscriptionsMap map[string]mqtt.MessageHandler
+var messageOutbox *outbox.Outbox
 
 func SendHeartbeat(msg StatusMsg) error {
 	nodeStatusTopic := topicNodeStatus + "/" + config.Params.NodeId
@@ -35,14 +43,18 @@ func SendHeartbeat(msg StatusMsg) error {
 	return publishMessage(nodeStatusTopic, msg, true, 0)
 }
 
-func SendEdgeAppLogs(msg []EdgeAppLogMsg) error {
-	if len(msg) > 0 {
-		edgeAppLogsTopic := topicAppLogs + "/" + config.Params.NodeId
-		log.Debugln("Sending edge app logs >>", "Topic:", edgeAppLogsTopic, ">> Body:", msg)
-		return publishMessage(edgeAppLogsTopic, msg, false, 0)
-	}
+func SendCommandAck(msg CommandAckMsg) error {
+	topic := topicCommandStatus + "/" + config.Params.NodeId
+	msg.Stage = CommandStageAck
+	log.Debugln("Sending command ack >>", "Topic:", topic, ">> Body:", msg)
+	return publishMessage(topic, msg, false, 1)
+}
 
-	return nil
+func SendCommandResult(msg CommandResultMsg) error {
+	topic := topicCommandStatus + "/" + config.Params.NodeId
+	msg.Stage = CommandStageResult
+	log.Debugln("Sending command result >>", "Topic:", topic, ">> Body:", msg)
+	return publishMessage(topic, msg, false, 1)
 }
 
 func SendNodePublicKey(nodePublicKey []byte) error {
@@ -58,7 +70,13 @@ func sendDisconnectedStatus() error {
 	nodeStatusTopic := topicNodeStatus + "/" + config.Params.NodeId
 	msg := disconnectedMsg
 	log.Debugln("Sending update >>", "Topic:", nodeStatusTopic, ">> Body:", msg)
-	return publishMessage(nodeStatusTopic, msg, true, 1)
+
+	// sent right before disconnecting, so it must not wait in the outbox behind other messages
+	payload, err := json.Marshal(msg)
+	if err != nil {
+		return traceutility.Wrap(err)
+	}
+	return publishNow(outbox.Message{Topic: nodeStatusTopic, Payload: payload, Retained: true, Qos: 1})
 }
 
 func ConnectNode(subscriptions map[string]mqtt.MessageHandler) error {
@@ -66,7 +84,12 @@ func ConnectNode(subscriptions map[string]mqtt.MessageHandler) error {
 
 	subscriptionsMap = subscriptions
 
-	err := createMqttClient()
+	err := openOutbox()
+	if err != nil {
+		return traceutility.Wrap(err)
+	}
+
+	err = createMqttClient()
 	if err != nil {
 		return traceutility.Wrap(err)
 	}
@@ -83,6 +106,7 @@ func DisconnectNode() error {
 			return traceutility.Wrap(err)
 		}
 		client.Disconnect(250)
+		metrics.SetMQTTConnected(false)
 		log.Debug("MQTT client disconnected")
 	}
 	return nil
@@ -139,10 +163,12 @@ func subscribeAndSetHandler(topic string, handler mqtt.MessageHandler) error {
 
 var connectLostHandler mqtt.ConnectionLostHandler = func(client mqtt.Client, err error) {
 	mqttLogger.Warning("Connection lost. Error: ", err)
+	metrics.SetMQTTConnected(false)
 }
 
 var onConnectHandler mqtt.OnConnectHandler = func(client mqtt.Client) {
 	log.Debug("MQTT client is (re)connected")
+	metrics.SetMQTTConnected(true)
 
 	for topic, handler := range subscriptionsMap {
 		err := subscribeAndSetHandler(topic, handler)
@@ -150,6 +176,8 @@ var onConnectHandler mqtt.OnConnectHandler = func(client mqtt.Client) {
 			log.Error(traceutility.Wrap(err))
 		}
 	}
+
+	go drainOutbox()
 }
 
 func newTLSConfig() (*tls.Config, error) {
@@ -168,26 +196,122 @@ func newTLSConfig() (*tls.Config, error) {
 		ClientAuth: tls.NoClientCert,
 	}
 
+	if config.Params.ClientCertPath != "" {
+		log.Debug("MQTT client cert path >> ", config.Params.ClientCertPath)
+		clientCert, err = NewCertReloader(config.Params.ClientCertPath, config.Params.ClientKeyPath, config.Params.ClientKeyPassword)
+		if err != nil {
+			return nil, traceutility.Wrap(err)
+		}
+		configTLS.GetClientCertificate = clientCert.GetClientCertificate
+	}
+
 	return configTLS, nil
 }
 
+// publishMessage sends the message right away if the node is connected, otherwise the message is queued in the outbox.
+// The message is queued as well if older messages are still waiting in the outbox, so that the order is kept.
 func publishMessage(topic string, message interface{}, retained bool, qos byte) error {
+	return publishRetrying(topic, message, retained, qos, 1, 0)
+}
+
+// publishRetrying is publishMessage, but a message that fails to publish is tried again up to attempts times in total,
+// with a growing delay, before it is queued in the outbox
+func publishRetrying(topic string, message interface{}, retained bool, qos byte, attempts int, retryInterval time.Duration) error {
+	if client == nil {
+		return errors.New("mqtt client is not created")
+	}
+
 	payload, err := json.Marshal(message)
 	if err != nil {
 		return traceutility.Wrap(err)
 	}
+	msg := outbox.Message{Topic: topic, Payload: payload, Retained: retained, Qos: qos}
+
+	if messageOutbox != nil && (!client.IsConnectionOpen() || messageOutbox.Len() > 0) {
+		return queueMessage(msg)
+	}
 
-	if token := client.Publish(topic, qos, retained, payload); token.WaitTimeout(time.Second) {
-		if token.Error() != nil {
-			return traceutility.Wrap(token.Error())
+	err = publishNow(msg)
+	for attempt := 2; err != nil && attempt <= attempts; attempt++ {
+		mqttLogger.Warning("Message not published, trying again! CAUSE --> ", err)
+		time.Sleep(retryInterval)
+		retryInterval *= 2
+		err = publishNow(msg)
+	}
+	if err != nil {
+		if messageOutbox != nil {
+			mqttLogger.Warning("Message not published, queueing it. CAUSE --> ", err)
+			return queueMessage(msg)
 		}
-	} else {
-		mqttLogger.Error("Timeout! Message not published! msg: ", string(payload))
+		return traceutility.Wrap(err)
 	}
 
 	return nil
 }
 
+func publishNow(msg outbox.Message) error {
+	token := client.Publish(msg.Topic, msg.Qos, msg.Retained, msg.Payload)
+	if !token.WaitTimeout(publishTimeout) {
+		metrics.PublishTimeouts.Inc()
+		return errors.New("timeout, message not published")
+	}
+	if token.Error() != nil {
+		return traceutility.Wrap(token.Error())
+	}
+	return nil
+}
+
+func queueMessage(msg outbox.Message) error {
+	err := messageOutbox.Enqueue(msg)
+	if err != nil {
+		return traceutility.Wrap(err)
+	}
+
+	if client.IsConnectionOpen() {
+		go drainOutbox()
+	}
+	return nil
+}
+
+// drainOutbox publishes the queued messages in the order they were queued
+func drainOutbox() {
+	if messageOutbox == nil {
+		return
+	}
+
+	err := messageOutbox.Drain(publishNow)
+	if err != nil {
+		mqttLogger.Warning("Sending queued messages interrupted, retrying on reconnect. CAUSE --> ", err)
+	}
+}
+
+func openOutbox() error {
+	var err error
+	messageOutbox, err = outbox.Open(config.Params.OutboxDir, outbox.Options{
+		MaxBytes: int64(config.Params.OutboxSize) * 1024 * 1024,
+		MaxAge:   time.Hour * time.Duration(config.Params.OutboxAge),
+		Policy:   outboxPolicy,
+	})
+	if err != nil {
+		return traceutility.Wrap(err)
+	}
+	if messageOutbox.Len() > 0 {
+		log.Info("Messages waiting in the outbox: ", messageOutbox.Len())
+	}
+
+	return nil
+}
+
+// outboxPolicy keeps only the latest status and public key of the node, logs are all kept
+func outboxPolicy(topic string) outbox.Policy {
+	switch strings.Split(topic, "/")[0] {
+	case topicNodeStatus, topicNodePublicKey:
+		return outbox.KeepLatest
+	default:
+		return outbox.KeepAll
+	}
+}
+
 func CreateMQTTLogger(out io.Writer, formatter log.Formatter, level log.Level) {
 	mqttLogger = log.Logger{
 		Out:       out,
@@ -0,0 +1,101 @@
+package com
+
+import (
+	"errors"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/weeveiot/weeve-agent/internal/config"
+	"github.com/weeveiot/weeve-agent/internal/outbox"
+)
+
+// fakeClient fails to publish the first failures messages and is disconnected after the last failure, so that the
+// outbox isn't drained. The methods it doesn't implement must not be called.
+type fakeClient struct {
+	mqtt.Client
+	mutex     sync.Mutex
+	failures  int
+	published int
+}
+
+func (c *fakeClient) IsConnectionOpen() bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return c.failures > 0 || c.published > 0
+}
+
+func (c *fakeClient) Publish(topic string, qos byte, retained bool, payload interface{}) mqtt.Token {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.failures > 0 {
+		c.failures--
+		return fakeToken{err: errors.New("broker unavailable")}
+	}
+	c.published++
+	return fakeToken{}
+}
+
+type fakeToken struct {
+	mqtt.Token
+	err error
+}
+
+func (t fakeToken) WaitTimeout(time.Duration) bool {
+	return true
+}
+
+func (t fakeToken) Error() error {
+	return t.err
+}
+
+func setupFakeClient(t *testing.T, failures int) *fakeClient {
+	var err error
+	messageOutbox, err = outbox.Open(t.TempDir(), outbox.Options{Policy: outboxPolicy})
+	if err != nil {
+		t.Fatal(err)
+	}
+	fake := &fakeClient{failures: failures}
+	client = fake
+	CreateMQTTLogger(io.Discard, &log.TextFormatter{}, log.WarnLevel)
+	chunkSize := config.Params.LogChunkSize
+	config.Params.LogChunkSize = 64
+	chunkRetryInterval = time.Millisecond
+
+	t.Cleanup(func() {
+		client = nil
+		messageOutbox = nil
+		config.Params.LogChunkSize = chunkSize
+		chunkRetryInterval = time.Second
+	})
+	return fake
+}
+
+func TestSendEdgeAppLogs_Retry(t *testing.T) {
+	assert := assert.New(t)
+	fake := setupFakeClient(t, chunkAttempts-1)
+
+	err := SendEdgeAppLogs([]EdgeAppLogMsg{{Message: "retried"}})
+	assert.NoError(err)
+	assert.Equal(1, fake.published)
+	assert.Equal(0, messageOutbox.Len())
+}
+
+func TestSendEdgeAppLogs_QueuedAfterRetries(t *testing.T) {
+	assert := assert.New(t)
+	fake := setupFakeClient(t, chunkAttempts)
+
+	// the outbox takes care of the chunk once all attempts failed
+	err := SendEdgeAppLogs([]EdgeAppLogMsg{{Message: "queued"}})
+	assert.NoError(err)
+	assert.Equal(0, fake.failures)
+	assert.Equal(0, fake.published)
+	assert.Equal(1, messageOutbox.Len())
+}
@@ -11,20 +11,86 @@ type EdgeAppLogMsg struct {
 	ContainerID string    `json:"containerID"`
 	ModuleName  string    `json:"moduleName"`
 	Time        time.Time `json:"time"`
+	Stream      string    `json:"stream,omitempty"`
 	Level       string    `json:"level"`
 	Filename    string    `json:"filename"`
 	Message     string    `json:"message"`
 }
 
+// EdgeAppLogsMsg is one chunk of an upload of edge app logs. The chunks of an upload share the upload ID and are
+// numbered from 1. The logs are either plain or, if ContentEncoding is set, a JSON array compressed into Data.
+type EdgeAppLogsMsg struct {
+	UploadID        string          `json:"uploadID"`
+	Seq             int             `json:"seq"`
+	Chunks          int             `json:"chunks"`
+	Count           int             `json:"count"`
+	ContentEncoding string          `json:"contentEncoding,omitempty"`
+	Logs            []EdgeAppLogMsg `json:"logs,omitempty"`
+	Data            []byte          `json:"data,omitempty"`
+}
+
 type ContainerMsg struct {
-	Name   string `json:"name"`
-	Status string `json:"status"`
+	Name    string               `json:"name"`
+	Status  string               `json:"status"`
+	Health  string               `json:"health,omitempty"`
+	Metrics *ContainerMetricsMsg `json:"metrics,omitempty"`
+}
+
+// ContainerMetricsMsg is the resource usage of a container. The network and block IO are totals since the container started.
+type ContainerMetricsMsg struct {
+	CPUPercent   float64 `json:"cpuPercent"`
+	MemoryUsage  uint64  `json:"memoryUsage"`
+	MemoryLimit  uint64  `json:"memoryLimit"`
+	NetworkRx    uint64  `json:"networkRx"`
+	NetworkTx    uint64  `json:"networkTx"`
+	BlockRead    uint64  `json:"blockRead"`
+	BlockWrite   uint64  `json:"blockWrite"`
+	RestartCount int     `json:"restartCount"`
+}
+
+// VolumeMsg is a named volume of an edge app. The size is -1 if the container engine can't tell.
+type VolumeMsg struct {
+	Name string `json:"name"`
+	Size int64  `json:"size"`
 }
 
 type EdgeAppMsg struct {
 	ManifestID string         `json:"manifestID"`
 	Status     string         `json:"status"`
 	Containers []ContainerMsg `json:"containers"`
+	Volumes    []VolumeMsg    `json:"volumes,omitempty"`
+}
+
+const (
+	CommandStageAck    = "ack"
+	CommandStageResult = "result"
+)
+
+type CommandAckMsg struct {
+	Stage         string    `json:"stage"`
+	CorrelationID string    `json:"correlationID,omitempty"`
+	Command       string    `json:"command"`
+	ManifestID    string    `json:"manifestID,omitempty"`
+	ReceivedAt    time.Time `json:"receivedAt"`
+}
+
+type CommandResultMsg struct {
+	Stage         string            `json:"stage"`
+	CorrelationID string            `json:"correlationID,omitempty"`
+	Command       string            `json:"command"`
+	ManifestID    string            `json:"manifestID,omitempty"`
+	Success       bool              `json:"success"`
+	Errors        []string          `json:"errors,omitempty"`
+	StartedAt     time.Time         `json:"startedAt"`
+	DurationMs    int64             `json:"durationMs"`
+	Modules       []ModuleResultMsg `json:"modules,omitempty"`
+}
+
+type ModuleResultMsg struct {
+	Name   string `json:"name"`
+	Image  string `json:"image"`
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
 }
 
 type agentLogMsg struct {
@@ -39,6 +105,7 @@ type StatusMsg struct {
 	DeviceParams     DeviceParamsMsg `json:"deviceParams"`
 	AgentVersion     string          `json:"agentVersion"`
 	OrgKeyHash       string          `json:"orgKeyHash"`
+	ClientCertExpiry *time.Time      `json:"clientCertExpiry,omitempty"`
 }
 
 type DeviceParamsMsg struct {
@@ -5,6 +5,7 @@ import (
 	"net"
 	"net/url"
 	"os"
+	"path/filepath"
 	"strings"
 
 	log "github.com/sirupsen/logrus"
@@ -13,37 +14,87 @@ import (
 )
 
 type ParamStruct struct {
-	Broker       string
-	NodeId       string
-	NodeName     string
-	NoTLS        bool
-	Password     string
-	RootCertPath string
-	LogLevel     string
-	LogFileName  string
-	LogSize      int
-	LogAge       int
-	LogBackup    int
-	LogCompress  bool
-	MqttLogs     bool
-	Heartbeat    int
-	LogSendInvl  int
+	Broker            string
+	NodeId            string
+	NodeName          string
+	NoTLS             bool
+	Password          string
+	RootCertPath      string
+	ClientCertPath    string
+	ClientKeyPath     string
+	ClientKeyPassword string
+	LogLevel          string
+	LogFileName       string
+	LogSize           int
+	LogAge            int
+	LogBackup         int
+	LogCompress       bool
+	MqttLogs          bool
+	Heartbeat         int
+	LogSendInvl       int
+	LogBatchSize      int
+	LogChunkSize      int
+	LogEncoding       string
+	StatusResync      int
+	UpdateMode        string
+	UpdateTimeout     int
+	UpdateSettle      int
+	ReconcileInvl     int
+	OutboxSize        int
+	OutboxAge         int
+	OutboxDir         string
+	Runtime           string
+	RuntimeSock       string
+	MetricsAddr       string
+	APISocket         string
 }
 
+const (
+	RuntimeDocker     = "docker"
+	RuntimePodman     = "podman"
+	RuntimeContainerd = "containerd"
+)
+
+const (
+	UpdateBlueGreen = "bluegreen"
+	UpdateRecreate  = "recreate"
+)
+
+const (
+	LogEncodingNone = "none"
+	LogEncodingGzip = "gzip"
+	LogEncodingZstd = "zstd"
+)
+
+const redacted = "REDACTED"
+
+const defaultOutboxDir = "outbox"
+
 // default values
 var Params = ParamStruct{
-	NoTLS:        false,
-	Password:     "",
-	RootCertPath: "ca.crt",
-	LogLevel:     "info",
-	LogFileName:  "Weeve_Agent.log",
-	LogSize:      1,
-	LogAge:       1,
-	LogBackup:    5,
-	LogCompress:  false,
-	MqttLogs:     false,
-	Heartbeat:    10,
-	LogSendInvl:  60,
+	NoTLS:         false,
+	Password:      "",
+	RootCertPath:  "ca.crt",
+	LogLevel:      "info",
+	LogFileName:   "Weeve_Agent.log",
+	LogSize:       1,
+	LogAge:        1,
+	LogBackup:     5,
+	LogCompress:   false,
+	MqttLogs:      false,
+	Heartbeat:     10,
+	LogSendInvl:   60,
+	LogBatchSize:  64,
+	LogChunkSize:  64,
+	LogEncoding:   LogEncodingNone,
+	StatusResync:  300,
+	UpdateMode:    UpdateRecreate,
+	UpdateTimeout: 120,
+	UpdateSettle:  10,
+	ReconcileInvl: 600,
+	OutboxSize:    10,
+	OutboxAge:     24,
+	Runtime:       RuntimeDocker,
 }
 
 func Set(opt model.Params) {
@@ -53,22 +104,35 @@ func Set(opt model.Params) {
 	}
 	applyCLIparams(opt)
 	validateConfig()
-	log.Infof("Set node config to following params: %+v", Params)
+	log.Infof("Set node config to following params: %+v", Redact(Params))
 }
 
 func readNodeConfigFromFile(configPath string) {
+	params, err := ReadFile(configPath)
+	if err != nil {
+		log.Fatal("Failed to read config file! CAUSE --> ", err)
+	}
+	Params = params
+}
+
+// ReadFile returns the params with the values of the .json config file applied, without validating them
+func ReadFile(configPath string) (ParamStruct, error) {
+	params := Params
+
 	jsonFile, err := os.Open(configPath)
 	if err != nil {
-		log.Fatal("Failed to open config file! CAUSE --> ", err)
+		return params, err
 	}
 	defer jsonFile.Close()
 
 	decoder := json.NewDecoder(jsonFile)
 	decoder.DisallowUnknownFields()
-	err = decoder.Decode(&Params)
+	err = decoder.Decode(&params)
 	if err != nil {
-		log.Fatal("Failed to parse config params! CAUSE --> ", err)
+		return params, err
 	}
+
+	return params, nil
 }
 
 func applyCLIparams(opt model.Params) {
@@ -96,6 +160,18 @@ func applyCLIparams(opt model.Params) {
 		Params.RootCertPath = opt.RootCertPath
 	}
 
+	if opt.ClientCertPath != "" {
+		Params.ClientCertPath = opt.ClientCertPath
+	}
+
+	if opt.ClientKeyPath != "" {
+		Params.ClientKeyPath = opt.ClientKeyPath
+	}
+
+	if opt.ClientKeyPassword != "" {
+		Params.ClientKeyPassword = opt.ClientKeyPassword
+	}
+
 	if opt.LogLevel != "" {
 		Params.LogLevel = opt.LogLevel
 	}
@@ -131,6 +207,77 @@ func applyCLIparams(opt model.Params) {
 	if opt.LogSendInvl > 0 {
 		Params.LogSendInvl = opt.LogSendInvl
 	}
+
+	if opt.LogBatchSize > 0 {
+		Params.LogBatchSize = opt.LogBatchSize
+	}
+
+	if opt.LogChunkSize > 0 {
+		Params.LogChunkSize = opt.LogChunkSize
+	}
+
+	if opt.LogEncoding != "" {
+		Params.LogEncoding = opt.LogEncoding
+	}
+
+	if opt.StatusResync > 0 {
+		Params.StatusResync = opt.StatusResync
+	}
+
+	if opt.UpdateMode != "" {
+		Params.UpdateMode = opt.UpdateMode
+	}
+
+	if opt.UpdateTimeout > 0 {
+		Params.UpdateTimeout = opt.UpdateTimeout
+	}
+
+	if opt.UpdateSettle > 0 {
+		Params.UpdateSettle = opt.UpdateSettle
+	}
+
+	if opt.ReconcileInvl > 0 {
+		Params.ReconcileInvl = opt.ReconcileInvl
+	}
+
+	if opt.OutboxSize > 0 {
+		Params.OutboxSize = opt.OutboxSize
+	}
+
+	if opt.OutboxAge > 0 {
+		Params.OutboxAge = opt.OutboxAge
+	}
+
+	if opt.OutboxDir != "" {
+		Params.OutboxDir = opt.OutboxDir
+	}
+
+	if opt.Runtime != "" {
+		Params.Runtime = opt.Runtime
+	}
+
+	if opt.RuntimeSock != "" {
+		Params.RuntimeSock = opt.RuntimeSock
+	}
+
+	if opt.MetricsAddr != "" {
+		Params.MetricsAddr = opt.MetricsAddr
+	}
+
+	if opt.APISocket != "" {
+		Params.APISocket = opt.APISocket
+	}
+}
+
+// Redact returns a copy of the params that is safe to show
+func Redact(params ParamStruct) ParamStruct {
+	if params.Password != "" {
+		params.Password = redacted
+	}
+	if params.ClientKeyPassword != "" {
+		params.ClientKeyPassword = redacted
+	}
+	return params
 }
 
 func validateConfig() {
@@ -151,6 +298,50 @@ func validateConfig() {
 			log.Fatalf("Incorrect protocol, TLS is required unless --notls is set. You specified protocol in broker to: %v", brokerUrl.Scheme)
 		}
 	}
+
+	if Params.ClientCertPath != "" && Params.ClientKeyPath == "" {
+		log.Fatal("no key specified for the client certificate")
+	}
+	if Params.NoTLS && Params.ClientCertPath != "" {
+		log.Warning("TLS disabled, the client certificate is not used!")
+	}
+
+	switch Params.Runtime {
+	case RuntimeDocker, RuntimePodman, RuntimeContainerd:
+	default:
+		log.Fatalf("Unsupported container runtime %v. Supported runtimes are: %v, %v, %v", Params.Runtime, RuntimeDocker, RuntimePodman, RuntimeContainerd)
+	}
+
+	switch Params.UpdateMode {
+	case UpdateBlueGreen, UpdateRecreate:
+	default:
+		log.Fatalf("Unsupported update mode %v. Supported modes are: %v, %v", Params.UpdateMode, UpdateBlueGreen, UpdateRecreate)
+	}
+
+	Params.OutboxDir = resolveOutboxDir(Params.OutboxDir)
+
+	switch Params.LogEncoding {
+	case LogEncodingNone, LogEncodingGzip, LogEncodingZstd:
+	default:
+		log.Fatalf("Unsupported log encoding %v. Supported encodings are: %v, %v, %v", Params.LogEncoding, LogEncodingNone, LogEncodingGzip, LogEncodingZstd)
+	}
+}
+
+// resolveOutboxDir makes the outbox directory absolute, by default it is next to the agent executable
+func resolveOutboxDir(dir string) string {
+	if dir == "" {
+		executable, err := os.Executable()
+		if err != nil {
+			log.Fatal("Failed to locate the agent executable! CAUSE --> ", err)
+		}
+		return filepath.Join(filepath.Dir(executable), defaultOutboxDir)
+	}
+
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		log.Fatal("Failed to resolve the outbox directory! CAUSE --> ", err)
+	}
+	return absDir
 }
 
 func validateBrokerUrl(u *url.URL) {
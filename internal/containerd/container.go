@@ -0,0 +1,658 @@
+package containerd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"time"
+
+	containerdclient "github.com/containerd/containerd"
+	"github.com/containerd/containerd/cio"
+	"github.com/containerd/containerd/containers"
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/oci"
+	"github.com/containerd/containerd/pkg/netns"
+	"github.com/containerd/containerd/runtime/restart"
+	gocni "github.com/containerd/go-cni"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/docker/go-connections/nat"
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/weeveiot/weeve-agent/internal/manifest"
+	"github.com/weeveiot/weeve-agent/internal/model"
+	traceutility "github.com/weeveiot/weeve-agent/internal/utility/trace"
+)
+
+// Labels the runtime keeps on each container next to the manifest labels
+const (
+	imageLabel   = "weeve.image"
+	networkLabel = "weeve.network"
+	netnsLabel   = "weeve.netns"
+	portsLabel   = "weeve.ports"
+)
+
+const stopTimeout = 2 * time.Second
+
+// cpuPeriod is the CFS period the CPU quota of a module is applied to, the same as Docker uses
+const cpuPeriod uint64 = 100000
+
+// CreateAndStartContainer creates the container in its own network namespace attached to the edge app network.
+// The container name is used as the container ID.
+func (r *Runtime) CreateAndStartContainer(containerConfig manifest.ContainerConfig) (string, error) {
+	log.Debugln("Creating container", containerConfig.ContainerName, "from", containerConfig.ImageNameFull)
+
+	containerID := containerConfig.ContainerName
+
+	ref, err := normalizeImageName(containerConfig.ImageNameFull)
+	if err != nil {
+		return containerID, traceutility.Wrap(err)
+	}
+	image, err := r.client.GetImage(r.ctx, ref)
+	if err != nil {
+		return containerID, traceutility.Wrap(err)
+	}
+
+	netRecord, err := r.getNetwork(containerConfig.NetworkName)
+	if err != nil {
+		return containerID, traceutility.Wrap(err)
+	}
+
+	portMappings, err := toPortMappings(containerConfig.PortBinding)
+	if err != nil {
+		return containerID, traceutility.Wrap(err)
+	}
+	encodedPorts, err := json.Marshal(portMappings)
+	if err != nil {
+		return containerID, traceutility.Wrap(err)
+	}
+
+	// the network namespace is created independently of the task, so that it survives restarts of the task
+	netNS, err := netns.NewNetNS(netnsDir)
+	if err != nil {
+		return containerID, traceutility.Wrap(err)
+	}
+
+	labels := map[string]string{
+		imageLabel:   containerConfig.ImageNameFull,
+		networkLabel: netRecord.Name,
+		netnsLabel:   netNS.GetPath(),
+		portsLabel:   string(encodedPorts),
+	}
+	for key, value := range containerConfig.Labels {
+		labels[key] = value
+	}
+
+	err = r.setupNetwork(containerID, labels)
+	if err != nil {
+		netNS.Remove()
+		return containerID, traceutility.Wrap(err)
+	}
+
+	mounts := []specs.Mount{
+		{Type: "bind", Source: r.hostsFile(netRecord.Name), Destination: "/etc/hosts", Options: []string{"rbind", "rprivate", "ro"}},
+		{Type: "bind", Source: "/etc/resolv.conf", Destination: "/etc/resolv.conf", Options: []string{"rbind", "rprivate", "ro"}},
+	}
+	specMounts, err := r.toSpecMounts(containerConfig.MountConfigs)
+	if err != nil {
+		r.teardownNetwork(containerID, labels)
+		return containerID, traceutility.Wrap(err)
+	}
+	mounts = append(mounts, specMounts...)
+
+	specOpts := []oci.SpecOpts{
+		oci.WithImageConfig(image),
+		oci.WithEnv(containerConfig.EnvArgs),
+		oci.WithLinuxNamespace(specs.LinuxNamespace{Type: specs.NetworkNamespace, Path: netNS.GetPath()}),
+		oci.WithMounts(mounts),
+	}
+	specOpts = append(specOpts, toDeviceOpts(containerConfig.Resources.Devices)...)
+	specOpts = append(specOpts, toResourceOpts(containerConfig.Resources)...)
+
+	if containerConfig.Healthcheck != nil {
+		log.Warningln("containerd does not run healthchecks, the healthcheck of", containerConfig.ContainerName, "is ignored")
+	}
+
+	logURI, err := cio.LogURIGenerator("file", r.logFile(containerID), nil)
+	if err != nil {
+		r.teardownNetwork(containerID, labels)
+		return containerID, traceutility.Wrap(err)
+	}
+
+	_, err = r.client.NewContainer(r.ctx, containerID,
+		containerdclient.WithImage(image),
+		containerdclient.WithNewSnapshot(containerID+"-snapshot", image),
+		containerdclient.WithNewSpec(specOpts...),
+		containerdclient.WithContainerLabels(labels),
+		restart.WithLogURI(logURI),
+	)
+	if err != nil {
+		r.teardownNetwork(containerID, labels)
+		return containerID, traceutility.Wrap(err)
+	}
+	log.Debug("Created container " + containerConfig.ContainerName)
+
+	err = r.StartContainer(containerID)
+	if err != nil {
+		return containerID, traceutility.Wrap(err)
+	}
+
+	return containerID, nil
+}
+
+func (r *Runtime) StartContainer(containerID string) error {
+	cont, err := r.client.LoadContainer(r.ctx, containerID)
+	if err != nil {
+		return traceutility.Wrap(err)
+	}
+
+	// a stopped task is kept to report the exit code, it has to be deleted before starting a new one
+	task, err := cont.Task(r.ctx, nil)
+	if err == nil {
+		status, err := task.Status(r.ctx)
+		if err != nil {
+			return traceutility.Wrap(err)
+		}
+		if status.Status == containerdclient.Running {
+			return nil
+		}
+		_, err = task.Delete(r.ctx, containerdclient.WithProcessKill)
+		if err != nil {
+			return traceutility.Wrap(err)
+		}
+	} else if !errdefs.IsNotFound(err) {
+		return traceutility.Wrap(err)
+	}
+
+	err = os.MkdirAll(filepath.Dir(r.logFile(containerID)), 0755)
+	if err != nil {
+		return traceutility.Wrap(err)
+	}
+
+	task, err = cont.NewTask(r.ctx, cio.LogFile(r.logFile(containerID)))
+	if err != nil {
+		return traceutility.Wrap(err)
+	}
+
+	err = task.Start(r.ctx)
+	if err != nil {
+		return traceutility.Wrap(err)
+	}
+
+	// from now on the restart monitor of containerd keeps the task running
+	_, err = cont.SetLabels(r.ctx, map[string]string{restart.StatusLabel: string(containerdclient.Running)})
+	if err != nil {
+		return traceutility.Wrap(err)
+	}
+	log.Debug("Started container ID ", containerID)
+
+	return nil
+}
+
+// StopContainer stops the task of the container, killing it if it doesn't exit within the stop timeout
+func (r *Runtime) StopContainer(containerID string) error {
+	cont, err := r.client.LoadContainer(r.ctx, containerID)
+	if err != nil {
+		return traceutility.Wrap(err)
+	}
+
+	_, err = cont.SetLabels(r.ctx, map[string]string{restart.StatusLabel: string(containerdclient.Stopped)})
+	if err != nil {
+		return traceutility.Wrap(err)
+	}
+
+	task, err := cont.Task(r.ctx, nil)
+	if err != nil {
+		if errdefs.IsNotFound(err) {
+			return nil
+		}
+		return traceutility.Wrap(err)
+	}
+
+	status, err := task.Status(r.ctx)
+	if err != nil {
+		return traceutility.Wrap(err)
+	}
+	if status.Status != containerdclient.Running {
+		return nil
+	}
+
+	exitCh, err := task.Wait(r.ctx)
+	if err != nil {
+		return traceutility.Wrap(err)
+	}
+
+	err = task.Kill(r.ctx, syscall.SIGTERM)
+	if err != nil {
+		return traceutility.Wrap(err)
+	}
+
+	select {
+	case <-exitCh:
+	case <-time.After(stopTimeout):
+		err = task.Kill(r.ctx, syscall.SIGKILL)
+		if err != nil {
+			return traceutility.Wrap(err)
+		}
+		<-exitCh
+	}
+
+	return nil
+}
+
+func (r *Runtime) StopAndRemoveContainer(containerID string) error {
+	if err := r.StopContainer(containerID); err != nil {
+		log.Errorf("Unable to stop container %s: %s. Will try to force remove...", containerID, err)
+	}
+
+	cont, err := r.client.LoadContainer(r.ctx, containerID)
+	if err != nil {
+		log.Errorf("Unable to remove container: %s", err)
+		return traceutility.Wrap(err)
+	}
+
+	task, err := cont.Task(r.ctx, nil)
+	if err == nil {
+		_, err = task.Delete(r.ctx, containerdclient.WithProcessKill)
+		if err != nil {
+			log.Errorf("Unable to delete task of container %s: %s", containerID, err)
+		}
+	}
+
+	labels, err := cont.Labels(r.ctx)
+	if err != nil {
+		return traceutility.Wrap(err)
+	}
+	r.teardownNetwork(containerID, labels)
+
+	err = cont.Delete(r.ctx, containerdclient.WithSnapshotCleanup)
+	if err != nil {
+		log.Errorf("Unable to remove container: %s", err)
+		return traceutility.Wrap(err)
+	}
+
+	r.removeLogs(containerID)
+
+	return nil
+}
+
+func (r *Runtime) ReadAllContainers() ([]types.Container, error) {
+	log.Debug("Containerd_container -> ReadAllContainers")
+
+	return r.listContainers()
+}
+
+func (r *Runtime) ReadEdgeAppContainers(manifestUniqueID model.ManifestUniqueID) ([]types.Container, error) {
+	return r.listContainers(fmt.Sprintf("labels.%q==%q", "manifestUniqueID", manifestUniqueID.String()))
+}
+
+func (r *Runtime) InspectContainer(containerID string) (types.ContainerJSON, error) {
+	cont, err := r.client.LoadContainer(r.ctx, containerID)
+	if err != nil {
+		return types.ContainerJSON{}, traceutility.Wrap(err)
+	}
+
+	info, err := cont.Info(r.ctx)
+	if err != nil {
+		return types.ContainerJSON{}, traceutility.Wrap(err)
+	}
+
+	spec, err := cont.Spec(r.ctx)
+	if err != nil {
+		return types.ContainerJSON{}, traceutility.Wrap(err)
+	}
+
+	state, err := r.containerState(cont, info)
+	if err != nil {
+		return types.ContainerJSON{}, traceutility.Wrap(err)
+	}
+
+	imageID, err := r.imageDigest(info.Image)
+	if err != nil {
+		return types.ContainerJSON{}, traceutility.Wrap(err)
+	}
+
+	var env []string
+	if spec.Process != nil {
+		env = spec.Process.Env
+	}
+
+	return types.ContainerJSON{
+		ContainerJSONBase: &types.ContainerJSONBase{
+			ID:      info.ID,
+			Name:    "/" + info.ID,
+			Created: info.CreatedAt.Format(time.RFC3339Nano),
+			Image:   imageID,
+			State:   state,
+		},
+		Config: &container.Config{
+			Image:  info.Labels[imageLabel],
+			Env:    env,
+			Labels: info.Labels,
+		},
+	}, nil
+}
+
+func (r *Runtime) listContainers(filters ...string) ([]types.Container, error) {
+	containerList, err := r.client.Containers(r.ctx, filters...)
+	if err != nil {
+		return nil, traceutility.Wrap(err)
+	}
+
+	result := []types.Container{}
+	for _, cont := range containerList {
+		info, err := cont.Info(r.ctx)
+		if err != nil {
+			return nil, traceutility.Wrap(err)
+		}
+
+		state, err := r.containerState(cont, info)
+		if err != nil {
+			return nil, traceutility.Wrap(err)
+		}
+
+		imageID, err := r.imageDigest(info.Image)
+		if err != nil {
+			return nil, traceutility.Wrap(err)
+		}
+
+		result = append(result, types.Container{
+			ID:      info.ID,
+			Names:   []string{"/" + info.ID},
+			Image:   info.Labels[imageLabel],
+			ImageID: imageID,
+			Created: info.CreatedAt.Unix(),
+			Labels:  info.Labels,
+			State:   state.Status,
+			Status:  containerStatusText(state),
+		})
+	}
+
+	return result, nil
+}
+
+// containerState maps the task of the container to the states known from Docker: created, running, restarting, paused, exited
+func (r *Runtime) containerState(cont containerdclient.Container, info containers.Container) (*types.ContainerState, error) {
+	task, err := cont.Task(r.ctx, nil)
+	if err != nil {
+		if errdefs.IsNotFound(err) {
+			return &types.ContainerState{Status: "created"}, nil
+		}
+		return nil, traceutility.Wrap(err)
+	}
+
+	status, err := task.Status(r.ctx)
+	if err != nil {
+		return nil, traceutility.Wrap(err)
+	}
+
+	state := &types.ContainerState{Pid: int(task.Pid())}
+	switch status.Status {
+	case containerdclient.Running:
+		state.Status = "running"
+		state.Running = true
+	case containerdclient.Paused, containerdclient.Pausing:
+		state.Status = "paused"
+		state.Paused = true
+	case containerdclient.Created:
+		state.Status = "created"
+	case containerdclient.Stopped:
+		if info.Labels[restart.StatusLabel] == string(containerdclient.Running) {
+			// the restart monitor is going to start the task again
+			state.Status = "restarting"
+			state.Restarting = true
+		} else {
+			state.Status = "exited"
+		}
+		state.ExitCode = int(status.ExitStatus)
+		state.FinishedAt = status.ExitTime.Format(time.RFC3339Nano)
+	default:
+		state.Status = "dead"
+		state.Dead = true
+	}
+
+	return state, nil
+}
+
+// containerRunning reports whether the task of the container runs or is going to be restarted
+func (r *Runtime) containerRunning(containerID string) (bool, error) {
+	cont, err := r.client.LoadContainer(r.ctx, containerID)
+	if err != nil {
+		return false, traceutility.Wrap(err)
+	}
+
+	info, err := cont.Info(r.ctx)
+	if err != nil {
+		return false, traceutility.Wrap(err)
+	}
+
+	state, err := r.containerState(cont, info)
+	if err != nil {
+		return false, traceutility.Wrap(err)
+	}
+
+	return state.Running || state.Restarting, nil
+}
+
+func (r *Runtime) imageDigest(ref string) (string, error) {
+	image, err := r.client.ImageService().Get(r.ctx, ref)
+	if err != nil {
+		if errdefs.IsNotFound(err) {
+			return "", nil
+		}
+		return "", traceutility.Wrap(err)
+	}
+	return image.Target.Digest.String(), nil
+}
+
+func (r *Runtime) setupNetwork(containerID string, labels map[string]string) error {
+	netRecord, err := r.getNetwork(labels[networkLabel])
+	if err != nil {
+		return traceutility.Wrap(err)
+	}
+
+	var portMappings []gocni.PortMapping
+	err = json.Unmarshal([]byte(labels[portsLabel]), &portMappings)
+	if err != nil {
+		return traceutility.Wrap(err)
+	}
+
+	cni, err := networkCNI(netRecord)
+	if err != nil {
+		return traceutility.Wrap(err)
+	}
+
+	result, err := cni.Setup(r.ctx, containerID, labels[netnsLabel], gocni.WithCapabilityPortMap(portMappings))
+	if err != nil {
+		return traceutility.Wrap(err)
+	}
+
+	for name, iface := range result.Interfaces {
+		if name == "lo" {
+			continue
+		}
+		for _, ipConfig := range iface.IPConfigs {
+			if ipConfig.IP.To4() != nil {
+				return r.addHostEntry(netRecord.Name, ipConfig.IP.String(), containerID)
+			}
+		}
+	}
+
+	return fmt.Errorf("no IP address assigned to container %s", containerID)
+}
+
+// teardownNetwork releases the network resources of the container on a best effort basis
+func (r *Runtime) teardownNetwork(containerID string, labels map[string]string) {
+	err := r.removeHostEntry(labels[networkLabel], containerID)
+	if err != nil {
+		log.Errorf("Unable to remove host entry of container %s: %s", containerID, err)
+	}
+
+	netRecord, err := r.getNetwork(labels[networkLabel])
+	if err == nil {
+		var portMappings []gocni.PortMapping
+		json.Unmarshal([]byte(labels[portsLabel]), &portMappings)
+
+		cni, err := networkCNI(netRecord)
+		if err == nil {
+			err = cni.Remove(r.ctx, containerID, labels[netnsLabel], gocni.WithCapabilityPortMap(portMappings))
+		}
+		if err != nil {
+			log.Errorf("Unable to detach container %s from network %s: %s", containerID, netRecord.Name, err)
+		}
+	}
+
+	if labels[netnsLabel] != "" {
+		err = netns.LoadNetNS(labels[netnsLabel]).Remove()
+		if err != nil {
+			log.Errorf("Unable to remove network namespace of container %s: %s", containerID, err)
+		}
+	}
+}
+
+func toPortMappings(portBinding nat.PortMap) ([]gocni.PortMapping, error) {
+	portMappings := []gocni.PortMapping{}
+	for port, bindings := range portBinding {
+		for _, binding := range bindings {
+			hostPort, err := strconv.ParseInt(binding.HostPort, 10, 32)
+			if err != nil {
+				return nil, traceutility.Wrap(err)
+			}
+			portMappings = append(portMappings, gocni.PortMapping{
+				HostPort:      int32(hostPort),
+				ContainerPort: int32(port.Int()),
+				Protocol:      port.Proto(),
+				HostIP:        binding.HostIP,
+			})
+		}
+	}
+	return portMappings, nil
+}
+
+func (r *Runtime) toSpecMounts(mountConfigs []mount.Mount) ([]specs.Mount, error) {
+	var mounts []specs.Mount
+	for _, mnt := range mountConfigs {
+		access := "rw"
+		if mnt.ReadOnly {
+			access = "ro"
+		}
+
+		switch mnt.Type {
+		case mount.TypeTmpfs:
+			options := []string{"nosuid", "nodev", access}
+			if mnt.TmpfsOptions != nil && mnt.TmpfsOptions.SizeBytes > 0 {
+				options = append(options, fmt.Sprintf("size=%d", mnt.TmpfsOptions.SizeBytes))
+			}
+			mounts = append(mounts, specs.Mount{
+				Type:        "tmpfs",
+				Source:      "tmpfs",
+				Destination: mnt.Target,
+				Options:     options,
+			})
+		case mount.TypeVolume:
+			// containerd has no volumes, they are directories in the state dir
+			source := r.volumeDir(mnt.Source)
+			err := os.MkdirAll(source, 0755)
+			if err != nil {
+				return nil, traceutility.Wrap(err)
+			}
+			mounts = append(mounts, specs.Mount{
+				Type:        "bind",
+				Source:      source,
+				Destination: mnt.Target,
+				Options:     []string{"rbind", "rprivate", access},
+			})
+		default:
+			options := []string{"rbind"}
+			if mnt.BindOptions != nil {
+				if mnt.BindOptions.NonRecursive {
+					options = []string{"bind"}
+				}
+				if mnt.BindOptions.Propagation != "" {
+					options = append(options, string(mnt.BindOptions.Propagation))
+				}
+			}
+			options = append(options, access)
+
+			mounts = append(mounts, specs.Mount{
+				Type:        "bind",
+				Source:      mnt.Source,
+				Destination: mnt.Target,
+				Options:     options,
+			})
+		}
+	}
+	return mounts, nil
+}
+
+func toDeviceOpts(devices []container.DeviceMapping) []oci.SpecOpts {
+	var opts []oci.SpecOpts
+	for _, device := range devices {
+		permissions := device.CgroupPermissions
+		if permissions == "" {
+			permissions = "rwm"
+		}
+		opts = append(opts, oci.WithDevices(device.PathOnHost, device.PathInContainer, permissions))
+	}
+	return opts
+}
+
+// toResourceOpts applies the resource limits with the same semantics as Docker
+func toResourceOpts(resources container.Resources) []oci.SpecOpts {
+	var opts []oci.SpecOpts
+	if resources.NanoCPUs > 0 {
+		quota := resources.NanoCPUs * int64(cpuPeriod) / 1e9
+		opts = append(opts, oci.WithCPUCFS(quota, cpuPeriod))
+	}
+	if resources.CPUShares > 0 {
+		opts = append(opts, oci.WithCPUShares(uint64(resources.CPUShares)))
+	}
+	if resources.CpusetCpus != "" {
+		opts = append(opts, oci.WithCPUs(resources.CpusetCpus))
+	}
+	if resources.Memory > 0 {
+		opts = append(opts, oci.WithMemoryLimit(uint64(resources.Memory)))
+	}
+	if resources.MemoryReservation > 0 {
+		reservation := resources.MemoryReservation
+		opts = append(opts, func(ctx context.Context, _ oci.Client, _ *containers.Container, s *oci.Spec) error {
+			if s.Linux == nil {
+				s.Linux = &specs.Linux{}
+			}
+			if s.Linux.Resources == nil {
+				s.Linux.Resources = &specs.LinuxResources{}
+			}
+			if s.Linux.Resources.Memory == nil {
+				s.Linux.Resources.Memory = &specs.LinuxMemory{}
+			}
+			s.Linux.Resources.Memory.Reservation = &reservation
+			return nil
+		})
+	}
+	if resources.MemorySwap != 0 {
+		opts = append(opts, oci.WithMemorySwap(resources.MemorySwap))
+	}
+	if resources.PidsLimit != nil {
+		opts = append(opts, oci.WithPidsLimit(*resources.PidsLimit))
+	}
+	return opts
+}
+
+func containerStatusText(state *types.ContainerState) string {
+	switch state.Status {
+	case "running":
+		return "Up"
+	case "exited":
+		return fmt.Sprintf("Exited (%d)", state.ExitCode)
+	case "restarting":
+		return fmt.Sprintf("Restarting (%d)", state.ExitCode)
+	default:
+		return state.Status
+	}
+}
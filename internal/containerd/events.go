@@ -0,0 +1,114 @@
+package containerd
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	apievents "github.com/containerd/containerd/api/events"
+	"github.com/containerd/containerd/namespaces"
+	"github.com/containerd/typeurl"
+	"github.com/docker/docker/api/types/events"
+	log "github.com/sirupsen/logrus"
+
+	traceutility "github.com/weeveiot/weeve-agent/internal/utility/trace"
+)
+
+const eventBufferSize = 100
+
+// WatchEdgeAppEvents translates the containerd task and container events of the agent namespace into Docker container events.
+// Containers are only looked up while they exist, so the destroy event carries no labels.
+func (r *Runtime) WatchEdgeAppEvents(ctx context.Context) (<-chan events.Message, <-chan error) {
+	messages := make(chan events.Message, eventBufferSize)
+	errs := make(chan error, 1)
+
+	ctx = namespaces.WithNamespace(ctx, Namespace)
+	envelopes, envelopeErrs := r.client.Subscribe(ctx,
+		fmt.Sprintf(`namespace==%q,topic~="^/tasks/"`, Namespace),
+		fmt.Sprintf(`namespace==%q,topic~="^/containers/"`, Namespace),
+	)
+
+	go func() {
+		for {
+			select {
+			case err := <-envelopeErrs:
+				errs <- traceutility.Wrap(err)
+				return
+			case envelope := <-envelopes:
+				event, err := typeurl.UnmarshalAny(envelope.Event)
+				if err != nil {
+					log.Warnln("Unable to decode containerd event", envelope.Topic, err)
+					continue
+				}
+
+				msg, ok := r.toEventMessage(ctx, event)
+				if !ok {
+					continue
+				}
+				msg.Time = envelope.Timestamp.Unix()
+				msg.TimeNano = envelope.Timestamp.UnixNano()
+
+				select {
+				case messages <- msg:
+				case <-ctx.Done():
+					errs <- ctx.Err()
+					return
+				}
+			}
+		}
+	}()
+
+	return messages, errs
+}
+
+func (r *Runtime) toEventMessage(ctx context.Context, event interface{}) (events.Message, bool) {
+	var containerID, action string
+	attributes := map[string]string{}
+
+	switch e := event.(type) {
+	case *apievents.ContainerCreate:
+		containerID, action = e.ID, "create"
+	case *apievents.ContainerDelete:
+		containerID, action = e.ID, "destroy"
+	case *apievents.TaskStart:
+		containerID, action = e.ContainerID, "start"
+	case *apievents.TaskExit:
+		// exits of exec processes are not container exits
+		if e.ID != e.ContainerID {
+			return events.Message{}, false
+		}
+		containerID, action = e.ContainerID, "die"
+		attributes["exitCode"] = strconv.FormatUint(uint64(e.ExitStatus), 10)
+	case *apievents.TaskOOM:
+		containerID, action = e.ContainerID, "oom"
+	case *apievents.TaskPaused:
+		containerID, action = e.ContainerID, "pause"
+	case *apievents.TaskResumed:
+		containerID, action = e.ContainerID, "unpause"
+	default:
+		return events.Message{}, false
+	}
+
+	if action != "destroy" {
+		cont, err := r.client.LoadContainer(ctx, containerID)
+		if err == nil {
+			labels, err := cont.Labels(ctx)
+			if err == nil {
+				for key, value := range labels {
+					attributes[key] = value
+				}
+				attributes["image"] = labels[imageLabel]
+			}
+		}
+		if _, found := attributes["manifestUniqueID"]; !found && action != "die" {
+			return events.Message{}, false
+		}
+	}
+	attributes["name"] = containerID
+
+	return events.Message{
+		Type:   events.ContainerEventType,
+		Action: action,
+		Actor:  events.Actor{ID: containerID, Attributes: attributes},
+	}, true
+}
@@ -0,0 +1,122 @@
+package containerd
+
+import (
+	"fmt"
+
+	containerdclient "github.com/containerd/containerd"
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/images"
+	"github.com/containerd/containerd/reference/docker"
+	"github.com/containerd/containerd/remotes"
+	dockerremote "github.com/containerd/containerd/remotes/docker"
+	"github.com/docker/docker/api/types"
+	log "github.com/sirupsen/logrus"
+
+	traceutility "github.com/weeveiot/weeve-agent/internal/utility/trace"
+)
+
+func (r *Runtime) PullImage(authConfig types.AuthConfig, imageName string) error {
+	ref, err := normalizeImageName(imageName)
+	if err != nil {
+		return traceutility.Wrap(err)
+	}
+
+	image, err := r.client.Pull(r.ctx, ref,
+		containerdclient.WithPullUnpack,
+		containerdclient.WithResolver(newResolver(authConfig)),
+	)
+	if err != nil {
+		return traceutility.Wrap(err)
+	}
+	log.Info("Pulled image ", image.Name(), " ", image.Target().Digest)
+
+	return nil
+}
+
+// Check if the image exists in the local context
+// Return an error only if something went wrong, if the image is not found the error is nil
+func (r *Runtime) ImageExists(imageName string) (bool, error) {
+	ref, err := normalizeImageName(imageName)
+	if err != nil {
+		return false, traceutility.Wrap(err)
+	}
+
+	_, err = r.client.ImageService().Get(r.ctx, ref)
+	if err != nil {
+		if errdefs.IsNotFound(err) {
+			return false, nil
+		}
+		return false, traceutility.Wrap(err)
+	}
+
+	return true, nil
+}
+
+// ImageRemove removes all image references that point to the given image digest
+func (r *Runtime) ImageRemove(imageID string) error {
+	imageList, err := r.client.ImageService().List(r.ctx, fmt.Sprintf("target.digest==%s", imageID))
+	if err != nil {
+		return traceutility.Wrap(err)
+	}
+	if len(imageList) == 0 {
+		return fmt.Errorf("no such image: %s", imageID)
+	}
+
+	for _, image := range imageList {
+		err := r.client.ImageService().Delete(r.ctx, image.Name, images.SynchronousDelete())
+		if err != nil {
+			return traceutility.Wrap(err)
+		}
+	}
+
+	return nil
+}
+
+func (r *Runtime) GetImagesByName(imageNames []string) ([]types.ImageSummary, error) {
+	var summaries []types.ImageSummary
+	for _, imageName := range imageNames {
+		ref, err := normalizeImageName(imageName)
+		if err != nil {
+			return nil, traceutility.Wrap(err)
+		}
+
+		image, err := r.client.ImageService().Get(r.ctx, ref)
+		if err != nil {
+			if errdefs.IsNotFound(err) {
+				continue
+			}
+			return nil, traceutility.Wrap(err)
+		}
+
+		summaries = append(summaries, types.ImageSummary{
+			ID:       image.Target.Digest.String(),
+			RepoTags: []string{image.Name},
+			Labels:   image.Labels,
+			Created:  image.CreatedAt.Unix(),
+		})
+	}
+
+	return summaries, nil
+}
+
+// normalizeImageName turns a Docker style image name like "weevenetwork/mqtt-ingress" into a
+// fully qualified reference like "docker.io/weevenetwork/mqtt-ingress:latest"
+func normalizeImageName(imageName string) (string, error) {
+	named, err := docker.ParseDockerRef(imageName)
+	if err != nil {
+		return "", err
+	}
+	return named.String(), nil
+}
+
+func newResolver(authConfig types.AuthConfig) remotes.Resolver {
+	credentials := func(host string) (string, string, error) {
+		return authConfig.Username, authConfig.Password, nil
+	}
+
+	return dockerremote.NewResolver(dockerremote.ResolverOptions{
+		Hosts: dockerremote.ConfigureDefaultRegistries(
+			dockerremote.WithAuthorizer(dockerremote.NewDockerAuthorizer(dockerremote.WithAuthCreds(credentials))),
+		),
+	})
+}
@@ -0,0 +1,321 @@
+package containerd
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/weeveiot/weeve-agent/internal/model"
+	"github.com/weeveiot/weeve-agent/internal/runtime"
+	traceutility "github.com/weeveiot/weeve-agent/internal/utility/trace"
+)
+
+const maxLogCheckpoints = 10
+
+// logPollInterval is how often a followed log file is checked for new lines
+const logPollInterval = time.Second
+
+// the log files are rotated when they grow beyond maxLogFileSize, keeping the previous content in one backup file
+const (
+	maxLogFileSize    = 10 * 1024 * 1024
+	logRotateInterval = 30 * time.Second
+)
+
+// logCheckpoint remembers up to which offset the log file was read at a point in time.
+// The shim writes the raw container output without timestamps, so the checkpoints are used to map since/until to file offsets.
+type logCheckpoint struct {
+	time   time.Time
+	offset int64
+}
+
+// ReadContainerLogs returns the complete lines written since the read that ended at `since`.
+// If there is no such read, the log file is read from the beginning. The lines are timestamped with the time of the read.
+func (r *Runtime) ReadContainerLogs(containerID string, since string, until string) ([]runtime.LogLine, error) {
+	logLines := []runtime.LogLine{}
+
+	logFile, err := os.Open(r.logFile(containerID))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return logLines, nil
+		}
+		return logLines, traceutility.Wrap(err)
+	}
+	defer logFile.Close()
+
+	untilTime := time.Now()
+	if until != "" {
+		untilTime, err = time.Parse(time.RFC3339Nano, until)
+		if err != nil {
+			return logLines, traceutility.Wrap(err)
+		}
+	}
+
+	info, err := logFile.Stat()
+	if err != nil {
+		return logLines, traceutility.Wrap(err)
+	}
+	offset := r.logOffset(containerID, since)
+	// the file was rotated since the read that ended at `since`
+	if info.Size() < offset {
+		offset = 0
+	}
+	_, err = logFile.Seek(offset, io.SeekStart)
+	if err != nil {
+		return logLines, traceutility.Wrap(err)
+	}
+
+	content, err := io.ReadAll(logFile)
+	if err != nil {
+		return logLines, traceutility.Wrap(err)
+	}
+
+	// an incomplete last line is left for the next read
+	complete := strings.LastIndexByte(string(content), '\n') + 1
+	readTime := time.Now().UTC()
+	lineOffset := offset
+	for _, line := range strings.SplitAfter(string(content[:complete]), "\n") {
+		if line != "" {
+			lineOffset += int64(len(line))
+			logLines = append(logLines, runtime.LogLine{
+				Time:   readTime,
+				Line:   strings.TrimSuffix(line, "\n"),
+				Cursor: model.LogCursor{Time: readTime, Offset: lineOffset},
+			})
+		}
+	}
+
+	r.addLogCheckpoint(containerID, logCheckpoint{time: untilTime, offset: offset + int64(complete)})
+
+	return logLines, nil
+}
+
+// FollowContainerLogs streams the lines of the log file from the offset of the cursor on. The shim writes no timestamps,
+// so the lines are timestamped with the time they were read.
+func (r *Runtime) FollowContainerLogs(ctx context.Context, containerID string, cursor model.LogCursor) (<-chan runtime.LogLine, <-chan error) {
+	lines := make(chan runtime.LogLine)
+	errs := make(chan error, 1)
+
+	go func() {
+		defer close(lines)
+
+		err := r.followLogFile(ctx, containerID, cursor.Offset, lines)
+		if err != nil {
+			errs <- traceutility.Wrap(err)
+		}
+	}()
+
+	return lines, errs
+}
+
+func (r *Runtime) followLogFile(ctx context.Context, containerID string, offset int64, lines chan<- runtime.LogLine) error {
+	logFile, err := os.Open(r.logFile(containerID))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return traceutility.Wrap(err)
+	}
+	defer logFile.Close()
+
+	info, err := logFile.Stat()
+	if err != nil {
+		return traceutility.Wrap(err)
+	}
+	// a shorter file was recreated since the cursor was taken
+	if info.Size() < offset {
+		offset = 0
+	}
+	_, err = logFile.Seek(offset, io.SeekStart)
+	if err != nil {
+		return traceutility.Wrap(err)
+	}
+
+	reader := bufio.NewReader(logFile)
+	var partial string
+	stopped := false
+	for {
+		chunk, err := reader.ReadString('\n')
+		partial += chunk
+		if err == nil {
+			offset += int64(len(partial))
+			readTime := time.Now().UTC()
+			line := runtime.LogLine{
+				Time:   readTime,
+				Line:   strings.TrimSuffix(partial, "\n"),
+				Cursor: model.LogCursor{Time: readTime, Offset: offset},
+			}
+			partial = ""
+
+			select {
+			case lines <- line:
+			case <-ctx.Done():
+				return nil
+			}
+			continue
+		}
+		if err != io.EOF {
+			return traceutility.Wrap(err)
+		}
+
+		// the lines written right before the container stopped are read once more before the stream ends
+		if stopped {
+			return nil
+		}
+		info, err = logFile.Stat()
+		if err != nil {
+			return traceutility.Wrap(err)
+		}
+		if info.Size() < offset {
+			// the file was rotated, the lines written to it from now on start at the beginning
+			offset = 0
+			partial = ""
+			_, err = logFile.Seek(0, io.SeekStart)
+			if err != nil {
+				return traceutility.Wrap(err)
+			}
+			reader.Reset(logFile)
+			continue
+		}
+		running, err := r.containerRunning(containerID)
+		if err != nil {
+			return traceutility.Wrap(err)
+		}
+		if !running {
+			stopped = true
+			continue
+		}
+
+		select {
+		case <-time.After(logPollInterval):
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+func (r *Runtime) logOffset(containerID string, since string) int64 {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	sinceTime, err := time.Parse(time.RFC3339Nano, since)
+	if err != nil {
+		return 0
+	}
+
+	var offset int64
+	for _, checkpoint := range r.logCheckpoints[containerID] {
+		if !checkpoint.time.After(sinceTime) {
+			offset = checkpoint.offset
+		}
+	}
+	return offset
+}
+
+func (r *Runtime) addLogCheckpoint(containerID string, checkpoint logCheckpoint) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	checkpoints := append(r.logCheckpoints[containerID], checkpoint)
+	if len(checkpoints) > maxLogCheckpoints {
+		checkpoints = checkpoints[len(checkpoints)-maxLogCheckpoints:]
+	}
+	r.logCheckpoints[containerID] = checkpoints
+}
+
+func (r *Runtime) removeLogs(containerID string) {
+	r.mutex.Lock()
+	delete(r.logCheckpoints, containerID)
+	r.mutex.Unlock()
+
+	for _, path := range []string{r.logFile(containerID), r.logFile(containerID) + ".1"} {
+		err := os.Remove(path)
+		if err != nil && !os.IsNotExist(err) {
+			log.Errorf("Unable to remove log file of container %s: %s", containerID, err)
+		}
+	}
+}
+
+// rotateLogs rotates the log files of all containers every logRotateInterval
+func (r *Runtime) rotateLogs() {
+	for range time.Tick(logRotateInterval) {
+		entries, err := os.ReadDir(filepath.Join(r.stateDir, "logs"))
+		if err != nil {
+			if !os.IsNotExist(err) {
+				log.Error("Unable to read the log files! CAUSE --> ", err)
+			}
+			continue
+		}
+		for _, entry := range entries {
+			containerID, found := strings.CutSuffix(entry.Name(), ".log")
+			if !found {
+				continue
+			}
+			err = r.rotateLog(containerID)
+			if err != nil {
+				log.Errorf("Unable to rotate log file of container %s: %s", containerID, err)
+			}
+		}
+	}
+}
+
+// rotateLog copies the log file to the backup file and truncates it once it is larger than maxLogFileSize.
+// The shim keeps the file open and appends to it, so it can't be moved away. The lines written between the copy
+// and the truncation are lost.
+func (r *Runtime) rotateLog(containerID string) error {
+	path := r.logFile(containerID)
+	info, err := os.Stat(path)
+	if err != nil {
+		return traceutility.Wrap(err)
+	}
+	if info.Size() <= maxLogFileSize {
+		return nil
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	err = copyFile(path, path+".1")
+	if err != nil {
+		return traceutility.Wrap(err)
+	}
+	err = os.Truncate(path, 0)
+	if err != nil {
+		return traceutility.Wrap(err)
+	}
+	// the offsets of the reads refer to the old content
+	delete(r.logCheckpoints, containerID)
+	log.Debugln("Rotated log file of container", containerID)
+
+	return nil
+}
+
+func copyFile(source string, target string) error {
+	sourceFile, err := os.Open(source)
+	if err != nil {
+		return traceutility.Wrap(err)
+	}
+	defer sourceFile.Close()
+
+	targetFile, err := os.Create(target)
+	if err != nil {
+		return traceutility.Wrap(err)
+	}
+	defer targetFile.Close()
+
+	_, err = io.Copy(targetFile, sourceFile)
+	if err != nil {
+		return traceutility.Wrap(err)
+	}
+
+	return nil
+}
+
+func (r *Runtime) logFile(containerID string) string {
+	return filepath.Join(r.stateDir, "logs", containerID+".log")
+}
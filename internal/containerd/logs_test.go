@@ -0,0 +1,144 @@
+package containerd
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/weeveiot/weeve-agent/internal/runtime"
+)
+
+const testContainerID = "a1b2c3d4e5f6"
+
+// appendLog writes to the log file like the shim does
+func appendLog(t *testing.T, r *Runtime, content string) {
+	err := os.MkdirAll(filepath.Dir(r.logFile(testContainerID)), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	logFile, err := os.OpenFile(r.logFile(testContainerID), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer logFile.Close()
+
+	_, err = logFile.WriteString(content)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func lineTexts(lines []runtime.LogLine) []string {
+	texts := []string{}
+	for _, line := range lines {
+		texts = append(texts, line.Line)
+	}
+	return texts
+}
+
+func TestReadContainerLogs(t *testing.T) {
+	assert := assert.New(t)
+	r := testRuntime(t)
+
+	lines, err := r.ReadContainerLogs(testContainerID, "", "")
+	assert.NoError(err)
+	assert.Empty(lines)
+
+	appendLog(t, r, "first\nsecond\nthi")
+	firstUntil := time.Now().UTC().Format(time.RFC3339Nano)
+	lines, err = r.ReadContainerLogs(testContainerID, "", firstUntil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the incomplete line is left for the next read
+	assert.Equal([]string{"first", "second"}, lineTexts(lines))
+	if assert.Len(lines, 2) {
+		assert.Equal(int64(len("first\n")), lines[0].Cursor.Offset)
+		assert.Equal(int64(len("first\nsecond\n")), lines[1].Cursor.Offset)
+	}
+
+	appendLog(t, r, "rd\nfourth\n")
+	secondUntil := time.Now().UTC().Add(time.Millisecond).Format(time.RFC3339Nano)
+	lines, err = r.ReadContainerLogs(testContainerID, firstUntil, secondUntil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal([]string{"third", "fourth"}, lineTexts(lines))
+
+	// without a read that ended at since, the file is read from the beginning
+	lines, err = r.ReadContainerLogs(testContainerID, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal([]string{"first", "second", "third", "fourth"}, lineTexts(lines))
+
+	lines, err = r.ReadContainerLogs(testContainerID, secondUntil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Empty(lines)
+}
+
+func TestAddLogCheckpoint(t *testing.T) {
+	assert := assert.New(t)
+	r := testRuntime(t)
+	start := time.Date(2023, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	for i := 0; i < maxLogCheckpoints+5; i++ {
+		r.addLogCheckpoint(testContainerID, logCheckpoint{time: start.Add(time.Duration(i) * time.Second), offset: int64(i * 10)})
+	}
+	assert.Len(r.logCheckpoints[testContainerID], maxLogCheckpoints)
+
+	assert.Equal(int64(140), r.logOffset(testContainerID, start.Add(time.Minute).Format(time.RFC3339Nano)))
+	assert.Equal(int64(100), r.logOffset(testContainerID, start.Add(10*time.Second).Format(time.RFC3339Nano)))
+	// the checkpoint was dropped already
+	assert.Equal(int64(0), r.logOffset(testContainerID, start.Add(time.Second).Format(time.RFC3339Nano)))
+	assert.Equal(int64(0), r.logOffset(testContainerID, "not a time"))
+}
+
+func TestRotateLog(t *testing.T) {
+	assert := assert.New(t)
+	r := testRuntime(t)
+	line := strings.Repeat("x", 1023) + "\n"
+
+	appendLog(t, r, line)
+	assert.NoError(r.rotateLog(testContainerID))
+	_, err := os.Stat(r.logFile(testContainerID) + ".1")
+	assert.True(os.IsNotExist(err))
+
+	appendLog(t, r, strings.Repeat(line, maxLogFileSize/len(line)))
+	until := time.Now().UTC().Format(time.RFC3339Nano)
+	lines, err := r.ReadContainerLogs(testContainerID, "", until)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Len(lines, maxLogFileSize/len(line)+1)
+
+	assert.NoError(r.rotateLog(testContainerID))
+	info, err := os.Stat(r.logFile(testContainerID))
+	if assert.NoError(err) {
+		assert.Equal(int64(0), info.Size())
+	}
+	info, err = os.Stat(r.logFile(testContainerID) + ".1")
+	if assert.NoError(err) {
+		assert.Equal(int64(maxLogFileSize+len(line)), info.Size())
+	}
+
+	// the shim keeps appending, the next read starts at the beginning of the rotated file
+	appendLog(t, r, "after rotation\n")
+	lines, err = r.ReadContainerLogs(testContainerID, until, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal([]string{"after rotation"}, lineTexts(lines))
+
+	r.removeLogs(testContainerID)
+	_, err = os.Stat(r.logFile(testContainerID))
+	assert.True(os.IsNotExist(err))
+	_, err = os.Stat(r.logFile(testContainerID) + ".1")
+	assert.True(os.IsNotExist(err))
+}
@@ -0,0 +1,356 @@
+package containerd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	gocni "github.com/containerd/go-cni"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/network"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/weeveiot/weeve-agent/internal/model"
+	traceutility "github.com/weeveiot/weeve-agent/internal/utility/trace"
+)
+
+// Network name constraints
+const manifestNamelength = 11
+const maxNetworkIndex = 254
+
+const networksFile = "networks.json"
+const hostsFileHeader = "127.0.0.1\tlocalhost\n::1\tlocalhost ip6-localhost ip6-loopback\n"
+
+// networkRecord describes a CNI bridge network of an edge app.
+// containerd has no notion of networks, so they are kept in a state file.
+type networkRecord struct {
+	Name   string
+	Index  int
+	Subnet string
+	Bridge string
+	Labels map[string]string
+}
+
+func (r *Runtime) CreateNetwork(name string, labels map[string]string) (string, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if name == "" {
+		return "", errors.New("failed to generate network name")
+	} else if len(name) > manifestNamelength {
+		name = name[:manifestNamelength]
+	}
+
+	networks, err := r.readNetworks()
+	if err != nil {
+		return "", traceutility.Wrap(err)
+	}
+
+	index := lowestAvailableNetworkIndex(networks)
+	if index < 0 {
+		log.Warning("Number of edge apps limit is exceeded")
+		return "", errors.New("failed to generate network name")
+	}
+
+	netRecord := networkRecord{
+		Name:   strings.ReplaceAll(fmt.Sprintf("%s_%03d", name, index), " ", ""),
+		Index:  index,
+		Subnet: fmt.Sprintf("10.89.%d.0/24", index),
+		Bridge: fmt.Sprintf("weeve%d", index),
+		Labels: labels,
+	}
+	networks[netRecord.Name] = netRecord
+
+	err = os.MkdirAll(r.networkDir(netRecord.Name), 0755)
+	if err != nil {
+		return "", traceutility.Wrap(err)
+	}
+	err = os.WriteFile(r.hostsFile(netRecord.Name), []byte(hostsFileHeader), 0644)
+	if err != nil {
+		return "", traceutility.Wrap(err)
+	}
+
+	err = r.writeNetworks(networks)
+	if err != nil {
+		return "", traceutility.Wrap(err)
+	}
+	log.Debugln("Created network", netRecord.Name, "with subnet", netRecord.Subnet, "on bridge", netRecord.Bridge)
+
+	return netRecord.Name, nil
+}
+
+func (r *Runtime) ReadEdgeAppNetworks(manifestUniqueID model.ManifestUniqueID) ([]types.NetworkResource, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	networks, err := r.readNetworks()
+	if err != nil {
+		return nil, traceutility.Wrap(err)
+	}
+
+	var networkResources []types.NetworkResource
+	for _, netRecord := range networks {
+		if netRecord.Labels["manifestUniqueID"] == manifestUniqueID.String() {
+			networkResources = append(networkResources, toNetworkResource(netRecord))
+		}
+	}
+
+	return networkResources, nil
+}
+
+func (r *Runtime) ReadAllEdgeAppNetworks() ([]types.NetworkResource, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	networks, err := r.readNetworks()
+	if err != nil {
+		return nil, traceutility.Wrap(err)
+	}
+
+	var networkResources []types.NetworkResource
+	for _, netRecord := range networks {
+		if _, found := netRecord.Labels["manifestUniqueID"]; found {
+			networkResources = append(networkResources, toNetworkResource(netRecord))
+		}
+	}
+
+	return networkResources, nil
+}
+
+// NetworkPrune removes the edge app networks that no container is attached to
+func (r *Runtime) NetworkPrune(manifestUniqueID model.ManifestUniqueID) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	networks, err := r.readNetworks()
+	if err != nil {
+		return traceutility.Wrap(err)
+	}
+
+	var pruned []string
+	for name, netRecord := range networks {
+		if netRecord.Labels["manifestUniqueID"] != manifestUniqueID.String() {
+			continue
+		}
+
+		attached, err := r.client.Containers(r.ctx, fmt.Sprintf("labels.%q==%q", networkLabel, name))
+		if err != nil {
+			return traceutility.Wrap(err)
+		}
+		if len(attached) > 0 {
+			continue
+		}
+
+		// the bridge interface itself is left in place and reused with the network index
+		err = os.RemoveAll(r.networkDir(name))
+		if err != nil {
+			return traceutility.Wrap(err)
+		}
+		delete(networks, name)
+		pruned = append(pruned, name)
+	}
+
+	err = r.writeNetworks(networks)
+	if err != nil {
+		return traceutility.Wrap(err)
+	}
+	log.Info("Pruned networks: ", pruned)
+
+	return nil
+}
+
+func (r *Runtime) getNetwork(name string) (networkRecord, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	networks, err := r.readNetworks()
+	if err != nil {
+		return networkRecord{}, traceutility.Wrap(err)
+	}
+
+	netRecord, found := networks[name]
+	if !found {
+		return networkRecord{}, fmt.Errorf("network %s not found", name)
+	}
+
+	return netRecord, nil
+}
+
+// networkCNI loads the CNI config of the network: a bridge with host-local IPAM, plus port mapping
+func networkCNI(netRecord networkRecord) (gocni.CNI, error) {
+	confList := map[string]interface{}{
+		"cniVersion": "1.0.0",
+		"name":       netRecord.Name,
+		"plugins": []interface{}{
+			map[string]interface{}{
+				"type":        "bridge",
+				"bridge":      netRecord.Bridge,
+				"isGateway":   true,
+				"ipMasq":      true,
+				"hairpinMode": true,
+				"ipam": map[string]interface{}{
+					"type":   "host-local",
+					"ranges": [][]map[string]string{{{"subnet": netRecord.Subnet}}},
+					"routes": []map[string]string{{"dst": "0.0.0.0/0"}},
+				},
+			},
+			map[string]interface{}{
+				"type":         "portmap",
+				"capabilities": map[string]bool{"portMappings": true},
+			},
+		},
+	}
+
+	confListBytes, err := json.Marshal(confList)
+	if err != nil {
+		return nil, traceutility.Wrap(err)
+	}
+
+	cni, err := gocni.New(
+		gocni.WithPluginDir([]string{cniPluginDir}),
+		gocni.WithMinNetworkCount(2),
+	)
+	if err != nil {
+		return nil, traceutility.Wrap(err)
+	}
+
+	err = cni.Load(gocni.WithLoNetwork, gocni.WithConfListBytes(confListBytes))
+	if err != nil {
+		return nil, traceutility.Wrap(err)
+	}
+
+	return cni, nil
+}
+
+// addHostEntry makes the container reachable by its name from all containers of the network
+func (r *Runtime) addHostEntry(networkName string, ip string, hostName string) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	hostsFile, err := os.OpenFile(r.hostsFile(networkName), os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return traceutility.Wrap(err)
+	}
+	defer hostsFile.Close()
+
+	_, err = fmt.Fprintf(hostsFile, "%s\t%s\n", ip, hostName)
+	if err != nil {
+		return traceutility.Wrap(err)
+	}
+
+	return nil
+}
+
+func (r *Runtime) removeHostEntry(networkName string, hostName string) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	content, err := os.ReadFile(r.hostsFile(networkName))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return traceutility.Wrap(err)
+	}
+
+	var lines []string
+	for _, line := range strings.SplitAfter(string(content), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 2 && fields[1] == hostName {
+			continue
+		}
+		lines = append(lines, line)
+	}
+
+	// the file is bind mounted into the containers, so it has to be rewritten in place
+	err = os.WriteFile(r.hostsFile(networkName), []byte(strings.Join(lines, "")), 0644)
+	if err != nil {
+		return traceutility.Wrap(err)
+	}
+
+	return nil
+}
+
+func (r *Runtime) networkDir(networkName string) string {
+	return filepath.Join(r.stateDir, "networks", networkName)
+}
+
+func (r *Runtime) hostsFile(networkName string) string {
+	return filepath.Join(r.networkDir(networkName), "hosts")
+}
+
+func (r *Runtime) readNetworks() (map[string]networkRecord, error) {
+	networks := make(map[string]networkRecord)
+
+	content, err := os.ReadFile(filepath.Join(r.stateDir, networksFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return networks, nil
+		}
+		return nil, traceutility.Wrap(err)
+	}
+
+	err = json.Unmarshal(content, &networks)
+	if err != nil {
+		return nil, traceutility.Wrap(err)
+	}
+
+	return networks, nil
+}
+
+func (r *Runtime) writeNetworks(networks map[string]networkRecord) error {
+	encodedJson, err := json.MarshalIndent(networks, "", " ")
+	if err != nil {
+		return traceutility.Wrap(err)
+	}
+
+	err = os.MkdirAll(r.stateDir, 0755)
+	if err != nil {
+		return traceutility.Wrap(err)
+	}
+
+	err = os.WriteFile(filepath.Join(r.stateDir, networksFile), encodedJson, 0644)
+	if err != nil {
+		return traceutility.Wrap(err)
+	}
+
+	return nil
+}
+
+func lowestAvailableNetworkIndex(networks map[string]networkRecord) int {
+	var used []int
+	for _, netRecord := range networks {
+		used = append(used, netRecord.Index)
+	}
+	sort.Ints(used)
+
+	index := 1
+	for _, usedIndex := range used {
+		if usedIndex == index {
+			index++
+		}
+	}
+	if index > maxNetworkIndex {
+		return -1
+	}
+
+	return index
+}
+
+func toNetworkResource(netRecord networkRecord) types.NetworkResource {
+	return types.NetworkResource{
+		Name:       netRecord.Name,
+		ID:         netRecord.Name,
+		Driver:     "bridge",
+		Attachable: true,
+		Labels:     netRecord.Labels,
+		IPAM: network.IPAM{
+			Driver: "host-local",
+			Config: []network.IPAMConfig{{Subnet: netRecord.Subnet}},
+		},
+	}
+}
@@ -0,0 +1,126 @@
+package containerd
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/weeveiot/weeve-agent/internal/model"
+)
+
+// testRuntime works on the state dir only, the functions that need containerd can't be called
+func testRuntime(t *testing.T) *Runtime {
+	return &Runtime{
+		stateDir:       t.TempDir(),
+		logCheckpoints: make(map[string][]logCheckpoint),
+	}
+}
+
+func TestCreateNetwork(t *testing.T) {
+	assert := assert.New(t)
+	r := testRuntime(t)
+	uniqueID := model.ManifestUniqueID{ID: "62bef68d664ed72f8ecdd690"}
+	labels := map[string]string{"manifestUniqueID": uniqueID.String()}
+
+	first, err := r.CreateNetwork("kunbus demo manifest", labels)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal("kunbusdemo_001", first)
+	second, err := r.CreateNetwork("other", map[string]string{"manifestUniqueID": "other"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal("other_002", second)
+
+	// the state survives a restart of the agent
+	networks, err := (&Runtime{stateDir: r.stateDir}).readNetworks()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(networkRecord{
+		Name:   "kunbusdemo_001",
+		Index:  1,
+		Subnet: "10.89.1.0/24",
+		Bridge: "weeve1",
+		Labels: labels,
+	}, networks[first])
+	assert.Len(networks, 2)
+
+	hosts, err := os.ReadFile(filepath.Join(r.stateDir, "networks", first, "hosts"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(hostsFileHeader, string(hosts))
+
+	edgeAppNetworks, err := r.ReadEdgeAppNetworks(uniqueID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if assert.Len(edgeAppNetworks, 1) {
+		assert.Equal(first, edgeAppNetworks[0].Name)
+		assert.Equal("10.89.1.0/24", edgeAppNetworks[0].IPAM.Config[0].Subnet)
+	}
+
+	// the index of a removed network is used again
+	delete(networks, first)
+	err = r.writeNetworks(networks)
+	if err != nil {
+		t.Fatal(err)
+	}
+	third, err := r.CreateNetwork("third", labels)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal("third_001", third)
+}
+
+func TestLowestAvailableNetworkIndex(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal(1, lowestAvailableNetworkIndex(map[string]networkRecord{}))
+	assert.Equal(2, lowestAvailableNetworkIndex(map[string]networkRecord{"a": {Index: 1}, "c": {Index: 3}}))
+
+	full := make(map[string]networkRecord)
+	for i := 1; i <= maxNetworkIndex; i++ {
+		full[string(rune(i))] = networkRecord{Index: i}
+	}
+	assert.Equal(-1, lowestAvailableNetworkIndex(full))
+}
+
+func TestHostEntries(t *testing.T) {
+	assert := assert.New(t)
+	r := testRuntime(t)
+
+	networkName, err := r.CreateNetwork("hosts", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hostsPath := r.hostsFile(networkName)
+	before, err := os.Stat(hostsPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.NoError(r.addHostEntry(networkName, "10.89.1.2", "ingress"))
+	assert.NoError(r.addHostEntry(networkName, "10.89.1.3", "egress"))
+	assert.NoError(r.removeHostEntry(networkName, "ingress"))
+
+	hosts, err := os.ReadFile(hostsPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(hostsFileHeader+"10.89.1.3\tegress\n", string(hosts))
+
+	// the file is bind mounted into the containers, replacing it would hide the changes from them
+	after, err := os.Stat(hostsPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.True(os.SameFile(before, after))
+
+	// the hosts file of a removed network is gone already
+	assert.NoError(r.removeHostEntry("gone_003", "ingress"))
+}
@@ -0,0 +1,70 @@
+// Package containerd runs edge apps directly on containerd, without a Docker engine.
+//
+// All containers live in a dedicated namespace and carry the manifest labels.
+// Each edge app network is a CNI bridge network with its own subnet; the containers
+// of a network find each other through a shared hosts file. Container output is
+// written to log files by the containerd shim, which are rotated by the agent.
+package containerd
+
+import (
+	"context"
+	"path/filepath"
+	"sync"
+
+	containerdclient "github.com/containerd/containerd"
+	"github.com/containerd/containerd/namespaces"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/weeveiot/weeve-agent/internal/runtime"
+	traceutility "github.com/weeveiot/weeve-agent/internal/utility/trace"
+)
+
+const (
+	DefaultSocketPath = "/run/containerd/containerd.sock"
+	Namespace         = "weeve-agent"
+	stateDirName      = "containerd"
+	netnsDir          = "/var/run/netns"
+	cniPluginDir      = "/opt/cni/bin"
+)
+
+// Runtime runs edge apps on containerd
+type Runtime struct {
+	client   *containerdclient.Client
+	ctx      context.Context
+	stateDir string
+	// guards the network state file, the hosts files and the log checkpoints
+	mutex          sync.Mutex
+	logCheckpoints map[string][]logCheckpoint
+}
+
+var _ runtime.Runtime = (*Runtime)(nil)
+
+// NewRuntime connects to containerd on the given unix socket.
+// If socketPath is empty, the default socket of containerd is used.
+func NewRuntime(socketPath string) (*Runtime, error) {
+	if socketPath == "" {
+		socketPath = DefaultSocketPath
+	}
+	log.Debug("Initalizing containerd client on ", socketPath)
+
+	client, err := containerdclient.New(socketPath, containerdclient.WithDefaultNamespace(Namespace))
+	if err != nil {
+		return nil, traceutility.Wrap(err)
+	}
+
+	// the state dir is bind mounted into containers, so it has to be absolute
+	stateDir, err := filepath.Abs(stateDirName)
+	if err != nil {
+		return nil, traceutility.Wrap(err)
+	}
+
+	r := &Runtime{
+		client:         client,
+		ctx:            namespaces.WithNamespace(context.Background(), Namespace),
+		stateDir:       stateDir,
+		logCheckpoints: make(map[string][]logCheckpoint),
+	}
+	go r.rotateLogs()
+
+	return r, nil
+}
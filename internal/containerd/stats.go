@@ -0,0 +1,142 @@
+package containerd
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	v1 "github.com/containerd/cgroups/stats/v1"
+	v2 "github.com/containerd/cgroups/v2/stats"
+	"github.com/containerd/typeurl"
+	"github.com/docker/docker/api/types"
+
+	traceutility "github.com/weeveiot/weeve-agent/internal/utility/trace"
+)
+
+// ReadContainerStats translates the cgroup metrics of the task into the Docker stats.
+// The network stats are read from the network namespace of the task.
+func (r *Runtime) ReadContainerStats(containerID string) (types.StatsJSON, error) {
+	cont, err := r.client.LoadContainer(r.ctx, containerID)
+	if err != nil {
+		return types.StatsJSON{}, traceutility.Wrap(err)
+	}
+
+	task, err := cont.Task(r.ctx, nil)
+	if err != nil {
+		return types.StatsJSON{}, traceutility.Wrap(err)
+	}
+
+	metric, err := task.Metrics(r.ctx)
+	if err != nil {
+		return types.StatsJSON{}, traceutility.Wrap(err)
+	}
+
+	data, err := typeurl.UnmarshalAny(metric.Data)
+	if err != nil {
+		return types.StatsJSON{}, traceutility.Wrap(err)
+	}
+
+	stats, err := statsFromMetrics(data)
+	if err != nil {
+		return types.StatsJSON{}, traceutility.Wrap(err)
+	}
+	stats.ID = containerID
+	stats.Read = time.Now()
+
+	stats.Networks, err = readNetworkStats(task.Pid())
+	if err != nil {
+		return types.StatsJSON{}, traceutility.Wrap(err)
+	}
+
+	return stats, nil
+}
+
+// statsFromMetrics translates the cgroup v1 or v2 metrics of a task into the Docker stats
+func statsFromMetrics(data interface{}) (types.StatsJSON, error) {
+	var stats types.StatsJSON
+	switch metrics := data.(type) {
+	case *v1.Metrics:
+		if metrics.CPU != nil && metrics.CPU.Usage != nil {
+			stats.CPUStats.CPUUsage.TotalUsage = metrics.CPU.Usage.Total
+		}
+		if metrics.Memory != nil && metrics.Memory.Usage != nil {
+			stats.MemoryStats.Usage = metrics.Memory.Usage.Usage
+			stats.MemoryStats.Limit = metrics.Memory.Usage.Limit
+			stats.MemoryStats.Stats = map[string]uint64{"total_inactive_file": metrics.Memory.TotalInactiveFile}
+		}
+		if metrics.Blkio != nil {
+			for _, entry := range metrics.Blkio.IoServiceBytesRecursive {
+				stats.BlkioStats.IoServiceBytesRecursive = append(stats.BlkioStats.IoServiceBytesRecursive,
+					types.BlkioStatEntry{Major: entry.Major, Minor: entry.Minor, Op: entry.Op, Value: entry.Value})
+			}
+		}
+	case *v2.Metrics:
+		if metrics.CPU != nil {
+			stats.CPUStats.CPUUsage.TotalUsage = metrics.CPU.UsageUsec * 1000
+		}
+		if metrics.Memory != nil {
+			stats.MemoryStats.Usage = metrics.Memory.Usage
+			stats.MemoryStats.Limit = metrics.Memory.UsageLimit
+			stats.MemoryStats.Stats = map[string]uint64{"inactive_file": metrics.Memory.InactiveFile}
+		}
+		if metrics.Io != nil {
+			for _, entry := range metrics.Io.Usage {
+				stats.BlkioStats.IoServiceBytesRecursive = append(stats.BlkioStats.IoServiceBytesRecursive,
+					types.BlkioStatEntry{Major: entry.Major, Minor: entry.Minor, Op: "read", Value: entry.Rbytes},
+					types.BlkioStatEntry{Major: entry.Major, Minor: entry.Minor, Op: "write", Value: entry.Wbytes})
+			}
+		}
+	default:
+		return types.StatsJSON{}, fmt.Errorf("unknown metrics type %T", data)
+	}
+
+	return stats, nil
+}
+
+// readNetworkStats reads the traffic of the interfaces in the network namespace of the process
+func readNetworkStats(pid uint32) (map[string]types.NetworkStats, error) {
+	file, err := os.Open(fmt.Sprintf("/proc/%d/net/dev", pid))
+	if err != nil {
+		return nil, traceutility.Wrap(err)
+	}
+	defer file.Close()
+
+	return parseNetworkStats(file)
+}
+
+// parseNetworkStats parses the content of /proc/<pid>/net/dev, leaving out the loopback interface
+func parseNetworkStats(netDev io.Reader) (map[string]types.NetworkStats, error) {
+	networks := make(map[string]types.NetworkStats)
+	scanner := bufio.NewScanner(netDev)
+	for scanner.Scan() {
+		// after two header lines: "  eth0: rx_bytes rx_packets ... (8 receive columns) tx_bytes tx_packets ..."
+		name, counters, found := strings.Cut(scanner.Text(), ":")
+		name = strings.TrimSpace(name)
+		if !found || name == "lo" {
+			continue
+		}
+		fields := strings.Fields(counters)
+		if len(fields) < 16 {
+			continue
+		}
+		var network types.NetworkStats
+		network.RxBytes, _ = strconv.ParseUint(fields[0], 10, 64)
+		network.RxPackets, _ = strconv.ParseUint(fields[1], 10, 64)
+		network.RxErrors, _ = strconv.ParseUint(fields[2], 10, 64)
+		network.RxDropped, _ = strconv.ParseUint(fields[3], 10, 64)
+		network.TxBytes, _ = strconv.ParseUint(fields[8], 10, 64)
+		network.TxPackets, _ = strconv.ParseUint(fields[9], 10, 64)
+		network.TxErrors, _ = strconv.ParseUint(fields[10], 10, 64)
+		network.TxDropped, _ = strconv.ParseUint(fields[11], 10, 64)
+		networks[name] = network
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, traceutility.Wrap(err)
+	}
+
+	return networks, nil
+}
@@ -0,0 +1,88 @@
+package containerd
+
+import (
+	"strings"
+	"testing"
+
+	v1 "github.com/containerd/cgroups/stats/v1"
+	v2 "github.com/containerd/cgroups/v2/stats"
+	"github.com/docker/docker/api/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStatsFromMetrics_CgroupV1(t *testing.T) {
+	assert := assert.New(t)
+
+	stats, err := statsFromMetrics(&v1.Metrics{
+		CPU:    &v1.CPUStat{Usage: &v1.CPUUsage{Total: 2500000000}},
+		Memory: &v1.MemoryStat{TotalInactiveFile: 1024, Usage: &v1.MemoryEntry{Usage: 4096, Limit: 8192}},
+		Blkio: &v1.BlkIOStat{IoServiceBytesRecursive: []*v1.BlkIOEntry{
+			{Op: "Read", Major: 8, Value: 100},
+			{Op: "Write", Major: 8, Value: 200},
+		}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(uint64(2500000000), stats.CPUStats.CPUUsage.TotalUsage)
+	assert.Equal(uint64(4096), stats.MemoryStats.Usage)
+	assert.Equal(uint64(8192), stats.MemoryStats.Limit)
+	assert.Equal(map[string]uint64{"total_inactive_file": 1024}, stats.MemoryStats.Stats)
+	assert.Equal([]types.BlkioStatEntry{
+		{Major: 8, Op: "Read", Value: 100},
+		{Major: 8, Op: "Write", Value: 200},
+	}, stats.BlkioStats.IoServiceBytesRecursive)
+}
+
+func TestStatsFromMetrics_CgroupV2(t *testing.T) {
+	assert := assert.New(t)
+
+	stats, err := statsFromMetrics(&v2.Metrics{
+		CPU:    &v2.CPUStat{UsageUsec: 2500000},
+		Memory: &v2.MemoryStat{Usage: 4096, UsageLimit: 8192, InactiveFile: 1024},
+		Io:     &v2.IOStat{Usage: []*v2.IOEntry{{Major: 8, Rbytes: 100, Wbytes: 200}}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the CPU time is given in microseconds, Docker reports nanoseconds
+	assert.Equal(uint64(2500000000), stats.CPUStats.CPUUsage.TotalUsage)
+	assert.Equal(uint64(4096), stats.MemoryStats.Usage)
+	assert.Equal(uint64(8192), stats.MemoryStats.Limit)
+	assert.Equal(map[string]uint64{"inactive_file": 1024}, stats.MemoryStats.Stats)
+	assert.Equal([]types.BlkioStatEntry{
+		{Major: 8, Op: "read", Value: 100},
+		{Major: 8, Op: "write", Value: 200},
+	}, stats.BlkioStats.IoServiceBytesRecursive)
+}
+
+func TestStatsFromMetrics_Empty(t *testing.T) {
+	assert := assert.New(t)
+
+	// the controllers that aren't enabled are left out of the metrics
+	stats, err := statsFromMetrics(&v2.Metrics{})
+	assert.NoError(err)
+	assert.Equal(types.StatsJSON{}, stats)
+
+	_, err = statsFromMetrics("metrics")
+	assert.Error(err)
+}
+
+func TestParseNetworkStats(t *testing.T) {
+	assert := assert.New(t)
+	netDev := `Inter-|   Receive                                                |  Transmit
+ face |bytes    packets errs drop fifo frame compressed multicast|bytes    packets errs drop fifo colls carrier compressed
+    lo:     840      10    0    0    0     0          0         0      840      10    0    0    0     0       0          0
+  eth0:   12345     100    1    2    0     0          0         0     6789      50    3    4    0     0       0          0
+`
+
+	networks, err := parseNetworkStats(strings.NewReader(netDev))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(map[string]types.NetworkStats{
+		"eth0": {RxBytes: 12345, RxPackets: 100, RxErrors: 1, RxDropped: 2, TxBytes: 6789, TxPackets: 50, TxErrors: 3, TxDropped: 4},
+	}, networks)
+}
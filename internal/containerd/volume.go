@@ -0,0 +1,94 @@
+package containerd
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/docker/docker/api/types/volume"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/weeveiot/weeve-agent/internal/manifest"
+	"github.com/weeveiot/weeve-agent/internal/model"
+	traceutility "github.com/weeveiot/weeve-agent/internal/utility/trace"
+)
+
+func (r *Runtime) volumeDir(volumeName string) string {
+	return filepath.Join(r.stateDir, "volumes", volumeName)
+}
+
+// ReadEdgeAppVolumes returns the volume directories of an edge app with the disk space they use
+func (r *Runtime) ReadEdgeAppVolumes(manifestUniqueID model.ManifestUniqueID) ([]*volume.Volume, error) {
+	log.Debug("Containerd_volume -> ReadEdgeAppVolumes")
+
+	entries, err := os.ReadDir(filepath.Join(r.stateDir, "volumes"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, traceutility.Wrap(err)
+	}
+
+	var volumes []*volume.Volume
+	for _, entry := range entries {
+		name, found := manifest.VolumeOfEdgeApp(manifestUniqueID, entry.Name())
+		if !found || !entry.IsDir() {
+			continue
+		}
+
+		size, err := dirSize(r.volumeDir(entry.Name()))
+		if err != nil {
+			log.Warning("Reading the size of volume ", entry.Name(), " failed! CAUSE --> ", err)
+			size = -1
+		}
+		volumes = append(volumes, &volume.Volume{
+			Name:       entry.Name(),
+			Driver:     "local",
+			Mountpoint: r.volumeDir(entry.Name()),
+			Labels: map[string]string{
+				"manifestUniqueID":   manifestUniqueID.String(),
+				manifest.VolumeLabel: name,
+			},
+			UsageData: &volume.UsageData{Size: size, RefCount: -1},
+		})
+	}
+
+	return volumes, nil
+}
+
+// RemoveEdgeAppVolumes removes the volume directories of an edge app, its containers must be removed already
+func (r *Runtime) RemoveEdgeAppVolumes(manifestUniqueID model.ManifestUniqueID) error {
+	log.Debug("Containerd_volume -> RemoveEdgeAppVolumes")
+
+	volumes, err := r.ReadEdgeAppVolumes(manifestUniqueID)
+	if err != nil {
+		return traceutility.Wrap(err)
+	}
+	for _, vol := range volumes {
+		err = os.RemoveAll(vol.Mountpoint)
+		if err != nil {
+			return traceutility.Wrap(err)
+		}
+		log.Info("Removed volume ", vol.Name)
+	}
+
+	return nil
+}
+
+func dirSize(dir string) (int64, error) {
+	var size int64
+	err := filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.Type().IsRegular() {
+			info, err := entry.Info()
+			if err != nil {
+				return err
+			}
+			size += info.Size()
+		}
+		return nil
+	})
+	return size, err
+}
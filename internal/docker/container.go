@@ -2,8 +2,7 @@ package docker
 
 import (
 	"context"
-	"encoding/binary"
-	"io"
+	"encoding/json"
 
 	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/api/types/container"
@@ -14,23 +13,48 @@ import (
 
 	"github.com/weeveiot/weeve-agent/internal/manifest"
 	"github.com/weeveiot/weeve-agent/internal/model"
+	"github.com/weeveiot/weeve-agent/internal/runtime"
 	traceutility "github.com/weeveiot/weeve-agent/internal/utility/trace"
 )
 
 var ctx = context.Background()
-var dockerClient *client.Client
 
-func SetupDockerClient() {
+// From https://docs.docker.com/config/containers/logging/local/: By default, the local driver preserves 100MB of log messages per container and uses automatic compression to reduce the size on disk. The 100MB default value is based on a 20M default size for each file and a default count of 5 for the number of such files (to account for log rotation).
+var localLogConfig = container.LogConfig{Type: "local"}
+
+// Runtime runs edge apps on the Docker engine or any other engine that serves the Docker API
+type Runtime struct {
+	client    *client.Client
+	logConfig container.LogConfig
+}
+
+var _ runtime.Runtime = (*Runtime)(nil)
+
+// NewRuntime connects to the Docker engine on the given unix socket.
+// If socketPath is empty, the engine configured in the environment is used.
+func NewRuntime(socketPath string) (*Runtime, error) {
+	opt := client.FromEnv
+	if socketPath != "" {
+		opt = client.WithHost("unix://" + socketPath)
+	}
+	return NewRuntimeWithOpts(localLogConfig, opt)
+}
+
+// NewRuntimeWithOpts connects to a Docker API compatible engine.
+// The log config is applied to every container created by the runtime.
+func NewRuntimeWithOpts(logConfig container.LogConfig, opts ...client.Opt) (*Runtime, error) {
 	log.Debug("Initalizing docker client...")
 
-	var err error
-	dockerClient, err = client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+	opts = append(opts, client.WithAPIVersionNegotiation())
+	dockerClient, err := client.NewClientWithOpts(opts...)
 	if err != nil {
-		log.Fatal("Docker client creation failed! CAUSE --> ", err)
+		return nil, traceutility.Wrap(err)
 	}
+
+	return &Runtime{client: dockerClient, logConfig: logConfig}, nil
 }
 
-func createContainer(containerConfig manifest.ContainerConfig) (string, error) {
+func (r *Runtime) createContainer(containerConfig manifest.ContainerConfig) (string, error) {
 	log.Debugln("Creating container", containerConfig.ContainerName, "from", containerConfig.ImageNameFull)
 
 	config := &container.Config{
@@ -42,12 +66,11 @@ func createContainer(containerConfig manifest.ContainerConfig) (string, error) {
 		Tty:          false,
 		ExposedPorts: containerConfig.ExposedPorts,
 		Labels:       containerConfig.Labels,
+		Healthcheck:  containerConfig.Healthcheck,
 	}
 
 	hostConfig := &container.HostConfig{
-		LogConfig: container.LogConfig{
-			Type: "local", // From https://docs.docker.com/config/containers/logging/local/: By default, the local driver preserves 100MB of log messages per container and uses automatic compression to reduce the size on disk. The 100MB default value is based on a 20M default size for each file and a default count of 5 for the number of such files (to account for log rotation).
-		},
+		LogConfig:    r.logConfig,
 		PortBindings: containerConfig.PortBinding,
 		RestartPolicy: container.RestartPolicy{
 			Name:              "on-failure",
@@ -63,7 +86,7 @@ func createContainer(containerConfig manifest.ContainerConfig) (string, error) {
 		},
 	}
 
-	containerCreateResponse, err := dockerClient.ContainerCreate(ctx,
+	containerCreateResponse, err := r.client.ContainerCreate(ctx,
 		config,
 		hostConfig,
 		networkConfig,
@@ -77,8 +100,8 @@ func createContainer(containerConfig manifest.ContainerConfig) (string, error) {
 	return containerCreateResponse.ID, nil
 }
 
-func StartContainer(containerID string) error {
-	err := dockerClient.ContainerStart(ctx, containerID, types.ContainerStartOptions{})
+func (r *Runtime) StartContainer(containerID string) error {
+	err := r.client.ContainerStart(ctx, containerID, types.ContainerStartOptions{})
 	if err != nil {
 		return traceutility.Wrap(err)
 	}
@@ -87,13 +110,13 @@ func StartContainer(containerID string) error {
 	return nil
 }
 
-func CreateAndStartContainer(containerConfig manifest.ContainerConfig) (string, error) {
-	id, err := createContainer(containerConfig)
+func (r *Runtime) CreateAndStartContainer(containerConfig manifest.ContainerConfig) (string, error) {
+	id, err := r.createContainer(containerConfig)
 	if err != nil {
 		return id, traceutility.Wrap(err)
 	}
 
-	err = StartContainer(id)
+	err = r.StartContainer(id)
 	if err != nil {
 		return id, traceutility.Wrap(err)
 	}
@@ -101,13 +124,13 @@ func CreateAndStartContainer(containerConfig manifest.ContainerConfig) (string,
 	return id, nil
 }
 
-func StopContainer(containerID string) error {
+func (r *Runtime) StopContainer(containerID string) error {
 	stopTimeout := 2
-	return dockerClient.ContainerStop(ctx, containerID, container.StopOptions{Timeout: &stopTimeout})
+	return r.client.ContainerStop(ctx, containerID, container.StopOptions{Timeout: &stopTimeout})
 }
 
-func StopAndRemoveContainer(containerID string) error {
-	if err := StopContainer(containerID); err != nil {
+func (r *Runtime) StopAndRemoveContainer(containerID string) error {
+	if err := r.StopContainer(containerID); err != nil {
 		log.Errorf("Unable to stop container %s: %s. Will try to force remove...", containerID, err)
 	}
 
@@ -116,7 +139,7 @@ func StopAndRemoveContainer(containerID string) error {
 		Force:         true,
 	}
 
-	if err := dockerClient.ContainerRemove(ctx, containerID, removeOptions); err != nil {
+	if err := r.client.ContainerRemove(ctx, containerID, removeOptions); err != nil {
 		log.Errorf("Unable to remove container: %s", err)
 		return traceutility.Wrap(err)
 	}
@@ -124,10 +147,10 @@ func StopAndRemoveContainer(containerID string) error {
 	return nil
 }
 
-func ReadAllContainers() ([]types.Container, error) {
+func (r *Runtime) ReadAllContainers() ([]types.Container, error) {
 	log.Debug("Docker_container -> ReadAllContainers")
 	options := types.ContainerListOptions{All: true}
-	containers, err := dockerClient.ContainerList(context.Background(), options)
+	containers, err := r.client.ContainerList(context.Background(), options)
 	if err != nil {
 		return nil, traceutility.Wrap(err)
 	}
@@ -136,11 +159,11 @@ func ReadAllContainers() ([]types.Container, error) {
 	return containers, nil
 }
 
-func ReadEdgeAppContainers(manifestUniqueID model.ManifestUniqueID) ([]types.Container, error) {
+func (r *Runtime) ReadEdgeAppContainers(manifestUniqueID model.ManifestUniqueID) ([]types.Container, error) {
 	filter := filters.NewArgs()
 	filter.Add("label", "manifestUniqueID="+manifestUniqueID.String())
 	options := types.ContainerListOptions{All: true, Filters: filter}
-	containers, err := dockerClient.ContainerList(context.Background(), options)
+	containers, err := r.client.ContainerList(context.Background(), options)
 	if err != nil {
 		return nil, traceutility.Wrap(err)
 	}
@@ -148,51 +171,27 @@ func ReadEdgeAppContainers(manifestUniqueID model.ManifestUniqueID) ([]types.Con
 	return containers, nil
 }
 
-func ReadContainerLogs(containerID string, since string, until string) ([]string, error) {
-	logLines := []string{}
-
-	options := types.ContainerLogsOptions{
-		ShowStdout: true,
-		ShowStderr: true,
-		Since:      since,
-		Until:      until,
+func (r *Runtime) InspectContainer(containerID string) (types.ContainerJSON, error) {
+	containerJSON, err := r.client.ContainerInspect(context.Background(), containerID)
+	if err != nil {
+		return types.ContainerJSON{}, traceutility.Wrap(err)
 	}
 
-	reader, err := dockerClient.ContainerLogs(context.Background(), containerID, options)
+	return containerJSON, nil
+}
+
+func (r *Runtime) ReadContainerStats(containerID string) (types.StatsJSON, error) {
+	stats, err := r.client.ContainerStatsOneShot(ctx, containerID)
 	if err != nil {
-		return logLines, traceutility.Wrap(err)
-	}
-	defer reader.Close()
-
-	header := make([]byte, 8)
-	for {
-		_, err := reader.Read(header)
-		if err != nil {
-			if err == io.EOF {
-				return logLines, nil
-			}
-			return logLines, traceutility.Wrap(err)
-		}
-
-		count := binary.BigEndian.Uint32(header[4:])
-		line := make([]byte, count)
-		_, err = reader.Read(line)
-		if err != nil {
-			if err == io.EOF {
-				return logLines, nil
-			}
-			return logLines, traceutility.Wrap(err)
-		}
-
-		logLines = append(logLines, string(line))
+		return types.StatsJSON{}, traceutility.Wrap(err)
 	}
-}
+	defer stats.Body.Close()
 
-func InspectContainer(containerID string) (types.ContainerJSON, error) {
-	containerJSON, err := dockerClient.ContainerInspect(context.Background(), containerID)
+	var statsJSON types.StatsJSON
+	err = json.NewDecoder(stats.Body).Decode(&statsJSON)
 	if err != nil {
-		return types.ContainerJSON{}, traceutility.Wrap(err)
+		return types.StatsJSON{}, traceutility.Wrap(err)
 	}
 
-	return containerJSON, nil
+	return statsJSON, nil
 }
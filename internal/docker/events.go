@@ -0,0 +1,17 @@
+package docker
+
+import (
+	"context"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/events"
+	"github.com/docker/docker/api/types/filters"
+)
+
+func (r *Runtime) WatchEdgeAppEvents(ctx context.Context) (<-chan events.Message, <-chan error) {
+	filter := filters.NewArgs()
+	filter.Add("type", events.ContainerEventType)
+	filter.Add("label", "manifestUniqueID")
+
+	return r.client.Events(ctx, types.EventsOptions{Filters: filter})
+}
@@ -14,7 +14,7 @@ import (
 	log "github.com/sirupsen/logrus"
 )
 
-func PullImage(authConfig types.AuthConfig, imageName string) error {
+func (r *Runtime) PullImage(authConfig types.AuthConfig, imageName string) error {
 	encodedJSON, err := json.Marshal(authConfig)
 	if err != nil {
 		return traceutility.Wrap(err)
@@ -22,7 +22,7 @@ func PullImage(authConfig types.AuthConfig, imageName string) error {
 
 	authStr := base64.URLEncoding.EncodeToString(encodedJSON)
 
-	events, err := dockerClient.ImagePull(ctx, imageName, types.ImagePullOptions{RegistryAuth: authStr})
+	events, err := r.client.ImagePull(ctx, imageName, types.ImagePullOptions{RegistryAuth: authStr})
 	if err != nil {
 		return traceutility.Wrap(err)
 	}
@@ -50,8 +50,8 @@ func PullImage(authConfig types.AuthConfig, imageName string) error {
 
 // Check if the image exists in the local context
 // Return an error only if something went wrong, if the image is not found the error is nil
-func ImageExists(imageName string) (bool, error) {
-	_, _, err := dockerClient.ImageInspectWithRaw(ctx, imageName)
+func (r *Runtime) ImageExists(imageName string) (bool, error) {
+	_, _, err := r.client.ImageInspectWithRaw(ctx, imageName)
 	if err != nil {
 		if client.IsErrNotFound(err) {
 			return false, nil
@@ -63,8 +63,8 @@ func ImageExists(imageName string) (bool, error) {
 	return true, nil
 }
 
-func ImageRemove(imageID string) error {
-	_, err := dockerClient.ImageRemove(ctx, imageID, types.ImageRemoveOptions{})
+func (r *Runtime) ImageRemove(imageID string) error {
+	_, err := r.client.ImageRemove(ctx, imageID, types.ImageRemoveOptions{})
 	if err != nil {
 		return traceutility.Wrap(err)
 	}
@@ -72,7 +72,7 @@ func ImageRemove(imageID string) error {
 	return nil
 }
 
-func GetImagesByName(images []string) ([]types.ImageSummary, error) {
+func (r *Runtime) GetImagesByName(images []string) ([]types.ImageSummary, error) {
 	if len(images) == 0 {
 		return nil, nil
 	}
@@ -83,5 +83,5 @@ func GetImagesByName(images []string) ([]types.ImageSummary, error) {
 	}
 	options := types.ImageListOptions{Filters: filter}
 
-	return dockerClient.ImageList(ctx, options)
+	return r.client.ImageList(ctx, options)
 }
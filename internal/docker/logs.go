@@ -0,0 +1,256 @@
+package docker
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/pkg/stdcopy"
+
+	"github.com/weeveiot/weeve-agent/internal/model"
+	"github.com/weeveiot/weeve-agent/internal/runtime"
+	traceutility "github.com/weeveiot/weeve-agent/internal/utility/trace"
+)
+
+func (r *Runtime) ReadContainerLogs(containerID string, since string, until string) ([]runtime.LogLine, error) {
+	logLines := []runtime.LogLine{}
+
+	options := types.ContainerLogsOptions{
+		ShowStdout: true,
+		ShowStderr: true,
+		Since:      since,
+		Until:      until,
+		Timestamps: true,
+	}
+
+	tty, err := r.containerTTY(context.Background(), containerID)
+	if err != nil {
+		return logLines, traceutility.Wrap(err)
+	}
+
+	reader, err := r.client.ContainerLogs(context.Background(), containerID, options)
+	if err != nil {
+		return logLines, traceutility.Wrap(err)
+	}
+	defer reader.Close()
+
+	err = readLogLines(reader, tty, runtime.NewLogPosition(model.LogCursor{}), func(line runtime.LogLine) bool {
+		logLines = append(logLines, line)
+		return true
+	})
+	if err != nil {
+		return logLines, traceutility.Wrap(err)
+	}
+
+	return logLines, nil
+}
+
+func (r *Runtime) FollowContainerLogs(ctx context.Context, containerID string, cursor model.LogCursor) (<-chan runtime.LogLine, <-chan error) {
+	lines := make(chan runtime.LogLine)
+	errs := make(chan error, 1)
+
+	go func() {
+		defer close(lines)
+
+		options := types.ContainerLogsOptions{
+			ShowStdout: true,
+			ShowStderr: true,
+			Follow:     true,
+			Timestamps: true,
+		}
+		if !cursor.Time.IsZero() {
+			options.Since = fmt.Sprintf("%d.%09d", cursor.Time.Unix(), cursor.Time.Nanosecond())
+		}
+
+		tty, err := r.containerTTY(ctx, containerID)
+		if err != nil {
+			errs <- traceutility.Wrap(err)
+			return
+		}
+
+		reader, err := r.client.ContainerLogs(ctx, containerID, options)
+		if err != nil {
+			errs <- traceutility.Wrap(err)
+			return
+		}
+		defer reader.Close()
+
+		err = readLogLines(reader, tty, runtime.NewLogPosition(cursor), func(line runtime.LogLine) bool {
+			select {
+			case lines <- line:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		})
+		// cancelling ctx closes the stream, which is not an error
+		if err != nil && ctx.Err() == nil {
+			errs <- traceutility.Wrap(err)
+		}
+	}()
+
+	return lines, errs
+}
+
+// containerTTY tells if the container has a TTY, whose logs are not multiplexed
+func (r *Runtime) containerTTY(ctx context.Context, containerID string) (bool, error) {
+	container, err := r.client.ContainerInspect(ctx, containerID)
+	if err != nil {
+		return false, traceutility.Wrap(err)
+	}
+	return container.Config != nil && container.Config.Tty, nil
+}
+
+// maxLogLineSize limits the lines that are put together from partial messages, longer lines are split
+const maxLogLineSize = 64 * 1024
+
+// readLogLines passes the timestamped lines of the logs endpoint after the position to send, until the stream ends or
+// send returns false
+func readLogLines(reader io.Reader, tty bool, position *runtime.LogPosition, send func(runtime.LogLine) bool) error {
+	frames := &logFrameReader{reader: bufio.NewReader(reader), tty: tty}
+	lines := &logLineAssembler{position: position, partial: make(map[string]*runtime.LogLine)}
+
+	for {
+		stream, payload, err := frames.next()
+		if err == io.EOF {
+			// the output of a stopped container can end without a line break
+			lines.flush(send)
+			return nil
+		}
+		if err != nil {
+			return traceutility.Wrap(err)
+		}
+
+		if !lines.add(stream, payload, send) {
+			return nil
+		}
+	}
+}
+
+// logFrameReader reads the frames of the multiplexed stream of the logs endpoint, like stdcopy does, keeping the
+// boundaries of the frames. Containers with a TTY have a raw stream without frame headers, it is read line by line.
+type logFrameReader struct {
+	reader *bufio.Reader
+	tty    bool
+	header [8]byte
+}
+
+// next returns the stream and the payload of the next frame, or io.EOF at the end of the stream
+func (r *logFrameReader) next() (string, []byte, error) {
+	if r.tty {
+		payload, err := r.reader.ReadBytes('\n')
+		if len(payload) > 0 {
+			return runtime.Stdout, payload, nil
+		}
+		return "", nil, err
+	}
+
+	_, err := io.ReadFull(r.reader, r.header[:])
+	if err == io.ErrUnexpectedEOF {
+		return "", nil, errors.New("log stream ended within a frame header")
+	}
+	if err != nil {
+		return "", nil, err
+	}
+
+	size := binary.BigEndian.Uint32(r.header[4:])
+	payload := make([]byte, size)
+	_, err = io.ReadFull(r.reader, payload)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return "", nil, fmt.Errorf("log stream ended within a frame of %d bytes", size)
+	}
+	if err != nil {
+		return "", nil, err
+	}
+
+	switch stdcopy.StdType(r.header[0]) {
+	case stdcopy.Stdout:
+		return runtime.Stdout, payload, nil
+	case stdcopy.Stderr:
+		return runtime.Stderr, payload, nil
+	case stdcopy.Systemerr:
+		return "", nil, fmt.Errorf("error from the log stream: %s", payload)
+	default:
+		return "", nil, fmt.Errorf("unknown stream %d in the log stream", r.header[0])
+	}
+}
+
+// logLineAssembler puts the lines of each stream together from the frames. Docker splits long lines into partial
+// messages, each with its own timestamp, so a frame can end in the middle of a line.
+type logLineAssembler struct {
+	position *runtime.LogPosition
+	partial  map[string]*runtime.LogLine // by stream
+}
+
+// add passes the lines that the frame completes to send and returns false if send does
+func (a *logLineAssembler) add(stream string, payload []byte, send func(runtime.LogLine) bool) bool {
+	timestamp, text := splitTimestamp(string(payload), a.position.Cursor().Time)
+	cursor, ok := a.position.Advance(timestamp)
+	if !ok {
+		return true
+	}
+
+	segments := strings.Split(text, "\n")
+	for i, segment := range segments {
+		last := i == len(segments)-1
+		line := a.partial[stream]
+		if line == nil {
+			if last && segment == "" {
+				break
+			}
+			line = &runtime.LogLine{Time: timestamp, Stream: stream}
+			a.partial[stream] = line
+		}
+		line.Line += segment
+		line.Cursor = cursor
+
+		// the line continues in the next frame of the stream
+		if last && len(line.Line) < maxLogLineSize {
+			break
+		}
+		delete(a.partial, stream)
+		if !send(completeLine(*line)) {
+			return false
+		}
+	}
+	return true
+}
+
+// flush passes the lines that are still incomplete to send
+func (a *logLineAssembler) flush(send func(runtime.LogLine) bool) {
+	for _, stream := range []string{runtime.Stdout, runtime.Stderr} {
+		line := a.partial[stream]
+		if line == nil {
+			continue
+		}
+		delete(a.partial, stream)
+		// the other stream may have moved the position on in the meantime
+		line.Cursor = a.position.Cursor()
+		if !send(completeLine(*line)) {
+			return
+		}
+	}
+}
+
+// splitTimestamp splits the timestamp off a message. A message without a valid timestamp gets the time of the one before.
+func splitTimestamp(payload string, previous time.Time) (time.Time, string) {
+	if prefix, rest, found := strings.Cut(payload, " "); found {
+		timestamp, err := time.Parse(time.RFC3339Nano, prefix)
+		if err == nil {
+			return timestamp, rest
+		}
+	}
+	return previous, payload
+}
+
+// completeLine drops the carriage return that terminals put before line breaks
+func completeLine(line runtime.LogLine) runtime.LogLine {
+	line.Line = strings.TrimSuffix(line.Line, "\r")
+	return line
+}
@@ -0,0 +1,179 @@
+package docker_test
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/client"
+	"github.com/docker/docker/pkg/stdcopy"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/weeveiot/weeve-agent/internal/docker"
+	"github.com/weeveiot/weeve-agent/internal/model"
+	"github.com/weeveiot/weeve-agent/internal/runtime"
+)
+
+const logContainerID = "logs"
+
+var logTime = time.Date(2023, 5, 1, 12, 0, 0, 0, time.UTC)
+
+// frame is a message of the multiplexed log stream, prefixed with the timestamp like the engine does
+func frame(stream stdcopy.StdType, second int, payload string) []byte {
+	payload = logTime.Add(time.Duration(second)*time.Second).Format(time.RFC3339Nano) + " " + payload
+	header := make([]byte, 8)
+	header[0] = byte(stream)
+	binary.BigEndian.PutUint32(header[4:], uint32(len(payload)))
+	return append(header, payload...)
+}
+
+// newLogServer serves the logs of a container from an engine that writes the stream a few bytes at a time
+func newLogServer(t *testing.T, tty bool, stream []byte) *docker.Runtime {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch {
+		case strings.HasSuffix(req.URL.Path, "/_ping"):
+			w.Header().Set("API-Version", "1.41")
+		case strings.HasSuffix(req.URL.Path, "/containers/"+logContainerID+"/json"):
+			fmt.Fprintf(w, `{"Id": %q, "Config": {"Tty": %t}}`, logContainerID, tty)
+		case strings.HasSuffix(req.URL.Path, "/containers/"+logContainerID+"/logs"):
+			for i := 0; i < len(stream); i += 5 {
+				w.Write(stream[i:min(i+5, len(stream))])
+				w.(http.Flusher).Flush()
+			}
+		default:
+			http.NotFound(w, req)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	rt, err := docker.NewRuntimeWithOpts(container.LogConfig{}, client.WithHost("tcp://"+server.Listener.Addr().String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rt
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func lineTexts(lines []runtime.LogLine) []string {
+	var texts []string
+	for _, line := range lines {
+		texts = append(texts, line.Stream+": "+line.Line)
+	}
+	return texts
+}
+
+func TestReadContainerLogs(t *testing.T) {
+	tests := []struct {
+		name   string
+		tty    bool
+		stream [][]byte
+		want   []string
+	}{
+		{
+			name: "frames split across reads",
+			stream: [][]byte{
+				frame(stdcopy.Stdout, 0, "first\n"),
+				frame(stdcopy.Stderr, 1, "failure\n"),
+				frame(stdcopy.Stdout, 2, "second\n"),
+			},
+			want: []string{"stdout: first", "stderr: failure", "stdout: second"},
+		},
+		{
+			name: "partial lines continue in the next frame of their stream",
+			stream: [][]byte{
+				frame(stdcopy.Stdout, 0, "a long "),
+				frame(stdcopy.Stderr, 1, "failure\n"),
+				frame(stdcopy.Stdout, 2, "line\nnext\n"),
+			},
+			want: []string{"stderr: failure", "stdout: a long line", "stdout: next"},
+		},
+		{
+			name: "incomplete line at the end of the stream",
+			stream: [][]byte{
+				frame(stdcopy.Stdout, 0, "complete\n"),
+				frame(stdcopy.Stdout, 1, "incomplete"),
+			},
+			want: []string{"stdout: complete", "stdout: incomplete"},
+		},
+		{
+			name: "TTY without frame headers",
+			tty:  true,
+			stream: [][]byte{
+				[]byte(logTime.Format(time.RFC3339Nano) + " prompt\r\n"),
+				[]byte(logTime.Add(time.Second).Format(time.RFC3339Nano) + " output\r\n"),
+			},
+			want: []string{"stdout: prompt", "stdout: output"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rt := newLogServer(t, test.tty, concat(test.stream))
+			lines, err := rt.ReadContainerLogs(logContainerID, "", "")
+			assert.NoError(t, err)
+			assert.Equal(t, test.want, lineTexts(lines))
+			for _, line := range lines {
+				assert.False(t, line.Time.IsZero())
+			}
+		})
+	}
+}
+
+func TestReadContainerLogsTruncated(t *testing.T) {
+	stream := frame(stdcopy.Stdout, 0, "first\n")
+	stream = append(stream, frame(stdcopy.Stdout, 1, "second\n")[:10]...)
+	rt := newLogServer(t, false, stream)
+
+	lines, err := rt.ReadContainerLogs(logContainerID, "", "")
+	assert.Error(t, err)
+	assert.Equal(t, []string{"stdout: first"}, lineTexts(lines))
+}
+
+func TestFollowContainerLogsResume(t *testing.T) {
+	assert := assert.New(t)
+	rt := newLogServer(t, false, concat([][]byte{
+		frame(stdcopy.Stdout, 0, "first\n"),
+		frame(stdcopy.Stderr, 0, "failure\n"),
+		frame(stdcopy.Stdout, 1, "sec"),
+		frame(stdcopy.Stdout, 2, "ond\n"),
+		frame(stdcopy.Stdout, 3, "third\n"),
+	}))
+
+	follow := func(cursor model.LogCursor) []runtime.LogLine {
+		lines, errs := rt.FollowContainerLogs(context.Background(), logContainerID, cursor)
+		var followed []runtime.LogLine
+		for line := range lines {
+			followed = append(followed, line)
+		}
+		assert.Empty(errs)
+		return followed
+	}
+
+	lines := follow(model.LogCursor{})
+	assert.Equal([]string{"stdout: first", "stderr: failure", "stdout: second", "stdout: third"}, lineTexts(lines))
+
+	// resuming after each line sends the lines that follow it, the engine sends the whole stream again
+	for i, line := range lines {
+		assert.Equal(lineTexts(lines[i+1:]), lineTexts(follow(line.Cursor)), "resuming after %q", line.Line)
+	}
+}
+
+func concat(frames [][]byte) []byte {
+	var stream []byte
+	for _, frame := range frames {
+		stream = append(stream, frame...)
+	}
+	return stream
+}
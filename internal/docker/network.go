@@ -22,10 +22,10 @@ const manifestNamelength = 11
 const indexLength = 3
 const maxNetworkIndex = 999
 
-func readAllNetworks() ([]types.NetworkResource, error) {
+func (r *Runtime) readAllNetworks() ([]types.NetworkResource, error) {
 	log.Debug("Docker_container -> readAllNetworks")
 
-	networks, err := dockerClient.NetworkList(ctx, types.NetworkListOptions{})
+	networks, err := r.client.NetworkList(ctx, types.NetworkListOptions{})
 	if err != nil {
 		return nil, traceutility.Wrap(err)
 	}
@@ -33,14 +33,14 @@ func readAllNetworks() ([]types.NetworkResource, error) {
 	return networks, nil
 }
 
-func ReadEdgeAppNetworks(manifestUniqueID model.ManifestUniqueID) ([]types.NetworkResource, error) {
+func (r *Runtime) ReadEdgeAppNetworks(manifestUniqueID model.ManifestUniqueID) ([]types.NetworkResource, error) {
 	log.Debug("Docker_container -> ReadEdgeAppNetworks")
 
 	filter := filters.NewArgs()
 	filter.Add("label", "manifestUniqueID="+manifestUniqueID.String())
 	options := types.NetworkListOptions{Filters: filter}
 
-	networks, err := dockerClient.NetworkList(ctx, options)
+	networks, err := r.client.NetworkList(ctx, options)
 	if err != nil {
 		return nil, traceutility.Wrap(err)
 	}
@@ -48,7 +48,23 @@ func ReadEdgeAppNetworks(manifestUniqueID model.ManifestUniqueID) ([]types.Netwo
 	return networks, nil
 }
 
-func makeNetworkName(name string) (string, error) {
+// ReadAllEdgeAppNetworks returns the networks of all edge apps, known or not
+func (r *Runtime) ReadAllEdgeAppNetworks() ([]types.NetworkResource, error) {
+	log.Debug("Docker_container -> ReadAllEdgeAppNetworks")
+
+	filter := filters.NewArgs()
+	filter.Add("label", "manifestUniqueID")
+	options := types.NetworkListOptions{Filters: filter}
+
+	networks, err := r.client.NetworkList(ctx, options)
+	if err != nil {
+		return nil, traceutility.Wrap(err)
+	}
+
+	return networks, nil
+}
+
+func (r *Runtime) makeNetworkName(name string) (string, error) {
 	format := "%s_%0" + strconv.Itoa(indexLength) + "d"
 
 	// Prune the name if necessary
@@ -60,14 +76,14 @@ func makeNetworkName(name string) (string, error) {
 
 	// Get new network count
 	var newCount int
-	maxCount, err := getLastCreatedNetworkCount()
+	maxCount, err := r.getLastCreatedNetworkCount()
 	if err != nil {
 		return "", traceutility.Wrap(err)
 	}
 	if maxCount < maxNetworkIndex {
 		newCount = maxCount + 1
 	} else {
-		newCount, err = getLowestAvailableNetworkCount()
+		newCount, err = r.getLowestAvailableNetworkCount()
 		if err != nil {
 			return "", traceutility.Wrap(err)
 		}
@@ -83,13 +99,13 @@ func makeNetworkName(name string) (string, error) {
 	return strings.ReplaceAll(networkName, " ", ""), nil
 }
 
-func CreateNetwork(name string, labels map[string]string) (string, error) {
+func (r *Runtime) CreateNetwork(name string, labels map[string]string) (string, error) {
 	var networkCreateOptions types.NetworkCreate
 	networkCreateOptions.CheckDuplicate = true
 	networkCreateOptions.Attachable = true
 	networkCreateOptions.Labels = labels
 
-	networkName, err := makeNetworkName(name)
+	networkName, err := r.makeNetworkName(name)
 	if err != nil {
 		return "", traceutility.Wrap(err)
 	}
@@ -97,7 +113,7 @@ func CreateNetwork(name string, labels map[string]string) (string, error) {
 		return "", errors.New("failed to generate network name")
 	}
 
-	_, err = dockerClient.NetworkCreate(context.Background(), networkName, networkCreateOptions)
+	_, err = r.client.NetworkCreate(context.Background(), networkName, networkCreateOptions)
 	if err != nil {
 		return networkName, traceutility.Wrap(err)
 	}
@@ -105,11 +121,11 @@ func CreateNetwork(name string, labels map[string]string) (string, error) {
 	return networkName, nil
 }
 
-func NetworkPrune(manifestUniqueID model.ManifestUniqueID) error {
+func (r *Runtime) NetworkPrune(manifestUniqueID model.ManifestUniqueID) error {
 	filter := filters.NewArgs()
 	filter.Add("label", "manifestUniqueID="+manifestUniqueID.String())
 
-	pruneReport, err := dockerClient.NetworksPrune(ctx, filter)
+	pruneReport, err := r.client.NetworksPrune(ctx, filter)
 	if err != nil {
 		return traceutility.Wrap(err)
 	}
@@ -117,10 +133,10 @@ func NetworkPrune(manifestUniqueID model.ManifestUniqueID) error {
 	return nil
 }
 
-func getLastCreatedNetworkCount() (int, error) {
+func (r *Runtime) getLastCreatedNetworkCount() (int, error) {
 	maxCount := 0
 
-	counts, err := getExistingNetworkCounts()
+	counts, err := r.getExistingNetworkCounts()
 	if err != nil {
 		return 0, traceutility.Wrap(err)
 	}
@@ -134,8 +150,8 @@ func getLastCreatedNetworkCount() (int, error) {
 	return maxCount, nil
 }
 
-func getLowestAvailableNetworkCount() (int, error) {
-	counts, err := getExistingNetworkCounts()
+func (r *Runtime) getLowestAvailableNetworkCount() (int, error) {
+	counts, err := r.getExistingNetworkCounts()
 	if err != nil {
 		return 0, traceutility.Wrap(err)
 	}
@@ -158,9 +174,9 @@ func getLowestAvailableNetworkCount() (int, error) {
 	return -1, nil
 }
 
-func getExistingNetworkCounts() ([]int, error) {
+func (r *Runtime) getExistingNetworkCounts() ([]int, error) {
 	var counts []int
-	networks, err := readAllNetworks()
+	networks, err := r.readAllNetworks()
 	if err != nil {
 		return nil, traceutility.Wrap(err)
 	}
@@ -0,0 +1,72 @@
+package docker
+
+import (
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/volume"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/weeveiot/weeve-agent/internal/model"
+	traceutility "github.com/weeveiot/weeve-agent/internal/utility/trace"
+)
+
+// ReadEdgeAppVolumes returns the named volumes of an edge app with the disk space they use
+func (r *Runtime) ReadEdgeAppVolumes(manifestUniqueID model.ManifestUniqueID) ([]*volume.Volume, error) {
+	log.Debug("Docker_volume -> ReadEdgeAppVolumes")
+
+	filter := filters.NewArgs()
+	filter.Add("label", "manifestUniqueID="+manifestUniqueID.String())
+
+	volumes, err := r.client.VolumeList(ctx, filter)
+	if err != nil {
+		return nil, traceutility.Wrap(err)
+	}
+	for _, vol := range volumes.Volumes {
+		vol.UsageData = &volume.UsageData{Size: -1, RefCount: -1}
+	}
+	if len(volumes.Volumes) == 0 {
+		return volumes.Volumes, nil
+	}
+
+	// the sizes are only reported by the disk usage endpoint, which can't filter by label
+	usage, err := r.client.DiskUsage(ctx, types.DiskUsageOptions{Types: []types.DiskUsageObject{types.VolumeObject}})
+	if err != nil {
+		log.Warning("Reading the size of the volumes failed! CAUSE --> ", err)
+		return volumes.Volumes, nil
+	}
+	usageByName := make(map[string]*volume.UsageData)
+	for _, vol := range usage.Volumes {
+		if vol.UsageData != nil {
+			usageByName[vol.Name] = vol.UsageData
+		}
+	}
+	for _, vol := range volumes.Volumes {
+		if usageData, found := usageByName[vol.Name]; found {
+			vol.UsageData = usageData
+		}
+	}
+
+	return volumes.Volumes, nil
+}
+
+// RemoveEdgeAppVolumes removes the named volumes of an edge app, its containers must be removed already
+func (r *Runtime) RemoveEdgeAppVolumes(manifestUniqueID model.ManifestUniqueID) error {
+	log.Debug("Docker_volume -> RemoveEdgeAppVolumes")
+
+	filter := filters.NewArgs()
+	filter.Add("label", "manifestUniqueID="+manifestUniqueID.String())
+
+	volumes, err := r.client.VolumeList(ctx, filter)
+	if err != nil {
+		return traceutility.Wrap(err)
+	}
+	for _, vol := range volumes.Volumes {
+		err = r.client.VolumeRemove(ctx, vol.Name, false)
+		if err != nil {
+			return traceutility.Wrap(err)
+		}
+		log.Info("Removed volume ", vol.Name)
+	}
+
+	return nil
+}
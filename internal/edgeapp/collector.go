@@ -0,0 +1,106 @@
+package edgeapp
+
+import (
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/weeveiot/weeve-agent/internal/manifest"
+	"github.com/weeveiot/weeve-agent/internal/model"
+)
+
+var edgeAppStatuses = []string{
+	model.EdgeAppInitiated,
+	model.EdgeAppExecuting,
+	model.EdgeAppRunning,
+	model.EdgeAppDegraded,
+	model.EdgeAppStopped,
+	model.EdgeAppError,
+	model.EdgeAppUndeployed,
+}
+
+var (
+	edgeAppStatusDesc = prometheus.NewDesc("weeve_edge_app_status",
+		"Status of the edge app, 1 for the current status.", []string{"manifest_id", "status"}, nil)
+	cpuUsageDesc = prometheus.NewDesc("weeve_container_cpu_usage_seconds_total",
+		"CPU time used by the container.", []string{"manifest_id", "container"}, nil)
+	memoryUsageDesc = prometheus.NewDesc("weeve_container_memory_usage_bytes",
+		"Memory used by the container, without the reclaimable page cache.", []string{"manifest_id", "container"}, nil)
+	memoryLimitDesc = prometheus.NewDesc("weeve_container_memory_limit_bytes",
+		"Memory limit of the container.", []string{"manifest_id", "container"}, nil)
+	networkReceiveDesc = prometheus.NewDesc("weeve_container_network_receive_bytes_total",
+		"Bytes received by the container.", []string{"manifest_id", "container"}, nil)
+	networkTransmitDesc = prometheus.NewDesc("weeve_container_network_transmit_bytes_total",
+		"Bytes sent by the container.", []string{"manifest_id", "container"}, nil)
+	blockReadDesc = prometheus.NewDesc("weeve_container_block_read_bytes_total",
+		"Bytes read by the container from block devices.", []string{"manifest_id", "container"}, nil)
+	blockWriteDesc = prometheus.NewDesc("weeve_container_block_write_bytes_total",
+		"Bytes written by the container to block devices.", []string{"manifest_id", "container"}, nil)
+	restartsDesc = prometheus.NewDesc("weeve_container_restarts_total",
+		"Number of times the container was restarted by the container runtime.", []string{"manifest_id", "container"}, nil)
+)
+
+// statusCollector reports the status of the edge apps and the resource usage of their containers. To not load the
+// runtime on every scrape, the container states come from the status monitor and the usage from the latest heartbeat.
+type statusCollector struct{}
+
+// StatusCollector returns the Prometheus collector for the status of the edge apps and their containers
+func StatusCollector() prometheus.Collector {
+	return statusCollector{}
+}
+
+func (statusCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- edgeAppStatusDesc
+	ch <- cpuUsageDesc
+	ch <- memoryUsageDesc
+	ch <- memoryLimitDesc
+	ch <- networkReceiveDesc
+	ch <- networkTransmitDesc
+	ch <- blockReadDesc
+	ch <- blockWriteDesc
+	ch <- restartsDesc
+}
+
+func (statusCollector) Collect(ch chan<- prometheus.Metric) {
+	for _, manif := range manifest.GetKnownManifests() {
+		manifestID := manif.Manifest.ID
+
+		containers, err := containerStates(*manif)
+		if err != nil {
+			log.Error("Failed to read the containers of edge app ", manifestID, "! CAUSE --> ", err)
+			continue
+		}
+
+		edgeApp := edgeAppStatus(*manif, containers)
+		for _, status := range edgeAppStatuses {
+			value := 0.0
+			if status == edgeApp.Status {
+				value = 1
+			}
+			ch <- prometheus.MustNewConstMetric(edgeAppStatusDesc, prometheus.GaugeValue, value, manifestID, status)
+		}
+
+		for _, con := range containers {
+			name := strings.TrimPrefix(con.Name, "/")
+			ch <- prometheus.MustNewConstMetric(restartsDesc, prometheus.CounterValue, float64(con.RestartCount), manifestID, name)
+			if con.State != "running" {
+				continue
+			}
+
+			// the usage of a container is known once a heartbeat reported it
+			stats, found := heartbeatCPU.latest(con.ID)
+			if !found {
+				continue
+			}
+			usage := summarizeStats(stats)
+			ch <- prometheus.MustNewConstMetric(cpuUsageDesc, prometheus.CounterValue, float64(stats.CPUStats.CPUUsage.TotalUsage)/1e9, manifestID, name)
+			ch <- prometheus.MustNewConstMetric(memoryUsageDesc, prometheus.GaugeValue, float64(usage.MemoryUsage), manifestID, name)
+			ch <- prometheus.MustNewConstMetric(memoryLimitDesc, prometheus.GaugeValue, float64(usage.MemoryLimit), manifestID, name)
+			ch <- prometheus.MustNewConstMetric(networkReceiveDesc, prometheus.CounterValue, float64(usage.NetworkRx), manifestID, name)
+			ch <- prometheus.MustNewConstMetric(networkTransmitDesc, prometheus.CounterValue, float64(usage.NetworkTx), manifestID, name)
+			ch <- prometheus.MustNewConstMetric(blockReadDesc, prometheus.CounterValue, float64(usage.BlockRead), manifestID, name)
+			ch <- prometheus.MustNewConstMetric(blockWriteDesc, prometheus.CounterValue, float64(usage.BlockWrite), manifestID, name)
+		}
+	}
+}
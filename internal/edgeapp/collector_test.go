@@ -0,0 +1,122 @@
+package edgeapp_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/weeveiot/weeve-agent/internal/com"
+	"github.com/weeveiot/weeve-agent/internal/edgeapp"
+	"github.com/weeveiot/weeve-agent/internal/model"
+	"github.com/weeveiot/weeve-agent/internal/runtime/fake"
+)
+
+func TestStatusCollector(t *testing.T) {
+	assert := assert.New(t)
+	rt := fake.NewRuntime()
+	edgeapp.SetRuntime(rt)
+
+	man := readManifest(t, "test_manifest.json")
+	err := edgeapp.DeployEdgeApp(man)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer edgeapp.RemoveEdgeApp(man.UniqueID, nil)
+
+	statusChanges := make(chan []com.EdgeAppMsg, 10)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		edgeapp.MonitorEdgeAppStatus(ctx, time.Hour, func(edgeApps []com.EdgeAppMsg) {
+			statusChanges <- edgeApps
+		})
+		close(done)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+	assertStatusChange(t, statusChanges, man.ID, model.EdgeAppRunning)
+
+	containers, err := rt.ReadEdgeAppContainers(man.UniqueID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stats := types.StatsJSON{}
+	stats.CPUStats.CPUUsage.TotalUsage = 2500000000
+	stats.MemoryStats = types.MemoryStats{Usage: 100 << 20, Limit: 512 << 20}
+	stats.Networks = map[string]types.NetworkStats{"eth0": {RxBytes: 1000, TxBytes: 2000}}
+	err = rt.SetStats(containers[0].ID, stats)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the usage is the one of the latest heartbeat, which fails to be sent without broker
+	edgeapp.SendStatus()
+	reads := rt.Reads()
+
+	problems, err := testutil.CollectAndLint(edgeapp.StatusCollector())
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Empty(problems)
+
+	registry := prometheus.NewPedanticRegistry()
+	registry.MustRegister(edgeapp.StatusCollector())
+	assert.Equal(1.0, gatherValue(t, registry, "weeve_edge_app_status", map[string]string{"manifest_id": man.UniqueID.ID, "status": model.EdgeAppRunning}))
+	assert.Equal(0.0, gatherValue(t, registry, "weeve_edge_app_status", map[string]string{"manifest_id": man.UniqueID.ID, "status": model.EdgeAppError}))
+
+	labels := map[string]string{"manifest_id": man.UniqueID.ID, "container": strings.TrimPrefix(containers[0].Names[0], "/")}
+	assert.Equal(2.5, gatherValue(t, registry, "weeve_container_cpu_usage_seconds_total", labels))
+	assert.Equal(float64(100<<20), gatherValue(t, registry, "weeve_container_memory_usage_bytes", labels))
+	assert.Equal(float64(512<<20), gatherValue(t, registry, "weeve_container_memory_limit_bytes", labels))
+	assert.Equal(1000.0, gatherValue(t, registry, "weeve_container_network_receive_bytes_total", labels))
+	assert.Equal(2000.0, gatherValue(t, registry, "weeve_container_network_transmit_bytes_total", labels))
+	assert.Equal(0.0, gatherValue(t, registry, "weeve_container_restarts_total", labels))
+	assert.Equal(reads, rt.Reads(), "scrapes read from the runtime")
+
+	// a crashed container reports its restarts only
+	err = rt.CrashContainer(containers[1].ID, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertStatusChange(t, statusChanges, man.ID, model.EdgeAppError)
+	assert.Equal(1.0, gatherValue(t, registry, "weeve_edge_app_status", map[string]string{"manifest_id": man.UniqueID.ID, "status": model.EdgeAppError}))
+	labels["container"] = strings.TrimPrefix(containers[1].Names[0], "/")
+	assert.Equal(0.0, gatherValue(t, registry, "weeve_container_restarts_total", labels))
+	assert.Equal(-1.0, gatherValue(t, registry, "weeve_container_cpu_usage_seconds_total", labels))
+}
+
+// gatherValue returns the value of the metric with the given labels, or -1 if there is none
+func gatherValue(t *testing.T, gatherer prometheus.Gatherer, name string, labels map[string]string) float64 {
+	families, err := gatherer.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, family := range families {
+		if family.GetName() != name {
+			continue
+		}
+	metrics:
+		for _, metric := range family.GetMetric() {
+			for _, label := range metric.GetLabel() {
+				if value, ok := labels[label.GetName()]; ok && value != label.GetValue() {
+					continue metrics
+				}
+			}
+			switch {
+			case metric.Gauge != nil:
+				return metric.Gauge.GetValue()
+			case metric.Counter != nil:
+				return metric.Counter.GetValue()
+			}
+		}
+	}
+	return -1
+}
@@ -3,14 +3,17 @@ package edgeapp
 import (
 	"fmt"
 	"strings"
+	"time"
 
 	"errors"
 
+	"github.com/docker/docker/api/types"
 	log "github.com/sirupsen/logrus"
 
-	"github.com/weeveiot/weeve-agent/internal/docker"
 	"github.com/weeveiot/weeve-agent/internal/manifest"
+	"github.com/weeveiot/weeve-agent/internal/metrics"
 	"github.com/weeveiot/weeve-agent/internal/model"
+	"github.com/weeveiot/weeve-agent/internal/runtime"
 	traceutility "github.com/weeveiot/weeve-agent/internal/utility/trace"
 )
 
@@ -20,17 +23,58 @@ const (
 	CMDResume   = "RESUME"
 	CMDUndeploy = "UNDEPLOY"
 	CMDRemove   = "REMOVE"
+	CMDLogs     = "LOGS" // changes the log limits
 )
 
+var containerRuntime runtime.Runtime
+
+// ModuleError is returned when a command failed on a specific module of an edge app
+type ModuleError struct {
+	Container string // empty if the container does not exist yet
+	Image     string
+	Err       error
+}
+
+func (e *ModuleError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *ModuleError) Unwrap() error {
+	return e.Err
+}
+
+// SetRuntime sets the container runtime that edge apps are deployed on
+func SetRuntime(r runtime.Runtime) {
+	containerRuntime = r
+}
+
 func DeployEdgeApp(man manifest.Manifest) error {
 	deploymentID := man.UniqueID.String() + " | "
+	deployStart := time.Now()
 
 	log.Info(deploymentID, "Deploying edge app ...")
 
+	// the manifest may have been validated on another machine, so the limits are checked against this node first
+	for _, module := range man.Modules {
+		err := manifest.CheckHostResources(module.Resources)
+		if err != nil {
+			log.Error(deploymentID, "Deployment failed! CAUSE --> ", err)
+			return &ModuleError{Image: module.ImageNameFull, Err: fmt.Errorf("invalid resources: %w", err)}
+		}
+	}
+
 	//******** STEP 1 - Check if a version of the edge app is already deployed *************//
 	edgeAppRecord := manifest.GetKnownManifest(man.UniqueID)
 	if edgeAppRecord != nil && edgeAppRecord.Status != model.EdgeAppUndeployed {
 		if edgeAppRecord.Manifest.UpdatedAt.Before(man.UpdatedAt) {
+			if canUpdateBlueGreen(*edgeAppRecord, man) {
+				err := updateEdgeApp(man, *edgeAppRecord)
+				if err != nil {
+					return traceutility.Wrap(err)
+				}
+				metrics.DeployDuration.Observe(time.Since(deployStart).Seconds())
+				return nil
+			}
 			// remove the old version of the edge app, except for the images that are used by the new edge app
 			var newImages []string
 			for _, module := range man.Modules {
@@ -49,7 +93,7 @@ func DeployEdgeApp(man manifest.Manifest) error {
 
 	for _, module := range man.Modules {
 		// Check if image exist in local
-		exists, err := docker.ImageExists(module.ImageNameFull)
+		exists, err := containerRuntime.ImageExists(module.ImageNameFull)
 		if err != nil {
 			log.Error(deploymentID, "Deployment failed! CAUSE --> ", err)
 			return traceutility.Wrap(err)
@@ -59,13 +103,13 @@ func DeployEdgeApp(man manifest.Manifest) error {
 		} else { // Pull this image
 			log.Info(deploymentID, fmt.Sprintf("Image %v, does not exist on host", module.ImageNameFull))
 			log.Info(deploymentID, "Pulling ", module.ImageNameFull)
-			err = docker.PullImage(module.AuthConfig, module.ImageNameFull)
+			err = pullImage(module.AuthConfig, module.ImageNameFull)
 			if err != nil {
 				log.Error(deploymentID, "Unable to pull image/s, "+err.Error())
 				setAndSendStatus(man.UniqueID, model.EdgeAppError)
 				log.Info(deploymentID, "Initiating rollback ...")
 				RemoveEdgeApp(man.UniqueID, nil)
-				return errors.New("unable to pull image/s")
+				return &ModuleError{Image: module.ImageNameFull, Err: fmt.Errorf("unable to pull image/s: %w", err)}
 			}
 		}
 	}
@@ -73,7 +117,7 @@ func DeployEdgeApp(man manifest.Manifest) error {
 	//******** STEP 3 - Create the network *************//
 	log.Info(deploymentID, "Creating network ...")
 
-	networkName, err := docker.CreateNetwork(man.ManifestName, man.Labels)
+	networkName, err := containerRuntime.CreateNetwork(man.ManifestName, man.Labels)
 	if err != nil {
 		log.Error("CreateNetwork failed! CAUSE --> ", err)
 		setAndSendStatus(man.UniqueID, model.EdgeAppError)
@@ -83,6 +127,11 @@ func DeployEdgeApp(man manifest.Manifest) error {
 	}
 
 	man.UpdateManifest(networkName)
+	// remember the container names
+	err = manifest.UpdateKnownManifest(man)
+	if err != nil {
+		return traceutility.Wrap(err)
+	}
 
 	log.Info(deploymentID, "Created network >> ", networkName)
 
@@ -101,19 +150,41 @@ func DeployEdgeApp(man manifest.Manifest) error {
 	// start containers in reverse order to prevent connectivity issues
 	for i := len(containerConfigs) - 1; i >= 0; i-- {
 		log.Info(deploymentID, "Creating ", containerConfigs[i].ContainerName, " from ", containerConfigs[i].ImageNameFull)
-		containerID, err := docker.CreateAndStartContainer(containerConfigs[i])
+		containerID, err := containerRuntime.CreateAndStartContainer(containerConfigs[i])
 		if err != nil {
 			log.Error(deploymentID, "Failed to create and start container ", containerConfigs[i].ContainerName, " CAUSE --> ", err)
 			log.Info(deploymentID, "Initiating rollback ...")
 			RemoveEdgeApp(man.UniqueID, nil)
 			setAndSendStatus(man.UniqueID, model.EdgeAppError)
-			return traceutility.Wrap(err)
+			return &ModuleError{Container: containerConfigs[i].ContainerName, Image: containerConfigs[i].ImageNameFull, Err: traceutility.Wrap(err)}
 		}
 		log.Info(deploymentID, "Successfully created container ", containerID)
 		log.Info(deploymentID, "Started!")
 	}
 
 	setAndSendStatus(man.UniqueID, model.EdgeAppRunning)
+	metrics.DeployDuration.Observe(time.Since(deployStart).Seconds())
+
+	return nil
+}
+
+// pullImage pulls the image and records the size of the image and the time it took
+func pullImage(authConfig types.AuthConfig, imageName string) error {
+	pullStart := time.Now()
+	err := containerRuntime.PullImage(authConfig, imageName)
+	if err != nil {
+		return traceutility.Wrap(err)
+	}
+	metrics.ImagePullDuration.Observe(time.Since(pullStart).Seconds())
+
+	images, err := containerRuntime.GetImagesByName([]string{imageName})
+	if err != nil {
+		log.Warning("Failed to read the size of image ", imageName, "! CAUSE --> ", err)
+		return nil
+	}
+	for _, image := range images {
+		metrics.ImagePullBytes.Add(float64(image.Size))
+	}
 
 	return nil
 }
@@ -129,7 +200,7 @@ func StopEdgeApp(manifestUniqueID model.ManifestUniqueID) error {
 		return errors.New("can't stop edge application " + manifestUniqueID.String() + " with status " + status)
 	}
 
-	containers, err := docker.ReadEdgeAppContainers(manifestUniqueID)
+	containers, err := containerRuntime.ReadEdgeAppContainers(manifestUniqueID)
 	if err != nil {
 		log.Error("Failed to read edge app containers! CAUSE --> ", err)
 		return traceutility.Wrap(err)
@@ -145,12 +216,12 @@ func StopEdgeApp(manifestUniqueID model.ManifestUniqueID) error {
 	for _, container := range containers {
 		if container.State == strings.ToLower(model.ModuleRunning) {
 			log.Info("Stopping container:", strings.Join(container.Names[:], ","))
-			err := docker.StopContainer(container.ID)
+			err := containerRuntime.StopContainer(container.ID)
 			if err != nil {
 				log.Error("Could not stop a container! CAUSE --> ", err)
 				setAndSendStatus(manifestUniqueID, model.EdgeAppError)
 
-				return traceutility.Wrap(err)
+				return &ModuleError{Container: containerName(container), Image: container.Image, Err: traceutility.Wrap(err)}
 			}
 
 			log.Info(strings.Join(container.Names[:], ","), ": ", container.Status, " --> exited")
@@ -175,7 +246,7 @@ func ResumeEdgeApp(manifestUniqueID model.ManifestUniqueID) error {
 		return errors.New("can't resume edge application " + manifestUniqueID.String() + " with status " + status)
 	}
 
-	containers, err := docker.ReadEdgeAppContainers(manifestUniqueID)
+	containers, err := containerRuntime.ReadEdgeAppContainers(manifestUniqueID)
 	if err != nil {
 		log.Error("Unable to resume edge app! CAUSE --> ", err)
 		log.Error("Failed to read edge app containers.")
@@ -194,11 +265,11 @@ func ResumeEdgeApp(manifestUniqueID model.ManifestUniqueID) error {
 	for i := len(containers) - 1; i >= 0; i-- {
 		if containers[i].State != strings.ToLower(model.ModuleRunning) {
 			log.Info("Starting container:", strings.Join(containers[i].Names[:], ","))
-			err := docker.StartContainer(containers[i].ID)
+			err := containerRuntime.StartContainer(containers[i].ID)
 			if err != nil {
 				log.Errorln("Could not start a container", err)
 				setAndSendStatus(manifestUniqueID, model.EdgeAppError)
-				return traceutility.Wrap(err)
+				return &ModuleError{Container: containerName(containers[i]), Image: containers[i].Image, Err: traceutility.Wrap(err)}
 			}
 
 			log.Info(strings.Join(containers[i].Names[:], ","), ": ", containers[i].State, "--> running")
@@ -227,7 +298,7 @@ func UndeployEdgeApp(manifestUniqueID model.ManifestUniqueID) error {
 
 	//******** STEP 1 - Stop and Remove Containers *************//
 	log.Info(undeploymentID, "Stopping and removing containers ...")
-	dsContainers, err := docker.ReadEdgeAppContainers(manifestUniqueID)
+	dsContainers, err := containerRuntime.ReadEdgeAppContainers(manifestUniqueID)
 	if err != nil {
 		log.Error("Undeployment failed! CAUSE --> ", err)
 		log.Error(undeploymentID, "Failed to read edge app containers.")
@@ -237,7 +308,7 @@ func UndeployEdgeApp(manifestUniqueID model.ManifestUniqueID) error {
 
 	var errorlist string
 	for _, dsContainer := range dsContainers {
-		err := docker.StopAndRemoveContainer(dsContainer.ID)
+		err := containerRuntime.StopAndRemoveContainer(dsContainer.ID)
 		if err != nil {
 			log.Errorf("Undeployment failed! UndeploymentID --> %s, CAUSE --> %v", undeploymentID, err)
 			setAndSendStatus(manifestUniqueID, model.EdgeAppError)
@@ -248,7 +319,7 @@ func UndeployEdgeApp(manifestUniqueID model.ManifestUniqueID) error {
 	//******** STEP 2 - Remove Network *************//
 	log.Info(undeploymentID, "Pruning networks ...")
 
-	err = docker.NetworkPrune(manifestUniqueID)
+	err = containerRuntime.NetworkPrune(manifestUniqueID)
 	if err != nil {
 		log.Errorf("Undeployment failed! UndeploymentID --> %s, CAUSE --> %v", undeploymentID, err)
 		setAndSendStatus(manifestUniqueID, model.EdgeAppError)
@@ -292,71 +363,80 @@ func RemoveEdgeApp(manifestUniqueID model.ManifestUniqueID, keepImages []string)
 		removeImageNames = usedImageNames
 	}
 
-	// check if there are images that should be removed
-	if len(removeImageNames) > 0 {
-		removeImageIDs, err := docker.GetImagesByName(removeImageNames)
-		if err != nil {
-			log.Error("Unable to get images! CAUSE --> ", err)
-			log.Error(removalID, "Failed to read the used images.")
-			setAndSendStatus(manifestUniqueID, model.EdgeAppError)
-			return traceutility.Wrap(err)
-		}
-
-		numContainersPerImage := make(map[string]int) // map { imageID: number_of_allocated_containers }
-		for _, image := range removeImageIDs {
-			numContainersPerImage[image.ID] = 0
-		}
-		containers, err := docker.ReadAllContainers()
-		if err != nil {
-			log.Error("Unable to read containers! CAUSE --> ", err)
-			log.Error(removalID, "Failed to read all containers.")
-			setAndSendStatus(manifestUniqueID, model.EdgeAppError)
-			return traceutility.Wrap(err)
-		}
-
-		var errorlist string
-		for imageID := range numContainersPerImage {
-			for _, container := range containers {
-				if container.ImageID == imageID {
-					numContainersPerImage[imageID]++
-				}
-			}
-
-			if numContainersPerImage[imageID] == 0 {
-				log.Info(removalID, "Remove Image - ", imageID)
-				err := docker.ImageRemove(imageID)
-				if err != nil {
-					log.Errorf("Edge app removal failed! RemovalID --> %s, CAUSE --> %v", removalID, err)
-					setAndSendStatus(manifestUniqueID, model.EdgeAppError)
-					errorlist = fmt.Sprintf("%v,%v", errorlist, err)
-				}
-			}
-		}
-
-		if errorlist != "" {
-			return errors.New("Edge app could not be removed completely. Cause(s): " + errorlist)
-		}
+	err = removeImages(removalID, removeImageNames)
+	if err != nil {
+		setAndSendStatus(manifestUniqueID, model.EdgeAppError)
+		return traceutility.Wrap(err)
 	}
 
 	//******** STEP 3 - Remove Manifest *************//
 	manifest.DeleteKnownManifest(manifestUniqueID)
+	// the edge app is removed at this point, so failing to report it is not a failure of the removal
 	err = SendStatus()
 	if err != nil {
-		log.Errorf("Failed to delete known manifest! RemovalID --> %s, CAUSE --> %v", removalID, err)
+		log.Errorf("Failed to send status after removal! RemovalID --> %s, CAUSE --> %v", removalID, err)
+	}
+
+	return nil
+}
+
+// RemoveEdgeAppVolumes removes the named volumes of an edge app and the data in them.
+// Only the REMOVE command calls this, updates and rollbacks keep the volumes.
+func RemoveEdgeAppVolumes(manifestUniqueID model.ManifestUniqueID) error {
+	log.Info(manifestUniqueID.String(), " | Removing volumes ...")
+
+	err := containerRuntime.RemoveEdgeAppVolumes(manifestUniqueID)
+	if err != nil {
 		return traceutility.Wrap(err)
 	}
 
 	return nil
 }
 
-func RemoveAll() error {
-	log.Info("Removing all edge apps")
+// removeImages removes the images that no container uses anymore
+func removeImages(removalID string, imageNames []string) error {
+	if len(imageNames) == 0 {
+		return nil
+	}
 
-	for uniqueID := range manifest.GetKnownManifests() {
-		err := RemoveEdgeApp(uniqueID, nil)
-		if err != nil {
-			return traceutility.Wrap(err)
+	removeImageIDs, err := containerRuntime.GetImagesByName(imageNames)
+	if err != nil {
+		log.Error("Unable to get images! CAUSE --> ", err)
+		log.Error(removalID, "Failed to read the used images.")
+		return traceutility.Wrap(err)
+	}
+
+	numContainersPerImage := make(map[string]int) // map { imageID: number_of_allocated_containers }
+	for _, image := range removeImageIDs {
+		numContainersPerImage[image.ID] = 0
+	}
+	containers, err := containerRuntime.ReadAllContainers()
+	if err != nil {
+		log.Error("Unable to read containers! CAUSE --> ", err)
+		log.Error(removalID, "Failed to read all containers.")
+		return traceutility.Wrap(err)
+	}
+
+	var errorlist string
+	for imageID := range numContainersPerImage {
+		for _, container := range containers {
+			if container.ImageID == imageID {
+				numContainersPerImage[imageID]++
+			}
 		}
+
+		if numContainersPerImage[imageID] == 0 {
+			log.Info(removalID, "Remove Image - ", imageID)
+			err := containerRuntime.ImageRemove(imageID)
+			if err != nil {
+				log.Errorf("Edge app removal failed! RemovalID --> %s, CAUSE --> %v", removalID, err)
+				errorlist = fmt.Sprintf("%v,%v", errorlist, err)
+			}
+		}
+	}
+
+	if errorlist != "" {
+		return errors.New("Edge app could not be removed completely. Cause(s): " + errorlist)
 	}
 
 	return nil
@@ -377,6 +457,13 @@ func setAndSendStatus(manifestUniqueID model.ManifestUniqueID, status string) {
 	}
 }
 
+func containerName(container types.Container) string {
+	if len(container.Names) == 0 {
+		return ""
+	}
+	return strings.TrimPrefix(container.Names[0], "/")
+}
+
 func subtractArray(minuend, subtrahend []string) (difference []string) {
 	subtrahendMap := make(map[string]struct{}, len(subtrahend))
 	for _, key := range subtrahend {
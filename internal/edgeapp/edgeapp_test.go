@@ -0,0 +1,426 @@
+package edgeapp_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/weeveiot/weeve-agent/internal/com"
+	"github.com/weeveiot/weeve-agent/internal/config"
+	"github.com/weeveiot/weeve-agent/internal/edgeapp"
+	"github.com/weeveiot/weeve-agent/internal/manifest"
+	"github.com/weeveiot/weeve-agent/internal/model"
+	"github.com/weeveiot/weeve-agent/internal/runtime/fake"
+)
+
+var testdataDir string
+
+func TestMain(m *testing.M) {
+	var err error
+	testdataDir, err = filepath.Abs("../../testdata")
+	if err != nil {
+		panic(err)
+	}
+
+	// the known manifests are persisted in the working directory
+	workDir, err := os.MkdirTemp("", "edgeapp_test")
+	if err != nil {
+		panic(err)
+	}
+	err = os.Chdir(workDir)
+	if err != nil {
+		panic(err)
+	}
+
+	// most tests cover blue/green updates, which are opt-in; don't wait for the new version to settle
+	config.Params.UpdateMode = config.UpdateBlueGreen
+	config.Params.UpdateSettle = 0
+	config.Params.UpdateTimeout = 5
+
+	code := m.Run()
+	os.RemoveAll(workDir)
+	os.Exit(code)
+}
+
+func TestEdgeAppLifecycle(t *testing.T) {
+	assert := assert.New(t)
+	rt := fake.NewRuntime()
+	edgeapp.SetRuntime(rt)
+
+	man := readManifest(t, "test_manifest.json")
+	man2 := readManifest(t, "test_manifest2.json")
+
+	// DEPLOY
+	err := edgeapp.DeployEdgeApp(man)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Len(rt.Pulls(), len(man.Modules))
+	assertContainers(t, rt, man.UniqueID, len(man.Modules), "running")
+	assertNetworks(t, rt, man.UniqueID, 1)
+	assertStatus(t, man.UniqueID, model.EdgeAppRunning)
+
+	// STOP
+	err = edgeapp.StopEdgeApp(man.UniqueID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertContainers(t, rt, man.UniqueID, len(man.Modules), "exited")
+	assertStatus(t, man.UniqueID, model.EdgeAppStopped)
+
+	// RESUME
+	err = edgeapp.ResumeEdgeApp(man.UniqueID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertContainers(t, rt, man.UniqueID, len(man.Modules), "running")
+	assertStatus(t, man.UniqueID, model.EdgeAppRunning)
+
+	// DEPLOY a newer version
+	err = edgeapp.DeployEdgeApp(man2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertContainers(t, rt, man2.UniqueID, len(man2.Modules), "running")
+	assertNetworks(t, rt, man2.UniqueID, 1)
+	assertStatus(t, man2.UniqueID, model.EdgeAppRunning)
+	// the image that is not part of the new version is removed, the others are reused
+	exists, _ := rt.ImageExists("weevenetwork/fluctuation-filter:v1.0.0")
+	assert.False(exists)
+	assert.Len(rt.Pulls(), len(man.Modules))
+
+	// UNDEPLOY
+	err = edgeapp.UndeployEdgeApp(man2.UniqueID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertContainers(t, rt, man2.UniqueID, 0, "")
+	assertNetworks(t, rt, man2.UniqueID, 0)
+	assertImages(t, rt, man2, true)
+	assertStatus(t, man2.UniqueID, model.EdgeAppUndeployed)
+
+	// REMOVE
+	err = edgeapp.DeployEdgeApp(man2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = edgeapp.RemoveEdgeApp(man2.UniqueID, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertContainers(t, rt, man2.UniqueID, 0, "")
+	assertNetworks(t, rt, man2.UniqueID, 0)
+	assertImages(t, rt, man2, false)
+	assert.Nil(manifest.GetKnownManifest(man2.UniqueID))
+}
+
+func TestDeployEdgeApp_PullFailure(t *testing.T) {
+	assert := assert.New(t)
+	rt := fake.NewRuntime()
+	edgeapp.SetRuntime(rt)
+
+	man := readManifest(t, "test_manifest.json")
+	rt.FailPull(man.Modules[2].ImageNameFull, errors.New("registry unreachable"))
+
+	err := edgeapp.DeployEdgeApp(man)
+	assert.NotNil(err)
+	assertContainers(t, rt, man.UniqueID, 0, "")
+	assertNetworks(t, rt, man.UniqueID, 0)
+	assert.Nil(manifest.GetKnownManifest(man.UniqueID))
+}
+
+func TestDeployEdgeApp_StartFailure(t *testing.T) {
+	assert := assert.New(t)
+	rt := fake.NewRuntime()
+	edgeapp.SetRuntime(rt)
+
+	man := readManifest(t, "test_manifest.json")
+	rt.FailStart(man.Modules[0].ImageNameFull, errors.New("exec format error"))
+
+	err := edgeapp.DeployEdgeApp(man)
+	assert.NotNil(err)
+	assertContainers(t, rt, man.UniqueID, 0, "")
+	assertNetworks(t, rt, man.UniqueID, 0)
+	assert.Nil(manifest.GetKnownManifest(man.UniqueID))
+}
+
+func TestDeployEdgeApp_ResourcesExceedHost(t *testing.T) {
+	assert := assert.New(t)
+	rt := fake.NewRuntime()
+	edgeapp.SetRuntime(rt)
+
+	// the manifest asks for more CPUs than any node has
+	man := readManifest(t, "unittests/failResourcesExceedHost.json")
+
+	err := edgeapp.DeployEdgeApp(man)
+	var moduleErr *edgeapp.ModuleError
+	if assert.ErrorAs(err, &moduleErr) {
+		assert.Equal(man.Modules[0].ImageNameFull, moduleErr.Image)
+		assert.Contains(err.Error(), "100000 CPUs requested")
+	}
+	assert.Empty(rt.Pulls())
+	assertContainers(t, rt, man.UniqueID, 0, "")
+	assert.Nil(manifest.GetKnownManifest(man.UniqueID))
+}
+
+func TestGetModuleResults(t *testing.T) {
+	assert := assert.New(t)
+	rt := fake.NewRuntime()
+	edgeapp.SetRuntime(rt)
+
+	man := readManifest(t, "test_manifest.json")
+	rt.FailStart(man.Modules[1].ImageNameFull, errors.New("exec format error"))
+
+	err := edgeapp.DeployEdgeApp(man)
+	assert.NotNil(err)
+
+	// all modules are rolled back, the failing one carries the error
+	results := edgeapp.GetModuleResults(man, err)
+	assert.Len(results, len(man.Modules))
+	for i, result := range results {
+		assert.Equal(man.Modules[i].ImageNameFull, result.Image)
+		assert.Equal("Missing", result.Status)
+		if i == 1 {
+			assert.Equal("exec format error", result.Error)
+		} else {
+			assert.Empty(result.Error)
+		}
+	}
+
+	rt.FailStart(man.Modules[1].ImageNameFull, nil)
+	err = edgeapp.DeployEdgeApp(man)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer edgeapp.RemoveEdgeApp(man.UniqueID, nil)
+
+	// the results are looked up with the known manifest after the deployment
+	for _, result := range edgeapp.GetModuleResults(manifest.GetKnownManifest(man.UniqueID).Manifest, nil) {
+		assert.NotEmpty(result.Name)
+		assert.Equal("Running", result.Status)
+		assert.Empty(result.Error)
+	}
+}
+
+func TestGetEdgeAppStatus_ContainerCrash(t *testing.T) {
+	assert := assert.New(t)
+	rt := fake.NewRuntime()
+	edgeapp.SetRuntime(rt)
+
+	man := readManifest(t, "test_manifest.json")
+	err := edgeapp.DeployEdgeApp(man)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer edgeapp.RemoveEdgeApp(man.UniqueID, nil)
+
+	containers, err := rt.ReadEdgeAppContainers(man.UniqueID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = rt.CrashContainer(containers[1].ID, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertStatus(t, man.UniqueID, model.EdgeAppError)
+
+	err = rt.RestartContainer(containers[1].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertStatus(t, man.UniqueID, model.EdgeAppRunning)
+
+	containerJSON, err := rt.InspectContainer(containers[1].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(1, containerJSON.RestartCount)
+}
+
+func TestGetEdgeAppStatus_Unhealthy(t *testing.T) {
+	assert := assert.New(t)
+	rt := fake.NewRuntime()
+	edgeapp.SetRuntime(rt)
+
+	man := readManifest(t, "test_manifest.json")
+	// the containers are created in reverse order, so this is the second one
+	man.Modules[2].Healthcheck = &container.HealthConfig{Test: []string{"CMD", "/bin/check"}}
+	err := edgeapp.DeployEdgeApp(man)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer edgeapp.RemoveEdgeApp(man.UniqueID, nil)
+
+	containers, err := rt.ReadEdgeAppContainers(man.UniqueID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = rt.SetHealth(containers[1].ID, "unhealthy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertStatus(t, man.UniqueID, model.EdgeAppDegraded)
+
+	// the health is reported for the containers with a healthcheck only
+	edgeApps, err := edgeapp.GetEdgeAppStatus()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, edgeApp := range edgeApps {
+		for i, container := range edgeApp.Containers {
+			if i == 1 {
+				assert.Equal("Unhealthy", container.Health)
+			} else {
+				assert.Empty(container.Health)
+			}
+		}
+	}
+
+	err = rt.SetHealth(containers[1].ID, "healthy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertStatus(t, man.UniqueID, model.EdgeAppRunning)
+}
+
+func TestEdgeAppVolumes(t *testing.T) {
+	assert := assert.New(t)
+	rt := fake.NewRuntime()
+	edgeapp.SetRuntime(rt)
+
+	config.Params.UpdateMode = config.UpdateRecreate
+	defer func() { config.Params.UpdateMode = config.UpdateBlueGreen }()
+
+	man := readManifest(t, "test_manifest.json")
+	man.Modules[0].MountConfigs = append(man.Modules[0].MountConfigs, volumeMount(man.UniqueID, "data"))
+	err := edgeapp.DeployEdgeApp(man)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = rt.SetVolumeSize(manifest.VolumeName(man.UniqueID, "data"), 4096)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	edgeApps, err := edgeapp.GetEdgeAppStatus()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Len(edgeApps, 1)
+	assert.Equal([]com.VolumeMsg{{Name: "data", Size: 4096}}, edgeApps[0].Volumes)
+
+	// a newer version keeps the data
+	newMan := newerVersion(t, "test_manifest2.json", man)
+	newMan.Modules[0].MountConfigs = append(newMan.Modules[0].MountConfigs, volumeMount(man.UniqueID, "data"))
+	err = edgeapp.DeployEdgeApp(newMan)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertVolumes(t, rt, man.UniqueID, 4096)
+
+	// so does undeploying, only removing the edge app removes the volumes
+	err = edgeapp.UndeployEdgeApp(man.UniqueID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertVolumes(t, rt, man.UniqueID, 4096)
+
+	err = edgeapp.RemoveEdgeApp(man.UniqueID, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = edgeapp.RemoveEdgeAppVolumes(man.UniqueID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertVolumes(t, rt, man.UniqueID)
+}
+
+func volumeMount(manifestUniqueID model.ManifestUniqueID, name string) mount.Mount {
+	return mount.Mount{
+		Type:   mount.TypeVolume,
+		Source: manifest.VolumeName(manifestUniqueID, name),
+		Target: "/" + name,
+		VolumeOptions: &mount.VolumeOptions{Labels: map[string]string{
+			"manifestUniqueID":   manifestUniqueID.String(),
+			manifest.VolumeLabel: name,
+		}},
+	}
+}
+
+func assertVolumes(t *testing.T, rt *fake.Runtime, manifestUniqueID model.ManifestUniqueID, sizes ...int64) {
+	volumes, err := rt.ReadEdgeAppVolumes(manifestUniqueID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Len(t, volumes, len(sizes))
+	for i, vol := range volumes {
+		assert.Equal(t, sizes[i], vol.UsageData.Size)
+	}
+}
+
+func readManifest(t *testing.T, fileName string) manifest.Manifest {
+	payload, err := os.ReadFile(filepath.Join(testdataDir, fileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	man, err := manifest.Parse(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return man
+}
+
+func assertContainers(t *testing.T, rt *fake.Runtime, manifestUniqueID model.ManifestUniqueID, count int, state string) {
+	containers, err := rt.ReadEdgeAppContainers(manifestUniqueID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Len(t, containers, count)
+	for _, container := range containers {
+		assert.Equal(t, state, container.State)
+	}
+}
+
+func assertNetworks(t *testing.T, rt *fake.Runtime, manifestUniqueID model.ManifestUniqueID, count int) {
+	networks, err := rt.ReadEdgeAppNetworks(manifestUniqueID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Len(t, networks, count)
+}
+
+func assertImages(t *testing.T, rt *fake.Runtime, man manifest.Manifest, exist bool) {
+	for _, module := range man.Modules {
+		exists, err := rt.ImageExists(module.ImageNameFull)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, exist, exists, module.ImageNameFull)
+	}
+}
+
+func assertStatus(t *testing.T, manifestUniqueID model.ManifestUniqueID, status string) {
+	edgeApps, err := edgeapp.GetEdgeAppStatus()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, edgeApp := range edgeApps {
+		if edgeApp.ManifestID == manifestUniqueID.ID {
+			assert.Equal(t, status, edgeApp.Status)
+			return
+		}
+	}
+	t.Errorf("edge app %s not found in status", manifestUniqueID)
+}
@@ -0,0 +1,346 @@
+package edgeapp
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/weeveiot/weeve-agent/internal/com"
+	"github.com/weeveiot/weeve-agent/internal/manifest"
+	"github.com/weeveiot/weeve-agent/internal/model"
+)
+
+const (
+	// logDiscoveryInterval is how often the collector looks for containers to follow
+	logDiscoveryInterval = 5 * time.Second
+	// a stream ends when its container stops, it is followed again with a growing delay until the container restarts
+	logRetryInterval    = time.Second
+	logMaxRetryInterval = 30 * time.Second
+	// logBatchCheckInterval is how often the age of the oldest line in the batch is checked, at most
+	logBatchCheckInterval = time.Second
+	// logJoinTimeout is how long a message that spans several lines waits for more lines
+	logJoinTimeout = time.Second
+	// logMaxRetainedBatches limits the lines kept while sending fails, the oldest lines are dropped beyond
+	logMaxRetainedBatches = 100
+)
+
+// logBatch collects the log lines of all containers until they are sent
+type logBatch struct {
+	mutex   sync.Mutex
+	msgs    []com.EdgeAppLogMsg
+	sizes   []int
+	size    int
+	oldest  time.Time
+	cursors map[model.ManifestUniqueID]map[string]model.LogCursor
+	full    chan struct{}
+
+	maxSize  int
+	maxDelay time.Duration
+	send     func([]com.EdgeAppLogMsg) error
+}
+
+// CollectEdgeAppLogs follows the logs of the containers of all known edge apps and sends the lines with send, once
+// they add up to batchSize bytes or the oldest line waited for maxDelay. After a batch is sent, the position in the
+// logs is persisted, so that the logs resume from there after a restart of the agent. It returns when ctx is cancelled.
+func CollectEdgeAppLogs(ctx context.Context, maxDelay time.Duration, batchSize int, send func([]com.EdgeAppLogMsg) error) {
+	batch := &logBatch{
+		cursors:  make(map[model.ManifestUniqueID]map[string]model.LogCursor),
+		full:     make(chan struct{}, 1),
+		maxSize:  batchSize,
+		maxDelay: maxDelay,
+		send:     send,
+	}
+
+	var wg sync.WaitGroup
+	followers := make(map[string]context.CancelFunc) // by container ID
+	filters := make(map[string]*logFilter)           // by container ID
+	defer func() {
+		for _, cancel := range followers {
+			cancel()
+		}
+		// the lines that the followers collected so far are sent before returning
+		wg.Wait()
+		batch.flush()
+	}()
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		batch.sendLoop(ctx)
+	}()
+
+	for {
+		alive := make(map[string]bool)
+		discovered := true
+		for uniqueID, manif := range manifest.GetKnownManifests() {
+			containers, err := containerRuntime.ReadEdgeAppContainers(uniqueID)
+			if err != nil {
+				log.Error("Failed to read the containers of edge app ", uniqueID, "! CAUSE --> ", err)
+				discovered = false
+				continue
+			}
+
+			var containerIDs []string
+			for _, container := range containers {
+				containerIDs = append(containerIDs, container.ID)
+				alive[container.ID] = true
+				// the log limits can change without a deployment
+				limits := moduleLogLimits(manif.Manifest, containerName(container))
+				if _, following := followers[container.ID]; following {
+					filters[container.ID].configure(limits)
+					continue
+				}
+
+				followCtx, cancel := context.WithCancel(ctx)
+				followers[container.ID] = cancel
+				filters[container.ID] = newLogFilter(limits)
+				wg.Add(1)
+				go func(manif manifest.ManifestRecord, container types.Container, filter *logFilter) {
+					defer wg.Done()
+					followContainerLogs(followCtx, manif, container, filter, batch)
+				}(*manif, container, filters[container.ID])
+			}
+			manifest.PruneLogCursors(uniqueID, containerIDs)
+		}
+
+		// the followers of containers that are gone are stopped, unless some containers couldn't be read
+		if discovered {
+			for containerID, cancel := range followers {
+				if !alive[containerID] {
+					cancel()
+					delete(followers, containerID)
+					delete(filters, containerID)
+				}
+			}
+		}
+
+		select {
+		case <-time.After(logDiscoveryInterval):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// followContainerLogs passes the log messages of the container that the filter lets through to the batch, following
+// the container again when its stream ends, e.g. because it stopped, until ctx is cancelled
+func followContainerLogs(ctx context.Context, manif manifest.ManifestRecord, container types.Container, filter *logFilter, batch *logBatch) {
+	uniqueID := manif.Manifest.UniqueID
+	cursor, found := manif.LogCursors[container.ID]
+	if !found && manif.LastLogReadTime != "" {
+		lastRead, err := time.Parse(time.RFC3339Nano, manif.LastLogReadTime)
+		if err == nil {
+			cursor = model.LogCursor{Time: lastRead}
+		}
+	}
+	log.Debug("Following the logs of container ", container.ID, " from ", cursor.Time)
+
+	logs := newModuleLogs(manif.Manifest, container)
+	summaryTemplate := com.EdgeAppLogMsg{ManifestID: manif.Manifest.ID, ContainerID: container.ID, ModuleName: moduleName(container.Image)}
+	addMessages := func(messages []logMessage) {
+		now := time.Now()
+		for _, message := range messages {
+			pass := filter.pass(now, message)
+			var msgs []com.EdgeAppLogMsg
+			if summary, ok := filter.summary(now, false, summaryTemplate); ok {
+				msgs = append(msgs, summary)
+			}
+			if pass {
+				msgs = append(msgs, message.msg)
+			}
+			// the cursor moves past dropped messages too, so that they aren't read again
+			batch.add(uniqueID, container.ID, msgs, message.cursor)
+		}
+	}
+
+	retryInterval := logRetryInterval
+	for {
+		lines, errs := containerRuntime.FollowContainerLogs(ctx, container.ID, cursor)
+		for following := true; following; {
+			// a message that spans several lines is complete once the next one starts, or no line followed for a while
+			var joinTimeout <-chan time.Time
+			if logs.hasPending() {
+				joinTimeout = time.After(logJoinTimeout)
+			}
+			// the messages that were dropped are summed up right away when no more messages follow
+			var quietTimeout <-chan time.Time
+			if filter.hasDropped() {
+				quietTimeout = time.After(logQuietInterval)
+			}
+
+			select {
+			case line, ok := <-lines:
+				if !ok {
+					following = false
+					break
+				}
+				addMessages(logs.add(line))
+				cursor = line.Cursor
+				retryInterval = logRetryInterval
+			case <-joinTimeout:
+				addMessages(logs.flush())
+			case now := <-quietTimeout:
+				if summary, ok := filter.summary(now, true, summaryTemplate); ok {
+					batch.add(uniqueID, container.ID, []com.EdgeAppLogMsg{summary}, cursor)
+				}
+			}
+		}
+		addMessages(logs.flush())
+
+		select {
+		case err := <-errs:
+			log.Warning("Following the logs of container ", container.ID, " failed! CAUSE --> ", err)
+		default:
+		}
+
+		select {
+		case <-time.After(retryInterval):
+		case <-ctx.Done():
+			return
+		}
+		retryInterval *= 2
+		if retryInterval > logMaxRetryInterval {
+			retryInterval = logMaxRetryInterval
+		}
+	}
+}
+
+func (b *logBatch) add(uniqueID model.ManifestUniqueID, containerID string, msgs []com.EdgeAppLogMsg, cursor model.LogCursor) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if len(b.msgs) == 0 && len(msgs) > 0 {
+		b.oldest = time.Now()
+	}
+	for _, msg := range msgs {
+		size := logMsgSize(msg)
+		b.msgs = append(b.msgs, msg)
+		b.sizes = append(b.sizes, size)
+		b.size += size
+	}
+	if b.cursors[uniqueID] == nil {
+		b.cursors[uniqueID] = make(map[string]model.LogCursor)
+	}
+	b.cursors[uniqueID][containerID] = cursor
+
+	if b.size >= b.maxSize {
+		select {
+		case b.full <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// sendLoop sends the batch whenever it is full or its oldest line is due, until ctx is cancelled
+func (b *logBatch) sendLoop(ctx context.Context) {
+	checkInterval := logBatchCheckInterval
+	if b.maxDelay < checkInterval {
+		checkInterval = b.maxDelay
+	}
+	ticker := time.NewTicker(checkInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-b.full:
+		case <-ticker.C:
+			if !b.due() {
+				continue
+			}
+		case <-ctx.Done():
+			return
+		}
+		b.flush()
+	}
+}
+
+func (b *logBatch) due() bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	return len(b.msgs) > 0 && (b.size >= b.maxSize || time.Since(b.oldest) >= b.maxDelay)
+}
+
+// flush sends the collected lines and persists the cursors. If sending fails, the lines that weren't sent are kept for
+// the next try.
+func (b *logBatch) flush() {
+	b.mutex.Lock()
+	msgs, sizes, size, oldest, cursors := b.msgs, b.sizes, b.size, b.oldest, b.cursors
+	b.msgs, b.sizes, b.size = nil, nil, 0
+	b.cursors = make(map[model.ManifestUniqueID]map[string]model.LogCursor)
+	b.mutex.Unlock()
+
+	if len(msgs) == 0 && len(cursors) == 0 {
+		return
+	}
+
+	// there may be only cursors, of messages that were dropped
+	if len(msgs) > 0 {
+		err := b.send(msgs)
+		if err != nil {
+			log.Error("Sending edge app logs failed! CAUSE --> ", err)
+			// only the lines of the chunks that weren't sent are sent again
+			var uploadErr *com.LogUploadError
+			if errors.As(err, &uploadErr) {
+				for _, sent := range sizes[:uploadErr.Sent] {
+					size -= sent
+				}
+				msgs, sizes = msgs[uploadErr.Sent:], sizes[uploadErr.Sent:]
+			}
+			b.retain(msgs, sizes, size, oldest, cursors)
+			return
+		}
+	}
+
+	for uniqueID, containerCursors := range cursors {
+		err := manifest.SetLogCursors(uniqueID, containerCursors)
+		if err != nil {
+			// the edge app was removed in the meantime
+			log.Debug("Log cursors of edge app ", uniqueID, " not set: ", err)
+		}
+	}
+}
+
+// retain puts lines that couldn't be sent back in front of the lines collected since
+func (b *logBatch) retain(msgs []com.EdgeAppLogMsg, sizes []int, size int, oldest time.Time, cursors map[model.ManifestUniqueID]map[string]model.LogCursor) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.msgs = append(msgs, b.msgs...)
+	b.sizes = append(sizes, b.sizes...)
+	b.size += size
+	b.oldest = oldest
+	// newer cursors of the same containers win
+	for uniqueID, containerCursors := range b.cursors {
+		if cursors[uniqueID] == nil {
+			cursors[uniqueID] = make(map[string]model.LogCursor)
+		}
+		for containerID, cursor := range containerCursors {
+			cursors[uniqueID][containerID] = cursor
+		}
+	}
+	b.cursors = cursors
+
+	dropped := 0
+	for b.size > b.maxSize*logMaxRetainedBatches {
+		b.size -= b.sizes[0]
+		b.msgs, b.sizes = b.msgs[1:], b.sizes[1:]
+		dropped++
+	}
+	if dropped > 0 {
+		log.Warning("Dropped ", dropped, " edge app log lines that couldn't be sent")
+	}
+}
+
+func logMsgSize(msg com.EdgeAppLogMsg) int {
+	encoded, err := json.Marshal(msg)
+	if err != nil {
+		return len(msg.Message)
+	}
+	return len(encoded)
+}
@@ -0,0 +1,248 @@
+package edgeapp_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/weeveiot/weeve-agent/internal/com"
+	"github.com/weeveiot/weeve-agent/internal/edgeapp"
+	"github.com/weeveiot/weeve-agent/internal/manifest"
+	"github.com/weeveiot/weeve-agent/internal/runtime/fake"
+)
+
+// logSink records the log lines that were sent, sending fails while err is set. If accepted is set as well, that many
+// lines are sent before sending fails.
+type logSink struct {
+	mutex    sync.Mutex
+	lines    []string
+	times    []time.Time
+	streams  []string
+	err      error
+	accepted int
+}
+
+func (s *logSink) send(msgs []com.EdgeAppLogMsg) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.err != nil {
+		if s.accepted == 0 || s.accepted >= len(msgs) {
+			return s.err
+		}
+		msgs = msgs[:s.accepted]
+		defer func() { s.accepted = 0 }()
+	}
+	for _, msg := range msgs {
+		s.lines = append(s.lines, msg.Message)
+		s.times = append(s.times, msg.Time)
+		s.streams = append(s.streams, msg.Stream)
+	}
+	if s.err != nil {
+		return &com.LogUploadError{Sent: len(msgs), Err: s.err}
+	}
+	return nil
+}
+
+func (s *logSink) sent() []string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return append([]string(nil), s.lines...)
+}
+
+func (s *logSink) setErr(err error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.err = err
+}
+
+func TestCollectEdgeAppLogs(t *testing.T) {
+	assert := assert.New(t)
+	rt := fake.NewRuntime()
+	edgeapp.SetRuntime(rt)
+
+	man := readManifest(t, "test_manifest.json")
+	err := edgeapp.DeployEdgeApp(man)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer edgeapp.RemoveEdgeApp(man.UniqueID, nil)
+
+	containers, err := rt.ReadEdgeAppContainers(man.UniqueID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	containerID := containers[0].ID
+	err = rt.AppendLogs(containerID, "first")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = rt.AppendErrLogs(containerID, "second")
+	if err != nil {
+		t.Fatal(err)
+	}
+	written, err := rt.ReadContainerLogs(containerID, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sink := &logSink{}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		edgeapp.CollectEdgeAppLogs(ctx, 50*time.Millisecond, 1<<20, sink.send)
+		close(done)
+	}()
+
+	// the lines keep the timestamps and the streams of the container engine
+	assert.Eventually(func() bool { return len(sink.sent()) == 2 }, time.Second, 10*time.Millisecond)
+	assert.Equal([]string{"first", "second"}, sink.sent())
+	assert.Equal([]string{"stdout", "stderr"}, sink.streams)
+	assert.Equal(written[0].Time, sink.times[0])
+	assert.Equal(written[1].Time, sink.times[1])
+
+	// lines that can't be sent are sent once sending works again
+	sink.setErr(errors.New("broker unavailable"))
+	err = rt.AppendLogs(containerID, "third")
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(200 * time.Millisecond)
+	sink.setErr(nil)
+	assert.Eventually(func() bool { return len(sink.sent()) == 3 }, time.Second, 10*time.Millisecond)
+
+	// the logs are followed again after the container restarted
+	err = rt.CrashContainer(containerID, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	err = rt.RestartContainer(containerID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = rt.AppendLogs(containerID, "after restart")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Eventually(func() bool { return len(sink.sent()) == 4 }, 5*time.Second, 10*time.Millisecond)
+
+	// a new collector resumes from the persisted cursor, without sending the lines again
+	cancel()
+	<-done
+	assert.Equal(sink.times[3], manifest.GetKnownManifest(man.UniqueID).LogCursors[containerID].Time)
+	err = rt.AppendLogs(containerID, "after agent restart")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	go edgeapp.CollectEdgeAppLogs(ctx, 50*time.Millisecond, 1<<20, sink.send)
+	assert.Eventually(func() bool { return len(sink.sent()) == 5 }, time.Second, 10*time.Millisecond)
+	time.Sleep(200 * time.Millisecond)
+	assert.Equal([]string{"first", "second", "third", "after restart", "after agent restart"}, sink.sent())
+}
+
+func TestCollectEdgeAppLogs_PartialUpload(t *testing.T) {
+	assert := assert.New(t)
+	rt := fake.NewRuntime()
+	edgeapp.SetRuntime(rt)
+
+	man := readManifest(t, "test_manifest.json")
+	err := edgeapp.DeployEdgeApp(man)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer edgeapp.RemoveEdgeApp(man.UniqueID, nil)
+
+	containers, err := rt.ReadEdgeAppContainers(man.UniqueID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the upload fails after the first chunk, only the lines of the other chunks are sent again
+	sink := &logSink{err: errors.New("broker unavailable"), accepted: 2}
+	err = rt.AppendLogs(containers[0].ID, "first", "second", "third", "fourth")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go edgeapp.CollectEdgeAppLogs(ctx, 50*time.Millisecond, 1<<20, sink.send)
+
+	assert.Eventually(func() bool { return len(sink.sent()) == 2 }, time.Second, 10*time.Millisecond)
+	sink.setErr(nil)
+	assert.Eventually(func() bool { return len(sink.sent()) == 4 }, time.Second, 10*time.Millisecond)
+	time.Sleep(200 * time.Millisecond)
+	assert.Equal([]string{"first", "second", "third", "fourth"}, sink.sent())
+}
+
+func TestCollectEdgeAppLogs_Limits(t *testing.T) {
+	assert := assert.New(t)
+	rt := fake.NewRuntime()
+	edgeapp.SetRuntime(rt)
+
+	man := readManifest(t, "test_manifest.json")
+	for i := range man.Modules {
+		man.Modules[i].Logs = &manifest.LogSettings{Limits: manifest.LogLimits{Level: "INFO", RateLimit: 1, Burst: 2}}
+	}
+	err := edgeapp.DeployEdgeApp(man)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer edgeapp.RemoveEdgeApp(man.UniqueID, nil)
+
+	containers, err := rt.ReadEdgeAppContainers(man.UniqueID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	containerID := containers[0].ID
+
+	sink := &logSink{}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go edgeapp.CollectEdgeAppLogs(ctx, 50*time.Millisecond, 1<<20, sink.send)
+
+	// messages below the level are dropped, lines without a level aren't, and the messages over the rate limit are summed up
+	err = rt.AppendLogs(containerID, `{"level": "debug", "message": "hidden"}`, `{"level": "info", "message": "one"}`, "two", "three", "four")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"one", "two", "2 log lines dropped: 2 over the rate limit of 1 per second"}
+	assert.Eventually(func() bool { return len(sink.sent()) == len(want) }, 3*time.Second, 10*time.Millisecond)
+	assert.Equal(want, sink.sent())
+
+	// the limits change without a deployment
+	limits := make([]manifest.LogLimits, len(man.Modules))
+	for i := range limits {
+		limits[i] = manifest.LogLimits{Level: "ERROR", Sampling: manifest.LogSampling{Interval: time.Minute, First: 1, Thereafter: 2}}
+	}
+	err = edgeapp.SetLogLimits(man.UniqueID, limits)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(limits[0], manifest.GetKnownManifest(man.UniqueID).Manifest.Modules[0].Logs.Limits)
+	time.Sleep(5500 * time.Millisecond)
+
+	err = rt.AppendLogs(containerID, `{"level": "info", "message": "ignored"}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 4; i++ {
+		err = rt.AppendLogs(containerID, `{"level": "error", "message": "same"}`)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	want = append(want, "same", "same", "2 log lines dropped: 2 repeated")
+	assert.Eventually(func() bool { return len(sink.sent()) == len(want) }, 3*time.Second, 10*time.Millisecond)
+	assert.Equal(want, sink.sent())
+}
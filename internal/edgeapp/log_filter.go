@@ -0,0 +1,193 @@
+package edgeapp
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/weeveiot/weeve-agent/internal/com"
+	"github.com/weeveiot/weeve-agent/internal/manifest"
+	"github.com/weeveiot/weeve-agent/internal/model"
+	traceutility "github.com/weeveiot/weeve-agent/internal/utility/trace"
+)
+
+const (
+	// logSummaryInterval is how often the messages that are dropped while messages keep coming are summed up
+	logSummaryInterval = 10 * time.Second
+	// logQuietInterval is how long no messages have to come to sum up the dropped messages right away
+	logQuietInterval = time.Second
+	// logSummaryFilename is the filename of the messages that sum up dropped messages
+	logSummaryFilename = "weeve-agent"
+)
+
+// logFilter decides which log messages of a module are sent, according to the log limits of the module. The limits can
+// change while the module runs.
+type logFilter struct {
+	mutex  sync.Mutex
+	limits manifest.LogLimits
+
+	minSeverity int
+	hasMinLevel bool
+
+	// token bucket of the rate limit
+	tokens     float64
+	lastRefill time.Time
+
+	// counts of the same messages since windowStart, by level and message
+	repeats     map[string]int
+	windowStart time.Time
+
+	// the messages dropped since firstDrop, which are summed up in one message
+	rateLimited int
+	sampled     int
+	firstDrop   time.Time
+}
+
+func newLogFilter(limits manifest.LogLimits) *logFilter {
+	f := &logFilter{}
+	f.configure(limits)
+	return f
+}
+
+// configure applies new limits, the counts of the old ones are kept
+func (f *logFilter) configure(limits manifest.LogLimits) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if f.repeats != nil && limits == f.limits {
+		return
+	}
+	f.limits = limits
+	f.minSeverity, f.hasMinLevel = manifest.LogLevelSeverity(limits.Level)
+	f.tokens = float64(f.burst())
+	f.lastRefill = time.Time{}
+	f.repeats = make(map[string]int)
+	f.windowStart = time.Time{}
+}
+
+// burst is the size of the token bucket, at least one message
+func (f *logFilter) burst() int {
+	if f.limits.Burst > 0 {
+		return f.limits.Burst
+	}
+	return int(math.Max(1, math.Ceil(f.limits.RateLimit)))
+}
+
+// pass tells if the message is sent. Messages without a level of their own are never dropped because of their level.
+func (f *logFilter) pass(now time.Time, message logMessage) bool {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if f.hasMinLevel && message.leveled {
+		if severity, known := manifest.LogLevelSeverity(message.msg.Level); known && severity < f.minSeverity {
+			return false
+		}
+	}
+
+	if f.limits.Sampling.First > 0 {
+		if now.Sub(f.windowStart) >= f.limits.Sampling.Interval {
+			f.repeats = make(map[string]int)
+			f.windowStart = now
+		}
+		key := message.msg.Level + "\x00" + message.msg.Message
+		f.repeats[key]++
+		count := f.repeats[key]
+		if count > f.limits.Sampling.First {
+			thereafter := f.limits.Sampling.Thereafter
+			if thereafter == 0 || (count-f.limits.Sampling.First)%thereafter != 0 {
+				f.dropped(now)
+				f.sampled++
+				return false
+			}
+		}
+	}
+
+	if f.limits.RateLimit > 0 {
+		if !f.lastRefill.IsZero() {
+			f.tokens = math.Min(float64(f.burst()), f.tokens+now.Sub(f.lastRefill).Seconds()*f.limits.RateLimit)
+		}
+		f.lastRefill = now
+		if f.tokens < 1 {
+			f.dropped(now)
+			f.rateLimited++
+			return false
+		}
+		f.tokens--
+	}
+
+	return true
+}
+
+func (f *logFilter) dropped(now time.Time) {
+	if f.rateLimited == 0 && f.sampled == 0 {
+		f.firstDrop = now
+	}
+}
+
+// hasDropped tells if there are dropped messages that weren't summed up yet
+func (f *logFilter) hasDropped() bool {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	return f.rateLimited > 0 || f.sampled > 0
+}
+
+// summary returns a message that sums up the dropped messages, once the first of them was dropped logSummaryInterval
+// ago or right away if quiet is set, because no messages came for a while
+func (f *logFilter) summary(now time.Time, quiet bool, template com.EdgeAppLogMsg) (com.EdgeAppLogMsg, bool) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	dropped := f.rateLimited + f.sampled
+	if dropped == 0 || !quiet && now.Sub(f.firstDrop) < logSummaryInterval {
+		return com.EdgeAppLogMsg{}, false
+	}
+
+	var reasons []string
+	if f.rateLimited > 0 {
+		reasons = append(reasons, fmt.Sprintf("%d over the rate limit of %g per second", f.rateLimited, f.limits.RateLimit))
+	}
+	if f.sampled > 0 {
+		reasons = append(reasons, fmt.Sprintf("%d repeated", f.sampled))
+	}
+	lines := "lines"
+	if dropped == 1 {
+		lines = "line"
+	}
+
+	msg := template
+	msg.Time = now
+	msg.Stream = ""
+	msg.Level = "WARNING"
+	msg.Filename = logSummaryFilename
+	msg.Message = fmt.Sprintf("%d log %s dropped: %s", dropped, lines, strings.Join(reasons, ", "))
+
+	f.rateLimited = 0
+	f.sampled = 0
+	return msg, true
+}
+
+// moduleLogLimits returns the log limits of the module that runs in the container
+func moduleLogLimits(man manifest.Manifest, name string) manifest.LogLimits {
+	for _, module := range man.Modules {
+		if module.ContainerName == name && module.Logs != nil {
+			return module.Logs.Limits
+		}
+	}
+	return manifest.LogLimits{}
+}
+
+// SetLogLimits replaces the log limits of the modules of an edge app, by the index of the module. The log collector
+// applies them to the modules that are running within logDiscoveryInterval.
+func SetLogLimits(manifestUniqueID model.ManifestUniqueID, limits []manifest.LogLimits) error {
+	err := manifest.SetLogLimits(manifestUniqueID, limits)
+	if err != nil {
+		return traceutility.Wrap(err)
+	}
+	log.Info("Log limits of edge app ", manifestUniqueID, " changed")
+	return nil
+}
@@ -0,0 +1,470 @@
+package edgeapp
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/docker/docker/api/types"
+
+	"github.com/weeveiot/weeve-agent/internal/com"
+	"github.com/weeveiot/weeve-agent/internal/manifest"
+	"github.com/weeveiot/weeve-agent/internal/model"
+	"github.com/weeveiot/weeve-agent/internal/runtime"
+)
+
+const (
+	defaultLogLevel    = "DEBUG"
+	defaultLogFilename = "unknown"
+	// defaultMaxJoinedLines limits the lines joined into one message, unless the manifest sets another limit
+	defaultMaxJoinedLines = 500
+)
+
+// defaultLogFields are tried in order when the manifest doesn't name the fields
+var defaultLogFields = logFields{
+	time:     []string{"timestamp", "time", "ts", "asctime"},
+	level:    []string{"level", "levelname", "lvl", "severity"},
+	filename: []string{"filename", "file", "caller"},
+	message:  []string{"message", "msg"},
+}
+
+// logTimeLayouts are tried in order when the manifest doesn't tell the format of the timestamps
+var logTimeLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02 15:04:05.999999999Z07:00",
+	"2006-01-02 15:04:05.999999999",
+	"2006-01-02 15:04:05,999", // Python logging
+	"2006-01-02T15:04:05.999999999",
+}
+
+// logParser extracts the fields of a log message from a line of a module's output
+type logParser interface {
+	// parse returns false if the line isn't in the format of the parser
+	parse(line string) (com.EdgeAppLogMsg, bool)
+}
+
+type logFields struct {
+	time     []string
+	level    []string
+	filename []string
+	message  []string
+}
+
+func newLogFields(parser manifest.LogParser) logFields {
+	fields := defaultLogFields
+	if parser.TimeField != "" {
+		fields.time = []string{parser.TimeField}
+	}
+	if parser.LevelField != "" {
+		fields.level = []string{parser.LevelField}
+	}
+	if parser.FilenameField != "" {
+		fields.filename = []string{parser.FilenameField}
+	}
+	if parser.MessageField != "" {
+		fields.message = []string{parser.MessageField}
+	}
+	return fields
+}
+
+// jsonLogParser parses lines that are JSON objects
+type jsonLogParser struct {
+	fields     logFields
+	timeFormat string
+}
+
+func (p jsonLogParser) parse(line string) (com.EdgeAppLogMsg, bool) {
+	var object map[string]interface{}
+	err := json.Unmarshal([]byte(line), &object)
+	if err != nil || object == nil {
+		return com.EdgeAppLogMsg{}, false
+	}
+
+	lookup := func(names []string) (interface{}, bool) {
+		for _, name := range names {
+			if value, found := jsonField(object, name); found {
+				return value, true
+			}
+		}
+		return nil, false
+	}
+
+	var msg com.EdgeAppLogMsg
+	if value, found := lookup(p.fields.time); found {
+		msg.Time, _ = parseLogValueTime(value, p.timeFormat)
+	}
+	if value, found := lookup(p.fields.level); found {
+		msg.Level = jsonString(value)
+	}
+	if value, found := lookup(p.fields.filename); found {
+		msg.Filename = jsonString(value)
+	}
+	if value, found := lookup(p.fields.message); found {
+		msg.Message = jsonString(value)
+	}
+	return msg, true
+}
+
+// jsonField looks up a field, the dots in the name separate the keys of nested objects
+func jsonField(object map[string]interface{}, name string) (interface{}, bool) {
+	if value, found := object[name]; found {
+		return value, true
+	}
+	key, rest, nested := strings.Cut(name, ".")
+	if !nested {
+		return nil, false
+	}
+	child, ok := object[key].(map[string]interface{})
+	if !ok {
+		return nil, false
+	}
+	return jsonField(child, rest)
+}
+
+func jsonString(value interface{}) string {
+	switch value := value.(type) {
+	case string:
+		return value
+	case nil:
+		return ""
+	default:
+		encoded, err := json.Marshal(value)
+		if err != nil {
+			return fmt.Sprint(value)
+		}
+		return string(encoded)
+	}
+}
+
+// logfmtLogParser parses lines of key=value pairs, the values quoted if they contain spaces
+type logfmtLogParser struct {
+	fields     logFields
+	timeFormat string
+}
+
+func (p logfmtLogParser) parse(line string) (com.EdgeAppLogMsg, bool) {
+	pairs, ok := parseLogfmt(line)
+	if !ok {
+		return com.EdgeAppLogMsg{}, false
+	}
+
+	lookup := func(names []string) (string, bool) {
+		for _, name := range names {
+			if value, found := pairs[name]; found {
+				return value, true
+			}
+		}
+		return "", false
+	}
+
+	// plain text can contain a key=value pair now and then, a logfmt line has a level or a message
+	level, hasLevel := lookup(p.fields.level)
+	message, hasMessage := lookup(p.fields.message)
+	if !hasLevel && !hasMessage {
+		return com.EdgeAppLogMsg{}, false
+	}
+
+	msg := com.EdgeAppLogMsg{Level: level, Message: message}
+	if value, found := lookup(p.fields.time); found {
+		msg.Time, _ = parseLogTime(value, p.timeFormat)
+	}
+	msg.Filename, _ = lookup(p.fields.filename)
+	return msg, true
+}
+
+// parseLogfmt returns the pairs of a logfmt line, or false if the line has something else than pairs
+func parseLogfmt(line string) (map[string]string, bool) {
+	pairs := make(map[string]string)
+	rest := strings.TrimSpace(line)
+	for rest != "" {
+		end := strings.IndexFunc(rest, func(r rune) bool { return r == '=' || unicode.IsSpace(r) })
+		if end == 0 {
+			return nil, false
+		}
+		if end < 0 || rest[end] != '=' {
+			return nil, false
+		}
+		key := rest[:end]
+		rest = rest[end+1:]
+
+		var value string
+		if strings.HasPrefix(rest, `"`) {
+			quoted, err := strconv.QuotedPrefix(rest)
+			if err != nil {
+				return nil, false
+			}
+			value, err = strconv.Unquote(quoted)
+			if err != nil {
+				return nil, false
+			}
+			rest = rest[len(quoted):]
+			if rest != "" && !unicode.IsSpace(rune(rest[0])) {
+				return nil, false
+			}
+		} else {
+			end = strings.IndexFunc(rest, unicode.IsSpace)
+			if end < 0 {
+				end = len(rest)
+			}
+			value = rest[:end]
+			rest = rest[end:]
+		}
+
+		pairs[key] = value
+		rest = strings.TrimLeftFunc(rest, unicode.IsSpace)
+	}
+	return pairs, len(pairs) > 0
+}
+
+// regexLogParser parses lines of text with a pattern, whose named groups are the fields
+type regexLogParser struct {
+	pattern    *regexp.Regexp
+	timeFormat string
+}
+
+func (p regexLogParser) parse(line string) (com.EdgeAppLogMsg, bool) {
+	match := p.pattern.FindStringSubmatch(line)
+	if match == nil {
+		return com.EdgeAppLogMsg{}, false
+	}
+
+	msg := com.EdgeAppLogMsg{Message: line}
+	for i, name := range p.pattern.SubexpNames() {
+		switch name {
+		case manifest.LogFieldTime:
+			msg.Time, _ = parseLogTime(match[i], p.timeFormat)
+		case manifest.LogFieldLevel:
+			msg.Level = match[i]
+		case manifest.LogFieldFilename:
+			msg.Filename = match[i]
+		case manifest.LogFieldMessage:
+			msg.Message = match[i]
+		}
+	}
+	return msg, true
+}
+
+// parseLogTime parses a timestamp in the format of the manifest, or in the formats that are common if it has none
+func parseLogTime(value string, format string) (time.Time, bool) {
+	switch strings.ToLower(format) {
+	case "":
+		for _, layout := range logTimeLayouts {
+			if timestamp, err := time.Parse(layout, value); err == nil {
+				return timestamp, true
+			}
+		}
+		if seconds, err := strconv.ParseFloat(value, 64); err == nil {
+			return unixTime(seconds), true
+		}
+		return time.Time{}, false
+	case "rfc3339":
+		timestamp, err := time.Parse(time.RFC3339Nano, value)
+		return timestamp, err == nil
+	case "unix":
+		seconds, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return time.Time{}, false
+		}
+		return unixTime(seconds), true
+	case "unixms":
+		milliseconds, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return time.Time{}, false
+		}
+		return unixTime(milliseconds / 1000), true
+	default:
+		timestamp, err := time.Parse(format, value)
+		return timestamp, err == nil
+	}
+}
+
+// parseLogValueTime parses a timestamp of a JSON line, which can be a number of seconds
+func parseLogValueTime(value interface{}, format string) (time.Time, bool) {
+	switch value := value.(type) {
+	case string:
+		return parseLogTime(value, format)
+	case float64:
+		if strings.ToLower(format) == "unixms" {
+			return unixTime(value / 1000), true
+		}
+		return unixTime(value), true
+	default:
+		return time.Time{}, false
+	}
+}
+
+func unixTime(seconds float64) time.Time {
+	whole, fraction := math.Modf(seconds)
+	return time.Unix(int64(whole), int64(fraction*1e9)).UTC()
+}
+
+func newLogParser(parser manifest.LogParser) (logParser, error) {
+	switch parser.Type {
+	case manifest.LogParserJSON:
+		return jsonLogParser{fields: newLogFields(parser), timeFormat: parser.TimeFormat}, nil
+	case manifest.LogParserLogfmt:
+		return logfmtLogParser{fields: newLogFields(parser), timeFormat: parser.TimeFormat}, nil
+	case manifest.LogParserRegex:
+		pattern, err := regexp.Compile(parser.Pattern)
+		if err != nil {
+			return nil, err
+		}
+		return regexLogParser{pattern: pattern, timeFormat: parser.TimeFormat}, nil
+	default:
+		return nil, fmt.Errorf("unknown log parser %q", parser.Type)
+	}
+}
+
+// logMessage is a message put together from lines of a module's output. Once it is sent, the logs continue at cursor.
+type logMessage struct {
+	msg     com.EdgeAppLogMsg
+	leveled bool // the level is from the line rather than the default
+	cursor  model.LogCursor
+	lines   int
+}
+
+// moduleLogs turns the lines of a module's output into log messages, with the parsers of the module in the manifest.
+// Without parsers, JSON lines with the common field names are parsed.
+type moduleLogs struct {
+	manifestID  string
+	containerID string
+	moduleName  string
+	parsers     []logParser
+
+	// multiline joins lines, which are kept in pending until the next message starts
+	multiline bool
+	start     *regexp.Regexp
+	maxLines  int
+	pending   *logMessage
+}
+
+func newModuleLogs(man manifest.Manifest, container types.Container) *moduleLogs {
+	logs := &moduleLogs{
+		manifestID:  man.ID,
+		containerID: container.ID,
+		moduleName:  moduleName(container.Image),
+		parsers:     []logParser{jsonLogParser{fields: defaultLogFields}},
+	}
+
+	var parsing *manifest.LogSettings
+	for _, module := range man.Modules {
+		if module.ContainerName == containerName(container) {
+			parsing = module.Logs
+		}
+	}
+	if parsing == nil {
+		return logs
+	}
+
+	if len(parsing.Parsers) > 0 {
+		logs.parsers = nil
+		for _, parser := range parsing.Parsers {
+			// the parsers are checked when the manifest is parsed
+			p, err := newLogParser(parser)
+			if err != nil {
+				continue
+			}
+			logs.parsers = append(logs.parsers, p)
+		}
+	}
+	if parsing.Multiline != nil {
+		logs.multiline = true
+		logs.maxLines = parsing.Multiline.MaxLines
+		if logs.maxLines == 0 {
+			logs.maxLines = defaultMaxJoinedLines
+		}
+		if parsing.Multiline.Start != "" {
+			logs.start, _ = regexp.Compile(parsing.Multiline.Start)
+		}
+	}
+	return logs
+}
+
+// add returns the messages that the line completes
+func (m *moduleLogs) add(line runtime.LogLine) []logMessage {
+	msg, parsed := m.parse(line)
+	message := logMessage{msg: msg, leveled: msg.Level != "", cursor: line.Cursor, lines: 1}
+	if !message.leveled {
+		message.msg.Level = defaultLogLevel
+	}
+
+	if !m.multiline {
+		return []logMessage{message}
+	}
+
+	pending := m.pending
+	if pending != nil && pending.msg.Stream == line.Stream && pending.lines < m.maxLines && m.continues(line, parsed) {
+		pending.msg.Message += "\n" + line.Line
+		pending.cursor = line.Cursor
+		pending.lines++
+		return nil
+	}
+
+	m.pending = &message
+	if pending == nil {
+		return nil
+	}
+	return []logMessage{*pending}
+}
+
+// continues tells if the line continues the message before, like the lines of a stack trace
+func (m *moduleLogs) continues(line runtime.LogLine, parsed bool) bool {
+	if m.start != nil {
+		return !m.start.MatchString(line.Line)
+	}
+	return !parsed
+}
+
+// flush returns the message that is still waiting for more lines
+func (m *moduleLogs) flush() []logMessage {
+	if m.pending == nil {
+		return nil
+	}
+	pending := *m.pending
+	m.pending = nil
+	return []logMessage{pending}
+}
+
+func (m *moduleLogs) hasPending() bool {
+	return m.pending != nil
+}
+
+// parse returns the log message of the line, with the fields that the first parser understanding the line finds. The
+// level is empty if the line has none.
+func (m *moduleLogs) parse(line runtime.LogLine) (com.EdgeAppLogMsg, bool) {
+	msg := com.EdgeAppLogMsg{
+		ManifestID:  m.manifestID,
+		ContainerID: m.containerID,
+		ModuleName:  m.moduleName,
+		Time:        line.Time,
+		Stream:      line.Stream,
+		Filename:    defaultLogFilename,
+		Message:     line.Line,
+	}
+
+	for _, parser := range m.parsers {
+		parsed, ok := parser.parse(line.Line)
+		if !ok {
+			continue
+		}
+		if !parsed.Time.IsZero() {
+			msg.Time = parsed.Time
+		}
+		if parsed.Level != "" {
+			msg.Level = strings.ToUpper(parsed.Level)
+		}
+		if parsed.Filename != "" {
+			msg.Filename = parsed.Filename
+		}
+		if parsed.Message != "" {
+			msg.Message = parsed.Message
+		}
+		return msg, true
+	}
+	return msg, false
+}
@@ -1,132 +1,129 @@
 package edgeapp
 
 import (
-	"encoding/json"
+	"context"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/docker/docker/api/types"
+	log "github.com/sirupsen/logrus"
+
 	"github.com/weeveiot/weeve-agent/internal/com"
-	"github.com/weeveiot/weeve-agent/internal/docker"
 	"github.com/weeveiot/weeve-agent/internal/manifest"
+	"github.com/weeveiot/weeve-agent/internal/model"
 	traceutility "github.com/weeveiot/weeve-agent/internal/utility/trace"
 )
 
-type logTimestamp time.Time
-
-type dockerLogLine struct {
-	Time     logTimestamp `json:"timestamp"`
-	Level    string       `json:"level"`
-	Filename string       `json:"filename"`
-	Message  string       `json:"message"`
-}
-
-func (t *logTimestamp) UnmarshalJSON(b []byte) error {
-	var s string
-	if err := json.Unmarshal(b, &s); err != nil {
-		return err
-	}
-	parsedTime, err := time.Parse(time.DateTime, s)
-	if err != nil {
-		return err
-	}
-	*t = logTimestamp(parsedTime)
-	return nil
-}
-
-func SendEdgeAppLogs(manif manifest.ManifestRecord, until string) error {
-	msg, err := GetEdgeAppLogs(manif, until)
-	if err != nil {
-		return traceutility.Wrap(err)
-	}
-	err = com.SendEdgeAppLogs(msg)
-	if err != nil {
-		return traceutility.Wrap(err)
-	}
-
-	return manifest.SetLastLogRead(manif.Manifest.UniqueID, until)
-}
-
-func GetEdgeAppLogs(manif manifest.ManifestRecord, until string) ([]com.EdgeAppLogMsg, error) {
+// GetEdgeAppLogs reads the log lines the containers of the edge app wrote between since and until (RFC 3339, both optional)
+func GetEdgeAppLogs(man manifest.Manifest, since string, until string) ([]com.EdgeAppLogMsg, error) {
 	var edgeAppLogs []com.EdgeAppLogMsg
 
-	appContainers, err := docker.ReadEdgeAppContainers(manif.Manifest.UniqueID)
+	appContainers, err := containerRuntime.ReadEdgeAppContainers(man.UniqueID)
 	if err != nil {
 		return nil, traceutility.Wrap(err)
 	}
 
 	for _, container := range appContainers {
-		logs, err := docker.ReadContainerLogs(container.ID, manif.LastLogReadTime, until)
+		lines, err := containerRuntime.ReadContainerLogs(container.ID, since, until)
 		if err != nil {
 			return nil, traceutility.Wrap(err)
 		}
-		logMsgs := constructLogEntry(manif.Manifest.ID, container.ID, strings.Split(container.Image, "/")[1], logs, manif.LastLogReadTime, until)
 
-		if len(logMsgs) > 0 {
-			edgeAppLogs = append(edgeAppLogs, logMsgs...)
+		logs := newModuleLogs(man, container)
+		var messages []logMessage
+		for _, line := range lines {
+			messages = append(messages, logs.add(line)...)
+		}
+		messages = append(messages, logs.flush()...)
+
+		for _, message := range messages {
+			edgeAppLogs = append(edgeAppLogs, message.msg)
 		}
 	}
 
 	return edgeAppLogs, nil
 }
 
-func constructLogEntry(manifestID string, containerID string, moduleName string, logLines []string, since string, until string) []com.EdgeAppLogMsg {
-	defaultTime := meanTime(since, until)
-	var logMsgs []com.EdgeAppLogMsg
-
-	for _, line := range logLines {
-		// * default log message
-		logMsg := com.EdgeAppLogMsg{
-			ManifestID:  manifestID,
-			ContainerID: containerID,
-			ModuleName:  moduleName,
-			Time:        defaultTime,
-			Level:       "DEBUG",
-			Filename:    "unknown",
-			Message:     line,
+// FollowEdgeAppLogs streams the log messages the containers of the edge app write from since (RFC 3339, optional) on.
+// The channel is closed once all containers stopped and their messages were sent, or ctx is cancelled.
+func FollowEdgeAppLogs(ctx context.Context, man manifest.Manifest, since string) (<-chan com.EdgeAppLogMsg, error) {
+	var cursor model.LogCursor
+	if since != "" {
+		sinceTime, err := time.Parse(time.RFC3339Nano, since)
+		if err != nil {
+			return nil, traceutility.Wrap(err)
 		}
+		cursor.Time = sinceTime
+	}
 
-		// try to extract log message from json
-		if parsedLog, ok := parseJSONLogLine(line); ok {
-			if !parsedLog.Time.IsZero() {
-				logMsg.Time = parsedLog.Time
-			}
-			if parsedLog.Level != "" {
-				logMsg.Level = parsedLog.Level
-			}
-			if parsedLog.Filename != "" {
-				logMsg.Filename = parsedLog.Filename
-			}
-			if parsedLog.Message != "" {
-				logMsg.Message = parsedLog.Message
-			}
-		}
+	appContainers, err := containerRuntime.ReadEdgeAppContainers(man.UniqueID)
+	if err != nil {
+		return nil, traceutility.Wrap(err)
+	}
 
-		logMsgs = append(logMsgs, logMsg)
+	msgs := make(chan com.EdgeAppLogMsg)
+	var wg sync.WaitGroup
+	for _, container := range appContainers {
+		wg.Add(1)
+		go func(container types.Container) {
+			defer wg.Done()
+			streamContainerLogs(ctx, man, container, cursor, msgs)
+		}(container)
 	}
+	go func() {
+		wg.Wait()
+		close(msgs)
+	}()
 
-	return logMsgs
+	return msgs, nil
 }
 
-func parseJSONLogLine(line string) (com.EdgeAppLogMsg, bool) {
-	docLog := dockerLogLine{}
-	logMsg := com.EdgeAppLogMsg{}
-	logLine := []byte(line)
-
-	err := json.Unmarshal(logLine, &docLog)
-	if err != nil {
-		return logMsg, false
+// streamContainerLogs passes the log messages of the container to msgs until its stream ends
+func streamContainerLogs(ctx context.Context, man manifest.Manifest, container types.Container, cursor model.LogCursor, msgs chan<- com.EdgeAppLogMsg) {
+	logs := newModuleLogs(man, container)
+	send := func(messages []logMessage) bool {
+		for _, message := range messages {
+			select {
+			case msgs <- message.msg:
+			case <-ctx.Done():
+				return false
+			}
+		}
+		return true
 	}
 
-	logMsg.Time = time.Time(docLog.Time)
-	logMsg.Level = docLog.Level
-	logMsg.Filename = docLog.Filename
-	logMsg.Message = docLog.Message
+	lines, errs := containerRuntime.FollowContainerLogs(ctx, container.ID, cursor)
+	for {
+		// a message that spans several lines is complete once the next one starts, or no line followed for a while
+		var joinTimeout <-chan time.Time
+		if logs.hasPending() {
+			joinTimeout = time.After(logJoinTimeout)
+		}
 
-	return logMsg, true
+		select {
+		case line, ok := <-lines:
+			if !ok {
+				send(logs.flush())
+				select {
+				case err := <-errs:
+					log.Warning("Following the logs of container ", container.ID, " failed! CAUSE --> ", err)
+				default:
+				}
+				return
+			}
+			if !send(logs.add(line)) {
+				return
+			}
+		case <-joinTimeout:
+			if !send(logs.flush()) {
+				return
+			}
+		}
+	}
 }
 
-func meanTime(first, second string) time.Time {
-	firstTime, _ := time.Parse(time.RFC3339Nano, first)
-	secondTime, _ := time.Parse(time.RFC3339Nano, second)
-	return firstTime.Add(secondTime.Sub(firstTime) / 2)
+// moduleName is the name of the image of the module without the registry and the organisation
+func moduleName(image string) string {
+	return image[strings.LastIndex(image, "/")+1:]
 }
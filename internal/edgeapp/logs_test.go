@@ -0,0 +1,154 @@
+package edgeapp_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/weeveiot/weeve-agent/internal/com"
+	"github.com/weeveiot/weeve-agent/internal/edgeapp"
+	"github.com/weeveiot/weeve-agent/internal/manifest"
+	"github.com/weeveiot/weeve-agent/internal/runtime/fake"
+)
+
+func TestGetEdgeAppLogs_Parsers(t *testing.T) {
+	pythonPattern := `^(?P<time>\d{4}-\d{2}-\d{2} [\d:,]+) - (?P<filename>\S+) - (?P<level>\w+) - (?P<message>.*)$`
+
+	tests := []struct {
+		name    string
+		parsing *manifest.LogSettings
+		lines   []string
+		want    []com.EdgeAppLogMsg
+	}{
+		{
+			name: "default",
+			lines: []string{
+				`{"timestamp": "2023-05-01 12:00:00", "level": "INFO", "filename": "main.py", "message": "started"}`,
+				`{"time": "2023-05-01T12:00:01.5Z", "level": "warn", "msg": "slow"}`,
+				"plain text",
+			},
+			want: []com.EdgeAppLogMsg{
+				{Time: time.Date(2023, 5, 1, 12, 0, 0, 0, time.UTC), Level: "INFO", Filename: "main.py", Message: "started"},
+				{Time: time.Date(2023, 5, 1, 12, 0, 1, 5e8, time.UTC), Level: "WARN", Filename: "unknown", Message: "slow"},
+				{Level: "DEBUG", Filename: "unknown", Message: "plain text"},
+			},
+		},
+		{
+			name: "json with field names",
+			parsing: &manifest.LogSettings{Parsers: []manifest.LogParser{
+				{Type: "json", TimeField: "@t", LevelField: "log.level", MessageField: "@m", TimeFormat: "unixms"},
+			}},
+			lines: []string{`{"@t": 1682942400500, "log": {"level": "error"}, "@m": "failed", "message": "ignored"}`},
+			want: []com.EdgeAppLogMsg{
+				{Time: time.Date(2023, 5, 1, 12, 0, 0, 5e8, time.UTC), Level: "ERROR", Filename: "unknown", Message: "failed"},
+			},
+		},
+		{
+			name: "logfmt",
+			parsing: &manifest.LogSettings{Parsers: []manifest.LogParser{
+				{Type: "logfmt"},
+			}},
+			lines: []string{
+				`ts=2023-05-01T12:00:00Z level=info caller=main.go:12 msg="listening on :80"`,
+				"status=ok",
+			},
+			want: []com.EdgeAppLogMsg{
+				{Time: time.Date(2023, 5, 1, 12, 0, 0, 0, time.UTC), Level: "INFO", Filename: "main.go:12", Message: "listening on :80"},
+				{Level: "DEBUG", Filename: "unknown", Message: "status=ok"},
+			},
+		},
+		{
+			name: "chain",
+			parsing: &manifest.LogSettings{Parsers: []manifest.LogParser{
+				{Type: "json"},
+				{Type: "regex", Pattern: pythonPattern},
+			}},
+			lines: []string{
+				`{"level": "INFO", "message": "from json"}`,
+				"2023-05-01 12:00:00,250 - worker - WARNING - from text",
+			},
+			want: []com.EdgeAppLogMsg{
+				{Level: "INFO", Filename: "unknown", Message: "from json"},
+				{Time: time.Date(2023, 5, 1, 12, 0, 0, 25e7, time.UTC), Level: "WARNING", Filename: "worker", Message: "from text"},
+			},
+		},
+		{
+			name: "stack traces",
+			parsing: &manifest.LogSettings{
+				Parsers:   []manifest.LogParser{{Type: "regex", Pattern: pythonPattern}},
+				Multiline: &manifest.Multiline{},
+			},
+			lines: []string{
+				"2023-05-01 12:00:00,000 - worker - ERROR - failed",
+				"Traceback (most recent call last):",
+				`  File "worker.py", line 3, in <module>`,
+				"ValueError: invalid",
+				"2023-05-01 12:00:01,000 - worker - INFO - recovered",
+			},
+			want: []com.EdgeAppLogMsg{
+				{Time: time.Date(2023, 5, 1, 12, 0, 0, 0, time.UTC), Level: "ERROR", Filename: "worker", Message: "failed\nTraceback (most recent call last):\n  File \"worker.py\", line 3, in <module>\nValueError: invalid"},
+				{Time: time.Date(2023, 5, 1, 12, 0, 1, 0, time.UTC), Level: "INFO", Filename: "worker", Message: "recovered"},
+			},
+		},
+		{
+			name: "multiline start and limit",
+			parsing: &manifest.LogSettings{
+				Multiline: &manifest.Multiline{Start: `^\S`, MaxLines: 2},
+			},
+			lines: []string{"Exception in thread main", "\tat Main.run", "\tat Main.main", "done"},
+			want: []com.EdgeAppLogMsg{
+				{Level: "DEBUG", Filename: "unknown", Message: "Exception in thread main\n\tat Main.run"},
+				{Level: "DEBUG", Filename: "unknown", Message: "\tat Main.main"},
+				{Level: "DEBUG", Filename: "unknown", Message: "done"},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert := assert.New(t)
+			rt := fake.NewRuntime()
+			edgeapp.SetRuntime(rt)
+
+			man := readManifest(t, "test_manifest.json")
+			for i := range man.Modules {
+				man.Modules[i].Logs = test.parsing
+			}
+			err := edgeapp.DeployEdgeApp(man)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer edgeapp.RemoveEdgeApp(man.UniqueID, nil)
+
+			containers, err := rt.ReadEdgeAppContainers(man.UniqueID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = rt.AppendLogs(containers[0].ID, test.lines...)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			logs, err := edgeapp.GetEdgeAppLogs(manifest.GetKnownManifest(man.UniqueID).Manifest, "", "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !assert.Len(logs, len(test.want)) {
+				return
+			}
+			for i, want := range test.want {
+				// lines without a timestamp keep the time of the container engine
+				if want.Time.IsZero() {
+					assert.WithinDuration(time.Now(), logs[i].Time, time.Minute)
+				} else {
+					assert.Equal(want.Time, logs[i].Time.UTC())
+				}
+				assert.Equal(want.Level, logs[i].Level)
+				assert.Equal(want.Filename, logs[i].Filename)
+				assert.Equal(want.Message, logs[i].Message)
+				assert.Equal("stdout", logs[i].Stream)
+			}
+		})
+	}
+}
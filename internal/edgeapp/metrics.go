@@ -0,0 +1,128 @@
+package edgeapp
+
+import (
+	"math"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types"
+
+	"github.com/weeveiot/weeve-agent/internal/com"
+	traceutility "github.com/weeveiot/weeve-agent/internal/utility/trace"
+)
+
+// cpuSample is the cumulative CPU time of a container at the time it was read, with the rest of the stats read with it
+type cpuSample struct {
+	usage uint64
+	read  time.Time
+	stats types.StatsJSON
+}
+
+// cpuSampler keeps the previous CPU sample of every container for one consumer of the status, so that e.g. a query
+// of the local API doesn't shorten the interval the next heartbeat reports the CPU load over
+type cpuSampler struct {
+	mutex   sync.Mutex
+	samples map[string]cpuSample
+}
+
+func newCPUSampler() *cpuSampler {
+	return &cpuSampler{samples: make(map[string]cpuSample)}
+}
+
+// the heartbeat reports the CPU load since the previous heartbeat, the other status queries since the previous query.
+// The first status of a container reports none.
+var (
+	heartbeatCPU = newCPUSampler()
+	queryCPU     = newCPUSampler()
+)
+
+// readContainerMetrics reports the resource usage of a container. Stopped containers only report their restart count.
+func readContainerMetrics(con containerState, cpu *cpuSampler) (*com.ContainerMetricsMsg, error) {
+	metrics := &com.ContainerMetricsMsg{RestartCount: con.RestartCount}
+	if con.State != "running" {
+		return metrics, nil
+	}
+
+	stats, err := containerRuntime.ReadContainerStats(con.ID)
+	if err != nil {
+		return nil, traceutility.Wrap(err)
+	}
+
+	usage := summarizeStats(stats)
+	usage.CPUPercent = cpu.percent(con.ID, stats)
+	usage.RestartCount = con.RestartCount
+
+	return &usage, nil
+}
+
+// summarizeStats adds up the usage of all networks and block devices of a container
+func summarizeStats(stats types.StatsJSON) com.ContainerMetricsMsg {
+	var usage com.ContainerMetricsMsg
+	usage.MemoryUsage = memoryUsage(stats.MemoryStats)
+	usage.MemoryLimit = stats.MemoryStats.Limit
+	for _, network := range stats.Networks {
+		usage.NetworkRx += network.RxBytes
+		usage.NetworkTx += network.TxBytes
+	}
+	for _, entry := range stats.BlkioStats.IoServiceBytesRecursive {
+		switch strings.ToLower(entry.Op) {
+		case "read":
+			usage.BlockRead += entry.Value
+		case "write":
+			usage.BlockWrite += entry.Value
+		}
+	}
+	return usage
+}
+
+// percent returns the CPU load since the previous sample of the container, 100% being one CPU
+func (c *cpuSampler) percent(containerID string, stats types.StatsJSON) float64 {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	sample := cpuSample{usage: stats.CPUStats.CPUUsage.TotalUsage, read: stats.Read, stats: stats}
+	previous, found := c.samples[containerID]
+	c.samples[containerID] = sample
+
+	// a restarted container starts counting from zero again
+	if !found || !sample.read.After(previous.read) || sample.usage < previous.usage {
+		return 0
+	}
+
+	percent := float64(sample.usage-previous.usage) / float64(sample.read.Sub(previous.read).Nanoseconds()) * 100
+	return math.Round(percent*100) / 100
+}
+
+// latest returns the stats of the latest sample of the container, or false if there is none
+func (c *cpuSampler) latest(containerID string) (types.StatsJSON, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	sample, found := c.samples[containerID]
+	return sample.stats, found
+}
+
+// forget drops the samples of all containers that are not in use
+func (c *cpuSampler) forget(inUse map[string]bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	for containerID := range c.samples {
+		if !inUse[containerID] {
+			delete(c.samples, containerID)
+		}
+	}
+}
+
+// memoryUsage excludes the page cache that the kernel can reclaim, like `docker stats` does
+func memoryUsage(memoryStats types.MemoryStats) uint64 {
+	inactiveFile, found := memoryStats.Stats["total_inactive_file"] // cgroup v1
+	if !found {
+		inactiveFile = memoryStats.Stats["inactive_file"] // cgroup v2
+	}
+	if inactiveFile > memoryStats.Usage {
+		return memoryStats.Usage
+	}
+	return memoryStats.Usage - inactiveFile
+}
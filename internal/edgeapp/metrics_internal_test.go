@@ -0,0 +1,38 @@
+package edgeapp
+
+import (
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCPUSampler(t *testing.T) {
+	assert := assert.New(t)
+	heartbeat := newCPUSampler()
+	query := newCPUSampler()
+	read := time.Now()
+
+	sample := func(seconds int, usage time.Duration) types.StatsJSON {
+		stats := types.StatsJSON{}
+		stats.Read = read.Add(time.Duration(seconds) * time.Second)
+		stats.CPUStats.CPUUsage.TotalUsage = uint64(usage.Nanoseconds())
+		return stats
+	}
+
+	assert.Equal(0.0, heartbeat.percent("a", sample(0, 0)))
+	assert.Equal(0.0, query.percent("a", sample(0, 0)))
+
+	// a query in between doesn't shorten the interval of the heartbeat
+	assert.Equal(100.0, query.percent("a", sample(1, time.Second)))
+	assert.Equal(25.0, heartbeat.percent("a", sample(4, time.Second)))
+
+	// a restarted container starts counting from zero again
+	assert.Equal(0.0, heartbeat.percent("a", sample(6, 0)))
+	assert.Equal(50.0, heartbeat.percent("a", sample(8, time.Second)))
+
+	heartbeat.forget(map[string]bool{"b": true})
+	assert.Empty(heartbeat.samples)
+	assert.Len(query.samples, 1)
+}
@@ -0,0 +1,103 @@
+package edgeapp_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/weeveiot/weeve-agent/internal/com"
+	"github.com/weeveiot/weeve-agent/internal/edgeapp"
+	"github.com/weeveiot/weeve-agent/internal/model"
+	"github.com/weeveiot/weeve-agent/internal/runtime/fake"
+)
+
+func TestGetEdgeAppStatus_Metrics(t *testing.T) {
+	assert := assert.New(t)
+	rt := fake.NewRuntime()
+	edgeapp.SetRuntime(rt)
+
+	man := readManifest(t, "test_manifest.json")
+	err := edgeapp.DeployEdgeApp(man)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer edgeapp.RemoveEdgeApp(man.UniqueID, nil)
+
+	containers, err := rt.ReadEdgeAppContainers(man.UniqueID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	read := time.Now()
+	stats := types.StatsJSON{}
+	stats.Read = read
+	stats.MemoryStats = types.MemoryStats{Usage: 100 << 20, Limit: 512 << 20, Stats: map[string]uint64{"inactive_file": 20 << 20}}
+	stats.Networks = map[string]types.NetworkStats{
+		"eth0": {RxBytes: 1000, TxBytes: 2000},
+		"eth1": {RxBytes: 10, TxBytes: 20},
+	}
+	stats.BlkioStats.IoServiceBytesRecursive = []types.BlkioStatEntry{
+		{Op: "Read", Value: 4096},
+		{Op: "Write", Value: 8192},
+		{Op: "Total", Value: 12288},
+	}
+	err = rt.SetStats(containers[0].ID, stats)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	metrics := readMetrics(t, man.UniqueID, containers[0])
+	assert.Equal(com.ContainerMetricsMsg{
+		MemoryUsage: 80 << 20,
+		MemoryLimit: 512 << 20,
+		NetworkRx:   1010,
+		NetworkTx:   2020,
+		BlockRead:   4096,
+		BlockWrite:  8192,
+	}, metrics)
+
+	stats.Read = read.Add(2 * time.Second)
+	stats.CPUStats.CPUUsage.TotalUsage = 1000000000
+	err = rt.SetStats(containers[0].ID, stats)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(50.0, readMetrics(t, man.UniqueID, containers[0]).CPUPercent)
+
+	// a crashed container reports its restarts only
+	err = rt.CrashContainer(containers[1].ID, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = rt.RestartContainer(containers[1].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = rt.CrashContainer(containers[1].ID, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(com.ContainerMetricsMsg{RestartCount: 1}, readMetrics(t, man.UniqueID, containers[1]))
+}
+
+func readMetrics(t *testing.T, manifestUniqueID model.ManifestUniqueID, container types.Container) com.ContainerMetricsMsg {
+	edgeApps, err := edgeapp.GetEdgeAppStatus()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, edgeApp := range edgeApps {
+		if edgeApp.ManifestID != manifestUniqueID.ID {
+			continue
+		}
+		for _, con := range edgeApp.Containers {
+			if con.Name == container.Names[0] && con.Metrics != nil {
+				return *con.Metrics
+			}
+		}
+	}
+	t.Fatalf("no metrics for container %s", container.Names[0])
+	return com.ContainerMetricsMsg{}
+}
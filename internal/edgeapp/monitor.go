@@ -0,0 +1,243 @@
+package edgeapp
+
+import (
+	"context"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/events"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/weeveiot/weeve-agent/internal/com"
+	"github.com/weeveiot/weeve-agent/internal/manifest"
+	"github.com/weeveiot/weeve-agent/internal/model"
+	traceutility "github.com/weeveiot/weeve-agent/internal/utility/trace"
+)
+
+// statusMonitor keeps the container states of all known edge apps up to date from the runtime events
+type statusMonitor struct {
+	containers map[model.ManifestUniqueID][]containerState
+	lastStatus map[model.ManifestUniqueID]com.EdgeAppMsg
+}
+
+// the container states of the running monitor, which the status messages are built from instead of asking the runtime
+var (
+	monitoredMutex sync.RWMutex
+	monitored      map[model.ManifestUniqueID][]containerState // nil while no monitor runs
+)
+
+// MonitorEdgeAppStatus watches the container events of the runtime and calls statusChanged with the status of all
+// edge apps on start and whenever the status of one of them changes. The container states are additionally
+// resynced from the runtime every resyncInterval in case an event was missed.
+// It returns when ctx is cancelled or the event stream breaks, in which case the caller is expected to restart it.
+func MonitorEdgeAppStatus(ctx context.Context, resyncInterval time.Duration, statusChanged func([]com.EdgeAppMsg)) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// subscribe before reading the states, so that no change in between is missed
+	messages, errs := containerRuntime.WatchEdgeAppEvents(ctx)
+
+	monitor := &statusMonitor{
+		containers: make(map[model.ManifestUniqueID][]containerState),
+		lastStatus: make(map[model.ManifestUniqueID]com.EdgeAppMsg),
+	}
+	err := monitor.resync()
+	if err != nil {
+		return traceutility.Wrap(err)
+	}
+	monitor.publish()
+	defer forgetMonitoredStates()
+	if edgeApps, changed := monitor.detectChanges(); changed {
+		statusChanged(edgeApps)
+	}
+
+	ticker := time.NewTicker(resyncInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case msg := <-messages:
+			err = monitor.handleEvent(msg)
+			if err != nil {
+				log.Error("Failed to handle container event! CAUSE --> ", err)
+				continue
+			}
+		case <-ticker.C:
+			err = monitor.resync()
+			if err != nil {
+				log.Error("Edge app status resync failed! CAUSE --> ", err)
+				continue
+			}
+		case err = <-errs:
+			if ctx.Err() != nil {
+				return nil
+			}
+			return traceutility.Wrap(err)
+		case <-ctx.Done():
+			return nil
+		}
+		monitor.publish()
+
+		if edgeApps, changed := monitor.detectChanges(); changed {
+			log.Debug("Latest edge app status: ", edgeApps)
+			statusChanged(edgeApps)
+		}
+	}
+}
+
+// resync reads the states of all containers of the known edge apps from the runtime
+func (m *statusMonitor) resync() error {
+	containers := make(map[model.ManifestUniqueID][]containerState)
+	for _, manif := range manifest.GetKnownManifests() {
+		states, err := readContainerStates(*manif)
+		if err != nil {
+			return traceutility.Wrap(err)
+		}
+		containers[manif.Manifest.UniqueID] = states
+	}
+	m.containers = containers
+
+	return nil
+}
+
+// handleEvent applies a container event to the cached states
+func (m *statusMonitor) handleEvent(msg events.Message) error {
+	log.Debugln("Container event", msg.Action, msg.Actor.ID)
+
+	uniqueID, index, found := m.findContainer(msg.Actor.ID)
+	if !found {
+		uniqueID = model.ManifestUniqueID{ID: msg.Actor.Attributes["manifestUniqueID"]}
+		if uniqueID.ID == "" || msg.Action == "destroy" {
+			return nil
+		}
+		// a new container, read the edge app again to learn everything about it
+		return m.resyncEdgeApp(uniqueID)
+	}
+
+	containers := m.containers[uniqueID]
+	container := &containers[index]
+
+	switch {
+	case msg.Action == "create":
+		container.State = "created"
+	case msg.Action == "start" || msg.Action == "restart" || msg.Action == "unpause":
+		container.State = "running"
+		container.ExitCode = 0
+		container.OOMKilled = false
+		if container.Health != "" {
+			// the healthcheck starts over with the container
+			container.Health = types.Starting
+		}
+		if msg.Action != "unpause" {
+			// the restarts by the restart policy are counted by the runtime only
+			containerJSON, err := containerRuntime.InspectContainer(container.ID)
+			if err != nil {
+				return traceutility.Wrap(err)
+			}
+			container.RestartCount = containerJSON.RestartCount
+		}
+	case msg.Action == "pause":
+		container.State = "paused"
+	case msg.Action == "die":
+		container.State = "exited"
+		exitCode, err := strconv.Atoi(msg.Actor.Attributes["exitCode"])
+		if err == nil {
+			container.ExitCode = exitCode
+		}
+	case msg.Action == "oom":
+		container.OOMKilled = true
+		log.Warningln("Container", container.Name, "of edge app", uniqueID, "ran out of memory")
+	case msg.Action == "destroy":
+		m.containers[uniqueID] = append(containers[:index], containers[index+1:]...)
+	case strings.HasPrefix(msg.Action, "health_status: "):
+		container.Health = strings.TrimPrefix(msg.Action, "health_status: ")
+	}
+
+	return nil
+}
+
+func (m *statusMonitor) resyncEdgeApp(uniqueID model.ManifestUniqueID) error {
+	manif := manifest.GetKnownManifest(uniqueID)
+	if manif == nil {
+		return nil
+	}
+
+	states, err := readContainerStates(*manif)
+	if err != nil {
+		return traceutility.Wrap(err)
+	}
+	m.containers[uniqueID] = states
+
+	return nil
+}
+
+// publish makes the cached states available to the status messages
+func (m *statusMonitor) publish() {
+	states := make(map[model.ManifestUniqueID][]containerState, len(m.containers))
+	for uniqueID, containers := range m.containers {
+		states[uniqueID] = append([]containerState(nil), containers...)
+	}
+
+	monitoredMutex.Lock()
+	defer monitoredMutex.Unlock()
+	monitored = states
+}
+
+func forgetMonitoredStates() {
+	monitoredMutex.Lock()
+	defer monitoredMutex.Unlock()
+	monitored = nil
+}
+
+// containerStates returns the container states of the edge app from the monitor, or from the runtime if the monitor
+// doesn't run or doesn't know the edge app yet
+func containerStates(manif manifest.ManifestRecord) ([]containerState, error) {
+	monitoredMutex.RLock()
+	states, found := monitored[manif.Manifest.UniqueID]
+	monitoredMutex.RUnlock()
+	if found && manif.Status != model.EdgeAppUndeployed {
+		return states, nil
+	}
+
+	return readContainerStates(manif)
+}
+
+func (m *statusMonitor) findContainer(containerID string) (model.ManifestUniqueID, int, bool) {
+	for uniqueID, containers := range m.containers {
+		for i, container := range containers {
+			if container.ID == containerID {
+				return uniqueID, i, true
+			}
+		}
+	}
+	return model.ManifestUniqueID{}, 0, false
+}
+
+// detectChanges derives the status of all known edge apps from the cached container states and reports whether it
+// differs from the previous one
+func (m *statusMonitor) detectChanges() ([]com.EdgeAppMsg, bool) {
+	edgeApps := []com.EdgeAppMsg{}
+	lastStatus := make(map[model.ManifestUniqueID]com.EdgeAppMsg)
+	changed := false
+
+	for _, manif := range manifest.GetKnownManifests() {
+		uniqueID := manif.Manifest.UniqueID
+		edgeApp := edgeAppStatus(*manif, m.containers[uniqueID])
+		edgeApps = append(edgeApps, edgeApp)
+		lastStatus[uniqueID] = edgeApp
+
+		if previous, found := m.lastStatus[uniqueID]; !found || !reflect.DeepEqual(previous, edgeApp) {
+			changed = true
+		}
+	}
+	if len(lastStatus) != len(m.lastStatus) {
+		changed = true
+	}
+	m.lastStatus = lastStatus
+
+	return edgeApps, changed
+}
@@ -0,0 +1,138 @@
+package edgeapp_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/weeveiot/weeve-agent/internal/com"
+	"github.com/weeveiot/weeve-agent/internal/edgeapp"
+	"github.com/weeveiot/weeve-agent/internal/model"
+	"github.com/weeveiot/weeve-agent/internal/runtime/fake"
+)
+
+func TestMonitorEdgeAppStatus(t *testing.T) {
+	assert := assert.New(t)
+	rt := fake.NewRuntime()
+	edgeapp.SetRuntime(rt)
+
+	man := readManifest(t, "test_manifest.json")
+	err := edgeapp.DeployEdgeApp(man)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer edgeapp.RemoveEdgeApp(man.UniqueID, nil)
+
+	statusChanges := make(chan []com.EdgeAppMsg, 10)
+	monitorErr := make(chan error, 1)
+	go func() {
+		// the resync is not supposed to kick in, all changes have to come from the events
+		monitorErr <- edgeapp.MonitorEdgeAppStatus(context.Background(), time.Hour, func(edgeApps []com.EdgeAppMsg) {
+			statusChanges <- edgeApps
+		})
+	}()
+
+	assertStatusChange(t, statusChanges, man.ID, model.EdgeAppRunning)
+
+	containers, err := rt.ReadEdgeAppContainers(man.UniqueID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = rt.CrashContainer(containers[0].ID, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertStatusChange(t, statusChanges, man.ID, model.EdgeAppError)
+
+	err = rt.RestartContainer(containers[0].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertStatusChange(t, statusChanges, man.ID, model.EdgeAppRunning)
+
+	// a broken event stream ends the monitoring
+	rt.FailEvents(errors.New("connection reset"))
+	select {
+	case err := <-monitorErr:
+		assert.NotNil(err)
+	case <-time.After(time.Second * 5):
+		t.Fatal("monitoring did not end after the event stream broke")
+	}
+}
+
+func TestGetEdgeAppStatus_FromMonitor(t *testing.T) {
+	assert := assert.New(t)
+	rt := fake.NewRuntime()
+	edgeapp.SetRuntime(rt)
+
+	man := readManifest(t, "test_manifest.json")
+	err := edgeapp.DeployEdgeApp(man)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer edgeapp.RemoveEdgeApp(man.UniqueID, nil)
+
+	statusChanges := make(chan []com.EdgeAppMsg, 10)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		edgeapp.MonitorEdgeAppStatus(ctx, time.Hour, func(edgeApps []com.EdgeAppMsg) {
+			statusChanges <- edgeApps
+		})
+		close(done)
+	}()
+	// the states of a monitor that stopped aren't used anymore
+	defer func() {
+		cancel()
+		<-done
+	}()
+	assertStatusChange(t, statusChanges, man.ID, model.EdgeAppRunning)
+
+	// while the monitor runs, the status messages don't ask the runtime for the containers
+	reads := rt.Reads()
+	for i := 0; i < 3; i++ {
+		msg, err := edgeapp.GetStatusMessage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if assert.Len(msg.EdgeApplications, 1) {
+			assert.Equal(model.EdgeAppRunning, msg.EdgeApplications[0].Status)
+			assert.Len(msg.EdgeApplications[0].Containers, len(man.Modules))
+		}
+	}
+	assert.Equal(reads, rt.Reads())
+
+	// the status messages follow the events
+	containers, err := rt.ReadEdgeAppContainers(man.UniqueID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = rt.CrashContainer(containers[0].ID, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertStatusChange(t, statusChanges, man.ID, model.EdgeAppError)
+	msg, err := edgeapp.GetStatusMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(model.EdgeAppError, msg.EdgeApplications[0].Status)
+}
+
+func assertStatusChange(t *testing.T, statusChanges chan []com.EdgeAppMsg, manifestID string, status string) {
+	select {
+	case edgeApps := <-statusChanges:
+		for _, edgeApp := range edgeApps {
+			if edgeApp.ManifestID == manifestID {
+				assert.Equal(t, status, edgeApp.Status)
+				return
+			}
+		}
+		t.Fatal("edge app missing in the status change")
+	case <-time.After(time.Second * 5):
+		t.Fatal("no status change reported")
+	}
+}
@@ -0,0 +1,206 @@
+package edgeapp
+
+import (
+	"fmt"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/weeveiot/weeve-agent/internal/manifest"
+	"github.com/weeveiot/weeve-agent/internal/model"
+	"github.com/weeveiot/weeve-agent/internal/secret"
+	traceutility "github.com/weeveiot/weeve-agent/internal/utility/trace"
+)
+
+// ReadRuntimeEdgeAppIDs returns the IDs of all edge apps that have containers or networks in the runtime, known or not
+func ReadRuntimeEdgeAppIDs() ([]model.ManifestUniqueID, error) {
+	found := make(map[model.ManifestUniqueID]bool)
+	var uniqueIDs []model.ManifestUniqueID
+	add := func(labels map[string]string) {
+		uniqueID := model.ManifestUniqueID{ID: labels["manifestUniqueID"]}
+		if uniqueID.ID != "" && !found[uniqueID] {
+			found[uniqueID] = true
+			uniqueIDs = append(uniqueIDs, uniqueID)
+		}
+	}
+
+	containers, err := containerRuntime.ReadAllContainers()
+	if err != nil {
+		return nil, traceutility.Wrap(err)
+	}
+	for _, container := range containers {
+		add(container.Labels)
+	}
+
+	networks, err := containerRuntime.ReadAllEdgeAppNetworks()
+	if err != nil {
+		return nil, traceutility.Wrap(err)
+	}
+	for _, network := range networks {
+		add(network.Labels)
+	}
+
+	return uniqueIDs, nil
+}
+
+// ReconcileEdgeApp converges the runtime to the known manifest of the edge app and returns what it changed.
+// The containers and networks of an edge app that is not known are removed.
+func ReconcileEdgeApp(manifestUniqueID model.ManifestUniqueID) ([]string, error) {
+	edgeAppRecord := manifest.GetKnownManifest(manifestUniqueID)
+	if edgeAppRecord == nil {
+		return removeOrphanedEdgeApp(manifestUniqueID)
+	}
+
+	switch edgeAppRecord.Status {
+	case model.EdgeAppRunning, model.EdgeAppStopped:
+		return reconcileModules(*edgeAppRecord)
+	case model.EdgeAppUndeployed:
+		return removeOrphanedEdgeApp(manifestUniqueID)
+	case model.EdgeAppInitiated, model.EdgeAppExecuting:
+		// the agent stopped in the middle of a command, there is no telling how far it got
+		err := manifest.SetStatus(manifestUniqueID, model.EdgeAppError)
+		if err != nil {
+			return nil, traceutility.Wrap(err)
+		}
+		return []string{fmt.Sprintf("edge app %s was interrupted while %s, set to %s", manifestUniqueID, strings.ToLower(edgeAppRecord.Status), model.EdgeAppError)}, nil
+	default:
+		return nil, nil
+	}
+}
+
+// reconcileModules recreates the missing containers of the edge app and brings all of them to the status of the edge app.
+// Missing containers with secrets are left for a later reconciliation until the org's key is there.
+func reconcileModules(edgeAppRecord manifest.ManifestRecord) ([]string, error) {
+	var changes []string
+	man := edgeAppRecord.Manifest
+
+	networks, err := containerRuntime.ReadEdgeAppNetworks(man.UniqueID)
+	if err != nil {
+		return nil, traceutility.Wrap(err)
+	}
+	networkExists := false
+	for _, network := range networks {
+		if len(man.Modules) > 0 && network.Name == man.Modules[0].NetworkName {
+			networkExists = true
+		}
+	}
+	if !networkExists {
+		// the container names are derived from the network name, so the modules can't be recreated in another network
+		if edgeAppRecord.Status != model.EdgeAppError {
+			err = manifest.SetStatus(man.UniqueID, model.EdgeAppError)
+			if err != nil {
+				return nil, traceutility.Wrap(err)
+			}
+		}
+		return []string{fmt.Sprintf("network of edge app %s is missing, set to %s", man.UniqueID, model.EdgeAppError)}, nil
+	}
+
+	containers, err := containerRuntime.ReadEdgeAppContainers(man.UniqueID)
+	if err != nil {
+		return nil, traceutility.Wrap(err)
+	}
+	containerStates := make(map[string]string)
+	containerIDs := make(map[string]string)
+	for _, container := range containers {
+		containerStates[containerName(container)] = container.State
+		containerIDs[containerName(container)] = container.ID
+	}
+
+	running := strings.ToLower(model.ModuleRunning)
+
+	// start containers in reverse order to prevent connectivity issues
+	for i := len(man.Modules) - 1; i >= 0; i-- {
+		module := man.Modules[i]
+		state, found := containerStates[module.ContainerName]
+
+		if !found && module.HasSecrets() && !secret.HasOrgKey() {
+			// the org's key usually arrives only after the agent connected, the edge apps are reconciled again then
+			log.Infoln("Recreating missing container", module.ContainerName, "of edge app", man.UniqueID, "deferred until the org's key is received")
+			continue
+		}
+
+		if !found {
+			containerConfig, err := manifest.RestoreSecretValues(module)
+			if err != nil {
+				return changes, traceutility.Wrap(err)
+			}
+			exists, err := containerRuntime.ImageExists(module.ImageNameFull)
+			if err != nil {
+				return changes, traceutility.Wrap(err)
+			}
+			if !exists {
+				err = pullImage(containerConfig.AuthConfig, module.ImageNameFull)
+				if err != nil {
+					return changes, traceutility.Wrap(err)
+				}
+			}
+			containerID, err := containerRuntime.CreateAndStartContainer(containerConfig)
+			if err != nil {
+				return changes, traceutility.Wrap(err)
+			}
+			containerIDs[module.ContainerName] = containerID
+			state = running
+			changes = append(changes, fmt.Sprintf("recreated missing container %s of edge app %s", module.ContainerName, man.UniqueID))
+		}
+
+		switch {
+		case edgeAppRecord.Status == model.EdgeAppRunning && state != running && state != strings.ToLower(model.ModuleRestarting):
+			err = containerRuntime.StartContainer(containerIDs[module.ContainerName])
+			if err != nil {
+				return changes, traceutility.Wrap(err)
+			}
+			changes = append(changes, fmt.Sprintf("started %s container %s of running edge app %s", state, module.ContainerName, man.UniqueID))
+		case edgeAppRecord.Status == model.EdgeAppStopped && state == running:
+			err = containerRuntime.StopContainer(containerIDs[module.ContainerName])
+			if err != nil {
+				return changes, traceutility.Wrap(err)
+			}
+			changes = append(changes, fmt.Sprintf("stopped running container %s of stopped edge app %s", module.ContainerName, man.UniqueID))
+		}
+		delete(containerIDs, module.ContainerName)
+	}
+
+	// containers that don't belong to any module, e.g. left over from an older version
+	for name, containerID := range containerIDs {
+		err = containerRuntime.StopAndRemoveContainer(containerID)
+		if err != nil {
+			return changes, traceutility.Wrap(err)
+		}
+		changes = append(changes, fmt.Sprintf("removed container %s that is not part of edge app %s", name, man.UniqueID))
+	}
+
+	return changes, nil
+}
+
+// removeOrphanedEdgeApp removes the containers and networks of an edge app that is not supposed to exist in the runtime
+func removeOrphanedEdgeApp(manifestUniqueID model.ManifestUniqueID) ([]string, error) {
+	var changes []string
+
+	containers, err := containerRuntime.ReadEdgeAppContainers(manifestUniqueID)
+	if err != nil {
+		return nil, traceutility.Wrap(err)
+	}
+	for _, container := range containers {
+		err = containerRuntime.StopAndRemoveContainer(container.ID)
+		if err != nil {
+			return changes, traceutility.Wrap(err)
+		}
+		changes = append(changes, fmt.Sprintf("removed orphaned container %s of edge app %s", containerName(container), manifestUniqueID))
+	}
+
+	networks, err := containerRuntime.ReadEdgeAppNetworks(manifestUniqueID)
+	if err != nil {
+		return changes, traceutility.Wrap(err)
+	}
+	if len(networks) > 0 {
+		err = containerRuntime.NetworkPrune(manifestUniqueID)
+		if err != nil {
+			return changes, traceutility.Wrap(err)
+		}
+		for _, network := range networks {
+			changes = append(changes, fmt.Sprintf("removed orphaned network %s of edge app %s", network.Name, manifestUniqueID))
+		}
+	}
+
+	return changes, nil
+}
@@ -0,0 +1,172 @@
+package edgeapp_test
+
+import (
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/weeveiot/weeve-agent/internal/edgeapp"
+	"github.com/weeveiot/weeve-agent/internal/manifest"
+	"github.com/weeveiot/weeve-agent/internal/model"
+	"github.com/weeveiot/weeve-agent/internal/runtime/fake"
+	"github.com/weeveiot/weeve-agent/internal/secret"
+)
+
+func TestReconcileEdgeApp(t *testing.T) {
+	assert := assert.New(t)
+	rt := fake.NewRuntime()
+	edgeapp.SetRuntime(rt)
+
+	man := readManifest(t, "test_manifest.json")
+	err := edgeapp.DeployEdgeApp(man)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer edgeapp.RemoveEdgeApp(man.UniqueID, nil)
+
+	// drift: one module is gone, another one crashed
+	containers, err := rt.ReadEdgeAppContainers(man.UniqueID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = rt.StopAndRemoveContainer(containers[0].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = rt.CrashContainer(containers[1].ID, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	changes, err := edgeapp.ReconcileEdgeApp(man.UniqueID)
+	assert.Nil(err)
+	assert.Len(changes, 2)
+	assertContainers(t, rt, man.UniqueID, len(man.Modules), "running")
+
+	// the missing module is recreated with its environment
+	known := manifest.GetKnownManifest(man.UniqueID)
+	for _, module := range known.Manifest.Modules {
+		if "/"+module.ContainerName == containers[0].Names[0] {
+			containerJSON, err := rt.InspectContainer(findContainer(t, rt, man.UniqueID, module.ContainerName).ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			assert.Subset(containerJSON.Config.Env, module.EnvArgs)
+		}
+	}
+
+	// nothing left to do
+	changes, err = edgeapp.ReconcileEdgeApp(man.UniqueID)
+	assert.Nil(err)
+	assert.Empty(changes)
+}
+
+func TestReconcileEdgeApp_OrgKey(t *testing.T) {
+	assert := assert.New(t)
+	rt := fake.NewRuntime()
+	edgeapp.SetRuntime(rt)
+
+	man := readManifest(t, "test_manifest.json")
+	err := edgeapp.DeployEdgeApp(man)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer edgeapp.RemoveEdgeApp(man.UniqueID, nil)
+
+	// the first module has a secret, the second one doesn't, and both are gone
+	known := manifest.GetKnownManifest(man.UniqueID).Manifest
+	known.Modules[0].SecretEnvs = map[string]string{"API_KEY": "not decryptable yet"}
+	err = manifest.UpdateKnownManifest(known)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, module := range known.Modules[:2] {
+		err = rt.StopAndRemoveContainer(findContainer(t, rt, man.UniqueID, module.ContainerName).ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// without the org's key, only the module without secrets is recreated and the edge app isn't failed
+	changes, err := edgeapp.ReconcileEdgeApp(man.UniqueID)
+	assert.Nil(err)
+	assert.Len(changes, 1)
+	assertContainers(t, rt, man.UniqueID, len(man.Modules)-1, "running")
+	status, err := manifest.GetEdgeAppStatus(man.UniqueID)
+	assert.Nil(err)
+	assert.Equal(model.EdgeAppRunning, status)
+
+	// once the key is there, the module with the secret is recreated too
+	err = secret.SetOrgKey([]byte("0123456789abcdef0123456789abcdef"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	encryptedKey, err := secret.EncryptSecret("decrypted")
+	if err != nil {
+		t.Fatal(err)
+	}
+	known.Modules[0].SecretEnvs["API_KEY"] = encryptedKey
+	err = manifest.UpdateKnownManifest(known)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	changes, err = edgeapp.ReconcileEdgeApp(man.UniqueID)
+	assert.Nil(err)
+	assert.Len(changes, 1)
+	assertContainers(t, rt, man.UniqueID, len(man.Modules), "running")
+	containerJSON, err := rt.InspectContainer(findContainer(t, rt, man.UniqueID, known.Modules[0].ContainerName).ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Contains(containerJSON.Config.Env, "API_KEY=decrypted")
+}
+
+func TestReconcileEdgeApp_Orphans(t *testing.T) {
+	assert := assert.New(t)
+	rt := fake.NewRuntime()
+	edgeapp.SetRuntime(rt)
+
+	// an edge app the agent does not know, e.g. because the manifest file got lost
+	orphanID := model.ManifestUniqueID{ID: "orphan"}
+	labels := map[string]string{"manifestUniqueID": orphanID.ID}
+	networkName, err := rt.CreateNetwork("orphan", labels)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rt.AddImage("weevenetwork/orphan")
+	_, err = rt.CreateAndStartContainer(manifest.ContainerConfig{
+		ContainerName: networkName + ".orphan",
+		ImageNameFull: "weevenetwork/orphan",
+		NetworkName:   networkName,
+		Labels:        labels,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	uniqueIDs, err := edgeapp.ReadRuntimeEdgeAppIDs()
+	assert.Nil(err)
+	assert.Equal([]model.ManifestUniqueID{orphanID}, uniqueIDs)
+
+	changes, err := edgeapp.ReconcileEdgeApp(orphanID)
+	assert.Nil(err)
+	assert.Len(changes, 2)
+	assertContainers(t, rt, orphanID, 0, "")
+	assertNetworks(t, rt, orphanID, 0)
+}
+
+func findContainer(t *testing.T, rt *fake.Runtime, manifestUniqueID model.ManifestUniqueID, name string) types.Container {
+	containers, err := rt.ReadEdgeAppContainers(manifestUniqueID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, container := range containers {
+		if container.Names[0] == "/"+name {
+			return container
+		}
+	}
+	t.Fatal("container ", name, " not found")
+	return types.Container{}
+}
@@ -1,15 +1,17 @@
 package edgeapp
 
 import (
+	"errors"
 	"strings"
 
+	"github.com/docker/docker/api/types"
 	"github.com/shirou/gopsutil/v3/cpu"
 	"github.com/shirou/gopsutil/v3/disk"
 	"github.com/shirou/gopsutil/v3/host"
 	"github.com/shirou/gopsutil/v3/mem"
+	log "github.com/sirupsen/logrus"
 
 	"github.com/weeveiot/weeve-agent/internal/com"
-	"github.com/weeveiot/weeve-agent/internal/docker"
 	"github.com/weeveiot/weeve-agent/internal/manifest"
 	"github.com/weeveiot/weeve-agent/internal/model"
 	"github.com/weeveiot/weeve-agent/internal/secret"
@@ -19,12 +21,34 @@ import (
 
 var nodeStatus string = model.NodeDisconnected
 
+// moduleMissing is reported for modules without a container
+const moduleMissing = "Missing"
+
 func SetNodeStatus(status string) {
 	nodeStatus = status
 }
 
+// SendStatus sends the heartbeat, with the CPU load of the containers since the previous heartbeat
 func SendStatus() error {
-	msg, err := GetStatusMessage()
+	edgeApps, err := readEdgeAppStatus(heartbeatCPU)
+	if err != nil {
+		return traceutility.Wrap(err)
+	}
+	msg, err := statusMessage(edgeApps)
+	if err != nil {
+		return traceutility.Wrap(err)
+	}
+	err = com.SendHeartbeat(msg)
+	if err != nil {
+		return traceutility.Wrap(err)
+	}
+	return nil
+}
+
+// SendEdgeAppStatus sends the status of the node with the given status of the edge apps, e.g. the one that
+// MonitorEdgeAppStatus reports. The metrics of the containers follow with the next heartbeat.
+func SendEdgeAppStatus(edgeApps []com.EdgeAppMsg) error {
+	msg, err := statusMessage(edgeApps)
 	if err != nil {
 		return traceutility.Wrap(err)
 	}
@@ -41,6 +65,10 @@ func GetStatusMessage() (com.StatusMsg, error) {
 		return com.StatusMsg{}, traceutility.Wrap(err)
 	}
 
+	return statusMessage(edgeApps)
+}
+
+func statusMessage(edgeApps []com.EdgeAppMsg) (com.StatusMsg, error) {
 	deviceParams, err := getDeviceParams()
 	if err != nil {
 		return com.StatusMsg{}, traceutility.Wrap(err)
@@ -53,74 +81,187 @@ func GetStatusMessage() (com.StatusMsg, error) {
 		AgentVersion:     model.Version,
 		OrgKeyHash:       secret.OrgKeyHash,
 	}
+	if expiry, found := com.ClientCertExpiry(); found {
+		msg.ClientCertExpiry = &expiry
+	}
 
 	return msg, nil
 }
 
+// GetEdgeAppStatus returns the status of all known edge apps with the metrics of their containers. The container states
+// come from the status monitor while it runs. The CPU load is the one since the previous call, the heartbeat keeps its own.
 func GetEdgeAppStatus() ([]com.EdgeAppMsg, error) {
+	return readEdgeAppStatus(queryCPU)
+}
+
+func readEdgeAppStatus(cpu *cpuSampler) ([]com.EdgeAppMsg, error) {
 	edgeApps := []com.EdgeAppMsg{}
+	inUse := make(map[string]bool)
 
 	for _, manif := range manifest.GetKnownManifests() {
-		edgeApplication := com.EdgeAppMsg{ManifestID: manif.Manifest.ID, Status: manif.Status}
+		containers, err := containerStates(*manif)
+		if err != nil {
+			return edgeApps, traceutility.Wrap(err)
+		}
 
-		if manif.Status == model.EdgeAppUndeployed {
-			edgeApps = append(edgeApps, edgeApplication)
-			continue
+		edgeApp := edgeAppStatus(*manif, containers)
+		for i, con := range containers {
+			inUse[con.ID] = true
+			edgeApp.Containers[i].Metrics, err = readContainerMetrics(con, cpu)
+			if err != nil {
+				log.Error("Failed to read the metrics of container ", con.Name, "! CAUSE --> ", err)
+			}
+		}
+		edgeApp.Volumes, err = readVolumes(manif.Manifest.UniqueID)
+		if err != nil {
+			log.Error("Failed to read the volumes of edge app ", manif.Manifest.UniqueID, "! CAUSE --> ", err)
 		}
+		edgeApps = append(edgeApps, edgeApp)
+	}
+	cpu.forget(inUse)
+
+	return edgeApps, nil
+}
 
-		appContainers, err := docker.ReadEdgeAppContainers(manif.Manifest.UniqueID)
+// readVolumes lists the named volumes of an edge app by their names in the manifest
+func readVolumes(manifestUniqueID model.ManifestUniqueID) ([]com.VolumeMsg, error) {
+	volumes, err := containerRuntime.ReadEdgeAppVolumes(manifestUniqueID)
+	if err != nil {
+		return nil, traceutility.Wrap(err)
+	}
+
+	var volumeMsgs []com.VolumeMsg
+	for _, vol := range volumes {
+		name := vol.Labels[manifest.VolumeLabel]
+		if name == "" {
+			name = vol.Name
+		}
+		size := int64(-1)
+		if vol.UsageData != nil {
+			size = vol.UsageData.Size
+		}
+		volumeMsgs = append(volumeMsgs, com.VolumeMsg{Name: name, Size: size})
+	}
+
+	return volumeMsgs, nil
+}
+
+// containerState is the part of a container's state that the edge app status is derived from
+type containerState struct {
+	ID           string
+	Name         string
+	State        string
+	ExitCode     int
+	OOMKilled    bool
+	Health       string
+	RestartCount int
+}
+
+func readContainerStates(manif manifest.ManifestRecord) ([]containerState, error) {
+	if manif.Status == model.EdgeAppUndeployed {
+		return nil, nil
+	}
+
+	appContainers, err := containerRuntime.ReadEdgeAppContainers(manif.Manifest.UniqueID)
+	if err != nil {
+		return nil, traceutility.Wrap(err)
+	}
+
+	containers := []containerState{}
+	for _, con := range appContainers {
+		containerJSON, err := containerRuntime.InspectContainer(con.ID)
 		if err != nil {
-			return edgeApps, traceutility.Wrap(err)
+			return nil, traceutility.Wrap(err)
 		}
 
-		if (manif.Status == model.EdgeAppRunning || manif.Status == model.EdgeAppStopped) && len(appContainers) != len(manif.Manifest.Modules) {
-			edgeApplication.Status = model.EdgeAppError
+		container := containerState{
+			ID:    con.ID,
+			Name:  strings.Join(con.Names, ", "),
+			State: con.State,
 		}
+		if containerJSON.State != nil {
+			container.ExitCode = containerJSON.State.ExitCode
+			container.OOMKilled = containerJSON.State.OOMKilled
+			if containerJSON.State.Health != nil {
+				container.Health = containerJSON.State.Health.Status
+			}
+			container.RestartCount = containerJSON.RestartCount
+		}
+		containers = append(containers, container)
+	}
 
-		containersStat := []com.ContainerMsg{}
-		for _, con := range appContainers {
-			containerJSON, err := docker.InspectContainer(con.ID)
-			if err != nil {
-				return edgeApps, traceutility.Wrap(err)
+	return containers, nil
+}
+
+// edgeAppStatus checks whether the containers match the status of the edge app and reports an error if they don't
+func edgeAppStatus(manif manifest.ManifestRecord, containers []containerState) com.EdgeAppMsg {
+	edgeApplication := com.EdgeAppMsg{ManifestID: manif.Manifest.ID, Status: manif.Status}
+
+	if manif.Status == model.EdgeAppUndeployed {
+		return edgeApplication
+	}
+
+	if (manif.Status == model.EdgeAppRunning || manif.Status == model.EdgeAppStopped) && len(containers) != len(manif.Manifest.Modules) {
+		edgeApplication.Status = model.EdgeAppError
+	}
+
+	unhealthy := false
+	containersStat := []com.ContainerMsg{}
+	for _, con := range containers {
+		// The Status of each container is (assumed to be): Running, Restarting, Created, Exited
+		container := com.ContainerMsg{Name: con.Name, Status: ioutility.FirstToUpper(con.State), Health: ioutility.FirstToUpper(con.Health)}
+		containersStat = append(containersStat, container)
+		if con.Health == types.Unhealthy {
+			unhealthy = true
+		}
+
+		if (manif.Status != model.EdgeAppInitiated && manif.Status != model.EdgeAppExecuting) && edgeApplication.Status != model.EdgeAppError {
+			if manif.Status == model.EdgeAppRunning && con.State != strings.ToLower(model.ModuleRunning) {
+				edgeApplication.Status = model.EdgeAppError
 			}
-			// The Status of each container is (assumed to be): Running, Restarting, Created, Exited
-			container := com.ContainerMsg{Name: strings.Join(con.Names, ", "), Status: ioutility.FirstToUpper(con.State)}
-			containersStat = append(containersStat, container)
-
-			if (manif.Status != model.EdgeAppInitiated && manif.Status != model.EdgeAppExecuting) && edgeApplication.Status != model.EdgeAppError {
-				if manif.Status == model.EdgeAppRunning && con.State != strings.ToLower(model.ModuleRunning) {
-					edgeApplication.Status = model.EdgeAppError
-				}
-				if manif.Status == model.EdgeAppStopped && (con.State != strings.ToLower(model.ModuleExited) || (containerJSON.State.ExitCode != 0 && containerJSON.State.ExitCode != 137)) {
-					edgeApplication.Status = model.EdgeAppError
-				}
+			if manif.Status == model.EdgeAppStopped && (con.State != strings.ToLower(model.ModuleExited) || (con.ExitCode != 0 && con.ExitCode != 137)) {
+				edgeApplication.Status = model.EdgeAppError
 			}
 		}
+	}
 
-		edgeApplication.Containers = containersStat
-		edgeApps = append(edgeApps, edgeApplication)
+	// the containers run, but at least one of them doesn't work as it should
+	if edgeApplication.Status == model.EdgeAppRunning && unhealthy {
+		edgeApplication.Status = model.EdgeAppDegraded
 	}
 
-	return edgeApps, nil
+	edgeApplication.Containers = containersStat
+	return edgeApplication
 }
 
-func CompareEdgeAppStatus(edgeApps []com.EdgeAppMsg) ([]com.EdgeAppMsg, bool, error) {
-	statusChange := false
+// GetModuleResults reports the state of each module of the edge app after a command.
+// If the command failed on a module, the error is attached to that module.
+func GetModuleResults(man manifest.Manifest, cmdErr error) []com.ModuleResultMsg {
+	var moduleErr *ModuleError
+	errors.As(cmdErr, &moduleErr)
 
-	latestEdgeApps, err := GetEdgeAppStatus()
+	states := make(map[string]string)
+	containers, err := containerRuntime.ReadEdgeAppContainers(man.UniqueID)
 	if err != nil {
-		return nil, false, traceutility.Wrap(err)
+		log.Error("Failed to read edge app containers! CAUSE --> ", err)
 	}
-	if len(edgeApps) == len(latestEdgeApps) {
-		for index, edgeApp := range edgeApps {
-			if edgeApp.Status != latestEdgeApps[index].Status {
-				statusChange = true
-			}
+	for _, con := range containers {
+		states[containerName(con)] = con.State
+	}
+
+	results := []com.ModuleResultMsg{}
+	for _, module := range man.Modules {
+		result := com.ModuleResultMsg{Name: module.ContainerName, Image: module.ImageNameFull, Status: moduleMissing}
+		if state, found := states[module.ContainerName]; found && module.ContainerName != "" {
+			result.Status = ioutility.FirstToUpper(state)
 		}
-	} else {
-		statusChange = true
+		if moduleErr != nil && moduleErr.Image == module.ImageNameFull && (moduleErr.Container == "" || moduleErr.Container == module.ContainerName) {
+			result.Error = strings.SplitN(moduleErr.Err.Error(), "\n", 2)[0]
+		}
+		results = append(results, result)
 	}
-	return latestEdgeApps, statusChange, nil
+
+	return results
 }
 
 func getDeviceParams() (com.DeviceParamsMsg, error) {
@@ -0,0 +1,227 @@
+package edgeapp
+
+import (
+	"fmt"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/weeveiot/weeve-agent/internal/config"
+	"github.com/weeveiot/weeve-agent/internal/manifest"
+	"github.com/weeveiot/weeve-agent/internal/model"
+	traceutility "github.com/weeveiot/weeve-agent/internal/utility/trace"
+)
+
+const healthPollInterval = time.Second
+
+// canUpdateBlueGreen reports whether the new version can run next to the old one for a while
+func canUpdateBlueGreen(oldRecord manifest.ManifestRecord, man manifest.Manifest) bool {
+	if config.Params.UpdateMode != config.UpdateBlueGreen || oldRecord.Status != model.EdgeAppRunning {
+		return false
+	}
+
+	// host ports can only be bound by one version at a time
+	for _, module := range oldRecord.Manifest.Modules {
+		if len(module.PortBinding) > 0 {
+			return false
+		}
+	}
+	for _, module := range man.Modules {
+		if len(module.PortBinding) > 0 {
+			return false
+		}
+	}
+
+	return true
+}
+
+// updateEdgeApp starts the new version of the edge app on a fresh network next to the old version and removes the old
+// version once the new one is healthy. If the new version fails, it is removed and the old version keeps running.
+func updateEdgeApp(man manifest.Manifest, oldRecord manifest.ManifestRecord) error {
+	updateID := man.UniqueID.String() + " | "
+	log.Info(updateID, "Updating edge app blue/green ...")
+
+	setAndSendStatus(man.UniqueID, model.EdgeAppExecuting)
+
+	//******** STEP 1 - Pull all new images *************//
+	// the images pulled for the new version are removed again if the update fails
+	var pulledImages []string
+	for _, module := range man.Modules {
+		exists, err := containerRuntime.ImageExists(module.ImageNameFull)
+		if err != nil {
+			restoreOldVersion(updateID, man, oldRecord, pulledImages)
+			return traceutility.Wrap(err)
+		}
+		if !exists {
+			log.Info(updateID, "Pulling ", module.ImageNameFull)
+			err = pullImage(module.AuthConfig, module.ImageNameFull)
+			if err != nil {
+				log.Error(updateID, "Unable to pull image/s, "+err.Error())
+				restoreOldVersion(updateID, man, oldRecord, pulledImages)
+				return &ModuleError{Image: module.ImageNameFull, Err: fmt.Errorf("unable to pull image/s: %w", err)}
+			}
+			pulledImages = append(pulledImages, module.ImageNameFull)
+		}
+	}
+
+	//******** STEP 2 - Start the new version on a fresh network *************//
+	networkName, err := containerRuntime.CreateNetwork(man.ManifestName, man.Labels)
+	if err != nil {
+		log.Error(updateID, "CreateNetwork failed! CAUSE --> ", err)
+		restoreOldVersion(updateID, man, oldRecord, pulledImages)
+		return traceutility.Wrap(err)
+	}
+	man.UpdateManifest(networkName)
+	log.Info(updateID, "Created network >> ", networkName)
+
+	var containerIDs []string
+	// start containers in reverse order to prevent connectivity issues
+	for i := len(man.Modules) - 1; i >= 0; i-- {
+		module := man.Modules[i]
+		log.Info(updateID, "Creating ", module.ContainerName, " from ", module.ImageNameFull)
+		containerID, err := containerRuntime.CreateAndStartContainer(module)
+		if err != nil {
+			log.Error(updateID, "Failed to create and start container ", module.ContainerName, " CAUSE --> ", err)
+			restoreOldVersion(updateID, man, oldRecord, pulledImages)
+			return &ModuleError{Container: module.ContainerName, Image: module.ImageNameFull, Err: traceutility.Wrap(err)}
+		}
+		containerIDs = append(containerIDs, containerID)
+	}
+
+	//******** STEP 3 - Wait for the new version to become healthy *************//
+	err = waitHealthy(containerIDs)
+	if err != nil {
+		log.Error(updateID, "New version did not become healthy! CAUSE --> ", err)
+		restoreOldVersion(updateID, man, oldRecord, pulledImages)
+		return traceutility.Wrap(err)
+	}
+
+	//******** STEP 4 - Remove the old version *************//
+	log.Info(updateID, "New version is healthy, removing the old version ...")
+	// the status and the log cursors of the edge app are kept
+	err = manifest.UpdateKnownManifest(man)
+	if err != nil {
+		log.Error(updateID, "Failed to replace the known manifest! CAUSE --> ", err)
+	}
+
+	err = removeContainers(oldRecord.Manifest)
+	if err != nil {
+		log.Error(updateID, "Failed to remove the old version! CAUSE --> ", err)
+	}
+	err = containerRuntime.NetworkPrune(man.UniqueID)
+	if err != nil {
+		log.Error(updateID, "Failed to remove the old network! CAUSE --> ", err)
+	}
+
+	var oldImages []string
+	for _, module := range oldRecord.Manifest.Modules {
+		oldImages = append(oldImages, module.ImageNameFull)
+	}
+	var newImages []string
+	for _, module := range man.Modules {
+		newImages = append(newImages, module.ImageNameFull)
+	}
+	err = removeImages(updateID, subtractArray(oldImages, newImages))
+	if err != nil {
+		log.Error(updateID, "Failed to remove the old images! CAUSE --> ", err)
+	}
+
+	setAndSendStatus(man.UniqueID, model.EdgeAppRunning)
+	log.Info(updateID, "Update done!")
+
+	return nil
+}
+
+// restoreOldVersion removes whatever was started and pulled of the new version and puts the old version back in charge
+func restoreOldVersion(updateID string, man manifest.Manifest, oldRecord manifest.ManifestRecord, pulledImages []string) {
+	log.Info(updateID, "Initiating rollback to the old version ...")
+
+	err := removeContainers(man)
+	if err != nil {
+		log.Error(updateID, "Failed to remove the new version! CAUSE --> ", err)
+	}
+	// the old network still has the old containers attached, so only the new one is pruned
+	err = containerRuntime.NetworkPrune(man.UniqueID)
+	if err != nil {
+		log.Error(updateID, "Failed to remove the new network! CAUSE --> ", err)
+	}
+	err = removeImages(updateID, pulledImages)
+	if err != nil {
+		log.Error(updateID, "Failed to remove the images of the new version! CAUSE --> ", err)
+	}
+
+	setAndSendStatus(man.UniqueID, oldRecord.Status)
+}
+
+// removeContainers removes the containers of the modules of the manifest, leaving other versions of the edge app alone
+func removeContainers(man manifest.Manifest) error {
+	containers, err := containerRuntime.ReadEdgeAppContainers(man.UniqueID)
+	if err != nil {
+		return traceutility.Wrap(err)
+	}
+
+	names := make(map[string]bool)
+	for _, module := range man.Modules {
+		if module.ContainerName != "" {
+			names[module.ContainerName] = true
+		}
+	}
+
+	for _, container := range containers {
+		if names[containerName(container)] {
+			err = containerRuntime.StopAndRemoveContainer(container.ID)
+			if err != nil {
+				return traceutility.Wrap(err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// waitHealthy waits until all containers run and stayed running for the settle time.
+// A container that exits or reports to be unhealthy fails the wait right away.
+func waitHealthy(containerIDs []string) error {
+	timeout := time.Now().Add(time.Second * time.Duration(config.Params.UpdateTimeout))
+	settle := time.Second * time.Duration(config.Params.UpdateSettle)
+	var healthySince time.Time
+
+	for {
+		healthy := true
+		for _, containerID := range containerIDs {
+			containerJSON, err := containerRuntime.InspectContainer(containerID)
+			if err != nil {
+				return traceutility.Wrap(err)
+			}
+
+			state := containerJSON.State
+			if state == nil {
+				healthy = false
+				continue
+			}
+			switch {
+			case state.Status == "exited" || state.Status == "dead":
+				return fmt.Errorf("container %s %s with code %d", containerJSON.Name, state.Status, state.ExitCode)
+			case state.Health != nil && state.Health.Status == "unhealthy":
+				return fmt.Errorf("container %s is unhealthy", containerJSON.Name)
+			case !state.Running || (state.Health != nil && state.Health.Status != "healthy"):
+				healthy = false
+			}
+		}
+
+		now := time.Now()
+		if !healthy {
+			healthySince = time.Time{}
+		} else if healthySince.IsZero() {
+			healthySince = now
+		}
+		if !healthySince.IsZero() && now.Sub(healthySince) >= settle {
+			return nil
+		}
+		if now.After(timeout) {
+			return fmt.Errorf("not healthy after %d seconds", config.Params.UpdateTimeout)
+		}
+
+		time.Sleep(healthPollInterval)
+	}
+}
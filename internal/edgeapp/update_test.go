@@ -0,0 +1,192 @@
+package edgeapp_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/weeveiot/weeve-agent/internal/config"
+	"github.com/weeveiot/weeve-agent/internal/edgeapp"
+	"github.com/weeveiot/weeve-agent/internal/manifest"
+	"github.com/weeveiot/weeve-agent/internal/model"
+	"github.com/weeveiot/weeve-agent/internal/runtime/fake"
+)
+
+func TestUpdateEdgeApp_BlueGreen(t *testing.T) {
+	assert := assert.New(t)
+	rt := fake.NewRuntime()
+	edgeapp.SetRuntime(rt)
+
+	man := readManifest(t, "test_manifest.json")
+	err := edgeapp.DeployEdgeApp(man)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer edgeapp.RemoveEdgeApp(man.UniqueID, nil)
+	oldContainers, err := rt.ReadEdgeAppContainers(man.UniqueID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cursor := model.LogCursor{Time: time.Date(2023, 5, 1, 12, 0, 0, 0, time.UTC)}
+	err = manifest.SetLogCursors(man.UniqueID, map[string]model.LogCursor{oldContainers[0].ID: cursor})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	man2 := readManifest(t, "test_manifest2.json")
+	err = edgeapp.DeployEdgeApp(man2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// only the new version is left, on its own network
+	assertContainers(t, rt, man2.UniqueID, len(man2.Modules), "running")
+	assertNetworks(t, rt, man2.UniqueID, 1)
+	assertStatus(t, man2.UniqueID, model.EdgeAppRunning)
+	for _, container := range oldContainers {
+		_, err = rt.InspectContainer(container.ID)
+		assert.NotNil(err)
+	}
+	exists, _ := rt.ImageExists("weevenetwork/fluctuation-filter:v1.0.0")
+	assert.False(exists)
+	assert.Equal(man2.UpdatedAt, manifest.GetKnownManifest(man2.UniqueID).Manifest.UpdatedAt)
+	// the record of the edge app is kept, only its manifest is replaced
+	assert.Equal(cursor, manifest.GetKnownManifest(man2.UniqueID).LogCursors[oldContainers[0].ID])
+}
+
+func TestUpdateEdgeApp_PullFailure(t *testing.T) {
+	assert := assert.New(t)
+	rt := fake.NewRuntime()
+	edgeapp.SetRuntime(rt)
+
+	man := readManifest(t, "test_manifest2.json")
+	err := edgeapp.DeployEdgeApp(man)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer edgeapp.RemoveEdgeApp(man.UniqueID, nil)
+
+	newMan := newerVersion(t, "test_manifest.json", man)
+	rt.FailPull("weevenetwork/fluctuation-filter:v1.0.0", errors.New("registry unreachable"))
+
+	err = edgeapp.DeployEdgeApp(newMan)
+	assert.NotNil(err)
+
+	// the old version was never touched
+	assertContainers(t, rt, man.UniqueID, len(man.Modules), "running")
+	assertNetworks(t, rt, man.UniqueID, 1)
+	assertStatus(t, man.UniqueID, model.EdgeAppRunning)
+	assert.Equal(man.UpdatedAt, manifest.GetKnownManifest(man.UniqueID).Manifest.UpdatedAt)
+}
+
+func TestUpdateEdgeApp_Rollback(t *testing.T) {
+	assert := assert.New(t)
+	rt := fake.NewRuntime()
+	edgeapp.SetRuntime(rt)
+
+	man := readManifest(t, "test_manifest2.json")
+	err := edgeapp.DeployEdgeApp(man)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer edgeapp.RemoveEdgeApp(man.UniqueID, nil)
+	oldContainers, err := rt.ReadEdgeAppContainers(man.UniqueID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the new module crashes right after it started
+	newMan := newerVersion(t, "test_manifest.json", man)
+	rt.CrashOnStart("weevenetwork/fluctuation-filter:v1.0.0", 1)
+
+	err = edgeapp.DeployEdgeApp(newMan)
+	assert.NotNil(err)
+
+	// the new version is removed and the old one keeps running
+	assertContainers(t, rt, man.UniqueID, len(man.Modules), "running")
+	assertNetworks(t, rt, man.UniqueID, 1)
+	assertStatus(t, man.UniqueID, model.EdgeAppRunning)
+	for _, container := range oldContainers {
+		_, err = rt.InspectContainer(container.ID)
+		assert.Nil(err)
+	}
+	assert.Equal(man.UpdatedAt, manifest.GetKnownManifest(man.UniqueID).Manifest.UpdatedAt)
+	// the image that was pulled for the new version is removed again
+	exists, _ := rt.ImageExists("weevenetwork/fluctuation-filter:v1.0.0")
+	assert.False(exists)
+}
+
+func TestUpdateEdgeApp_Unhealthy(t *testing.T) {
+	assert := assert.New(t)
+	rt := fake.NewRuntime()
+	edgeapp.SetRuntime(rt)
+
+	man := readManifest(t, "test_manifest2.json")
+	err := edgeapp.DeployEdgeApp(man)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer edgeapp.RemoveEdgeApp(man.UniqueID, nil)
+
+	// the new module keeps running, but its healthcheck fails
+	newMan := newerVersion(t, "test_manifest.json", man)
+	newMan.Modules[1].Healthcheck = &container.HealthConfig{Test: []string{"CMD", "/bin/check"}}
+	rt.HealthOnStart(newMan.Modules[1].ImageNameFull, "unhealthy")
+
+	err = edgeapp.DeployEdgeApp(newMan)
+	assert.NotNil(err)
+	assertContainers(t, rt, man.UniqueID, len(man.Modules), "running")
+	assertStatus(t, man.UniqueID, model.EdgeAppRunning)
+	assert.Equal(man.UpdatedAt, manifest.GetKnownManifest(man.UniqueID).Manifest.UpdatedAt)
+
+	// a healthy new version replaces the old one
+	rt.HealthOnStart(newMan.Modules[1].ImageNameFull, "healthy")
+	newMan = newerVersion(t, "test_manifest.json", man)
+	newMan.Modules[1].Healthcheck = &container.HealthConfig{Test: []string{"CMD", "/bin/check"}}
+	err = edgeapp.DeployEdgeApp(newMan)
+	assert.Nil(err)
+	assertContainers(t, rt, man.UniqueID, len(newMan.Modules), "running")
+	assertStatus(t, man.UniqueID, model.EdgeAppRunning)
+	assert.Equal(newMan.UpdatedAt, manifest.GetKnownManifest(man.UniqueID).Manifest.UpdatedAt)
+}
+
+func TestUpdateEdgeApp_Recreate(t *testing.T) {
+	assert := assert.New(t)
+	rt := fake.NewRuntime()
+	edgeapp.SetRuntime(rt)
+
+	config.Params.UpdateMode = config.UpdateRecreate
+	defer func() { config.Params.UpdateMode = config.UpdateBlueGreen }()
+
+	man := readManifest(t, "test_manifest.json")
+	err := edgeapp.DeployEdgeApp(man)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer edgeapp.RemoveEdgeApp(man.UniqueID, nil)
+
+	// without blue/green, a failing new version takes the edge app down
+	newMan := newerVersion(t, "test_manifest2.json", man)
+	rt.CrashOnStart(newMan.Modules[0].ImageNameFull, 1)
+	err = edgeapp.DeployEdgeApp(newMan)
+	assert.Nil(err)
+	rt.CrashOnStart(newMan.Modules[0].ImageNameFull, -1)
+
+	containers, err := rt.ReadEdgeAppContainers(man.UniqueID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Len(containers, len(newMan.Modules))
+	assert.Equal(newMan.UpdatedAt, manifest.GetKnownManifest(man.UniqueID).Manifest.UpdatedAt)
+}
+
+// newerVersion reads the manifest from the file and makes it a newer version of the edge app
+func newerVersion(t *testing.T, fileName string, man manifest.Manifest) manifest.Manifest {
+	newMan := readManifest(t, fileName)
+	newMan.UniqueID = man.UniqueID
+	newMan.UpdatedAt = man.UpdatedAt.Add(time.Hour)
+	return newMan
+}
@@ -0,0 +1,111 @@
+package handler_test
+
+import (
+	"encoding/json"
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/eclipse/paho.mqtt.golang/packets"
+)
+
+// broker is an MQTT broker stub on localhost. It accepts any client and keeps what they publish, nothing is delivered.
+type broker struct {
+	listener  net.Listener
+	mutex     sync.Mutex
+	published []*packets.PublishPacket
+}
+
+func newBroker() (*broker, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+
+	b := &broker{listener: listener}
+	go b.serve()
+	return b, nil
+}
+
+func (b *broker) url() string {
+	return "mqtt://" + b.listener.Addr().String()
+}
+
+func (b *broker) close() {
+	b.listener.Close()
+}
+
+func (b *broker) serve() {
+	for {
+		conn, err := b.listener.Accept()
+		if err != nil {
+			return
+		}
+		go b.handle(conn)
+	}
+}
+
+func (b *broker) handle(conn net.Conn) {
+	defer conn.Close()
+
+	for {
+		packet, err := packets.ReadPacket(conn)
+		if err != nil {
+			return
+		}
+
+		var reply packets.ControlPacket
+		switch p := packet.(type) {
+		case *packets.ConnectPacket:
+			reply = packets.NewControlPacket(packets.Connack)
+		case *packets.PublishPacket:
+			b.mutex.Lock()
+			b.published = append(b.published, p)
+			b.mutex.Unlock()
+			if p.Qos == 1 {
+				ack := packets.NewControlPacket(packets.Puback).(*packets.PubackPacket)
+				ack.MessageID = p.MessageID
+				reply = ack
+			}
+		case *packets.SubscribePacket:
+			ack := packets.NewControlPacket(packets.Suback).(*packets.SubackPacket)
+			ack.MessageID = p.MessageID
+			ack.ReturnCodes = p.Qoss
+			reply = ack
+		case *packets.PingreqPacket:
+			reply = packets.NewControlPacket(packets.Pingresp)
+		case *packets.DisconnectPacket:
+			return
+		}
+
+		if reply != nil {
+			err = reply.Write(conn)
+			if err != nil {
+				return
+			}
+		}
+	}
+}
+
+// messages decodes the payloads published to the topics starting with prefix into new elements of the slice that
+// messages points to
+func (b *broker) messages(prefix string, messages interface{}) error {
+	b.mutex.Lock()
+	var payloads []string
+	for _, p := range b.published {
+		if strings.HasPrefix(p.TopicName, prefix) {
+			payloads = append(payloads, string(p.Payload))
+		}
+	}
+	b.mutex.Unlock()
+
+	return json.Unmarshal([]byte("["+strings.Join(payloads, ",")+"]"), messages)
+}
+
+// reset forgets the messages published so far
+func (b *broker) reset() {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.published = nil
+}
@@ -0,0 +1,57 @@
+package handler
+
+import (
+	"sync"
+
+	"github.com/weeveiot/weeve-agent/internal/model"
+)
+
+// CommandQueue runs the commands of each edge app one after another, in the order they arrived.
+// Commands of different edge apps run in parallel.
+type CommandQueue struct {
+	mutex sync.Mutex
+	// an edge app has an entry while a goroutine is running its commands
+	queues map[model.ManifestUniqueID][]func()
+	wg     sync.WaitGroup
+}
+
+func NewCommandQueue() *CommandQueue {
+	return &CommandQueue{queues: make(map[model.ManifestUniqueID][]func())}
+}
+
+// Enqueue schedules the command to run after all commands of the edge app that were enqueued before
+func (q *CommandQueue) Enqueue(manifestUniqueID model.ManifestUniqueID, command func()) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	queue, running := q.queues[manifestUniqueID]
+	q.queues[manifestUniqueID] = append(queue, command)
+	if !running {
+		q.wg.Add(1)
+		go q.run(manifestUniqueID)
+	}
+}
+
+// Wait blocks until all enqueued commands are done
+func (q *CommandQueue) Wait() {
+	q.wg.Wait()
+}
+
+func (q *CommandQueue) run(manifestUniqueID model.ManifestUniqueID) {
+	defer q.wg.Done()
+
+	for {
+		q.mutex.Lock()
+		queue := q.queues[manifestUniqueID]
+		if len(queue) == 0 {
+			delete(q.queues, manifestUniqueID)
+			q.mutex.Unlock()
+			return
+		}
+		command := queue[0]
+		q.queues[manifestUniqueID] = queue[1:]
+		q.mutex.Unlock()
+
+		command()
+	}
+}
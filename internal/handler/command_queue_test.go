@@ -0,0 +1,48 @@
+package handler_test
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/weeveiot/weeve-agent/internal/handler"
+	"github.com/weeveiot/weeve-agent/internal/model"
+)
+
+func TestCommandQueue(t *testing.T) {
+	assert := assert.New(t)
+	queue := handler.NewCommandQueue()
+
+	app1 := model.ManifestUniqueID{ID: "app1"}
+	app2 := model.ManifestUniqueID{ID: "app2"}
+
+	var mutex sync.Mutex
+	var order []string
+	record := func(command string) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		order = append(order, command)
+	}
+
+	// the first command of app1 blocks until app2 was able to run, so app2 must not wait for app1
+	app2Done := make(chan struct{})
+	queue.Enqueue(app1, func() {
+		select {
+		case <-app2Done:
+		case <-time.After(5 * time.Second):
+			t.Error("edge apps are not processed in parallel")
+		}
+		record("app1 DEPLOY")
+	})
+	queue.Enqueue(app1, func() { record("app1 STOP") })
+	queue.Enqueue(app1, func() { record("app1 REMOVE") })
+	queue.Enqueue(app2, func() {
+		record("app2 DEPLOY")
+		close(app2Done)
+	})
+
+	queue.Wait()
+	assert.Equal([]string{"app2 DEPLOY", "app1 DEPLOY", "app1 STOP", "app1 REMOVE"}, order)
+}
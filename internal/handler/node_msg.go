@@ -5,6 +5,7 @@ import (
 	log "github.com/sirupsen/logrus"
 
 	"github.com/weeveiot/weeve-agent/internal/edgeapp"
+	"github.com/weeveiot/weeve-agent/internal/manifest"
 	"github.com/weeveiot/weeve-agent/internal/model"
 )
 
@@ -16,10 +17,21 @@ var NodeDeleteHandler mqtt.MessageHandler = func(client mqtt.Client, msg mqtt.Me
 func DeleteNode(nodeStatus string) {
 	log.Debug("Deleting node...")
 
-	err := edgeapp.RemoveAll()
-	if err != nil {
-		log.Error("Deletion of node failed! CAUSE --> ", err)
+	log.Info("Removing all edge apps")
+	// the removals go through the command queues, so that they don't race commands that are still running
+	for uniqueID := range manifest.GetKnownManifests() {
+		uniqueID := uniqueID
+		orchestrationQueue.Enqueue(uniqueID, func() {
+			err := edgeapp.RemoveEdgeApp(uniqueID, nil)
+			if err == nil {
+				err = edgeapp.RemoveEdgeAppVolumes(uniqueID)
+			}
+			if err != nil {
+				log.Error("Deletion of node failed! CAUSE --> ", err)
+			}
+		})
 	}
+	orchestrationQueue.Wait()
 
 	edgeapp.SetNodeStatus(nodeStatus)
 	edgeapp.SendStatus()
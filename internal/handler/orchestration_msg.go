@@ -1,39 +1,173 @@
 package handler
 
 import (
+	"encoding/json"
 	"errors"
+	"strings"
+	"time"
 
 	mqtt "github.com/eclipse/paho.mqtt.golang"
 	log "github.com/sirupsen/logrus"
 
+	"github.com/weeveiot/weeve-agent/internal/com"
 	"github.com/weeveiot/weeve-agent/internal/edgeapp"
 	"github.com/weeveiot/weeve-agent/internal/manifest"
+	"github.com/weeveiot/weeve-agent/internal/metrics"
+	"github.com/weeveiot/weeve-agent/internal/model"
 	traceutility "github.com/weeveiot/weeve-agent/internal/utility/trace"
 )
 
+// orchestrationQueue makes sure that the commands of an edge app don't interfere with each other
+var orchestrationQueue = NewCommandQueue()
+
 var OrchestrationHandler mqtt.MessageHandler = func(client mqtt.Client, msg mqtt.Message) {
 	log.Debugln("Received message on topic:", msg.Topic(), "Payload:", string(msg.Payload()))
 
-	err := ProcessOrchestrationMessage(msg.Payload())
+	payload := msg.Payload()
+	manifestUniqueID, err := manifest.GetEdgeAppUniqueID(payload)
 	if err != nil {
 		log.Error("Failed to process orchestration message! CAUSE --> ", err)
+		rejectMessage(payload, time.Now(), err)
+		return
+	}
+
+	orchestrationQueue.Enqueue(manifestUniqueID, func() {
+		err := ProcessOrchestrationMessage(payload)
+		if err != nil {
+			log.Error("Failed to process orchestration message! CAUSE --> ", err)
+		}
+	})
+}
+
+// ProcessLocalCommand runs a command for an edge app that didn't come from the manager, e.g. from the local API.
+// It goes through the command queue of the edge app like any orchestration message and waits for the command to finish.
+func ProcessLocalCommand(command string, manifestUniqueID model.ManifestUniqueID) error {
+	payload, err := json.Marshal(map[string]string{"_id": manifestUniqueID.ID, "command": command})
+	if err != nil {
+		return traceutility.Wrap(err)
 	}
+
+	done := make(chan error, 1)
+	orchestrationQueue.Enqueue(manifestUniqueID, func() {
+		done <- ProcessOrchestrationMessage(payload)
+	})
+	return <-done
 }
 
+// ProcessOrchestrationMessage executes the command of the message. The receipt of the command is acknowledged right
+// away and the outcome is reported once the command is done, both with the correlation ID of the message if it has one.
 func ProcessOrchestrationMessage(payload []byte) error {
+	receivedAt := time.Now()
+
 	operation, err := manifest.GetCommand(payload)
 	if err != nil {
+		rejectMessage(payload, receivedAt, err)
 		return traceutility.Wrap(err)
 	}
 	log.Infoln("Processing the", operation, "message")
 
+	correlationID, _ := manifest.GetCorrelationID(payload)
+	manifestUniqueID, _ := manifest.GetEdgeAppUniqueID(payload)
+
+	err = com.SendCommandAck(com.CommandAckMsg{
+		CorrelationID: correlationID,
+		Command:       operation,
+		ManifestID:    manifestUniqueID.ID,
+		ReceivedAt:    receivedAt,
+	})
+	if err != nil {
+		log.Error("Failed to acknowledge the command! CAUSE --> ", err)
+	}
+
+	// the known manifest is gone after a removal, so the modules have to be looked up beforehand
+	man := manifest.Manifest{UniqueID: manifestUniqueID}
+	if edgeAppRecord := manifest.GetKnownManifest(manifestUniqueID); edgeAppRecord != nil {
+		man = edgeAppRecord.Manifest
+	}
+
+	cmdErr := executeCommand(operation, payload, &man)
+	if cmdErr != nil {
+		metrics.Commands.WithLabelValues(operation, "failure").Inc()
+	} else {
+		metrics.Commands.WithLabelValues(operation, "success").Inc()
+	}
+
+	if edgeAppRecord := manifest.GetKnownManifest(manifestUniqueID); edgeAppRecord != nil {
+		man = edgeAppRecord.Manifest
+	}
+	result := com.CommandResultMsg{
+		CorrelationID: correlationID,
+		Command:       operation,
+		ManifestID:    manifestUniqueID.ID,
+		Success:       cmdErr == nil,
+		StartedAt:     receivedAt,
+		DurationMs:    time.Since(receivedAt).Milliseconds(),
+		Modules:       edgeapp.GetModuleResults(man, cmdErr),
+	}
+	if cmdErr != nil {
+		result.Errors = CommandErrors(cmdErr)
+	}
+	err = com.SendCommandResult(result)
+	if err != nil {
+		log.Error("Failed to send the command result! CAUSE --> ", err)
+	}
+
+	return cmdErr
+}
+
+// rejectMessage acknowledges a message that can't be processed and reports it as failed, so that the manager doesn't
+// wait for its outcome. The message is reported with as much of the command as could be read from it.
+func rejectMessage(payload []byte, receivedAt time.Time, cause error) {
+	var msg struct {
+		ID            string `json:"_id"`
+		Command       string
+		CorrelationID string
+	}
+	// a message that isn't even JSON is reported without command
+	_ = json.Unmarshal(payload, &msg)
+
+	metrics.Commands.WithLabelValues(msg.Command, "failure").Inc()
+
+	err := com.SendCommandAck(com.CommandAckMsg{
+		CorrelationID: msg.CorrelationID,
+		Command:       msg.Command,
+		ManifestID:    msg.ID,
+		ReceivedAt:    receivedAt,
+	})
+	if err != nil {
+		log.Error("Failed to acknowledge the command! CAUSE --> ", err)
+	}
+
+	err = com.SendCommandResult(com.CommandResultMsg{
+		CorrelationID: msg.CorrelationID,
+		Command:       msg.Command,
+		ManifestID:    msg.ID,
+		Success:       false,
+		Errors:        CommandErrors(cause),
+		StartedAt:     receivedAt,
+		DurationMs:    time.Since(receivedAt).Milliseconds(),
+	})
+	if err != nil {
+		log.Error("Failed to send the command result! CAUSE --> ", err)
+	}
+}
+
+// CommandErrors returns the messages of the error of a command that are reported to the manager. These are the lines of
+// the root cause, e.g. one per validation problem, without the traces of the source code.
+func CommandErrors(err error) []string {
+	return strings.Split(traceutility.Cause(err).Error(), "\n")
+}
+
+// executeCommand runs the command. For a deployment, man is set to the deployed manifest.
+func executeCommand(operation string, payload []byte, man *manifest.Manifest) error {
 	switch operation {
 	case edgeapp.CMDDeploy:
-		manifest, err := manifest.Parse(payload)
+		deployManifest, err := manifest.Parse(payload)
 		if err != nil {
 			return traceutility.Wrap(err)
 		}
-		err = edgeapp.DeployEdgeApp(manifest)
+		*man = deployManifest
+		err = edgeapp.DeployEdgeApp(deployManifest)
 		if err != nil {
 			return traceutility.Wrap(err)
 		}
@@ -81,8 +215,27 @@ func ProcessOrchestrationMessage(payload []byte) error {
 		if err != nil {
 			return traceutility.Wrap(err)
 		}
+		err = edgeapp.RemoveEdgeAppVolumes(manifestUniqueID)
+		if err != nil {
+			return traceutility.Wrap(err)
+		}
 		log.Info("Full removal done!")
 
+	case edgeapp.CMDLogs:
+		manifestUniqueID, err := manifest.GetEdgeAppUniqueID(payload)
+		if err != nil {
+			return traceutility.Wrap(err)
+		}
+		limits, err := manifest.ParseLogLimits(payload, len(man.Modules))
+		if err != nil {
+			return traceutility.Wrap(err)
+		}
+		err = edgeapp.SetLogLimits(manifestUniqueID, limits)
+		if err != nil {
+			return traceutility.Wrap(err)
+		}
+		log.Info("Log limits changed!")
+
 	default:
 		return errors.New("received message with unknown command")
 	}
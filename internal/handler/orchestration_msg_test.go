@@ -1,34 +1,72 @@
 package handler_test
 
 import (
-	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
-	"strings"
+	"path/filepath"
 	"testing"
+	"time"
 
-	"errors"
-
-	"github.com/Jeffail/gabs/v2"
-	"github.com/docker/docker/api/types"
-	"github.com/docker/docker/api/types/filters"
-	"github.com/docker/docker/client"
 	mqtt "github.com/eclipse/paho.mqtt.golang"
-	log "github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
 
 	"github.com/weeveiot/weeve-agent/internal/com"
 	"github.com/weeveiot/weeve-agent/internal/config"
-	"github.com/weeveiot/weeve-agent/internal/docker"
 	"github.com/weeveiot/weeve-agent/internal/edgeapp"
 	"github.com/weeveiot/weeve-agent/internal/handler"
 	"github.com/weeveiot/weeve-agent/internal/manifest"
 	"github.com/weeveiot/weeve-agent/internal/model"
+	"github.com/weeveiot/weeve-agent/internal/runtime/fake"
+	traceutility "github.com/weeveiot/weeve-agent/internal/utility/trace"
+)
+
+var (
+	testdataDir string
+	mqttBroker  *broker
 )
 
-func init() {
-	docker.SetupDockerClient()
+// TestMain connects the agent to a broker stub and runs the tests in a temporary working directory, as the known
+// manifests are persisted in the working directory
+func TestMain(m *testing.M) {
+	var err error
+	testdataDir, err = filepath.Abs("../../testdata")
+	if err != nil {
+		panic(err)
+	}
+
+	workDir, err := os.MkdirTemp("", "handler_test")
+	if err != nil {
+		panic(err)
+	}
+	err = os.Chdir(workDir)
+	if err != nil {
+		panic(err)
+	}
+
+	mqttBroker, err = newBroker()
+	if err != nil {
+		panic(err)
+	}
+	config.Set(model.Params{
+		Broker:    mqttBroker.url(),
+		NoTLS:     true,
+		Heartbeat: 60,
+		NodeId:    "1234567890",
+		NodeName:  "Test Node",
+		OutboxDir: "outbox",
+	})
+	err = com.ConnectNode(map[string]mqtt.MessageHandler{})
+	if err != nil {
+		panic(err)
+	}
+
+	code := m.Run()
+	com.DisconnectNode()
+	mqttBroker.close()
+	os.RemoveAll(workDir)
+	os.Exit(code)
 }
 
 type msgType struct {
@@ -38,237 +76,251 @@ type msgType struct {
 	Command      string `json:"command"`
 }
 
-var ctx = context.Background()
-var dockerCli *client.Client
+func TestCommandErrors(t *testing.T) {
+	assert := assert.New(t)
 
-func TestProcessMessagePass(t *testing.T) {
-	log.SetLevel(log.DebugLevel)
-	opt := model.Params{
-		Broker:    "mqtt://test.mosquitto.org:1883",
-		NoTLS:     true,
-		Heartbeat: 60,
-		NodeId:    "1234567890",
-		NodeName:  "Test Node",
+	validationErr := &manifest.ValidationError{Problems: []manifest.Problem{
+		{Path: "modules.0.image.name", Message: "is required"},
+		{Path: "modules.1.ports", Message: "port 80 is bound twice"},
+	}}
+	tests := []struct {
+		err  error
+		want []string
+	}{
+		{
+			err:  traceutility.Wrap(traceutility.Wrap(validationErr)),
+			want: []string{"modules.0.image.name: is required", "modules.1.ports: port 80 is bound twice"},
+		},
+		{
+			err:  traceutility.Wrap(fmt.Errorf("pulling image failed: %w", traceutility.Wrap(errors.New("manifest unknown")))),
+			want: []string{"manifest unknown"},
+		},
+		{
+			err:  errors.New("edge app not known"),
+			want: []string{"edge app not known"},
+		},
+	}
+
+	for _, test := range tests {
+		errs := handler.CommandErrors(test.err)
+		assert.Equal(test.want, errs)
+		for _, line := range errs {
+			assert.NotContains(line, ".go:")
+		}
 	}
-	config.Set(opt)
-	com.ConnectNode(map[string]mqtt.MessageHandler{})
+}
 
+func TestProcessMessagePass(t *testing.T) {
 	assert := assert.New(t)
-	// Prepare test data
-	manifestPath := "../../testdata/test_manifest.json"
-	msg, err := os.ReadFile(manifestPath)
-	if err != nil {
-		t.Fatal(err)
-	}
+	rt := fake.NewRuntime()
+	edgeapp.SetRuntime(rt)
+	mqttBroker.reset()
 
-	jsonParsed, err := gabs.ParseJSON(msg)
-	if err != nil {
-		t.Fatal(err)
-	}
+	msg, man := readManifest(t, "test_manifest.json")
+	msg2, man2 := readManifest(t, "test_manifest2.json")
 
-	man, err := parseManifest(jsonParsed)
+	t.Log("TESTING EDGE APPLICATION DEPLOYMENT...")
+	err := deployEdgeApplication(rt, msg, man)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	manifestPath2 := "../../testdata/test_manifest2.json"
-	msg2, err := os.ReadFile(manifestPath2)
-	if err != nil {
-		t.Fatal(err)
+	// the manager learns about the receipt and the outcome of the command
+	var statuses []com.CommandResultMsg
+	assert.Eventually(func() bool {
+		statuses = nil
+		err := mqttBroker.messages("commandstatus/", &statuses)
+		return err == nil && len(statuses) == 2
+	}, time.Second, 10*time.Millisecond)
+	if assert.Len(statuses, 2) {
+		assert.Equal(com.CommandStageAck, statuses[0].Stage)
+		assert.Equal(com.CommandStageResult, statuses[1].Stage)
+		assert.True(statuses[1].Success)
+		assert.Equal(man.ID, statuses[1].ManifestID)
 	}
 
-	jsonParsed2, err := gabs.ParseJSON(msg2)
+	t.Log("TESTING STOP EDGE APPLICATION...")
+	err = sendCommand(man, edgeapp.CMDStop)
 	if err != nil {
 		t.Fatal(err)
 	}
-
-	man2, err := parseManifest(jsonParsed2)
+	err = checkContainersExistsWithStatus(rt, man.UniqueID, len(man.Modules), "exited")
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	dockerCli, err = client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+	t.Log("TESTING RESUME EDGE APPLICATION...")
+	err = sendCommand(man, edgeapp.CMDResume)
 	if err != nil {
 		t.Fatal(err)
 	}
-
-	fmt.Println("TESTING EDGE APPLICATION DEPLOYMENT...")
-	err = deployEdgeApplication(msg, man)
+	err = checkContainersExistsWithStatus(rt, man.UniqueID, len(man.Modules), "running")
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	fmt.Println("TESTING STOP EDGE APPLICATION...")
-	err = stopEdgeApplication(man)
-	if err != nil {
-		t.Error(err)
-		err = undeployEdgeApplication(man, edgeapp.CMDRemove)
-		if err != nil {
-			t.Fatal(err)
-		}
-		t.FailNow()
-	}
-
-	fmt.Println("TESTING RESUME EDGE APPLICATION...")
-	err = resumeEdgeApplication(man)
-	if err != nil {
-		t.Error(err)
-		err = undeployEdgeApplication(man, edgeapp.CMDRemove)
-		if err != nil {
-			t.Fatal(err)
-		}
-		t.FailNow()
-	}
-
-	fmt.Println("TESTING EDGE APPLICATION REDEPLOYMENT...")
-	err = deployEdgeApplication(msg2, man2)
+	t.Log("TESTING EDGE APPLICATION REDEPLOYMENT...")
+	err = deployEdgeApplication(rt, msg2, man2)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	fmt.Println("TESTING UNDEPLOY EDGE APPLICATION...")
-	err = undeployEdgeApplication(man2, edgeapp.CMDUndeploy)
+	t.Log("TESTING UNDEPLOY EDGE APPLICATION...")
+	err = undeployEdgeApplication(rt, man2, edgeapp.CMDUndeploy)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	fmt.Println("DEPLOYING EDGE APPLICATION FOR TESTING REMOVE EDGE APPLICATION...")
-	err = deployEdgeApplication(msg2, man2)
+	t.Log("DEPLOYING EDGE APPLICATION FOR TESTING REMOVE EDGE APPLICATION...")
+	err = deployEdgeApplication(rt, msg2, man2)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	fmt.Println("TESTING REMOVE EDGE APPLICATION...")
-	err = undeployEdgeApplication(man2, edgeapp.CMDRemove)
+	t.Log("TESTING REMOVE EDGE APPLICATION...")
+	err = undeployEdgeApplication(rt, man2, edgeapp.CMDRemove)
 	assert.Nil(err)
 }
 
-func deployEdgeApplication(jsonBytes []byte, man manifest.Manifest) error {
-	// Process deploy edge application
-	err := handler.ProcessOrchestrationMessage(jsonBytes)
-	if err != nil {
-		return fmt.Errorf("ProcessMessage returned %v status", err)
-	}
+// message is an MQTT message as the handler receives it, the methods it doesn't implement must not be called
+type message struct {
+	mqtt.Message
+	payload []byte
+}
 
-	// Verify deployment
-	net, err := getNetwork(man.UniqueID)
-	if err != nil {
-		return err
-	}
+func (m message) Topic() string {
+	return "orchestration/1234567890"
+}
 
-	if len(net) > 0 {
-		_, err := checkContainersExistsWithStatus(man.UniqueID, len(man.Modules), "running")
-		if err != nil {
-			return err
+func (m message) Payload() []byte {
+	return m.payload
+}
+
+func TestProcessMessageRejected(t *testing.T) {
+	assert := assert.New(t)
+	edgeapp.SetRuntime(fake.NewRuntime())
+
+	tests := []struct {
+		name    string
+		payload string
+		process func(payload []byte)
+		want    com.CommandResultMsg
+	}{
+		{
+			name:    "no edge app",
+			payload: `{"command": "STOP", "correlationID": "c1"}`,
+			process: func(payload []byte) { handler.OrchestrationHandler(nil, message{payload: payload}) },
+			want:    com.CommandResultMsg{CorrelationID: "c1", Command: "STOP"},
+		},
+		{
+			name:    "no command",
+			payload: `{"_id": "abc123", "correlationID": "c2"}`,
+			process: func(payload []byte) { assert.Error(handler.ProcessOrchestrationMessage(payload)) },
+			want:    com.CommandResultMsg{CorrelationID: "c2", ManifestID: "abc123"},
+		},
+		{
+			name:    "no JSON",
+			payload: `STOP`,
+			process: func(payload []byte) { handler.OrchestrationHandler(nil, message{payload: payload}) },
+		},
+	}
+
+	for _, test := range tests {
+		mqttBroker.reset()
+		test.process([]byte(test.payload))
+
+		// the manager still learns that the command was received and failed
+		var statuses []com.CommandResultMsg
+		assert.Eventually(func() bool {
+			statuses = nil
+			err := mqttBroker.messages("commandstatus/", &statuses)
+			return err == nil && len(statuses) == 2
+		}, time.Second, 10*time.Millisecond, test.name)
+		if assert.Len(statuses, 2, test.name) {
+			assert.Equal(com.CommandStageAck, statuses[0].Stage, test.name)
+			assert.Equal(test.want.CorrelationID, statuses[0].CorrelationID, test.name)
+			assert.Equal(com.CommandStageResult, statuses[1].Stage, test.name)
+			assert.False(statuses[1].Success, test.name)
+			assert.NotEmpty(statuses[1].Errors, test.name)
+			assert.Equal(test.want.CorrelationID, statuses[1].CorrelationID, test.name)
+			assert.Equal(test.want.Command, statuses[1].Command, test.name)
+			assert.Equal(test.want.ManifestID, statuses[1].ManifestID, test.name)
 		}
-	} else {
-		return errors.New("Network not created")
 	}
-
-	return nil
 }
 
-func stopEdgeApplication(man manifest.Manifest) error {
-	msg := msgType{
-		ID:      man.ID,
-		Command: edgeapp.CMDStop,
+func readManifest(t *testing.T, fileName string) ([]byte, manifest.Manifest) {
+	msg, err := os.ReadFile(filepath.Join(testdataDir, fileName))
+	if err != nil {
+		t.Fatal(err)
 	}
-	jsonB, err := json.Marshal(msg)
+	man, err := manifest.Parse(msg)
 	if err != nil {
-		panic(err)
+		t.Fatal(err)
 	}
+	return msg, man
+}
 
-	fmt.Println("Sending STOP command: ", string(jsonB))
-
-	err = handler.ProcessOrchestrationMessage(jsonB)
+func deployEdgeApplication(rt *fake.Runtime, jsonBytes []byte, man manifest.Manifest) error {
+	err := handler.ProcessOrchestrationMessage(jsonBytes)
 	if err != nil {
 		return fmt.Errorf("ProcessMessage returned %v status", err)
 	}
 
-	_, err = checkContainersExistsWithStatus(man.UniqueID, len(man.Modules), "exited")
+	networks, err := rt.ReadEdgeAppNetworks(man.UniqueID)
 	if err != nil {
 		return err
 	}
+	if len(networks) == 0 {
+		return errors.New("Network not created")
+	}
 
-	return nil
+	return checkContainersExistsWithStatus(rt, man.UniqueID, len(man.Modules), "running")
 }
 
-func resumeEdgeApplication(man manifest.Manifest) error {
-	msg := msgType{
-		ID:      man.ID,
-		Command: edgeapp.CMDResume,
-	}
-	jsonB, err := json.Marshal(msg)
+func sendCommand(man manifest.Manifest, command string) error {
+	jsonB, err := json.Marshal(msgType{ID: man.ID, Command: command})
 	if err != nil {
 		return err
 	}
 
-	fmt.Println("Sending RESUME command: ", string(jsonB))
-
 	err = handler.ProcessOrchestrationMessage(jsonB)
 	if err != nil {
 		return fmt.Errorf("ProcessMessage returned %v status", err)
 	}
-
-	_, err = checkContainersExistsWithStatus(man.UniqueID, len(man.Modules), "running")
-	if err != nil {
-		return err
-	}
-
 	return nil
 }
 
-func undeployEdgeApplication(man manifest.Manifest, operation string) error {
-	msg := msgType{
-		ID: man.ID,
-	}
-	if operation == edgeapp.CMDUndeploy || operation == edgeapp.CMDRemove {
-		msg.Command = operation
-	} else {
-		return errors.New("Invalid operation: " + operation)
-	}
-	jsonB, err := json.Marshal(msg)
+func undeployEdgeApplication(rt *fake.Runtime, man manifest.Manifest, operation string) error {
+	err := sendCommand(man, operation)
 	if err != nil {
 		return err
 	}
 
-	fmt.Println("Sending UNDEPLOY / REMOVE command: ", string(jsonB))
-
-	err = handler.ProcessOrchestrationMessage(jsonB)
+	networks, err := rt.ReadEdgeAppNetworks(man.UniqueID)
 	if err != nil {
-		return fmt.Errorf("ProcessMessage returned %v status", err)
+		return err
 	}
-
-	net, err := getNetwork(man.UniqueID)
+	if len(networks) > 0 {
+		return errors.New("Edge application undeployment failed, network not deleted")
+	}
+	containers, err := rt.ReadEdgeAppContainers(man.UniqueID)
 	if err != nil {
 		return err
 	}
-
-	if len(net) <= 0 {
-		dsContainers, _ := getEdgeApplicationContainers(man.UniqueID)
-		if len(dsContainers) > 0 {
-			return errors.New("Edge application undeployment failed, containers not deleted")
-		}
-	} else {
-		return errors.New("Edge application undeployment failed, network not deleted")
+	if len(containers) > 0 {
+		return errors.New("Edge application undeployment failed, containers not deleted")
 	}
 
-	if operation == edgeapp.CMDUndeploy {
-		exist, err := checkImages(man, true)
+	for _, module := range man.Modules {
+		exists, err := rt.ImageExists(module.ImageNameFull)
 		if err != nil {
 			return err
 		}
-
-		if !exist {
+		if operation == edgeapp.CMDUndeploy && !exists {
 			return errors.New("Edge application undeploy should not delete images")
 		}
-	} else {
-		deleted, err := checkImages(man, false)
-		if err != nil {
-			return err
-		}
-
-		if !deleted {
+		if operation == edgeapp.CMDRemove && exists {
 			return errors.New("Edge application removal should delete images")
 		}
 	}
@@ -276,90 +328,19 @@ func undeployEdgeApplication(man manifest.Manifest, operation string) error {
 	return nil
 }
 
-func parseManifest(jsonParsed *gabs.Container) (manifest.Manifest, error) {
-	id := jsonParsed.Search("_id").Data().(string)
-
-	var containerConfigs []manifest.ContainerConfig
-
-	modules := jsonParsed.Search("modules").Children()
-	for _, module := range modules {
-		var containerConfig manifest.ContainerConfig
-
-		imageName := module.Search("image").Search("name").Data().(string)
-		imageTag := module.Search("image").Search("tag").Data().(string)
-
-		if imageTag == "" {
-			containerConfig.ImageNameFull = imageName
-		} else {
-			containerConfig.ImageNameFull = imageName + ":" + imageTag
-		}
-
-		containerConfigs = append(containerConfigs, containerConfig)
-	}
-
-	manifest := manifest.Manifest{
-		UniqueID: model.ManifestUniqueID{ID: id},
-		ID:       id,
-		Modules:  containerConfigs,
-	}
-
-	return manifest, nil
-}
-
-func getNetwork(manifestUniqueID model.ManifestUniqueID) ([]types.NetworkResource, error) {
-	filter := filters.NewArgs()
-	filter.Add("label", "manifestUniqueID="+manifestUniqueID.String())
-	options := types.NetworkListOptions{Filters: filter}
-	networks, err := dockerCli.NetworkList(context.Background(), options)
-	if err != nil {
-		return nil, err
-	}
-
-	return networks, nil
-}
-
-func getEdgeApplicationContainers(manifestUniqueID model.ManifestUniqueID) ([]types.Container, error) {
-	filter := filters.NewArgs()
-	filter.Add("label", "manifestUniqueID="+manifestUniqueID.String())
-	options := types.ContainerListOptions{All: true, Filters: filter}
-	containers, err := dockerCli.ContainerList(context.Background(), options)
+func checkContainersExistsWithStatus(rt *fake.Runtime, manID model.ManifestUniqueID, containerCount int, status string) error {
+	containers, err := rt.ReadEdgeAppContainers(manID)
 	if err != nil {
-		return nil, err
+		return err
 	}
-
-	return containers, nil
-}
-
-func checkContainersExistsWithStatus(manID model.ManifestUniqueID, containerCount int, status string) (bool, error) {
-	dsContainers, _ := getEdgeApplicationContainers(manID)
-	if containerCount != len(dsContainers) {
-		return false, fmt.Errorf("Expected number of containers %v, number of available containers %v", containerCount, len(dsContainers))
+	if containerCount != len(containers) {
+		return fmt.Errorf("Expected number of containers %v, number of available containers %v", containerCount, len(containers))
 	}
-	for _, dsContainer := range dsContainers {
-		if dsContainer.State != strings.ToLower(status) {
-			return false, fmt.Errorf("Container expected status %s, but current status %s", status, dsContainer.State)
+	for _, container := range containers {
+		if container.State != status {
+			return fmt.Errorf("Container expected status %s, but current status %s", status, container.State)
 		}
 	}
 
-	return true, nil
-}
-
-func checkImages(man manifest.Manifest, exist bool) (bool, error) {
-
-	for _, module := range man.Modules {
-		_, _, err := dockerCli.ImageInspectWithRaw(ctx, module.ImageNameFull)
-		if err != nil {
-			if client.IsErrNotFound(err) {
-				if exist {
-					return false, nil
-				}
-			} else {
-				return false, err
-			}
-		} else if !exist {
-			return false, err
-		}
-	}
-
-	return true, nil
+	return nil
 }
@@ -0,0 +1,63 @@
+package handler
+
+import (
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/weeveiot/weeve-agent/internal/edgeapp"
+	"github.com/weeveiot/weeve-agent/internal/manifest"
+)
+
+// ReconcileEdgeApps converges the runtime to the known manifests and reports the changes in the agent logs.
+// Every edge app is reconciled in its command queue, so that it doesn't interfere with the commands for it.
+func ReconcileEdgeApps() error {
+	log.Debug("Reconciling edge apps...")
+
+	uniqueIDs, err := edgeapp.ReadRuntimeEdgeAppIDs()
+	if err != nil {
+		return err
+	}
+	for uniqueID := range manifest.GetKnownManifests() {
+		uniqueIDs = append(uniqueIDs, uniqueID)
+	}
+
+	var mutex sync.Mutex
+	var wg sync.WaitGroup
+	reconciled := make(map[string]bool)
+	changed := false
+	for _, uniqueID := range uniqueIDs {
+		if reconciled[uniqueID.ID] {
+			continue
+		}
+		reconciled[uniqueID.ID] = true
+
+		uniqueID := uniqueID
+		wg.Add(1)
+		orchestrationQueue.Enqueue(uniqueID, func() {
+			defer wg.Done()
+
+			changes, err := edgeapp.ReconcileEdgeApp(uniqueID)
+			for _, change := range changes {
+				log.Info("Reconciliation: ", change)
+			}
+			if err != nil {
+				log.Errorln("Reconciliation of edge app", uniqueID, "failed! CAUSE --> ", err)
+			}
+
+			mutex.Lock()
+			changed = changed || len(changes) > 0
+			mutex.Unlock()
+		})
+	}
+	wg.Wait()
+
+	if changed {
+		err = edgeapp.SendStatus()
+		if err != nil {
+			log.Error("SendStatus failed! CAUSE --> ", err)
+		}
+	}
+
+	return nil
+}
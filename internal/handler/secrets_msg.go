@@ -13,5 +13,14 @@ var OrgPrivKeyHandler mqtt.MessageHandler = func(client mqtt.Client, msg mqtt.Me
 	err := secret.ProcessOrgPrivKeyMessage(msg.Payload())
 	if err != nil {
 		log.Error("Failed to process organization private key message! CAUSE --> ", err)
+		return
 	}
+
+	// the missing containers with secrets can only be recreated now, without waiting for the next reconciliation
+	go func() {
+		err := ReconcileEdgeApps()
+		if err != nil {
+			log.Error("Reconciliation of edge apps failed! CAUSE --> ", err)
+		}
+	}()
 }
@@ -0,0 +1,194 @@
+package manifest
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// inputModuleType is the type of the modules that bring the data into the edge app
+const inputModuleType = "input"
+
+// connectionProblems checks the graph of the connections between the modules. Connections to modules that don't exist,
+// modules connected to themselves and cycles (unless allowed) are problems. Modules that no data reaches are warnings.
+func connectionProblems(connections connectionsString, modules []moduleMsg, allowCycles bool) (problems []Problem, warnings []Problem) {
+	moduleIndex := func(path string, value string) (int, bool) {
+		index, err := strconv.Atoi(value)
+		if err != nil {
+			problems = append(problems, Problem{Path: path, Message: fmt.Sprintf("%q is not a module index", value)})
+			return 0, false
+		}
+		if index < 0 || index >= len(modules) {
+			problems = append(problems, Problem{Path: path, Message: fmt.Sprintf("module %d doesn't exist, there are %d modules", index, len(modules))})
+			return 0, false
+		}
+		return index, true
+	}
+
+	keys := make([]string, 0, len(connections))
+	for key := range connections {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	graph := make(map[int][]int)
+	for _, key := range keys {
+		path := "connections." + key
+		start, startOK := moduleIndex(path, key)
+		for i, value := range connections[key] {
+			endPath := fmt.Sprintf("%s[%d]", path, i)
+			end, endOK := moduleIndex(endPath, value)
+			if !startOK || !endOK {
+				continue
+			}
+			if start == end {
+				problems = append(problems, Problem{Path: endPath, Message: fmt.Sprintf("module %d is connected to itself", start)})
+				continue
+			}
+			graph[start] = append(graph[start], end)
+		}
+	}
+	if len(problems) > 0 {
+		return problems, nil
+	}
+
+	if !allowCycles {
+		if cycle := findCycle(graph, len(modules)); cycle != nil {
+			problems = append(problems, Problem{Path: "connections", Message: "the modules form a cycle " + formatPath(cycle) + ", set allowCycles to deploy it anyway"})
+		}
+	}
+
+	for _, index := range unreachableModules(graph, modules) {
+		warnings = append(warnings, Problem{Path: fmt.Sprintf("modules[%d]", index), Message: fmt.Sprintf("module %s doesn't receive data from any input module", modules[index].ModuleName)})
+	}
+
+	return problems, warnings
+}
+
+// findCycle returns the modules of a cycle, starting and ending with the same module, or nil if there is none
+func findCycle(graph map[int][]int, moduleCount int) []int {
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	state := make([]int, moduleCount)
+	var path []int
+
+	var visit func(node int) []int
+	visit = func(node int) []int {
+		state[node] = visiting
+		path = append(path, node)
+		for _, next := range graph[node] {
+			switch state[next] {
+			case visiting:
+				for i, n := range path {
+					if n == next {
+						return append(append([]int{}, path[i:]...), next)
+					}
+				}
+			case unvisited:
+				if cycle := visit(next); cycle != nil {
+					return cycle
+				}
+			}
+		}
+		path = path[:len(path)-1]
+		state[node] = visited
+		return nil
+	}
+
+	for node := 0; node < moduleCount; node++ {
+		if state[node] == unvisited {
+			if cycle := visit(node); cycle != nil {
+				return cycle
+			}
+		}
+	}
+	return nil
+}
+
+// unreachableModules returns the modules that can't be reached from the input modules.
+// Without input modules, the modules without incoming connections are where the data comes from.
+func unreachableModules(graph map[int][]int, modules []moduleMsg) []int {
+	var queue []int
+	for i, module := range modules {
+		if strings.EqualFold(module.Type, inputModuleType) {
+			queue = append(queue, i)
+		}
+	}
+	if len(queue) == 0 {
+		incoming := make(map[int]bool)
+		for _, ends := range graph {
+			for _, end := range ends {
+				incoming[end] = true
+			}
+		}
+		for i := range modules {
+			if !incoming[i] {
+				queue = append(queue, i)
+			}
+		}
+	}
+
+	reached := make(map[int]bool)
+	for _, node := range queue {
+		reached[node] = true
+	}
+	for len(queue) > 0 {
+		node := queue[0]
+		queue = queue[1:]
+		for _, next := range graph[node] {
+			if !reached[next] {
+				reached[next] = true
+				queue = append(queue, next)
+			}
+		}
+	}
+
+	var unreachable []int
+	for i := range modules {
+		if !reached[i] {
+			unreachable = append(unreachable, i)
+		}
+	}
+	return unreachable
+}
+
+func formatPath(path []int) string {
+	var nodes []string
+	for _, node := range path {
+		nodes = append(nodes, strconv.Itoa(node))
+	}
+	return strings.Join(nodes, " -> ")
+}
+
+// parseConnections converts the checked connections to module indices
+func parseConnections(man manifestMsg) (connectionsInt, error) {
+	log.Debug("Parsing modules' connections")
+
+	problems, warnings := connectionProblems(man.Connections, man.Modules, man.AllowCycles)
+	if len(problems) > 0 {
+		return nil, &ValidationError{Problems: problems}
+	}
+	for _, warning := range warnings {
+		log.Warn("Manifest ", man.ID, " | ", warning.Path, ": ", warning.Message)
+	}
+
+	connectionsIntMap := make(connectionsInt)
+	for key, values := range man.Connections {
+		// the indices are checked already
+		keyInt, _ := strconv.Atoi(key)
+		var valuesInt []int
+		for _, value := range values {
+			valueInt, _ := strconv.Atoi(value)
+			valuesInt = append(valuesInt, valueInt)
+		}
+		connectionsIntMap[keyInt] = valuesInt
+	}
+
+	return connectionsIntMap, nil
+}
@@ -0,0 +1,295 @@
+package manifest
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	traceutility "github.com/weeveiot/weeve-agent/internal/utility/trace"
+)
+
+// The types of log parsers
+const (
+	LogParserJSON   = "json"
+	LogParserLogfmt = "logfmt"
+	LogParserRegex  = "regex"
+)
+
+// The fields of log messages, as named groups of the regex parsers
+const (
+	LogFieldTime     = "time"
+	LogFieldLevel    = "level"
+	LogFieldFilename = "filename"
+	LogFieldMessage  = "message"
+)
+
+// defaultSamplingInterval is the interval of the sampling of repetitive messages, unless the manifest sets another
+const defaultSamplingInterval = time.Minute
+
+// logLevels orders the levels of log messages by severity
+var logLevels = map[string]int{
+	"TRACE":    0,
+	"DEBUG":    1,
+	"INFO":     2,
+	"NOTICE":   2,
+	"WARN":     3,
+	"WARNING":  3,
+	"ERROR":    4,
+	"CRITICAL": 5,
+	"FATAL":    5,
+	"PANIC":    5,
+}
+
+// LogLevelSeverity returns the severity of a log level, higher for more severe levels, or false if the level isn't known
+func LogLevelSeverity(level string) (int, bool) {
+	severity, known := logLevels[strings.ToUpper(level)]
+	return severity, known
+}
+
+// LogSettings tells how the output of a module is turned into log messages and which of them are sent
+type LogSettings struct {
+	Parsers   []LogParser
+	Multiline *Multiline
+	Limits    LogLimits
+}
+
+// LogLimits keeps chatty modules from flooding the logs
+type LogLimits struct {
+	Level     string  // the minimum level of the messages that are sent, all are sent if empty
+	RateLimit float64 // messages per second, unlimited if zero
+	Burst     int     // messages that may exceed the rate limit at once
+	Sampling  LogSampling
+}
+
+// LogSampling drops repetitive messages. Of the same messages within the interval, the first are sent and then every
+// thereafter-th. It is off if First is zero.
+type LogSampling struct {
+	Interval   time.Duration
+	First      int
+	Thereafter int
+}
+
+// LogParser extracts the fields of log messages from lines of one format
+type LogParser struct {
+	Type          string
+	TimeField     string
+	LevelField    string
+	FilenameField string
+	MessageField  string
+	TimeFormat    string
+	Pattern       string
+}
+
+// Multiline joins the lines of messages that span several lines
+type Multiline struct {
+	Start    string
+	MaxLines int
+}
+
+// checkLogParser returns the field of the parser that is invalid
+func checkLogParser(parser logParserMsg) (string, error) {
+	// a missing pattern is a validation problem already
+	if parser.Type != LogParserRegex || parser.Pattern == "" {
+		return "", nil
+	}
+
+	pattern, err := regexp.Compile(parser.Pattern)
+	if err != nil {
+		return "pattern", err
+	}
+	for _, name := range pattern.SubexpNames() {
+		switch name {
+		case LogFieldTime, LogFieldLevel, LogFieldFilename, LogFieldMessage:
+			return "", nil
+		}
+	}
+	return "pattern", fmt.Errorf("has none of the named groups %s, %s, %s and %s", LogFieldTime, LogFieldLevel, LogFieldFilename, LogFieldMessage)
+}
+
+func checkLogLevel(level string) (string, error) {
+	if _, known := LogLevelSeverity(level); level != "" && !known {
+		return "level", fmt.Errorf("%q is not a log level", level)
+	}
+	return "", nil
+}
+
+func checkMultiline(multiline multilineMsg) (string, error) {
+	_, err := regexp.Compile(multiline.Start)
+	if err != nil {
+		return "start", err
+	}
+	return "", nil
+}
+
+// parseLogs returns the log settings of a module, whose fields default to the settings of the edge app
+func parseLogs(app *logsMsg, module *logsMsg) (*LogSettings, error) {
+	if app == nil && module == nil {
+		return nil, nil
+	}
+	log.Debug("Parsing log settings")
+
+	logs := mergeLogs(app, module)
+	settings := &LogSettings{}
+	for _, parser := range logs.Parsers {
+		err := validate.Struct(parser)
+		if err != nil {
+			return nil, err
+		}
+		if field, err := checkLogParser(parser); err != nil {
+			return nil, fmt.Errorf("invalid %s of %s log parser: %w", field, parser.Type, err)
+		}
+		settings.Parsers = append(settings.Parsers, LogParser(parser))
+	}
+
+	if logs.Multiline != nil {
+		if field, err := checkMultiline(*logs.Multiline); err != nil {
+			return nil, fmt.Errorf("invalid %s of multiline: %w", field, err)
+		}
+		multiline := Multiline(*logs.Multiline)
+		settings.Multiline = &multiline
+	}
+
+	var err error
+	settings.Limits, err = parseLogLimits(logs)
+	if err != nil {
+		return nil, err
+	}
+	return settings, nil
+}
+
+// mergeLogs sets the fields that the module doesn't set to those of the edge app
+func mergeLogs(app *logsMsg, module *logsMsg) logsMsg {
+	if app == nil {
+		return *module
+	}
+	if module == nil {
+		return *app
+	}
+
+	merged := *module
+	if merged.Parsers == nil {
+		merged.Parsers = app.Parsers
+	}
+	if merged.Multiline == nil {
+		merged.Multiline = app.Multiline
+	}
+	if merged.Level == "" {
+		merged.Level = app.Level
+	}
+	if merged.RateLimit == 0 {
+		merged.RateLimit = app.RateLimit
+	}
+	if merged.Burst == 0 {
+		merged.Burst = app.Burst
+	}
+	if merged.Sampling == nil {
+		merged.Sampling = app.Sampling
+	}
+	return merged
+}
+
+func parseLogLimits(logs logsMsg) (LogLimits, error) {
+	if field, err := checkLogLevel(logs.Level); err != nil {
+		return LogLimits{}, fmt.Errorf("invalid %s: %w", field, err)
+	}
+
+	limits := LogLimits{
+		Level:     strings.ToUpper(logs.Level),
+		RateLimit: logs.RateLimit,
+		Burst:     logs.Burst,
+	}
+	if logs.Sampling != nil {
+		err := validate.Struct(logs.Sampling)
+		if err != nil {
+			return LogLimits{}, err
+		}
+		limits.Sampling = LogSampling{
+			Interval:   time.Duration(logs.Sampling.Interval) * time.Second,
+			First:      logs.Sampling.First,
+			Thereafter: logs.Sampling.Thereafter,
+		}
+		if limits.Sampling.Interval == 0 {
+			limits.Sampling.Interval = defaultSamplingInterval
+		}
+	}
+	return limits, nil
+}
+
+// logLimitsMsg changes the log limits of an edge app that is deployed already
+type logLimitsMsg struct {
+	ID      string              `json:"_id" validate:"required,notblank,alphanum"`
+	Logs    *logsMsg            // the limits of all modules
+	Modules map[string]*logsMsg // the limits of single modules, by their index
+}
+
+// ParseLogLimits returns the limits of the modules of an edge app with moduleCount modules from a LOGS command. The
+// limits of the command replace those of the manifest entirely, without limits in the command all messages are sent.
+func ParseLogLimits(payload []byte, moduleCount int) ([]LogLimits, error) {
+	var msg logLimitsMsg
+	err := json.Unmarshal(payload, &msg)
+	if err != nil {
+		return nil, &ValidationError{Problems: []Problem{jsonProblem(err)}}
+	}
+
+	problems := structProblems("", msg)
+	problems = append(problems, logLimitsProblems("logs", msg.Logs)...)
+	keys := make([]string, 0, len(msg.Modules))
+	for key := range msg.Modules {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	moduleLogs := make(map[int]*logsMsg)
+	for _, key := range keys {
+		path := "modules." + key
+		index, err := strconv.Atoi(key)
+		if err != nil || index < 0 || index >= moduleCount {
+			problems = append(problems, Problem{Path: path, Message: fmt.Sprintf("%q is not the index of one of the %d modules", key, moduleCount)})
+			continue
+		}
+		if msg.Modules[key] != nil {
+			problems = append(problems, structProblems(path, *msg.Modules[key])...)
+			problems = append(problems, logLimitsProblems(path, msg.Modules[key])...)
+		}
+		moduleLogs[index] = msg.Modules[key]
+	}
+	if len(problems) > 0 {
+		return nil, &ValidationError{Problems: problems}
+	}
+
+	limits := make([]LogLimits, moduleCount)
+	for i := range limits {
+		if msg.Logs == nil && moduleLogs[i] == nil {
+			continue
+		}
+		limits[i], err = parseLogLimits(mergeLogs(msg.Logs, moduleLogs[i]))
+		if err != nil {
+			return nil, traceutility.Wrap(err)
+		}
+	}
+	return limits, nil
+}
+
+// logLimitsProblems checks the limits of a LOGS command, which can't change how the output is parsed
+func logLimitsProblems(path string, logs *logsMsg) []Problem {
+	if logs == nil {
+		return nil
+	}
+
+	var problems []Problem
+	if field, err := checkLogLevel(logs.Level); err != nil {
+		problems = append(problems, Problem{Path: path + "." + field, Message: firstLine(err)})
+	}
+	if logs.Parsers != nil {
+		problems = append(problems, Problem{Path: path + ".parsers", Message: "can only be changed by deploying the edge app"})
+	}
+	if logs.Multiline != nil {
+		problems = append(problems, Problem{Path: path + ".multiline", Message: "can only be changed by deploying the edge app"})
+	}
+	return problems
+}
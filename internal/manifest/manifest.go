@@ -4,7 +4,6 @@ import (
 	"encoding/json"
 	"fmt"
 	"regexp"
-	"strconv"
 	"strings"
 	"time"
 
@@ -38,6 +37,7 @@ type ContainerConfig struct {
 	ContainerName string
 	ImageNameFull string
 	EnvArgs       []string
+	SecretEnvs    map[string]string // the encrypted values of the secret env variables, by key
 	NetworkName   string
 	ExposedPorts  nat.PortSet // This must be set for the container create
 	PortBinding   nat.PortMap // This must be set for the containerStart
@@ -45,17 +45,36 @@ type ContainerConfig struct {
 	MountConfigs  []mount.Mount
 	Labels        map[string]string
 	AuthConfig    types.AuthConfig
+	SecretAuth    string // the registry password encrypted like the secret env variables, as it isn't stored in plain
 	Resources     container.Resources
+	Healthcheck   *container.HealthConfig
+	Logs          *LogSettings // how the output is turned into log messages, the default if nil
 }
 
+const (
+	ingressPort = 80
+	ingressPath = "/"
+)
+
+const redacted = "REDACTED"
+
 type connectionsInt map[int][]int
 type connectionsString map[string][]string
 
 var validate *validator.Validate
 
+// httpPathRegexp allows the URL paths that can be pasted into the shell command of the http healthcheck as they are,
+// i.e. without whitespace, quotes or other characters that mean something to the shell
+var httpPathRegexp = regexp.MustCompile(`^/[A-Za-z0-9/._~%:@+=,-]*$`)
+
 func init() {
 	validate = validator.New()
 	validate.RegisterValidation("notblank", validators.NotBlank)
+	validate.RegisterValidation("httppath", httpPath)
+}
+
+func httpPath(fl validator.FieldLevel) bool {
+	return httpPathRegexp.MatchString(fl.Field().String())
 }
 
 func Parse(payload []byte) (Manifest, error) {
@@ -72,6 +91,19 @@ func Parse(payload []byte) (Manifest, error) {
 		return Manifest{}, traceutility.Wrap(err)
 	}
 
+	for _, module := range man.Modules {
+		err = validate.Struct(module)
+		if err != nil {
+			return Manifest{}, traceutility.Wrap(err)
+		}
+	}
+
+	return buildManifest(man, parseArguments)
+}
+
+// buildManifest turns the validated manifest message into the configs of the containers.
+// parseEnvs decides what happens to the values of secret env variables.
+func buildManifest(man manifestMsg, parseEnvs func([]envMsg) ([]string, error)) (Manifest, error) {
 	updatedAt, err := time.Parse(time.RFC3339, man.UpdatedAt)
 	if err != nil {
 		return Manifest{}, traceutility.Wrap(err)
@@ -86,11 +118,6 @@ func Parse(payload []byte) (Manifest, error) {
 	var containerConfigs []ContainerConfig
 
 	for _, module := range man.Modules {
-		err = validate.Struct(module)
-		if err != nil {
-			return Manifest{}, traceutility.Wrap(err)
-		}
-
 		var containerConfig ContainerConfig
 
 		containerConfig.Labels = labels
@@ -107,10 +134,11 @@ func Parse(payload []byte) (Manifest, error) {
 			Password:      module.Image.Registry.Password,
 		}
 
-		envArgs, err := parseArguments(module.Envs)
+		envArgs, err := parseEnvs(module.Envs)
 		if err != nil {
 			return Manifest{}, traceutility.Wrap(err)
 		}
+		containerConfig.SecretEnvs = secretEnvs(module.Envs)
 
 		if man.DebugMode {
 			envArgs = append(envArgs, fmt.Sprintf("%v=%v", "LOG_LEVEL", "DEBUG"))
@@ -120,14 +148,14 @@ func Parse(payload []byte) (Manifest, error) {
 
 		envArgs = append(envArgs, fmt.Sprintf("%v=%v", "MANIFEST_ID", man.ID))
 		envArgs = append(envArgs, fmt.Sprintf("%v=%v", "MODULE_NAME", containerConfig.ImageNameFull))
-		envArgs = append(envArgs, fmt.Sprintf("%v=%v", "INGRESS_PORT", 80))
-		envArgs = append(envArgs, fmt.Sprintf("%v=%v", "INGRESS_PATH", "/"))
+		envArgs = append(envArgs, fmt.Sprintf("%v=%v", "INGRESS_PORT", ingressPort))
+		envArgs = append(envArgs, fmt.Sprintf("%v=%v", "INGRESS_PATH", ingressPath))
 		envArgs = append(envArgs, fmt.Sprintf("%v=%v", "MODULE_TYPE", module.Type))
 		envArgs = append(envArgs, fmt.Sprintf("%v=%v", "NODE_ID", config.Params.NodeId))
 		envArgs = append(envArgs, fmt.Sprintf("%v=%v", "NODE_NAME", config.Params.NodeName))
 
 		containerConfig.EnvArgs = envArgs
-		containerConfig.MountConfigs, err = parseMounts(module.Mounts)
+		containerConfig.MountConfigs, err = parseMounts(module.Mounts, uniqueID)
 		if err != nil {
 			return Manifest{}, traceutility.Wrap(err)
 		}
@@ -136,13 +164,21 @@ func Parse(payload []byte) (Manifest, error) {
 		if err != nil {
 			return Manifest{}, traceutility.Wrap(err)
 		}
-		containerConfig.Resources = container.Resources{Devices: devices}
+		containerConfig.Resources, err = parseResources(module.Resources, devices)
+		if err != nil {
+			return Manifest{}, traceutility.Wrap(fmt.Errorf("invalid resources of module %s: %w", module.ModuleName, err))
+		}
 
 		containerConfig.ExposedPorts, containerConfig.PortBinding = parsePorts(module.Ports)
+		containerConfig.Healthcheck = parseHealthcheck(module.Healthcheck)
+		containerConfig.Logs, err = parseLogs(man.Logs, module.Logs)
+		if err != nil {
+			return Manifest{}, traceutility.Wrap(fmt.Errorf("invalid logs of module %s: %w", module.ModuleName, err))
+		}
 		containerConfigs = append(containerConfigs, containerConfig)
 	}
 
-	connections, err := parseConnections(man.Connections)
+	connections, err := parseConnections(man)
 	if err != nil {
 		return Manifest{}, traceutility.Wrap(err)
 	}
@@ -175,6 +211,17 @@ func GetCommand(payload []byte) (string, error) {
 	return msg.Command, nil
 }
 
+// GetCorrelationID returns the optional ID the manager sent the command with to match the acknowledgement and result
+func GetCorrelationID(payload []byte) (string, error) {
+	var msg commandMsg
+	err := json.Unmarshal(payload, &msg)
+	if err != nil {
+		return "", traceutility.Wrap(err)
+	}
+
+	return msg.CorrelationID, nil
+}
+
 func GetEdgeAppUniqueID(payload []byte) (model.ManifestUniqueID, error) {
 	var uniqueID uniqueIDmsg
 	err := json.Unmarshal(payload, &uniqueID)
@@ -199,9 +246,18 @@ func (m Manifest) UpdateManifest(networkName string) {
 	}
 
 	for start, ends := range m.Connections {
+		// the connections are checked when parsing, but manifests stored by older versions weren't
+		if start < 0 || start >= len(m.Modules) {
+			log.Error("Ignoring the connections of module ", start, ", the edge app has ", len(m.Modules), " modules")
+			continue
+		}
 		var endpointStrings []string
 		for _, end := range ends {
-			endpointStrings = append(endpointStrings, fmt.Sprintf("http://%v:80/", m.Modules[end].ContainerName))
+			if end < 0 || end >= len(m.Modules) {
+				log.Error("Ignoring the connection of module ", start, " to module ", end, ", the edge app has ", len(m.Modules), " modules")
+				continue
+			}
+			endpointStrings = append(endpointStrings, fmt.Sprintf("http://%v:%v%v", m.Modules[end].ContainerName, ingressPort, ingressPath))
 		}
 		m.Modules[start].EnvArgs = append(m.Modules[start].EnvArgs, fmt.Sprintf("%v=%v", "EGRESS_URLS", strings.Join(endpointStrings, ",")))
 	}
@@ -245,20 +301,31 @@ func parseArguments(options []envMsg) ([]string, error) {
 	return args, nil
 }
 
-func parseMounts(mnts []mountMsg) ([]mount.Mount, error) {
+func parseMounts(mnts []mountMsg, manifestUniqueID model.ManifestUniqueID) ([]mount.Mount, error) {
 	log.Debug("Parsing mount points")
 
 	mounts := []mount.Mount{}
 
 	for _, mnt := range mnts {
-		mount := mount.Mount{
-			Type:        "bind",
-			Source:      mnt.Host,
-			Target:      mnt.Container,
-			ReadOnly:    false,
-			Consistency: "default",
-			BindOptions: &mount.BindOptions{Propagation: "rprivate", NonRecursive: true},
+		err := validate.Struct(mnt)
+		if err != nil {
+			return nil, traceutility.Wrap(err)
+		}
+		field, err := checkMount(mnt)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s of mount %s: %w", field, mnt.Container, err)
+		}
+
+		var mount mount.Mount
+		switch mnt.Type {
+		case mountVolume:
+			mount = volumeMount(mnt, manifestUniqueID)
+		case mountTmpfs:
+			mount = tmpfsMount(mnt)
+		default:
+			mount = bindMount(mnt)
 		}
+		mount.ReadOnly = mnt.ReadOnly
 
 		mounts = append(mounts, mount)
 	}
@@ -299,38 +366,133 @@ func parsePorts(ports []portMsg) (nat.PortSet, nat.PortMap) {
 	return exposedPorts, portBinding
 }
 
-func parseConnections(connectionsStringMap connectionsString) (connectionsInt, error) {
-	log.Debug("Parsing modules' connections")
+func parseHealthcheck(healthcheck *healthcheckMsg) *container.HealthConfig {
+	if healthcheck == nil {
+		return nil
+	}
+	log.Debug("Parsing healthcheck")
+
+	port := healthcheck.Port
+	if port == 0 {
+		port = ingressPort
+	}
+	path := healthcheck.Path
+	if path == "" {
+		path = ingressPath
+	}
 
-	connectionsIntMap := make(connectionsInt)
+	// the checks run inside the container, so they rely on the tools the image ships with
+	var test []string
+	switch healthcheck.Type {
+	case "command":
+		test = append([]string{"CMD"}, healthcheck.Command...)
+	case "http":
+		url := fmt.Sprintf("http://localhost:%d%s", port, path)
+		test = []string{"CMD-SHELL", fmt.Sprintf("wget -q -O /dev/null %s || curl -fsS -o /dev/null %s || exit 1", url, url)}
+	case "tcp":
+		test = []string{"CMD-SHELL", fmt.Sprintf("nc -z localhost %d || exit 1", port)}
+	}
 
-	for key, values := range connectionsStringMap {
-		var valuesInt []int
-		for _, value := range values {
-			valueInt, err := strconv.Atoi(value)
-			if err != nil {
-				return nil, traceutility.Wrap(err)
-			}
-			valuesInt = append(valuesInt, valueInt)
-		}
-		keyInt, err := strconv.Atoi(key)
-		if err != nil {
-			return nil, traceutility.Wrap(err)
-		}
-		connectionsIntMap[keyInt] = valuesInt
+	return &container.HealthConfig{
+		Test:        test,
+		Interval:    time.Duration(healthcheck.Interval) * time.Second,
+		Timeout:     time.Duration(healthcheck.Timeout) * time.Second,
+		StartPeriod: time.Duration(healthcheck.StartPeriod) * time.Second,
+		Retries:     healthcheck.Retries,
 	}
+}
 
-	return connectionsIntMap, nil
+// secretEnvs keeps the secret env variables encrypted, as they were received
+func secretEnvs(options []envMsg) map[string]string {
+	secrets := make(map[string]string)
+	for _, env := range options {
+		if env.Secret {
+			secrets[env.Key] = env.Value
+		}
+	}
+	return secrets
 }
 
 func clearSecretValues(man Manifest) Manifest {
-	// perform a deep copy, while removing env variables and passwords
+	// perform a deep copy, while removing decrypted env variables and passwords
 	manCopy := man
 	manCopy.Modules = make([]ContainerConfig, len(man.Modules))
 	copy(manCopy.Modules, man.Modules)
-	for i := range manCopy.Modules {
-		manCopy.Modules[i].EnvArgs = nil
+	for i, module := range manCopy.Modules {
+		var envArgs []string
+		for _, envArg := range module.EnvArgs {
+			key, _, _ := strings.Cut(envArg, "=")
+			if _, secret := module.SecretEnvs[key]; !secret {
+				envArgs = append(envArgs, envArg)
+			}
+		}
+		manCopy.Modules[i].EnvArgs = envArgs
 		manCopy.Modules[i].AuthConfig.Password = ""
 	}
 	return manCopy
 }
+
+// storableManifest returns a copy of the manifest without the secret values, with the registry passwords encrypted
+func storableManifest(man Manifest) Manifest {
+	manCopy := clearSecretValues(man)
+	for i, module := range man.Modules {
+		if module.AuthConfig.Password == "" {
+			continue
+		}
+		encrypted, err := secret.EncryptSecret(module.AuthConfig.Password)
+		if err != nil {
+			log.Warning("Failed to encrypt the registry password of ", module.ImageNameFull, ", the image can't be pulled again! CAUSE --> ", err)
+			manCopy.Modules[i].SecretAuth = ""
+			continue
+		}
+		manCopy.Modules[i].SecretAuth = encrypted
+	}
+	return manCopy
+}
+
+// Redact returns a copy of the manifest that is safe to show, the keys of the secret env variables are kept
+func Redact(man Manifest) Manifest {
+	manCopy := clearSecretValues(man)
+	for i, module := range manCopy.Modules {
+		if module.SecretAuth != "" {
+			manCopy.Modules[i].SecretAuth = redacted
+		}
+		secretEnvs := make(map[string]string, len(module.SecretEnvs))
+		for key := range module.SecretEnvs {
+			secretEnvs[key] = redacted
+		}
+		manCopy.Modules[i].SecretEnvs = secretEnvs
+		manCopy.Modules[i].AuthConfig.IdentityToken = ""
+		manCopy.Modules[i].AuthConfig.RegistryToken = ""
+	}
+	return manCopy
+}
+
+// HasSecrets tells whether the container config has secret values that RestoreSecretValues needs the org's key for
+func (c ContainerConfig) HasSecrets() bool {
+	return c.SecretAuth != "" || len(c.SecretEnvs) > 0
+}
+
+// RestoreSecretValues decrypts the secret env variables and the registry password of a container config that was
+// stored without them
+func RestoreSecretValues(containerConfig ContainerConfig) (ContainerConfig, error) {
+	if containerConfig.SecretAuth != "" {
+		password, err := secret.DecryptEnv(containerConfig.SecretAuth)
+		if err != nil {
+			return containerConfig, traceutility.Wrap(err)
+		}
+		containerConfig.AuthConfig.Password = password
+	}
+
+	envArgs := append([]string{}, containerConfig.EnvArgs...)
+	for key, value := range containerConfig.SecretEnvs {
+		decrypted, err := secret.DecryptEnv(value)
+		if err != nil {
+			return containerConfig, traceutility.Wrap(err)
+		}
+		envArgs = append(envArgs, fmt.Sprintf("%v=%v", key, decrypted))
+	}
+	containerConfig.EnvArgs = envArgs
+
+	return containerConfig, nil
+}
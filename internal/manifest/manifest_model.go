@@ -9,22 +9,27 @@ type manifestMsg struct {
 	Modules       []moduleMsg       `validate:"required,notblank"`
 	Command       string            `validate:"required,notblank"`
 	DebugMode     bool
+	AllowCycles   bool     // the modules may send data in a circle
+	Logs          *logsMsg // the defaults of the modules
 }
 
 type moduleMsg struct {
-	ModuleName string   `validate:"required,notblank"`
-	Image      imageMsg `validate:"required"`
-	Envs       []envMsg
-	Ports      []portMsg
-	Mounts     []mountMsg
-	Devices    []deviceMsg
-	Type       string `validate:"required,notblank"`
+	ModuleName  string   `validate:"required,notblank"`
+	Image       imageMsg `validate:"required"`
+	Envs        []envMsg
+	Ports       []portMsg
+	Mounts      []mountMsg
+	Devices     []deviceMsg
+	Healthcheck *healthcheckMsg
+	Resources   *resourcesMsg
+	Logs        *logsMsg
+	Type        string `validate:"required,notblank"`
 }
 
 type envMsg struct {
 	Key    string `validate:"required,notblank"`
 	Value  string `validate:"required"`
-	Secret bool   `validate:"required"`
+	Secret bool
 }
 
 type portMsg struct {
@@ -32,9 +37,15 @@ type portMsg struct {
 	Host      string `validate:"required,notblank"`
 }
 
+// mountMsg mounts a host path (bind, the default), a named volume of the edge app that is kept across updates or a
+// tmpfs into the container
 type mountMsg struct {
+	Type      string `validate:"omitempty,oneof=bind volume tmpfs"`
 	Container string `validate:"required,notblank"`
-	Host      string `validate:"required,notblank"`
+	Host      string // for bind mounts
+	Volume    string // for volumes, modules of the edge app that use the same name share the volume
+	Size      string // for tmpfs, like "64m"
+	ReadOnly  bool
 }
 
 type deviceMsg struct {
@@ -42,6 +53,67 @@ type deviceMsg struct {
 	Host      string `validate:"required,notblank"`
 }
 
+// healthcheckMsg describes how the health of a module is checked from inside its container.
+// The durations are in seconds, zero keeps the default of the container engine.
+type healthcheckMsg struct {
+	Type        string   `validate:"required,oneof=command http tcp"`
+	Command     []string `validate:"required_if=Type command"`
+	Path        string   `validate:"omitempty,httppath"`        // for http, defaults to the ingress path
+	Port        int      `validate:"omitempty,min=1,max=65535"` // for http and tcp, defaults to the ingress port
+	Interval    int      `validate:"min=0"`
+	Timeout     int      `validate:"min=0"`
+	StartPeriod int      `validate:"min=0"`
+	Retries     int      `validate:"min=0"`
+}
+
+// resourcesMsg limits what a module can use of the node. The memory sizes are given like "512m" or "1g".
+type resourcesMsg struct {
+	Cpus              float64 `validate:"min=0"` // CPU quota in CPUs, e.g. 0.5
+	CpuShares         int64   `validate:"omitempty,min=2,max=262144"`
+	CpusetCpus        string  // CPUs the module may run on, e.g. "0-2,4"
+	Memory            string
+	MemoryReservation string
+	MemorySwap        string // memory plus swap, "-1" for unlimited swap
+	PidsLimit         int64  `validate:"min=0"`
+}
+
+// logsMsg tells how the output of a module is turned into log messages and which of them are sent. The parsers are
+// tried in order on every line and the first one that understands the line wins.
+type logsMsg struct {
+	Parsers   []logParserMsg
+	Multiline *multilineMsg
+	// the limits keep chatty modules from flooding the logs
+	Level     string  // the minimum level of the messages that are sent
+	RateLimit float64 `validate:"min=0"` // messages per second, unlimited if zero
+	Burst     int     `validate:"min=0"` // messages that may exceed the rate limit at once
+	Sampling  *samplingMsg
+}
+
+// samplingMsg drops repetitive messages: of the same messages within the interval, the first are sent and then every
+// thereafter-th
+type samplingMsg struct {
+	Interval   int `validate:"min=0"` // seconds
+	First      int `validate:"required,min=1"`
+	Thereafter int `validate:"min=0"`
+}
+
+type logParserMsg struct {
+	Type string `validate:"required,oneof=json logfmt regex"`
+	// the fields of json and logfmt lines, dots separate the keys of nested json objects
+	TimeField     string
+	LevelField    string
+	FilenameField string
+	MessageField  string
+	TimeFormat    string // a Go time layout, "rfc3339", "unix" or "unixms", various formats are tried if empty
+	Pattern       string `validate:"required_if=Type regex"` // for regex, with the named groups time, level, filename and message
+}
+
+// multilineMsg joins the lines of a message that spans several lines, like a stack trace
+type multilineMsg struct {
+	Start    string // a regex matching the first line of a message, the lines no parser understands continue a message if empty
+	MaxLines int    `validate:"min=0"`
+}
+
 type imageMsg struct {
 	Name     string `validate:"required,notblank"`
 	Tag      string
@@ -62,5 +134,6 @@ type uniqueIDmsg struct {
 }
 
 type commandMsg struct {
-	Command string `validate:"required,notblank"`
+	Command       string `validate:"required,notblank"`
+	CorrelationID string
 }
@@ -3,15 +3,20 @@ package manifest_test
 import (
 	"encoding/json"
 	"os"
+	"strconv"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/api/types/container"
 	"github.com/docker/docker/api/types/mount"
 	"github.com/docker/go-connections/nat"
 	"github.com/stretchr/testify/assert"
 
 	"github.com/weeveiot/weeve-agent/internal/manifest"
+	"github.com/weeveiot/weeve-agent/internal/model"
+	"github.com/weeveiot/weeve-agent/internal/secret"
 )
 
 var manifestUniqueID struct {
@@ -137,6 +142,19 @@ func TestGetCommand(t *testing.T) {
 	}
 }
 
+func TestGetCorrelationID(t *testing.T) {
+	assert := assert.New(t)
+
+	correlationID, err := manifest.GetCorrelationID([]byte(`{"command": "STOP", "correlationID": "c0ffee"}`))
+	assert.Nil(err)
+	assert.Equal("c0ffee", correlationID)
+
+	// the correlation ID is optional
+	correlationID, err = manifest.GetCorrelationID([]byte(`{"command": "STOP"}`))
+	assert.Nil(err)
+	assert.Equal("", correlationID)
+}
+
 // Utility function to run ValidateManifest fail tests
 func utilFailTestValidateManifest(t *testing.T, filePath string, errMsg string) {
 	assert := assert.New(t)
@@ -219,6 +237,315 @@ func TestValidateManifest_EmptyManifestImageName(t *testing.T) {
 	utilFailTestValidateManifest(t, filePath, errMsg)
 }
 
+func TestValidateManifest_InvalidHealthcheckType(t *testing.T) {
+	errMsg := "Key: 'moduleMsg.Healthcheck.Type' Error:Field validation for 'Type' failed on the 'oneof' tag"
+	filePath := "../../testdata/unittests/failInvalidHealthcheckType.json"
+	utilFailTestValidateManifest(t, filePath, errMsg)
+}
+
+func TestValidateManifest_MissingHealthcheckCommand(t *testing.T) {
+	errMsg := "Key: 'moduleMsg.Healthcheck.Command' Error:Field validation for 'Command' failed on the 'required_if' tag"
+	filePath := "../../testdata/unittests/failMissingHealthcheckCommand.json"
+	utilFailTestValidateManifest(t, filePath, errMsg)
+}
+
+func TestValidateManifest_InvalidHealthcheckPath(t *testing.T) {
+	errMsg := "Key: 'moduleMsg.Healthcheck.Path' Error:Field validation for 'Path' failed on the 'httppath' tag"
+	filePath := "../../testdata/unittests/failInvalidHealthcheckPath.json"
+	utilFailTestValidateManifest(t, filePath, errMsg)
+
+	json, err := os.ReadFile(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _, err = manifest.Validate(json)
+	var validationErr *manifest.ValidationError
+	if !assert.ErrorAs(t, err, &validationErr) {
+		return
+	}
+	assert.Equal(t, []manifest.Problem{{
+		Path:    "modules[0].healthcheck.path",
+		Message: "must be a URL path starting with /, without whitespace, quotes or shell special characters",
+	}}, validationErr.Problems)
+}
+
+func TestGetManifest_Healthcheck(t *testing.T) {
+	assert := assert.New(t)
+
+	json, err := os.ReadFile("../../testdata/unittests/healthcheckManifest.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	manifest, err := manifest.Parse(json)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(&container.HealthConfig{
+		Test:     []string{"CMD-SHELL", "nc -z localhost 1883 || exit 1"},
+		Interval: 10 * time.Second,
+		Timeout:  3 * time.Second,
+		Retries:  3,
+	}, manifest.Modules[0].Healthcheck)
+	assert.Equal(&container.HealthConfig{
+		Test:        []string{"CMD-SHELL", "wget -q -O /dev/null http://localhost:80/health || curl -fsS -o /dev/null http://localhost:80/health || exit 1"},
+		StartPeriod: 30 * time.Second,
+	}, manifest.Modules[1].Healthcheck)
+	assert.Equal(&container.HealthConfig{
+		Test: []string{"CMD", "/bin/check", "--quick"},
+	}, manifest.Modules[2].Healthcheck)
+	assert.Nil(manifest.Modules[3].Healthcheck)
+}
+
+func TestValidateManifest_ResourcesExceedHost(t *testing.T) {
+	assert := assert.New(t)
+
+	// the limits are checked against the node on deployment, the manifest itself is valid
+	json, err := os.ReadFile("../../testdata/unittests/failResourcesExceedHost.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	man, err := manifest.Parse(json)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = manifest.CheckHostResources(man.Modules[0].Resources)
+	assert.NotNil(err)
+	if err != nil {
+		assert.Contains(err.Error(), "100000 CPUs requested, but the node has")
+	}
+}
+
+func TestValidateManifest_CPUSetTooLarge(t *testing.T) {
+	errMsg := "invalid resources of module mqtt-ingress: invalid cpuset: 0-2000000000, CPU 2000000000 is beyond the 8192 CPUs Linux supports"
+	filePath := "../../testdata/unittests/failCPUSetTooLarge.json"
+	utilFailTestValidateManifest(t, filePath, errMsg)
+}
+
+func TestValidateManifest_InvalidMemory(t *testing.T) {
+	errMsg := "invalid resources of module mqtt-ingress: invalid size: 'lots'"
+	filePath := "../../testdata/unittests/failInvalidMemory.json"
+	utilFailTestValidateManifest(t, filePath, errMsg)
+}
+
+func TestValidateManifest_MemoryReservation(t *testing.T) {
+	errMsg := "invalid resources of module mqtt-ingress: memory reservation 64m exceeds the memory limit 32m"
+	filePath := "../../testdata/unittests/failMemoryReservation.json"
+	utilFailTestValidateManifest(t, filePath, errMsg)
+}
+
+func TestGetManifest_Resources(t *testing.T) {
+	assert := assert.New(t)
+
+	json, err := os.ReadFile("../../testdata/unittests/resourcesManifest.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	manifest, err := manifest.Parse(json)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pidsLimit := int64(100)
+	assert.Equal(container.Resources{
+		NanoCPUs:          500000000,
+		CPUShares:         512,
+		CpusetCpus:        "0",
+		Memory:            64 * 1024 * 1024,
+		MemoryReservation: 32 * 1024 * 1024,
+		MemorySwap:        128 * 1024 * 1024,
+		PidsLimit:         &pidsLimit,
+		Devices:           manifest.Modules[0].Resources.Devices,
+	}, manifest.Modules[0].Resources)
+	assert.Equal(int64(1024*1024*1024), manifest.Modules[1].Resources.Memory)
+	assert.Equal(int64(-1), manifest.Modules[1].Resources.MemorySwap)
+	assert.Equal(container.Resources{Devices: []container.DeviceMapping{}}, manifest.Modules[2].Resources)
+}
+
+func TestRedact(t *testing.T) {
+	assert := assert.New(t)
+
+	man := manifest.Manifest{Modules: []manifest.ContainerConfig{{
+		EnvArgs:    []string{"LOG_LEVEL=debug", "API_KEY=decrypted"},
+		SecretEnvs: map[string]string{"API_KEY": "encrypted"},
+		AuthConfig: types.AuthConfig{Username: "weeve", Password: "registry password"},
+	}}}
+
+	redacted := manifest.Redact(man)
+	assert.Equal([]string{"LOG_LEVEL=debug"}, redacted.Modules[0].EnvArgs)
+	assert.Equal(map[string]string{"API_KEY": "REDACTED"}, redacted.Modules[0].SecretEnvs)
+	assert.Equal("weeve", redacted.Modules[0].AuthConfig.Username)
+	assert.Empty(redacted.Modules[0].AuthConfig.Password)
+
+	// the manifest itself is left alone
+	assert.Equal("encrypted", man.Modules[0].SecretEnvs["API_KEY"])
+	assert.Equal("registry password", man.Modules[0].AuthConfig.Password)
+}
+
+func TestGetKnownManifest_DeepCopy(t *testing.T) {
+	assert := assert.New(t)
+
+	json, err := os.ReadFile("../../testdata/unittests/healthcheckManifest.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	man, err := manifest.Parse(json)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	workDir, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.Chdir(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(workDir)
+	manifest.AddKnownManifest(man)
+	defer manifest.DeleteKnownManifest(man.UniqueID)
+
+	// changing a copy leaves the known manifest alone
+	record := manifest.GetKnownManifest(man.UniqueID)
+	module := &record.Manifest.Modules[0]
+	module.EnvArgs[0] = "CHANGED=1"
+	module.Labels["changed"] = "true"
+	module.Healthcheck.Test[0] = "NONE"
+	module.MountConfigs[0].Source = "/changed"
+	record.Manifest.Labels["changed"] = "true"
+	record.Manifest.Connections[0][0] = 3
+
+	assert.Equal(man.Modules[0], manifest.GetKnownManifest(man.UniqueID).Manifest.Modules[0])
+	assert.Equal(man.Labels, manifest.GetKnownManifest(man.UniqueID).Manifest.Labels)
+	assert.Equal(man.Connections, manifest.GetKnownManifest(man.UniqueID).Manifest.Connections)
+}
+
+func TestRestoreSecretValues(t *testing.T) {
+	assert := assert.New(t)
+
+	// the known manifests are persisted in the working directory
+	workDir, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.Chdir(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(workDir)
+
+	err = secret.SetOrgKey([]byte("0123456789abcdef0123456789abcdef"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	encryptedKey, err := secret.EncryptSecret("decrypted")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	man := manifest.Manifest{UniqueID: model.ManifestUniqueID{ID: "secrets"}, Modules: []manifest.ContainerConfig{{
+		EnvArgs:    []string{"LOG_LEVEL=debug", "API_KEY=decrypted"},
+		SecretEnvs: map[string]string{"API_KEY": encryptedKey},
+		AuthConfig: types.AuthConfig{Username: "weeve", Password: "registry password"},
+	}}}
+	manifest.AddKnownManifest(man)
+	defer manifest.DeleteKnownManifest(man.UniqueID)
+
+	// the secrets are stored encrypted only
+	stored := manifest.GetKnownManifest(man.UniqueID).Manifest.Modules[0]
+	assert.Equal([]string{"LOG_LEVEL=debug"}, stored.EnvArgs)
+	assert.Empty(stored.AuthConfig.Password)
+	assert.NotEmpty(stored.SecretAuth)
+	assert.NotContains(stored.SecretAuth, "registry password")
+
+	restored, err := manifest.RestoreSecretValues(stored)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.ElementsMatch([]string{"LOG_LEVEL=debug", "API_KEY=decrypted"}, restored.EnvArgs)
+	assert.Equal("registry password", restored.AuthConfig.Password)
+
+	assert.Equal("REDACTED", manifest.Redact(manifest.GetKnownManifest(man.UniqueID).Manifest).Modules[0].SecretAuth)
+}
+
+func TestLogCursors(t *testing.T) {
+	assert := assert.New(t)
+
+	workDir, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.Chdir(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(workDir)
+
+	man := manifest.Manifest{UniqueID: model.ManifestUniqueID{ID: "cursors"}, Modules: []manifest.ContainerConfig{{}}}
+	manifest.AddKnownManifest(man)
+	err = manifest.SetStatus(man.UniqueID, model.EdgeAppRunning)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cursor := model.LogCursor{Time: time.Date(2023, 5, 1, 12, 0, 0, 0, time.UTC), Skip: 2}
+	err = manifest.SetLogCursors(man.UniqueID, map[string]model.LogCursor{"container": cursor})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the cursors are written later and not with the manifests
+	_, err = os.Stat(manifest.LogCursorsFile)
+	assert.True(os.IsNotExist(err))
+	manifests, err := os.ReadFile(manifest.ManifestFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.NotContains(string(manifests), "container")
+
+	err = manifest.WriteLogCursors()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cursors, err := os.ReadFile(manifest.LogCursorsFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the cursors are restored with the manifests after a restart
+	manifest.DeleteKnownManifest(man.UniqueID)
+	for file, content := range map[string][]byte{manifest.ManifestFile: manifests, manifest.LogCursorsFile: cursors} {
+		err = os.WriteFile(file, content, 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = manifest.InitKnownManifests()
+	if err != nil {
+		t.Fatal(err)
+	}
+	record := manifest.GetKnownManifest(man.UniqueID)
+	if assert.NotNil(record) {
+		assert.Equal(model.EdgeAppRunning, record.Status)
+		assert.Equal(map[string]model.LogCursor{"container": cursor}, record.LogCursors)
+	}
+
+	manifest.DeleteKnownManifest(man.UniqueID)
+	err = manifest.WriteLogCursors()
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries, err := os.ReadDir(".")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// no temporary files are left behind
+	assert.Len(entries, 2)
+}
+
 func TestValidateManifest(t *testing.T) {
 	json, err := os.ReadFile("../../testdata/unittests/mvpManifest.json")
 	if err != nil {
@@ -257,3 +584,396 @@ func TestValidateUniqueIDExist(t *testing.T) {
 	_, err = manifest.GetEdgeAppUniqueID(json)
 	assert.Nil(t, err)
 }
+
+func TestValidate(t *testing.T) {
+	assert := assert.New(t)
+
+	json, err := os.ReadFile("../../testdata/unittests/healthcheckManifest.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	validated, warnings, err := manifest.Validate(json)
+	assert.Empty(warnings)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := manifest.Parse(json)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(parsed, validated)
+}
+
+func TestValidate_AllProblems(t *testing.T) {
+	assert := assert.New(t)
+
+	json, err := os.ReadFile("../../testdata/unittests/failManyProblems.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, _, err = manifest.Validate(json)
+	var validationErr *manifest.ValidationError
+	if !assert.ErrorAs(err, &validationErr) {
+		return
+	}
+	assert.ElementsMatch([]manifest.Problem{
+		{Path: "updatedAt", Message: "must be an RFC 3339 time, e.g. 2006-01-02T15:04:05Z"},
+		{Path: "modules[0].envs[1].key", Message: "must not be blank"},
+		{Path: "modules[0].mounts[1].container", Message: "/data is already mounted by modules[0].mounts[0]"},
+		{Path: "modules[1].healthcheck.type", Message: "must be one of command, http, tcp"},
+		{Path: "modules[1].ports[0].host", Message: "host port 1883 is already bound by modules[0].ports[0]"},
+		{Path: "connections.1[0]", Message: "module 3 doesn't exist, there are 2 modules"},
+	}, validationErr.Problems)
+	assert.Contains(err.Error(), "modules[0].envs[1].key: must not be blank")
+}
+
+func TestValidate_InvalidJSON(t *testing.T) {
+	assert := assert.New(t)
+
+	_, _, err := manifest.Validate([]byte(`{"_id": "62bef68d664ed72f8ecdd690", "modules": [{"ports": [{"host": 1883}]}]}`))
+	var validationErr *manifest.ValidationError
+	if !assert.ErrorAs(err, &validationErr) {
+		return
+	}
+	assert.Equal([]manifest.Problem{{Path: "modules[0].ports[0].host", Message: "has the wrong type (number)"}}, validationErr.Problems)
+}
+
+func TestValidate_SecretsNotDecrypted(t *testing.T) {
+	assert := assert.New(t)
+
+	json, err := os.ReadFile("../../testdata/unittests/mvpManifest.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	json = []byte(strings.Replace(string(json), `"key": "MQTT_BROKER",`, `"key": "MQTT_BROKER", "secret": true,`, 1))
+
+	man, _, err := manifest.Validate(json)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Contains(man.Modules[0].EnvArgs, "MQTT_BROKER=REDACTED")
+}
+
+func TestConnections(t *testing.T) {
+	tests := []struct {
+		name        string
+		connections map[string][]string
+		allowCycles bool
+		err         string
+		warnings    []manifest.Problem
+	}{
+		{
+			name:        "pipeline",
+			connections: map[string][]string{"0": {"1"}, "1": {"2"}, "2": {"3"}},
+		},
+		{
+			name:        "missing module",
+			connections: map[string][]string{"0": {"1"}, "1": {"2"}, "2": {"3", "7"}},
+			err:         "connections.2[1]: module 7 doesn't exist, there are 4 modules",
+		},
+		{
+			name:        "no index",
+			connections: map[string][]string{"first": {"1"}},
+			err:         `connections.first: "first" is not a module index`,
+		},
+		{
+			name:        "self-loop",
+			connections: map[string][]string{"0": {"1"}, "1": {"1", "2"}, "2": {"3"}},
+			err:         "connections.1[0]: module 1 is connected to itself",
+		},
+		{
+			name:        "cycle",
+			connections: map[string][]string{"0": {"1"}, "1": {"2"}, "2": {"3"}, "3": {"1"}},
+			err:         "connections: the modules form a cycle 1 -> 2 -> 3 -> 1, set allowCycles to deploy it anyway",
+		},
+		{
+			name:        "allowed cycle",
+			connections: map[string][]string{"0": {"1"}, "1": {"2"}, "2": {"3"}, "3": {"1"}},
+			allowCycles: true,
+		},
+		{
+			name:        "unreachable module",
+			connections: map[string][]string{"0": {"1"}, "1": {"3"}, "2": {"3"}},
+			warnings:    []manifest.Problem{{Path: "modules[2]", Message: "module comparison-filter doesn't receive data from any input module"}},
+		},
+	}
+
+	payload, err := os.ReadFile("../../testdata/unittests/healthcheckManifest.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert := assert.New(t)
+
+			var msg map[string]interface{}
+			err := json.Unmarshal(payload, &msg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			msg["connections"] = test.connections
+			msg["allowCycles"] = test.allowCycles
+			json, err := json.Marshal(msg)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			man, err := manifest.Parse(json)
+			_, warnings, validateErr := manifest.Validate(json)
+			if test.err != "" {
+				assert.ErrorContains(err, test.err)
+				assert.EqualError(validateErr, test.err)
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			assert.Nil(validateErr)
+			assert.Equal(test.warnings, warnings)
+
+			// every connection ends up as an egress URL of its module
+			man.UpdateManifest("network")
+			for start, ends := range test.connections {
+				index, _ := strconv.Atoi(start)
+				assert.Contains(strings.Join(man.Modules[index].EnvArgs, " "), "EGRESS_URLS=", start)
+				assert.Len(man.Connections[index], len(ends))
+			}
+		})
+	}
+}
+
+func TestMounts(t *testing.T) {
+	tests := []struct {
+		name   string
+		mounts []map[string]interface{}
+		err    string
+		want   []mount.Mount
+	}{
+		{
+			name:   "bind",
+			mounts: []map[string]interface{}{{"container": "/data", "host": "/data/host", "readOnly": true}},
+			want: []mount.Mount{{
+				Type:        mount.TypeBind,
+				Source:      "/data/host",
+				Target:      "/data",
+				ReadOnly:    true,
+				Consistency: "default",
+				BindOptions: &mount.BindOptions{Propagation: "rprivate", NonRecursive: true},
+			}},
+		},
+		{
+			name: "volume and tmpfs",
+			mounts: []map[string]interface{}{
+				{"type": "volume", "container": "/data", "volume": "data"},
+				{"type": "tmpfs", "container": "/cache", "size": "64m"},
+			},
+			want: []mount.Mount{
+				{
+					Type:   mount.TypeVolume,
+					Source: "62bef68d664ed72f8ecdd690_data",
+					Target: "/data",
+					VolumeOptions: &mount.VolumeOptions{Labels: map[string]string{
+						"manifestUniqueID": "62bef68d664ed72f8ecdd690",
+						"volume":           "data",
+					}},
+				},
+				{
+					Type:         mount.TypeTmpfs,
+					Target:       "/cache",
+					TmpfsOptions: &mount.TmpfsOptions{SizeBytes: 64 * 1024 * 1024},
+				},
+			},
+		},
+		{
+			name:   "unknown type",
+			mounts: []map[string]interface{}{{"type": "nfs", "container": "/data", "host": "/data/host"}},
+			err:    "modules[0].mounts[0].type: must be one of bind, volume, tmpfs",
+		},
+		{
+			name:   "bind without host",
+			mounts: []map[string]interface{}{{"container": "/data"}},
+			err:    "modules[0].mounts[0].host: is required for bind mounts",
+		},
+		{
+			name:   "invalid volume name",
+			mounts: []map[string]interface{}{{"type": "volume", "container": "/data", "volume": "../data"}},
+			err:    `modules[0].mounts[0].volume: "../data" is not a valid volume name`,
+		},
+		{
+			name:   "invalid tmpfs size",
+			mounts: []map[string]interface{}{{"type": "tmpfs", "container": "/cache", "size": "lots"}},
+			err:    "modules[0].mounts[0].size: invalid size: 'lots'",
+		},
+	}
+
+	payload, err := os.ReadFile("../../testdata/unittests/healthcheckManifest.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert := assert.New(t)
+
+			var msg map[string]interface{}
+			err := json.Unmarshal(payload, &msg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			msg["modules"].([]interface{})[0].(map[string]interface{})["mounts"] = test.mounts
+			json, err := json.Marshal(msg)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			man, err := manifest.Parse(json)
+			_, _, validateErr := manifest.Validate(json)
+			if test.err != "" {
+				assert.NotNil(err)
+				assert.EqualError(validateErr, test.err)
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			assert.Nil(validateErr)
+			assert.Equal(test.want, man.Modules[0].MountConfigs)
+		})
+	}
+}
+
+func TestLogSettings(t *testing.T) {
+	tests := []struct {
+		name    string
+		appLogs map[string]interface{}
+		logs    map[string]interface{}
+		err     string
+		want    *manifest.LogSettings
+		want1   *manifest.LogSettings // of the second module
+	}{
+		{
+			name: "parsers and multiline",
+			logs: map[string]interface{}{
+				"parsers": []map[string]interface{}{
+					{"type": "json", "timeField": "ts", "messageField": "msg"},
+					{"type": "regex", "pattern": `^(?P<time>\S+ \S+) (?P<level>\w+) (?P<message>.*)$`},
+				},
+				"multiline": map[string]interface{}{"start": `^\d{4}-`},
+			},
+			want: &manifest.LogSettings{
+				Parsers: []manifest.LogParser{
+					{Type: "json", TimeField: "ts", MessageField: "msg"},
+					{Type: "regex", Pattern: `^(?P<time>\S+ \S+) (?P<level>\w+) (?P<message>.*)$`},
+				},
+				Multiline: &manifest.Multiline{Start: `^\d{4}-`},
+			},
+		},
+		{
+			name:    "limits of the edge app and the module",
+			appLogs: map[string]interface{}{"level": "info", "rateLimit": 10, "sampling": map[string]interface{}{"first": 5}},
+			logs:    map[string]interface{}{"level": "warning", "burst": 20},
+			want: &manifest.LogSettings{Limits: manifest.LogLimits{
+				Level:     "WARNING",
+				RateLimit: 10,
+				Burst:     20,
+				Sampling:  manifest.LogSampling{Interval: time.Minute, First: 5},
+			}},
+			want1: &manifest.LogSettings{Limits: manifest.LogLimits{
+				Level:     "INFO",
+				RateLimit: 10,
+				Sampling:  manifest.LogSampling{Interval: time.Minute, First: 5},
+			}},
+		},
+		{
+			name:    "invalid limits",
+			appLogs: map[string]interface{}{"level": "verbose", "sampling": map[string]interface{}{"interval": 10}},
+			logs:    map[string]interface{}{"rateLimit": -1},
+			err:     "logs.sampling.first: is required\nlogs.level: \"verbose\" is not a log level\nmodules[0].logs.rateLimit: must be at least 0",
+		},
+		{
+			name: "unknown parser",
+			logs: map[string]interface{}{"parsers": []map[string]interface{}{{"type": "xml"}}},
+			err:  "modules[0].logs.parsers[0].type: must be one of json, logfmt, regex",
+		},
+		{
+			name: "regex without pattern",
+			logs: map[string]interface{}{"parsers": []map[string]interface{}{{"type": "regex"}}},
+			err:  "modules[0].logs.parsers[0].pattern: is required",
+		},
+		{
+			name: "pattern without fields",
+			logs: map[string]interface{}{"parsers": []map[string]interface{}{{"type": "regex", "pattern": `^(\w+) (.*)$`}}},
+			err:  "modules[0].logs.parsers[0].pattern: has none of the named groups time, level, filename and message",
+		},
+		{
+			name: "invalid pattern",
+			logs: map[string]interface{}{"parsers": []map[string]interface{}{{"type": "regex", "pattern": "(?P<message>"}}},
+			err:  "modules[0].logs.parsers[0].pattern: error parsing regexp: missing closing ): `(?P<message>`",
+		},
+		{
+			name: "invalid multiline",
+			logs: map[string]interface{}{"multiline": map[string]interface{}{"start": "[", "maxLines": -1}},
+			err:  "modules[0].logs.multiline.maxLines: must be at least 0\nmodules[0].logs.multiline.start: error parsing regexp: missing closing ]: `[`",
+		},
+	}
+
+	payload, err := os.ReadFile("../../testdata/unittests/healthcheckManifest.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert := assert.New(t)
+
+			var msg map[string]interface{}
+			err := json.Unmarshal(payload, &msg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			msg["modules"].([]interface{})[0].(map[string]interface{})["logs"] = test.logs
+			if test.appLogs != nil {
+				msg["logs"] = test.appLogs
+			}
+			json, err := json.Marshal(msg)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			man, err := manifest.Parse(json)
+			_, _, validateErr := manifest.Validate(json)
+			if test.err != "" {
+				assert.NotNil(err)
+				assert.EqualError(validateErr, test.err)
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			assert.Nil(validateErr)
+			assert.Equal(test.want, man.Modules[0].Logs)
+			assert.Equal(test.want1, man.Modules[1].Logs)
+		})
+	}
+}
+
+func TestParseLogLimits(t *testing.T) {
+	assert := assert.New(t)
+
+	limits, err := manifest.ParseLogLimits([]byte(`{"_id": "62bef68d664ed72f8ecdd690", "command": "LOGS", "logs": {"level": "error"}, "modules": {"1": {"rateLimit": 2}}}`), 3)
+	assert.NoError(err)
+	assert.Equal([]manifest.LogLimits{{Level: "ERROR"}, {Level: "ERROR", RateLimit: 2}, {Level: "ERROR"}}, limits)
+
+	// without limits all messages are sent
+	limits, err = manifest.ParseLogLimits([]byte(`{"_id": "62bef68d664ed72f8ecdd690", "command": "LOGS"}`), 2)
+	assert.NoError(err)
+	assert.Equal([]manifest.LogLimits{{}, {}}, limits)
+
+	_, err = manifest.ParseLogLimits([]byte(`{"_id": "62bef68d664ed72f8ecdd690", "command": "LOGS", "logs": {"parsers": [{"type": "logfmt"}]}, "modules": {"3": {"level": "info"}, "0": {"burst": -1}}}`), 3)
+	assert.EqualError(err, `logs.parsers: can only be changed by deploying the edge app
+modules.0.burst: must be at least 0
+modules.3: "3" is not the index of one of the 3 modules`)
+}
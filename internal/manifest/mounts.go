@@ -0,0 +1,87 @@
+package manifest
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/docker/docker/api/types/mount"
+
+	"github.com/weeveiot/weeve-agent/internal/model"
+)
+
+const (
+	mountBind   = "bind"
+	mountVolume = "volume"
+	mountTmpfs  = "tmpfs"
+)
+
+// VolumeLabel is the label of a volume with its name in the manifest
+const VolumeLabel = "volume"
+
+// volumeNameRegexp is what container engines accept as volume names
+var volumeNameRegexp = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]*$`)
+
+// VolumeName is the name of the volume in the container runtime. The volumes are scoped to the edge app, so that edge
+// apps can use the same names without sharing their data.
+func VolumeName(manifestUniqueID model.ManifestUniqueID, name string) string {
+	return manifestUniqueID.ID + "_" + name
+}
+
+// VolumeOfEdgeApp returns the name in the manifest of a volume in the container runtime, if it belongs to the edge app
+func VolumeOfEdgeApp(manifestUniqueID model.ManifestUniqueID, volumeName string) (string, bool) {
+	return strings.CutPrefix(volumeName, manifestUniqueID.ID+"_")
+}
+
+// checkMount returns the field of the mount that is invalid for its type
+func checkMount(mnt mountMsg) (string, error) {
+	switch mnt.Type {
+	case "", mountBind:
+		if strings.TrimSpace(mnt.Host) == "" {
+			return "host", errors.New("is required for bind mounts")
+		}
+	case mountVolume:
+		if !volumeNameRegexp.MatchString(mnt.Volume) {
+			return "volume", fmt.Errorf("%q is not a valid volume name", mnt.Volume)
+		}
+	case mountTmpfs:
+		if _, err := parseMemory(mnt.Size); err != nil {
+			return "size", err
+		}
+	}
+	return "", nil
+}
+
+func bindMount(mnt mountMsg) mount.Mount {
+	return mount.Mount{
+		Type:        mount.TypeBind,
+		Source:      mnt.Host,
+		Target:      mnt.Container,
+		Consistency: "default",
+		BindOptions: &mount.BindOptions{Propagation: "rprivate", NonRecursive: true},
+	}
+}
+
+// volumeMount mounts the named volume, the container engine creates it with the labels on first use
+func volumeMount(mnt mountMsg, manifestUniqueID model.ManifestUniqueID) mount.Mount {
+	return mount.Mount{
+		Type:   mount.TypeVolume,
+		Source: VolumeName(manifestUniqueID, mnt.Volume),
+		Target: mnt.Container,
+		VolumeOptions: &mount.VolumeOptions{Labels: map[string]string{
+			"manifestUniqueID": manifestUniqueID.String(),
+			VolumeLabel:        mnt.Volume,
+		}},
+	}
+}
+
+func tmpfsMount(mnt mountMsg) mount.Mount {
+	// checked already
+	size, _ := parseMemory(mnt.Size)
+	return mount.Mount{
+		Type:         mount.TypeTmpfs,
+		Target:       mnt.Container,
+		TmpfsOptions: &mount.TmpfsOptions{SizeBytes: size},
+	}
+}
@@ -0,0 +1,152 @@
+package manifest
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/go-units"
+	"github.com/shirou/gopsutil/v3/cpu"
+	"github.com/shirou/gopsutil/v3/mem"
+	log "github.com/sirupsen/logrus"
+
+	traceutility "github.com/weeveiot/weeve-agent/internal/utility/trace"
+)
+
+// unlimitedSwap lets a module use as much swap as the node has
+const unlimitedSwap = "-1"
+
+// maxCPUs is the most CPUs Linux supports, cpusets beyond it are invalid on any node
+const maxCPUs = 8192
+
+func parseResources(resources *resourcesMsg, devices []container.DeviceMapping) (container.Resources, error) {
+	result := container.Resources{Devices: devices}
+	if resources == nil {
+		return result, nil
+	}
+	log.Debug("Parsing resource limits")
+
+	result.NanoCPUs = int64(resources.Cpus * 1e9)
+	result.CPUShares = resources.CpuShares
+	if resources.CpusetCpus != "" {
+		_, err := maxCPUSetID(resources.CpusetCpus)
+		if err != nil {
+			return result, traceutility.Wrap(err)
+		}
+		result.CpusetCpus = resources.CpusetCpus
+	}
+
+	var err error
+	result.Memory, err = parseMemory(resources.Memory)
+	if err != nil {
+		return result, traceutility.Wrap(err)
+	}
+	result.MemoryReservation, err = parseMemory(resources.MemoryReservation)
+	if err != nil {
+		return result, traceutility.Wrap(err)
+	}
+	if resources.MemorySwap == unlimitedSwap {
+		result.MemorySwap = -1
+	} else {
+		result.MemorySwap, err = parseMemory(resources.MemorySwap)
+		if err != nil {
+			return result, traceutility.Wrap(err)
+		}
+	}
+
+	if result.MemoryReservation > 0 && result.Memory > 0 && result.MemoryReservation > result.Memory {
+		return result, fmt.Errorf("memory reservation %s exceeds the memory limit %s", resources.MemoryReservation, resources.Memory)
+	}
+	if result.MemorySwap != 0 {
+		if result.Memory == 0 {
+			return result, fmt.Errorf("memory swap requires a memory limit")
+		}
+		if result.MemorySwap > 0 && result.MemorySwap < result.Memory {
+			return result, fmt.Errorf("memory swap %s is less than the memory limit %s, it includes the memory", resources.MemorySwap, resources.Memory)
+		}
+	}
+
+	if resources.PidsLimit > 0 {
+		pidsLimit := resources.PidsLimit
+		result.PidsLimit = &pidsLimit
+	}
+
+	return result, nil
+}
+
+// CheckHostResources rejects limits that the node can never satisfy. It is checked when the edge app is deployed,
+// as the manifest may be validated on another machine. The swap is not checked, a module just doesn't swap on a node
+// without swap.
+func CheckHostResources(resources container.Resources) error {
+	cpuCount, err := cpu.Counts(true)
+	if err != nil {
+		return traceutility.Wrap(err)
+	}
+	if float64(resources.NanoCPUs)/1e9 > float64(cpuCount) {
+		return fmt.Errorf("%v CPUs requested, but the node has %d", float64(resources.NanoCPUs)/1e9, cpuCount)
+	}
+	if resources.CpusetCpus != "" {
+		id, err := maxCPUSetID(resources.CpusetCpus)
+		if err != nil {
+			return traceutility.Wrap(err)
+		}
+		if id >= cpuCount {
+			return fmt.Errorf("CPU %d requested, but the node has %d CPUs", id, cpuCount)
+		}
+	}
+
+	virtualMemory, err := mem.VirtualMemory()
+	if err != nil {
+		return traceutility.Wrap(err)
+	}
+	if uint64(resources.Memory) > virtualMemory.Total {
+		return fmt.Errorf("%s of memory requested, but the node has %s", units.BytesSize(float64(resources.Memory)), units.BytesSize(float64(virtualMemory.Total)))
+	}
+	if uint64(resources.MemoryReservation) > virtualMemory.Total {
+		return fmt.Errorf("%s of memory reserved, but the node has %s", units.BytesSize(float64(resources.MemoryReservation)), units.BytesSize(float64(virtualMemory.Total)))
+	}
+
+	return nil
+}
+
+// parseMemory converts a size like "512m" into bytes, an empty size is no limit
+func parseMemory(size string) (int64, error) {
+	if size == "" {
+		return 0, nil
+	}
+	bytes, err := units.RAMInBytes(size)
+	if err != nil {
+		return 0, traceutility.Wrap(err)
+	}
+	if bytes < 0 {
+		return 0, fmt.Errorf("invalid size: %s", size)
+	}
+	return bytes, nil
+}
+
+// maxCPUSetID returns the highest CPU of a list like "0-2,4"
+func maxCPUSetID(cpuSet string) (int, error) {
+	maxID := -1
+	for _, part := range strings.Split(cpuSet, ",") {
+		first, last, isRange := strings.Cut(part, "-")
+		start, err := strconv.Atoi(first)
+		if err != nil || start < 0 {
+			return 0, fmt.Errorf("invalid cpuset: %s", cpuSet)
+		}
+		end := start
+		if isRange {
+			end, err = strconv.Atoi(last)
+			if err != nil || end < start {
+				return 0, fmt.Errorf("invalid cpuset: %s", cpuSet)
+			}
+		}
+		if end >= maxCPUs {
+			return 0, fmt.Errorf("invalid cpuset: %s, CPU %d is beyond the %d CPUs Linux supports", cpuSet, end, maxCPUs)
+		}
+		if end > maxID {
+			maxID = end
+		}
+	}
+	return maxID, nil
+}
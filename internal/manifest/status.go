@@ -4,34 +4,72 @@ import (
 	"encoding/json"
 	"io"
 	"os"
+	"sync"
+	"time"
 
 	"errors"
+	"fmt"
 
+	"github.com/docker/docker/api/types/mount"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/go-connections/nat"
 	log "github.com/sirupsen/logrus"
 
 	"github.com/weeveiot/weeve-agent/internal/model"
+	ioutility "github.com/weeveiot/weeve-agent/internal/utility/io"
 	traceutility "github.com/weeveiot/weeve-agent/internal/utility/trace"
 )
 
 type ManifestRecord struct {
-	Manifest        Manifest
-	Status          string
-	LastLogReadTime string
+	Manifest Manifest
+	Status   string
+	// LogCursors are the positions up to which the logs of the containers were sent, by container ID. They change
+	// with every batch of logs, so they are stored in LogCursorsFile instead of the ManifestFile.
+	LogCursors map[string]model.LogCursor `json:"-"`
+	// LastLogReadTime was written by agents that polled the logs, the logs of containers without cursor are sent from it
+	LastLogReadTime string `json:",omitempty"`
 }
 
+// knownManifests is accessed by the command queues, the status monitor and the log sender concurrently.
+// Records are only handed out as deep copies, so they can't be changed behind the mutex.
 var knownManifests = make(map[model.ManifestUniqueID]*ManifestRecord)
+var knownManifestsMutex sync.RWMutex
 
 const ManifestFile = "known_manifests.jsonl"
 
+// LogCursorsFile holds the log cursors of the known manifests. To spare the flash storage of the nodes, it is written
+// at most every logCursorsWriteInterval; after a crash the logs of that interval are sent again.
+const LogCursorsFile = "log_cursors.json"
+
+const logCursorsWriteInterval = 5 * time.Second
+
+// logCursorsWrite is the scheduled write of the LogCursorsFile, guarded by knownManifestsMutex
+var logCursorsWrite *time.Timer
+
+// GetKnownManifests returns a snapshot of the known manifests
 func GetKnownManifests() map[model.ManifestUniqueID]*ManifestRecord {
-	return knownManifests
+	knownManifestsMutex.RLock()
+	defer knownManifestsMutex.RUnlock()
+
+	manifests := make(map[model.ManifestUniqueID]*ManifestRecord, len(knownManifests))
+	for uniqueID, manifest := range knownManifests {
+		manifests[uniqueID] = manifest.copy()
+	}
+	return manifests
 }
 
+// GetKnownManifest returns a copy of the known manifest or nil if the edge app is not known
 func GetKnownManifest(manifestUniqueID model.ManifestUniqueID) *ManifestRecord {
-	return knownManifests[manifestUniqueID]
+	knownManifestsMutex.RLock()
+	defer knownManifestsMutex.RUnlock()
+
+	return knownManifests[manifestUniqueID].copy()
 }
 
 func GetUsedImages(uniqueID model.ManifestUniqueID) ([]string, error) {
+	knownManifestsMutex.RLock()
+	defer knownManifestsMutex.RUnlock()
+
 	var images []string
 	manifest, manifestKnown := knownManifests[uniqueID]
 	if !manifestKnown {
@@ -44,25 +82,53 @@ func GetUsedImages(uniqueID model.ManifestUniqueID) ([]string, error) {
 }
 
 func AddKnownManifest(man Manifest) {
-	manCopy := clearSecretValues(man) // remove some fields so that secret values never touch the hard disk
+	knownManifestsMutex.Lock()
+	defer knownManifestsMutex.Unlock()
+
+	manCopy := storableManifest(man) // remove some fields so that secret values never touch the hard disk
 	knownManifests[man.UniqueID] = &ManifestRecord{
 		Manifest: manCopy,
 		Status:   model.EdgeAppInitiated,
 	}
 }
 
+// UpdateKnownManifest replaces the manifest of a known edge app, keeping its status
+func UpdateKnownManifest(man Manifest) error {
+	knownManifestsMutex.Lock()
+	defer knownManifestsMutex.Unlock()
+
+	manifest, manifestKnown := knownManifests[man.UniqueID]
+	if !manifestKnown {
+		return errors.New("could not update the manifest. the edge app is not known")
+	}
+	manifest.Manifest = storableManifest(man)
+
+	err := writeKnownManifestsToFile()
+	if err != nil {
+		log.Fatal("Failed to write known manifest to file! CAUSE --> ", err)
+	}
+	return nil
+}
+
 func DeleteKnownManifest(manifestUniqueID model.ManifestUniqueID) {
+	knownManifestsMutex.Lock()
+	defer knownManifestsMutex.Unlock()
+
 	delete(knownManifests, manifestUniqueID)
 
 	err := writeKnownManifestsToFile()
 	if err != nil {
 		log.Fatal("Failed to write known manifest to file! CAUSE --> ", err)
 	}
+	scheduleLogCursorsWrite()
 }
 
 func SetStatus(manifestUniqueID model.ManifestUniqueID, status string) error {
 	log.Debugln("Setting status", status, "to edge app", manifestUniqueID)
 
+	knownManifestsMutex.Lock()
+	defer knownManifestsMutex.Unlock()
+
 	manifest, manifestKnown := knownManifests[manifestUniqueID]
 	if !manifestKnown {
 		return errors.New("could not set the status. the edge app is not known (deployed)")
@@ -76,14 +142,50 @@ func SetStatus(manifestUniqueID model.ManifestUniqueID, status string) error {
 	return nil
 }
 
-func SetLastLogRead(manifestUniqueID model.ManifestUniqueID, lastLogReadTime string) error {
-	log.Debugln("Setting last log read time", lastLogReadTime, "to edge app", manifestUniqueID)
+// SetLogCursors records up to where the logs of the containers of an edge app were sent
+func SetLogCursors(manifestUniqueID model.ManifestUniqueID, cursors map[string]model.LogCursor) error {
+	knownManifestsMutex.Lock()
+	defer knownManifestsMutex.Unlock()
 
 	manifest, manifestKnown := knownManifests[manifestUniqueID]
 	if !manifestKnown {
-		return errors.New("could not set the status. the edge app is not known (deployed)")
+		return errors.New("could not set the log cursors. the edge app is not known")
+	}
+	if manifest.LogCursors == nil {
+		manifest.LogCursors = make(map[string]model.LogCursor)
+	}
+	for containerID, cursor := range cursors {
+		manifest.LogCursors[containerID] = cursor
 	}
-	manifest.LastLogReadTime = lastLogReadTime
+
+	scheduleLogCursorsWrite()
+	return nil
+}
+
+// SetLogLimits replaces the log limits of the modules of an edge app, by the index of the module
+func SetLogLimits(manifestUniqueID model.ManifestUniqueID, limits []LogLimits) error {
+	knownManifestsMutex.Lock()
+	defer knownManifestsMutex.Unlock()
+
+	manifest, manifestKnown := knownManifests[manifestUniqueID]
+	if !manifestKnown {
+		return errors.New("could not set the log limits. the edge app is not known")
+	}
+	if len(limits) != len(manifest.Manifest.Modules) {
+		return fmt.Errorf("could not set the log limits. the edge app has %d modules, not %d", len(manifest.Manifest.Modules), len(limits))
+	}
+
+	// the copies of the record that were handed out share the modules, so they are replaced rather than changed
+	modules := append([]ContainerConfig(nil), manifest.Manifest.Modules...)
+	for i := range modules {
+		logs := LogSettings{}
+		if modules[i].Logs != nil {
+			logs = *modules[i].Logs
+		}
+		logs.Limits = limits[i]
+		modules[i].Logs = &logs
+	}
+	manifest.Manifest.Modules = modules
 
 	err := writeKnownManifestsToFile()
 	if err != nil {
@@ -92,9 +194,81 @@ func SetLastLogRead(manifestUniqueID model.ManifestUniqueID, lastLogReadTime str
 	return nil
 }
 
+// PruneLogCursors forgets the log cursors of the containers of an edge app that don't exist anymore
+func PruneLogCursors(manifestUniqueID model.ManifestUniqueID, containerIDs []string) {
+	knownManifestsMutex.Lock()
+	defer knownManifestsMutex.Unlock()
+
+	manifest, manifestKnown := knownManifests[manifestUniqueID]
+	if !manifestKnown {
+		return
+	}
+
+	exists := make(map[string]bool)
+	for _, containerID := range containerIDs {
+		exists[containerID] = true
+	}
+	pruned := false
+	for containerID := range manifest.LogCursors {
+		if !exists[containerID] {
+			delete(manifest.LogCursors, containerID)
+			pruned = true
+		}
+	}
+	if pruned {
+		scheduleLogCursorsWrite()
+	}
+}
+
+// WriteLogCursors writes the log cursors of the known manifests to the LogCursorsFile right away, e.g. before the agent
+// exits
+func WriteLogCursors() error {
+	knownManifestsMutex.Lock()
+	defer knownManifestsMutex.Unlock()
+
+	if logCursorsWrite != nil {
+		logCursorsWrite.Stop()
+		logCursorsWrite = nil
+	}
+	cursors := make(map[model.ManifestUniqueID]map[string]model.LogCursor)
+	for uniqueID, manifest := range knownManifests {
+		if len(manifest.LogCursors) > 0 {
+			cursors[uniqueID] = manifest.LogCursors
+		}
+	}
+
+	encodedJson, err := json.Marshal(cursors)
+	if err != nil {
+		return traceutility.Wrap(err)
+	}
+	err = ioutility.WriteFileAtomic(LogCursorsFile, encodedJson, 0644)
+	if err != nil {
+		return traceutility.Wrap(err)
+	}
+	return nil
+}
+
+// scheduleLogCursorsWrite writes the log cursors after logCursorsWriteInterval, unless a write is scheduled already.
+// It must be called with the knownManifestsMutex locked.
+func scheduleLogCursorsWrite() {
+	if logCursorsWrite != nil {
+		return
+	}
+
+	logCursorsWrite = time.AfterFunc(logCursorsWriteInterval, func() {
+		err := WriteLogCursors()
+		if err != nil {
+			log.Error("Failed to write the log cursors to file! CAUSE --> ", err)
+		}
+	})
+}
+
 func InitKnownManifests() error {
 	log.Debug("Initializing known manifests...")
 
+	knownManifestsMutex.Lock()
+	defer knownManifestsMutex.Unlock()
+
 	jsonFile, err := os.Open(ManifestFile)
 	if os.IsNotExist(err) {
 		return nil
@@ -109,10 +283,44 @@ func InitKnownManifests() error {
 		return traceutility.Wrap(err)
 	}
 
-	return json.Unmarshal(byteValue, &knownManifests)
+	err = json.Unmarshal(byteValue, &knownManifests)
+	if err != nil {
+		return traceutility.Wrap(err)
+	}
+
+	readLogCursorsFromFile()
+	return nil
+}
+
+// readLogCursorsFromFile adds the stored log cursors to the known manifests. Without them the logs of the containers
+// are sent again from the start, so a broken file is no reason to stop the agent.
+func readLogCursorsFromFile() {
+	byteValue, err := os.ReadFile(LogCursorsFile)
+	if os.IsNotExist(err) {
+		return
+	}
+	if err != nil {
+		log.Warning("Failed to read the log cursors! CAUSE --> ", err)
+		return
+	}
+
+	var cursors map[model.ManifestUniqueID]map[string]model.LogCursor
+	err = json.Unmarshal(byteValue, &cursors)
+	if err != nil {
+		log.Warning("Failed to read the log cursors! CAUSE --> ", err)
+		return
+	}
+	for uniqueID, containerCursors := range cursors {
+		if manifest, manifestKnown := knownManifests[uniqueID]; manifestKnown {
+			manifest.LogCursors = containerCursors
+		}
+	}
 }
 
 func GetEdgeAppStatus(manifestUniqueID model.ManifestUniqueID) (string, error) {
+	knownManifestsMutex.RLock()
+	defer knownManifestsMutex.RUnlock()
+
 	manifest, manifestKnown := knownManifests[manifestUniqueID]
 	if !manifestKnown || manifest == nil {
 		return "", errors.New("could not get the status. the edge app " + manifestUniqueID.String() + " is not known")
@@ -120,13 +328,116 @@ func GetEdgeAppStatus(manifestUniqueID model.ManifestUniqueID) (string, error) {
 	return manifest.Status, nil
 }
 
+func (record *ManifestRecord) copy() *ManifestRecord {
+	if record == nil {
+		return nil
+	}
+	recordCopy := *record
+	recordCopy.Manifest = record.Manifest.copy()
+	if record.LogCursors != nil {
+		recordCopy.LogCursors = make(map[string]model.LogCursor, len(record.LogCursors))
+		for containerID, cursor := range record.LogCursors {
+			recordCopy.LogCursors[containerID] = cursor
+		}
+	}
+	return &recordCopy
+}
+
+// copy returns a deep copy of the manifest, which shares none of the slices, maps and pointers of the modules that the
+// agent changes. The resources and the options of the mounts are only read, so they are shared.
+func (m Manifest) copy() Manifest {
+	manifestCopy := m
+	manifestCopy.Labels = copyStringMap(m.Labels)
+	if m.Connections != nil {
+		manifestCopy.Connections = make(connectionsInt, len(m.Connections))
+		for from, to := range m.Connections {
+			manifestCopy.Connections[from] = append([]int(nil), to...)
+		}
+	}
+	if m.Modules != nil {
+		manifestCopy.Modules = make([]ContainerConfig, len(m.Modules))
+		for i, module := range m.Modules {
+			manifestCopy.Modules[i] = module.copy()
+		}
+	}
+	return manifestCopy
+}
+
+func (c ContainerConfig) copy() ContainerConfig {
+	configCopy := c
+	configCopy.EnvArgs = copyStrings(c.EnvArgs)
+	configCopy.SecretEnvs = copyStringMap(c.SecretEnvs)
+	configCopy.Labels = copyStringMap(c.Labels)
+	if c.ExposedPorts != nil {
+		configCopy.ExposedPorts = make(nat.PortSet, len(c.ExposedPorts))
+		for port := range c.ExposedPorts {
+			configCopy.ExposedPorts[port] = struct{}{}
+		}
+	}
+	if c.PortBinding != nil {
+		configCopy.PortBinding = make(nat.PortMap, len(c.PortBinding))
+		for port, bindings := range c.PortBinding {
+			configCopy.PortBinding[port] = append([]nat.PortBinding(nil), bindings...)
+		}
+	}
+	if c.NetworkConfig.EndpointsConfig != nil {
+		configCopy.NetworkConfig.EndpointsConfig = make(map[string]*network.EndpointSettings, len(c.NetworkConfig.EndpointsConfig))
+		for name, settings := range c.NetworkConfig.EndpointsConfig {
+			if settings != nil {
+				settingsCopy := *settings
+				settingsCopy.Aliases = copyStrings(settings.Aliases)
+				settingsCopy.Links = copyStrings(settings.Links)
+				settings = &settingsCopy
+			}
+			configCopy.NetworkConfig.EndpointsConfig[name] = settings
+		}
+	}
+	if c.MountConfigs != nil {
+		configCopy.MountConfigs = append([]mount.Mount(nil), c.MountConfigs...)
+	}
+	if c.Healthcheck != nil {
+		healthcheck := *c.Healthcheck
+		healthcheck.Test = copyStrings(c.Healthcheck.Test)
+		configCopy.Healthcheck = &healthcheck
+	}
+	if c.Logs != nil {
+		logs := *c.Logs
+		logs.Parsers = append([]LogParser(nil), c.Logs.Parsers...)
+		if c.Logs.Multiline != nil {
+			multiline := *c.Logs.Multiline
+			logs.Multiline = &multiline
+		}
+		configCopy.Logs = &logs
+	}
+	return configCopy
+}
+
+func copyStrings(s []string) []string {
+	if s == nil {
+		return nil
+	}
+	return append([]string(nil), s...)
+}
+
+func copyStringMap(m map[string]string) map[string]string {
+	if m == nil {
+		return nil
+	}
+	mapCopy := make(map[string]string, len(m))
+	for key, value := range m {
+		mapCopy[key] = value
+	}
+	return mapCopy
+}
+
+// writeKnownManifestsToFile must be called with the knownManifestsMutex locked
 func writeKnownManifestsToFile() error {
 	encodedJson, err := json.MarshalIndent(knownManifests, "", " ")
 	if err != nil {
 		return traceutility.Wrap(err)
 	}
 
-	err = os.WriteFile(ManifestFile, encodedJson, 0644)
+	err = ioutility.WriteFileAtomic(ManifestFile, encodedJson, 0644)
 	if err != nil {
 		return traceutility.Wrap(err)
 	}
@@ -0,0 +1,255 @@
+package manifest
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/go-playground/validator/v10/non-standard/validators"
+
+	traceutility "github.com/weeveiot/weeve-agent/internal/utility/trace"
+)
+
+// Problem is something wrong with a manifest, found at the JSON path of the field that causes it
+type Problem struct {
+	Path    string `json:"path"`
+	Message string `json:"message"`
+}
+
+// ValidationError lists all problems of a manifest
+type ValidationError struct {
+	Problems []Problem
+}
+
+func (e *ValidationError) Error() string {
+	var lines []string
+	for _, problem := range e.Problems {
+		if problem.Path == "" {
+			lines = append(lines, problem.Message)
+		} else {
+			lines = append(lines, problem.Path+": "+problem.Message)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// pathValidate names the fields like the manifest JSON does, so that the problems point to the JSON paths
+var pathValidate *validator.Validate
+
+func init() {
+	pathValidate = validator.New()
+	pathValidate.RegisterValidation("notblank", validators.NotBlank)
+	pathValidate.RegisterValidation("httppath", httpPath)
+	pathValidate.RegisterTagNameFunc(func(field reflect.StructField) string {
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "" {
+			name = strings.ToLower(field.Name[:1]) + field.Name[1:]
+		}
+		return name
+	})
+}
+
+// Validate checks the manifest without touching the container runtime and returns the configs of the containers that
+// would be created, along with warnings that don't prevent a deployment. All problems are reported at once in a
+// *ValidationError. The values of secret env variables are not decrypted, so the agent's keys aren't needed.
+func Validate(payload []byte) (Manifest, []Problem, error) {
+	var man manifestMsg
+	err := json.Unmarshal(payload, &man)
+	if err != nil {
+		return Manifest{}, nil, &ValidationError{Problems: []Problem{jsonProblem(err)}}
+	}
+
+	problems, warnings := validateManifest(man)
+	if len(problems) > 0 {
+		return Manifest{}, warnings, &ValidationError{Problems: problems}
+	}
+
+	manifest, err := buildManifest(man, redactArguments)
+	if err != nil {
+		return Manifest{}, warnings, traceutility.Wrap(err)
+	}
+	return manifest, warnings, nil
+}
+
+func validateManifest(man manifestMsg) (problems []Problem, warnings []Problem) {
+	problems = structProblems("", man)
+
+	if man.UpdatedAt != "" {
+		_, err := time.Parse(time.RFC3339, man.UpdatedAt)
+		if err != nil {
+			problems = append(problems, Problem{Path: "updatedAt", Message: "must be an RFC 3339 time, e.g. 2006-01-02T15:04:05Z"})
+		}
+	}
+
+	problems = append(problems, logsProblems("logs", man.Logs)...)
+
+	// host ports can only be bound once on the node
+	hostPorts := make(map[string]string)
+	for i, module := range man.Modules {
+		modulePath := fmt.Sprintf("modules[%d]", i)
+		problems = append(problems, structProblems(modulePath, module)...)
+
+		for j, env := range module.Envs {
+			problems = append(problems, structProblems(fmt.Sprintf("%s.envs[%d]", modulePath, j), env)...)
+		}
+		for j, port := range module.Ports {
+			portPath := fmt.Sprintf("%s.ports[%d]", modulePath, j)
+			problems = append(problems, structProblems(portPath, port)...)
+			if port.Host == "" {
+				continue
+			}
+			if boundBy, bound := hostPorts[port.Host]; bound {
+				problems = append(problems, Problem{Path: portPath + ".host", Message: fmt.Sprintf("host port %s is already bound by %s", port.Host, boundBy)})
+			} else {
+				hostPorts[port.Host] = portPath
+			}
+		}
+
+		mounts := make(map[string]string)
+		for j, mnt := range module.Mounts {
+			mountPath := fmt.Sprintf("%s.mounts[%d]", modulePath, j)
+			problems = append(problems, structProblems(mountPath, mnt)...)
+			if field, err := checkMount(mnt); err != nil {
+				problems = append(problems, Problem{Path: mountPath + "." + field, Message: firstLine(err)})
+			}
+			if mnt.Container == "" {
+				continue
+			}
+			if mountedBy, mounted := mounts[mnt.Container]; mounted {
+				problems = append(problems, Problem{Path: mountPath + ".container", Message: fmt.Sprintf("%s is already mounted by %s", mnt.Container, mountedBy)})
+			} else {
+				mounts[mnt.Container] = mountPath
+			}
+		}
+
+		for j, dev := range module.Devices {
+			problems = append(problems, structProblems(fmt.Sprintf("%s.devices[%d]", modulePath, j), dev)...)
+		}
+
+		problems = append(problems, logsProblems(modulePath+".logs", module.Logs)...)
+
+		if module.Resources != nil {
+			_, err := parseResources(module.Resources, nil)
+			if err != nil {
+				problems = append(problems, Problem{Path: modulePath + ".resources", Message: firstLine(err)})
+			}
+		}
+	}
+
+	connProblems, warnings := connectionProblems(man.Connections, man.Modules, man.AllowCycles)
+	return append(problems, connProblems...), warnings
+}
+
+// logsProblems checks the log settings at the path, apart from the fields that the struct of the path validates already
+func logsProblems(path string, logs *logsMsg) []Problem {
+	if logs == nil {
+		return nil
+	}
+
+	var problems []Problem
+	for i, parser := range logs.Parsers {
+		parserPath := fmt.Sprintf("%s.parsers[%d]", path, i)
+		problems = append(problems, structProblems(parserPath, parser)...)
+		if field, err := checkLogParser(parser); err != nil {
+			problems = append(problems, Problem{Path: parserPath + "." + field, Message: firstLine(err)})
+		}
+	}
+	if logs.Multiline != nil {
+		if field, err := checkMultiline(*logs.Multiline); err != nil {
+			problems = append(problems, Problem{Path: path + ".multiline." + field, Message: firstLine(err)})
+		}
+	}
+	if field, err := checkLogLevel(logs.Level); err != nil {
+		problems = append(problems, Problem{Path: path + "." + field, Message: firstLine(err)})
+	}
+	return problems
+}
+
+// structProblems validates the struct, whose fields are at the path prefix in the manifest
+func structProblems(prefix string, s interface{}) []Problem {
+	err := pathValidate.Struct(s)
+	if err == nil {
+		return nil
+	}
+
+	var fieldErrors validator.ValidationErrors
+	if !errors.As(err, &fieldErrors) {
+		return []Problem{{Path: prefix, Message: err.Error()}}
+	}
+
+	var problems []Problem
+	for _, fieldError := range fieldErrors {
+		// the namespace starts with the name of the struct type
+		_, path, _ := strings.Cut(fieldError.Namespace(), ".")
+		if prefix != "" {
+			path = prefix + "." + path
+		}
+		problems = append(problems, Problem{Path: path, Message: problemMessage(fieldError)})
+	}
+	return problems
+}
+
+func problemMessage(fieldError validator.FieldError) string {
+	switch fieldError.Tag() {
+	case "required", "required_if":
+		return "is required"
+	case "notblank":
+		return "must not be blank"
+	case "httppath":
+		return "must be a URL path starting with /, without whitespace, quotes or shell special characters"
+	case "alphanum":
+		return "must be alphanumeric"
+	case "oneof":
+		return "must be one of " + strings.ReplaceAll(fieldError.Param(), " ", ", ")
+	case "min":
+		return "must be at least " + fieldError.Param()
+	case "max":
+		return "must be at most " + fieldError.Param()
+	default:
+		return fmt.Sprintf("fails the %s check", fieldError.Tag())
+	}
+}
+
+// jsonProblem points to the field of a type mismatch, other JSON errors concern the whole manifest
+func jsonProblem(err error) Problem {
+	var typeError *json.UnmarshalTypeError
+	if errors.As(err, &typeError) && typeError.Field != "" {
+		// the field is like Modules.0.Ports, with the indices of the arrays as segments
+		var path string
+		for _, segment := range strings.Split(typeError.Field, ".") {
+			if _, err := strconv.Atoi(segment); err == nil {
+				path += "[" + segment + "]"
+			} else if path == "" {
+				path = segment
+			} else {
+				path += "." + segment
+			}
+		}
+		return Problem{Path: path, Message: fmt.Sprintf("has the wrong type (%s)", typeError.Value)}
+	}
+	return Problem{Message: "invalid JSON: " + err.Error()}
+}
+
+// redactArguments is parseArguments without decrypting the secret values
+func redactArguments(options []envMsg) ([]string, error) {
+	var args []string
+	for _, env := range options {
+		value := env.Value
+		if env.Secret {
+			value = redacted
+		}
+		args = append(args, fmt.Sprintf("%v=%v", env.Key, value))
+	}
+	return args, nil
+}
+
+// firstLine drops the trace of a wrapped error
+func firstLine(err error) string {
+	line, _, _ := strings.Cut(err.Error(), "\n")
+	return line
+}
@@ -0,0 +1,133 @@
+// Package metrics exposes the internals of the agent for Prometheus.
+//
+// The instruments of the agent are package level variables, so that they can be updated from anywhere without
+// passing them around. They are only served if the agent is started with a metrics address.
+package metrics
+
+import (
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"github.com/weeveiot/weeve-agent/internal/model"
+	traceutility "github.com/weeveiot/weeve-agent/internal/utility/trace"
+)
+
+const namespace = "weeve_agent"
+
+// durationBuckets span from a second to about half an hour, pulls and deployments on slow links take long
+var durationBuckets = prometheus.ExponentialBuckets(1, 2, 12)
+
+var registry = prometheus.NewRegistry()
+
+var startTime = time.Now()
+
+var (
+	MQTTConnected = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "mqtt_connected",
+		Help:      "Whether the agent is connected to the MQTT broker.",
+	})
+	MQTTReconnects = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "mqtt_reconnects_total",
+		Help:      "Number of times the connection to the MQTT broker was established again after it was lost.",
+	})
+	PublishTimeouts = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "mqtt_publish_timeouts_total",
+		Help:      "Number of MQTT messages that were not published in time.",
+	})
+	Commands = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "commands_total",
+		Help:      "Number of orchestration commands by command and result.",
+	}, []string{"command", "result"})
+	DeployDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "deploy_duration_seconds",
+		Help:      "Time it took to deploy an edge app, including the image pulls.",
+		Buckets:   durationBuckets,
+	})
+	ImagePullBytes = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "image_pull_bytes_total",
+		Help:      "Size of the pulled images.",
+	})
+	ImagePullDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "image_pull_duration_seconds",
+		Help:      "Time it took to pull an image.",
+		Buckets:   durationBuckets,
+	})
+)
+
+// the first connection to the broker is not a reconnect
+var connectedBefore atomic.Bool
+
+func init() {
+	registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace:   namespace,
+			Name:        "info",
+			Help:        "Version of the agent.",
+			ConstLabels: prometheus.Labels{"version": model.Version},
+		}, func() float64 { return 1 }),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "uptime_seconds",
+			Help:      "Time since the agent started.",
+		}, func() float64 { return time.Since(startTime).Seconds() }),
+		MQTTConnected,
+		MQTTReconnects,
+		PublishTimeouts,
+		Commands,
+		DeployDuration,
+		ImagePullBytes,
+		ImagePullDuration,
+	)
+}
+
+// SetMQTTConnected records the state of the connection to the broker
+func SetMQTTConnected(connected bool) {
+	if connected {
+		MQTTConnected.Set(1)
+		if connectedBefore.Swap(true) {
+			MQTTReconnects.Inc()
+		}
+	} else {
+		MQTTConnected.Set(0)
+	}
+}
+
+// Register adds a collector of another package, e.g. for the status of the edge apps
+func Register(collector prometheus.Collector) error {
+	err := registry.Register(collector)
+	if err != nil {
+		return traceutility.Wrap(err)
+	}
+	return nil
+}
+
+// Handler serves the metrics in the Prometheus text format or OpenMetrics, depending on what the scraper accepts
+func Handler() http.Handler {
+	return promhttp.HandlerFor(registry, promhttp.HandlerOpts{EnableOpenMetrics: true})
+}
+
+// Serve serves the metrics on /metrics at addr. It only returns if the listener fails.
+func Serve(addr string) error {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", Handler())
+
+	err := http.ListenAndServe(addr, mux)
+	if err != nil {
+		return traceutility.Wrap(err)
+	}
+	return nil
+}
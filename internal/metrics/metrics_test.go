@@ -0,0 +1,51 @@
+package metrics_test
+
+import (
+	"io"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/weeveiot/weeve-agent/internal/metrics"
+)
+
+func TestSetMQTTConnected(t *testing.T) {
+	assert := assert.New(t)
+
+	metrics.SetMQTTConnected(true)
+	assert.Equal(1.0, testutil.ToFloat64(metrics.MQTTConnected))
+	assert.Equal(0.0, testutil.ToFloat64(metrics.MQTTReconnects))
+
+	metrics.SetMQTTConnected(false)
+	assert.Equal(0.0, testutil.ToFloat64(metrics.MQTTConnected))
+
+	metrics.SetMQTTConnected(true)
+	assert.Equal(1.0, testutil.ToFloat64(metrics.MQTTConnected))
+	assert.Equal(1.0, testutil.ToFloat64(metrics.MQTTReconnects))
+}
+
+func TestHandler(t *testing.T) {
+	assert := assert.New(t)
+
+	metrics.Commands.WithLabelValues("deploy", "success").Inc()
+
+	server := httptest.NewServer(metrics.Handler())
+	defer server.Close()
+
+	resp, err := server.Client().Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Contains(string(body), `weeve_agent_info{version="X.Y.Z"} 1`)
+	assert.Contains(string(body), `weeve_agent_commands_total{command="deploy",result="success"} 1`)
+	assert.Contains(string(body), "weeve_agent_uptime_seconds")
+	assert.Contains(string(body), "go_goroutines")
+}
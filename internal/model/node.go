@@ -1,28 +1,57 @@
 package model
 
+import "time"
+
 var Version string = "X.Y.Z"
 
 type Params struct {
-	Version      bool   `long:"version" short:"v" description:"Print version information and exit"`
-	Broker       string `long:"broker" short:"b" description:"Broker to connect"`
-	NodeId       string `long:"id" short:"i" description:"ID of this node"`
-	NodeName     string `long:"name" short:"n" description:"Name of this node to be registered"`
-	NoTLS        bool   `long:"notls" description:"For developer - disable TLS for MQTT"`
-	Password     string `long:"password" description:"Password for TLS"`
-	RootCertPath string `long:"rootcert" description:"Path to MQTT broker (server) certificate"`
-	LogLevel     string `long:"loglevel" short:"l" description:"Set the logging level"`
-	LogFileName  string `long:"logfilename" description:"Set the name of the log file"`
-	LogSize      int    `long:"logsize" description:"Set the size of each log files (MB)"`
-	LogAge       int    `long:"logage" description:"Set the time period to retain the log files (days)"`
-	LogBackup    int    `long:"logbackup" description:"Set the max number of log files to retain"`
-	LogCompress  bool   `long:"logcompress" description:"To compress the log files"`
-	MqttLogs     bool   `long:"mqttlogs" description:"For developer - Display detailed MQTT logging messages"`
-	Heartbeat    int    `long:"heartbeat" short:"t" description:"Heartbeat time in seconds" `
-	LogSendInvl  int    `long:"logsendinvl" description:"Time interval in sec to send edge app logs" `
-	Stdout       bool   `long:"out" description:"Print logs to stdout"`
-	ConfigPath   string `long:"config" description:"Path to the .json config file"`
-	ManifestPath string `long:"manifest" description:"Path to the .json manifest file"`
-	Delete       bool   `long:"delete" short:"d" description:"Remove node from weeve manager (when uninstalling the agent)"`
+	Version           bool   `long:"version" short:"v" description:"Print version information and exit"`
+	Broker            string `long:"broker" short:"b" description:"Broker to connect"`
+	NodeId            string `long:"id" short:"i" description:"ID of this node"`
+	NodeName          string `long:"name" short:"n" description:"Name of this node to be registered"`
+	NoTLS             bool   `long:"notls" description:"For developer - disable TLS for MQTT"`
+	Password          string `long:"password" description:"Password for TLS"`
+	RootCertPath      string `long:"rootcert" description:"Path to MQTT broker (server) certificate"`
+	ClientCertPath    string `long:"clientcert" description:"Path to the client certificate (PEM) to authenticate at the MQTT broker"`
+	ClientKeyPath     string `long:"clientkey" description:"Path to the key (PEM) of the client certificate"`
+	ClientKeyPassword string `long:"clientkeypassword" description:"Password of the encrypted client key (PKCS#8 with PBES2 and AES, or legacy PEM encryption)"`
+	LogLevel          string `long:"loglevel" short:"l" description:"Set the logging level"`
+	LogFileName       string `long:"logfilename" description:"Set the name of the log file"`
+	LogSize           int    `long:"logsize" description:"Set the size of each log files (MB)"`
+	LogAge            int    `long:"logage" description:"Set the time period to retain the log files (days)"`
+	LogBackup         int    `long:"logbackup" description:"Set the max number of log files to retain"`
+	LogCompress       bool   `long:"logcompress" description:"To compress the log files"`
+	MqttLogs          bool   `long:"mqttlogs" description:"For developer - Display detailed MQTT logging messages"`
+	Heartbeat         int    `long:"heartbeat" short:"t" description:"Heartbeat time in seconds" `
+	LogSendInvl       int    `long:"logsendinvl" description:"Max time in sec edge app log lines wait before they are sent" `
+	LogBatchSize      int    `long:"logbatchsize" description:"Size of the edge app logs (KB) that are sent right away" `
+	LogChunkSize      int    `long:"logchunksize" description:"Max size of the messages (KB) the edge app logs are split into" `
+	LogEncoding       string `long:"logencoding" description:"Compression of the edge app logs (none, gzip, zstd)"`
+	StatusResync      int    `long:"statusresync" description:"Time interval in sec to resync the edge app status with the container runtime" `
+	UpdateMode        string `long:"updatemode" description:"How edge apps are updated to a newer version (bluegreen, recreate)"`
+	UpdateTimeout     int    `long:"updatetimeout" description:"Time in sec a new edge app version has to become healthy during a blue/green update" `
+	UpdateSettle      int    `long:"updatesettle" description:"Time in sec a new edge app version has to stay healthy before the old version is removed" `
+	ReconcileInvl     int    `long:"reconcileinvl" description:"Time interval in sec to reconcile the edge apps with the container runtime" `
+	OutboxSize        int    `long:"outboxsize" description:"Max size of the messages kept while offline (MB)" `
+	OutboxAge         int    `long:"outboxage" description:"Time period to keep messages while offline (hours)" `
+	OutboxDir         string `long:"outboxdir" description:"Directory to keep messages in while offline"`
+	Runtime           string `long:"runtime" description:"Container runtime to run the edge apps with (docker, podman, containerd)"`
+	RuntimeSock       string `long:"runtimesocket" description:"Path to the socket of the container runtime"`
+	MetricsAddr       string `long:"metricsaddr" description:"Address to serve Prometheus metrics on, e.g. 127.0.0.1:9110"`
+	APISocket         string `long:"apisocket" description:"Path to the Unix socket of the local control API"`
+	Stdout            bool   `long:"out" description:"Print logs to stdout"`
+	ConfigPath        string `long:"config" description:"Path to the .json config file"`
+	ManifestPath      string `long:"manifest" description:"Path to the .json manifest file"`
+	Delete            bool   `long:"delete" short:"d" description:"Remove node from weeve manager (when uninstalling the agent)"`
+}
+
+// LogCursor is the position in the log of a container right after a line. Logs that the container engine timestamps
+// are positioned by the timestamp of the line and the number of lines with the same timestamp up to it, log files
+// without timestamps by the offset.
+type LogCursor struct {
+	Time   time.Time `json:"time"`
+	Skip   int       `json:"skip,omitempty"`
+	Offset int64     `json:"offset,omitempty"`
 }
 
 type ManifestUniqueID struct {
@@ -52,6 +81,7 @@ const (
 	EdgeAppRunning    = "Running"
 	EdgeAppStopped    = "Stopped"
 	EdgeAppError      = "Error"
+	EdgeAppDegraded   = "Degraded" // only reported, for running edge apps with an unhealthy module
 	EdgeAppInitiated  = "Initiated"
 	EdgeAppExecuting  = "Executing"
 	EdgeAppUndeployed = "Undeployed"
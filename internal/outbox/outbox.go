@@ -0,0 +1,267 @@
+// Package outbox keeps outgoing messages on disk while the agent is offline, so they can be sent in order once
+// the connection is back.
+//
+// Every message is stored in its own file named after its sequence number. The outbox is bounded by the total
+// size and the age of the messages; when it is full, the oldest messages are dropped first.
+package outbox
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	traceutility "github.com/weeveiot/weeve-agent/internal/utility/trace"
+)
+
+// Policy decides what happens to the queued messages of a topic when a new one is queued
+type Policy int
+
+const (
+	// KeepAll queues every message
+	KeepAll Policy = iota
+	// KeepLatest replaces the queued messages of the topic, e.g. for status messages where only the latest matters
+	KeepLatest
+)
+
+const fileExtension = ".json"
+
+type Message struct {
+	Topic    string
+	Payload  []byte
+	Retained bool
+	Qos      byte
+	Queued   time.Time
+}
+
+type Options struct {
+	// MaxBytes bounds the total size of the queued payloads, 0 means unbounded
+	MaxBytes int64
+	// MaxAge is the time after which queued messages are dropped, 0 means they never expire
+	MaxAge time.Duration
+	// Policy returns the policy of a topic, by default all messages are kept
+	Policy func(topic string) Policy
+}
+
+type Outbox struct {
+	dir     string
+	options Options
+
+	mutex    sync.Mutex
+	entries  []entry
+	size     int64
+	nextSeq  uint64
+	draining bool
+}
+
+// entry is the in-memory index of a queued message, the message itself stays on disk
+type entry struct {
+	seq    uint64
+	topic  string
+	size   int64
+	queued time.Time
+}
+
+// Open loads the outbox from dir, creating the directory if needed
+func Open(dir string, options Options) (*Outbox, error) {
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, traceutility.Wrap(err)
+	}
+
+	o := &Outbox{dir: dir, options: options, nextSeq: 1}
+
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, traceutility.Wrap(err)
+	}
+	for _, file := range files {
+		seq, err := strconv.ParseUint(strings.TrimSuffix(file.Name(), fileExtension), 10, 64)
+		if err != nil || !strings.HasSuffix(file.Name(), fileExtension) {
+			// leftover of an interrupted write
+			os.Remove(filepath.Join(dir, file.Name()))
+			continue
+		}
+
+		msg, err := o.readMessage(seq)
+		if err != nil {
+			log.Warning("Dropped unreadable message ", seq, " from the outbox! CAUSE --> ", err)
+			os.Remove(o.messageFile(seq))
+			continue
+		}
+
+		o.entries = append(o.entries, entry{seq: seq, topic: msg.Topic, size: int64(len(msg.Payload)), queued: msg.Queued})
+		o.size += int64(len(msg.Payload))
+		if seq >= o.nextSeq {
+			o.nextSeq = seq + 1
+		}
+	}
+	sort.Slice(o.entries, func(i, j int) bool { return o.entries[i].seq < o.entries[j].seq })
+
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	o.expire()
+
+	return o, nil
+}
+
+// Len returns the number of queued messages
+func (o *Outbox) Len() int {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	return len(o.entries)
+}
+
+// Enqueue stores the message at the end of the outbox
+func (o *Outbox) Enqueue(msg Message) error {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	if msg.Queued.IsZero() {
+		msg.Queued = time.Now()
+	}
+
+	if o.options.Policy != nil && o.options.Policy(msg.Topic) == KeepLatest {
+		for i := len(o.entries) - 1; i >= 0; i-- {
+			if o.entries[i].topic == msg.Topic {
+				o.remove(i)
+			}
+		}
+	}
+
+	seq := o.nextSeq
+	o.nextSeq++
+
+	encodedJson, err := json.Marshal(msg)
+	if err != nil {
+		return traceutility.Wrap(err)
+	}
+
+	// write to a temporary file first, so that a crash never leaves a partial message behind
+	tmpFile := o.messageFile(seq) + ".tmp"
+	err = os.WriteFile(tmpFile, encodedJson, 0644)
+	if err != nil {
+		return traceutility.Wrap(err)
+	}
+	err = os.Rename(tmpFile, o.messageFile(seq))
+	if err != nil {
+		return traceutility.Wrap(err)
+	}
+
+	o.entries = append(o.entries, entry{seq: seq, topic: msg.Topic, size: int64(len(msg.Payload)), queued: msg.Queued})
+	o.size += int64(len(msg.Payload))
+	o.expire()
+
+	return nil
+}
+
+// Drain publishes the queued messages in order and removes them from the outbox. It stops at the first message
+// that fails to publish and returns the error; the message stays queued. Messages that can't be read are dropped.
+// Messages queued while draining are published as well. If another Drain is running, Drain returns immediately.
+func (o *Outbox) Drain(publish func(Message) error) error {
+	o.mutex.Lock()
+	if o.draining {
+		o.mutex.Unlock()
+		return nil
+	}
+	o.draining = true
+	o.mutex.Unlock()
+
+	for {
+		seq, msg, found := o.next()
+		if !found {
+			return nil
+		}
+
+		err := publish(msg)
+		if err != nil {
+			o.mutex.Lock()
+			o.draining = false
+			o.mutex.Unlock()
+			return traceutility.Wrap(err)
+		}
+
+		o.mutex.Lock()
+		for i, e := range o.entries {
+			if e.seq == seq {
+				o.remove(i)
+				break
+			}
+		}
+		o.mutex.Unlock()
+	}
+}
+
+// next returns the oldest message that can be read. If there is none, the drain is marked as finished under the same
+// lock, so that a message queued right after starts a Drain of its own instead of finding this one still running.
+func (o *Outbox) next() (uint64, Message, bool) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	o.expire()
+	for len(o.entries) > 0 {
+		seq := o.entries[0].seq
+		msg, err := o.readMessage(seq)
+		if err == nil {
+			return seq, msg, true
+		}
+		// a message that can't be read would block all messages behind it
+		log.Warning("Dropped unreadable message ", seq, " from the outbox! CAUSE --> ", err)
+		o.remove(0)
+	}
+
+	o.draining = false
+	return 0, Message{}, false
+}
+
+// expire drops the messages that are too old and then the oldest ones until the outbox fits into its size
+func (o *Outbox) expire() {
+	if o.options.MaxAge > 0 {
+		deadline := time.Now().Add(-o.options.MaxAge)
+		for i := len(o.entries) - 1; i >= 0; i-- {
+			if o.entries[i].queued.Before(deadline) {
+				o.remove(i)
+			}
+		}
+	}
+
+	if o.options.MaxBytes > 0 {
+		for o.size > o.options.MaxBytes && len(o.entries) > 0 {
+			o.remove(0)
+		}
+	}
+}
+
+func (o *Outbox) remove(index int) {
+	os.Remove(o.messageFile(o.entries[index].seq))
+	o.size -= o.entries[index].size
+	o.entries = append(o.entries[:index], o.entries[index+1:]...)
+}
+
+func (o *Outbox) readMessage(seq uint64) (Message, error) {
+	var msg Message
+
+	content, err := os.ReadFile(o.messageFile(seq))
+	if err != nil {
+		return msg, traceutility.Wrap(err)
+	}
+
+	err = json.Unmarshal(content, &msg)
+	if err != nil {
+		return msg, traceutility.Wrap(err)
+	}
+
+	return msg, nil
+}
+
+func (o *Outbox) messageFile(seq uint64) string {
+	return filepath.Join(o.dir, fmt.Sprintf("%020d%s", seq, fileExtension))
+}
@@ -0,0 +1,34 @@
+package outbox
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDrainWhileFinishing(t *testing.T) {
+	assert := assert.New(t)
+	o, err := Open(t.TempDir(), Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a running drain finds the outbox empty and is about to return
+	o.draining = true
+	_, _, found := o.next()
+	assert.False(found)
+
+	// a message queued right then is published by the drain it starts
+	err = o.Enqueue(Message{Topic: "applogs", Payload: []byte("log")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var published []string
+	err = o.Drain(func(msg Message) error {
+		published = append(published, string(msg.Payload))
+		return nil
+	})
+	assert.Nil(err)
+	assert.Equal([]string{"log"}, published)
+	assert.Equal(0, o.Len())
+}
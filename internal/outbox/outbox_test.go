@@ -0,0 +1,167 @@
+package outbox_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/weeveiot/weeve-agent/internal/outbox"
+)
+
+func policy(topic string) outbox.Policy {
+	if topic == "nodestatus" {
+		return outbox.KeepLatest
+	}
+	return outbox.KeepAll
+}
+
+func TestDrainInOrderAfterReopen(t *testing.T) {
+	assert := assert.New(t)
+	dir := t.TempDir()
+
+	box, err := outbox.Open(dir, outbox.Options{Policy: policy})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, msg := range []outbox.Message{
+		{Topic: "applogs", Payload: []byte("1")},
+		{Topic: "nodestatus", Payload: []byte("2")},
+		{Topic: "applogs", Payload: []byte("3")},
+		{Topic: "nodestatus", Payload: []byte("4")},
+	} {
+		err = box.Enqueue(msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	assert.Equal(3, box.Len())
+
+	// the outbox survives a restart of the agent
+	box, err = outbox.Open(dir, outbox.Options{Policy: policy})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var published []string
+	err = box.Drain(func(msg outbox.Message) error {
+		published = append(published, string(msg.Payload))
+		return nil
+	})
+	assert.Nil(err)
+	assert.Equal([]string{"1", "3", "4"}, published)
+	assert.Equal(0, box.Len())
+
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Empty(files)
+}
+
+func TestDrainStopsOnPublishFailure(t *testing.T) {
+	assert := assert.New(t)
+
+	box, err := outbox.Open(t.TempDir(), outbox.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, payload := range []string{"1", "2", "3"} {
+		err = box.Enqueue(outbox.Message{Topic: "applogs", Payload: []byte(payload)})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var published []string
+	err = box.Drain(func(msg outbox.Message) error {
+		if string(msg.Payload) == "2" {
+			return errors.New("not connected")
+		}
+		published = append(published, string(msg.Payload))
+		return nil
+	})
+	assert.NotNil(err)
+	assert.Equal([]string{"1"}, published)
+	assert.Equal(2, box.Len())
+
+	err = box.Drain(func(msg outbox.Message) error {
+		published = append(published, string(msg.Payload))
+		return nil
+	})
+	assert.Nil(err)
+	assert.Equal([]string{"1", "2", "3"}, published)
+}
+
+func TestDrainDropsCorruptMessage(t *testing.T) {
+	assert := assert.New(t)
+	dir := t.TempDir()
+
+	box, err := outbox.Open(dir, outbox.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, payload := range []string{"1", "2", "3"} {
+		err = box.Enqueue(outbox.Message{Topic: "applogs", Payload: []byte(payload)})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the file of the first message is truncated after the outbox was opened, e.g. by a full disk
+	err = os.Truncate(filepath.Join(dir, "00000000000000000001.json"), 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var published []string
+	err = box.Drain(func(msg outbox.Message) error {
+		published = append(published, string(msg.Payload))
+		return nil
+	})
+	assert.Nil(err)
+	assert.Equal([]string{"2", "3"}, published)
+	assert.Equal(0, box.Len())
+
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Empty(files)
+}
+
+func TestBounds(t *testing.T) {
+	assert := assert.New(t)
+
+	box, err := outbox.Open(t.TempDir(), outbox.Options{MaxBytes: 10, MaxAge: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// too old to be kept
+	err = box.Enqueue(outbox.Message{Topic: "applogs", Payload: []byte("old"), Queued: time.Now().Add(-2 * time.Hour)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(0, box.Len())
+
+	// the oldest messages are dropped to stay within the size
+	for _, payload := range []string{"aaaa", "bbbb", "cccc"} {
+		err = box.Enqueue(outbox.Message{Topic: "applogs", Payload: []byte(payload)})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	assert.Equal(2, box.Len())
+
+	var published []string
+	err = box.Drain(func(msg outbox.Message) error {
+		published = append(published, string(msg.Payload))
+		return nil
+	})
+	assert.Nil(err)
+	assert.Equal([]string{"bbbb", "cccc"}, published)
+}
@@ -0,0 +1,73 @@
+// Package podman runs edge apps on Podman through the Docker compatible API that the Podman service serves next to its
+// own libpod API, on the same socket. The compatible API covers all the agent needs: containers, images, networks,
+// volumes, logs and events, with label filters and registry authentication. The Go bindings of the libpod API pull in
+// the storage and image libraries of Podman, which by default need cgo and C libraries like gpgme. The agent is built
+// statically for all node architectures, so the bindings would cost more than the few places where Podman differs,
+// which are handled here.
+package podman
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/client"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/weeveiot/weeve-agent/internal/docker"
+	traceutility "github.com/weeveiot/weeve-agent/internal/utility/trace"
+)
+
+const rootfulSocketPath = "/run/podman/podman.sock"
+
+// Podman doesn't support the "local" log driver of Docker, k8s-file is its file based equivalent with rotation
+var k8sFileLogConfig = container.LogConfig{
+	Type: "k8s-file",
+	Config: map[string]string{
+		"max-size": "20mb",
+	},
+}
+
+// Runtime runs edge apps on Podman through the Docker compatible endpoints of the Podman REST API.
+// This covers containers, images, networks and logs, including label filters and registry authentication.
+type Runtime struct {
+	*docker.Runtime
+}
+
+// NewRuntime connects to the Podman REST API on the given unix socket.
+// If socketPath is empty, the default socket of the rootful or rootless Podman service is used.
+func NewRuntime(socketPath string) (*Runtime, error) {
+	if socketPath == "" {
+		socketPath = DefaultSocketPath()
+	}
+	log.Debug("Podman socket path >> ", socketPath)
+
+	_, err := os.Stat(socketPath)
+	if err != nil {
+		return nil, fmt.Errorf("podman socket %s is not available, make sure the podman.socket service is enabled: %w", socketPath, err)
+	}
+
+	dockerRuntime, err := docker.NewRuntimeWithOpts(k8sFileLogConfig, client.WithHost("unix://"+socketPath))
+	if err != nil {
+		return nil, traceutility.Wrap(err)
+	}
+
+	return &Runtime{Runtime: dockerRuntime}, nil
+}
+
+// DefaultSocketPath returns the socket of the system wide Podman service for root and the user's service otherwise
+func DefaultSocketPath() string {
+	return socketPath(os.Geteuid(), os.Getenv("XDG_RUNTIME_DIR"))
+}
+
+func socketPath(euid int, runtimeDir string) string {
+	if euid == 0 {
+		return rootfulSocketPath
+	}
+
+	if runtimeDir == "" {
+		runtimeDir = fmt.Sprintf("/run/user/%d", euid)
+	}
+	return filepath.Join(runtimeDir, "podman", "podman.sock")
+}
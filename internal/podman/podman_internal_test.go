@@ -0,0 +1,76 @@
+package podman
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/weeveiot/weeve-agent/internal/manifest"
+)
+
+func TestSocketPath(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal("/run/podman/podman.sock", socketPath(0, "/run/user/0"))
+	assert.Equal("/run/user/1000/podman/podman.sock", socketPath(1000, ""))
+	assert.Equal("/tmp/runtime/podman/podman.sock", socketPath(1000, "/tmp/runtime"))
+}
+
+func TestNewRuntime_NoSocket(t *testing.T) {
+	_, err := NewRuntime(filepath.Join(t.TempDir(), "podman.sock"))
+	assert.ErrorContains(t, err, "podman.socket")
+}
+
+func TestCreateContainer_LogConfig(t *testing.T) {
+	assert := assert.New(t)
+
+	var hostConfig container.HostConfig
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch {
+		case strings.HasSuffix(req.URL.Path, "/_ping"):
+			w.Header().Set("API-Version", "1.41")
+		case strings.HasSuffix(req.URL.Path, "/containers/create"):
+			var body struct {
+				HostConfig container.HostConfig
+			}
+			err := json.NewDecoder(req.Body).Decode(&body)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			hostConfig = body.HostConfig
+			fmt.Fprint(w, `{"Id": "module"}`)
+		case strings.HasSuffix(req.URL.Path, "/containers/module/start"):
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			http.NotFound(w, req)
+		}
+	}))
+	socket := filepath.Join(t.TempDir(), "podman.sock")
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.Listener = listener
+	server.Start()
+	t.Cleanup(server.Close)
+
+	rt, err := NewRuntime(socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = rt.CreateAndStartContainer(manifest.ContainerConfig{ContainerName: "module", ImageNameFull: "module:latest"})
+	assert.NoError(err)
+
+	// Podman has no "local" log driver, the logs are rotated by k8s-file instead
+	assert.Equal("k8s-file", hostConfig.LogConfig.Type)
+	assert.Equal(map[string]string{"max-size": "20mb"}, hostConfig.LogConfig.Config)
+}
@@ -0,0 +1,922 @@
+// Package fake provides an in-memory container runtime for testing the edge app lifecycle without a container engine.
+package fake
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/events"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/docker/docker/api/types/volume"
+
+	"github.com/weeveiot/weeve-agent/internal/manifest"
+	"github.com/weeveiot/weeve-agent/internal/model"
+	"github.com/weeveiot/weeve-agent/internal/runtime"
+	ioutility "github.com/weeveiot/weeve-agent/internal/utility/io"
+)
+
+const manifestNamelength = 11
+const eventBufferSize = 100
+const logPollInterval = 10 * time.Millisecond
+
+type fakeImage struct {
+	id   string
+	name string
+}
+
+type fakeContainer struct {
+	id           string
+	name         string
+	image        string
+	imageID      string
+	env          []string
+	labels       map[string]string
+	networkName  string
+	state        string
+	exitCode     int
+	oomKilled    bool
+	healthcheck  bool
+	health       string
+	restartCount int
+	stats        types.StatsJSON
+	logs         []logEntry
+	volumes      []string
+}
+
+type fakeNetwork struct {
+	id     string
+	name   string
+	labels map[string]string
+}
+
+type fakeVolume struct {
+	name   string
+	labels map[string]string
+	size   int64
+}
+
+type subscriber struct {
+	messages chan events.Message
+	errs     chan error
+}
+
+type logEntry struct {
+	time   time.Time
+	stream string
+	line   string
+}
+
+// Runtime simulates images, containers, networks and logs in memory
+type Runtime struct {
+	mutex         sync.Mutex
+	images        map[string]*fakeImage // key: normalized image name
+	containers    map[string]*fakeContainer
+	networks      map[string]*fakeNetwork
+	volumes       map[string]*fakeVolume
+	order         []string // container IDs in creation order
+	networkCount  int
+	pullFailures  map[string]error
+	startFailures map[string]error
+	startCrashes  map[string]int
+	startHealth   map[string]string
+	pulls         []string
+	reads         int
+	subscribers   map[*subscriber]struct{}
+}
+
+var _ runtime.Runtime = (*Runtime)(nil)
+
+func NewRuntime() *Runtime {
+	return &Runtime{
+		images:        make(map[string]*fakeImage),
+		containers:    make(map[string]*fakeContainer),
+		networks:      make(map[string]*fakeNetwork),
+		volumes:       make(map[string]*fakeVolume),
+		pullFailures:  make(map[string]error),
+		startFailures: make(map[string]error),
+		startCrashes:  make(map[string]int),
+		startHealth:   make(map[string]string),
+		subscribers:   make(map[*subscriber]struct{}),
+	}
+}
+
+// AddImage makes an image available locally as if it had been pulled before
+func (r *Runtime) AddImage(imageName string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.addImage(imageName)
+}
+
+// FailPull makes every pull of the image fail with err until it is cleared by passing a nil error
+func (r *Runtime) FailPull(imageName string, err error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	setFailure(r.pullFailures, imageName, err)
+}
+
+// FailStart makes every start of a container created from the image fail with err until it is cleared by passing a nil error
+func (r *Runtime) FailStart(imageName string, err error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	setFailure(r.startFailures, imageName, err)
+}
+
+// CrashOnStart makes every container created from the image exit with exitCode right after it started.
+// Passing a negative exitCode clears it.
+func (r *Runtime) CrashOnStart(imageName string, exitCode int) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if exitCode < 0 {
+		delete(r.startCrashes, normalizeImageName(imageName))
+	} else {
+		r.startCrashes[normalizeImageName(imageName)] = exitCode
+	}
+}
+
+// HealthOnStart makes the healthcheck of every container created from the image report status right after the
+// container started, instead of "starting". Passing an empty status clears it.
+func (r *Runtime) HealthOnStart(imageName string, status string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if status == "" {
+		delete(r.startHealth, normalizeImageName(imageName))
+	} else {
+		r.startHealth[normalizeImageName(imageName)] = status
+	}
+}
+
+// SetHealth changes the health status of a container that has a healthcheck
+func (r *Runtime) SetHealth(containerID string, status string) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	cont, err := r.getContainer(containerID)
+	if err != nil {
+		return err
+	}
+	if !cont.healthcheck {
+		return fmt.Errorf("container %s has no healthcheck", containerID)
+	}
+	cont.health = status
+	r.emit(cont, "health_status: "+status, nil)
+
+	return nil
+}
+
+// CrashContainer stops a running container with the given exit code
+func (r *Runtime) CrashContainer(containerID string, exitCode int) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	cont, err := r.getContainer(containerID)
+	if err != nil {
+		return err
+	}
+	cont.state = "exited"
+	cont.exitCode = exitCode
+	r.emit(cont, "die", map[string]string{"exitCode": strconv.Itoa(exitCode)})
+
+	return nil
+}
+
+// OOMKillContainer stops a running container as if the kernel killed it for running out of memory
+func (r *Runtime) OOMKillContainer(containerID string) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	cont, err := r.getContainer(containerID)
+	if err != nil {
+		return err
+	}
+	cont.state = "exited"
+	cont.exitCode = 137
+	cont.oomKilled = true
+	r.emit(cont, "oom", nil)
+	r.emit(cont, "die", map[string]string{"exitCode": "137"})
+
+	return nil
+}
+
+// FailEvents ends all event streams with err
+func (r *Runtime) FailEvents(err error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	for sub := range r.subscribers {
+		sub.errs <- err
+		delete(r.subscribers, sub)
+	}
+}
+
+// RestartContainer simulates the engine restarting a container according to its restart policy
+func (r *Runtime) RestartContainer(containerID string) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	cont, err := r.getContainer(containerID)
+	if err != nil {
+		return err
+	}
+	cont.state = "running"
+	cont.exitCode = 0
+	cont.oomKilled = false
+	cont.restartCount++
+	r.emit(cont, "start", nil)
+
+	return nil
+}
+
+// SetStats sets the resource usage that is reported for a container. The sample is taken now, unless stats.Read is set.
+func (r *Runtime) SetStats(containerID string, stats types.StatsJSON) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	cont, err := r.getContainer(containerID)
+	if err != nil {
+		return err
+	}
+	cont.stats = stats
+
+	return nil
+}
+
+// AppendLogs adds log lines to the stdout of a container, timestamped with the current time
+func (r *Runtime) AppendLogs(containerID string, lines ...string) error {
+	return r.appendLogs(containerID, runtime.Stdout, lines)
+}
+
+// AppendErrLogs adds log lines to the stderr of a container, timestamped with the current time
+func (r *Runtime) AppendErrLogs(containerID string, lines ...string) error {
+	return r.appendLogs(containerID, runtime.Stderr, lines)
+}
+
+func (r *Runtime) appendLogs(containerID string, stream string, lines []string) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	cont, err := r.getContainer(containerID)
+	if err != nil {
+		return err
+	}
+	for _, line := range lines {
+		cont.logs = append(cont.logs, logEntry{time: time.Now().UTC(), stream: stream, line: line})
+	}
+
+	return nil
+}
+
+// SetVolumeSize sets the disk space that is reported for a volume
+func (r *Runtime) SetVolumeSize(volumeName string, size int64) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	vol, found := r.volumes[volumeName]
+	if !found {
+		return fmt.Errorf("no such volume: %s", volumeName)
+	}
+	vol.size = size
+
+	return nil
+}
+
+// Reads returns how often the containers were listed or inspected so far
+func (r *Runtime) Reads() int {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	return r.reads
+}
+
+// Pulls returns the names of all images pulled so far, in order
+func (r *Runtime) Pulls() []string {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	return append([]string(nil), r.pulls...)
+}
+
+func (r *Runtime) CreateAndStartContainer(containerConfig manifest.ContainerConfig) (string, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	img, found := r.images[normalizeImageName(containerConfig.ImageNameFull)]
+	if !found {
+		return "", fmt.Errorf("no such image: %s", containerConfig.ImageNameFull)
+	}
+	if _, found := r.networks[containerConfig.NetworkName]; !found {
+		return "", fmt.Errorf("network %s not found", containerConfig.NetworkName)
+	}
+	for _, cont := range r.containers {
+		if cont.name == containerConfig.ContainerName {
+			return "", fmt.Errorf("container name %s is already in use", containerConfig.ContainerName)
+		}
+	}
+
+	labels := make(map[string]string, len(containerConfig.Labels))
+	for key, value := range containerConfig.Labels {
+		labels[key] = value
+	}
+
+	cont := &fakeContainer{
+		id:          makeID("container", containerConfig.ContainerName, len(r.order)),
+		name:        containerConfig.ContainerName,
+		image:       containerConfig.ImageNameFull,
+		imageID:     img.id,
+		env:         append([]string(nil), containerConfig.EnvArgs...),
+		labels:      labels,
+		networkName: containerConfig.NetworkName,
+		state:       "created",
+		healthcheck: containerConfig.Healthcheck != nil && len(containerConfig.Healthcheck.Test) > 0 && containerConfig.Healthcheck.Test[0] != "NONE",
+	}
+	// like the engine, volumes are created on first use and kept when the container is removed
+	for _, mnt := range containerConfig.MountConfigs {
+		if mnt.Type != mount.TypeVolume {
+			continue
+		}
+		if _, found := r.volumes[mnt.Source]; !found {
+			vol := &fakeVolume{name: mnt.Source, labels: make(map[string]string)}
+			if mnt.VolumeOptions != nil {
+				for key, value := range mnt.VolumeOptions.Labels {
+					vol.labels[key] = value
+				}
+			}
+			r.volumes[mnt.Source] = vol
+		}
+		cont.volumes = append(cont.volumes, mnt.Source)
+	}
+	r.containers[cont.id] = cont
+	r.order = append(r.order, cont.id)
+	r.emit(cont, "create", nil)
+
+	return cont.id, r.startContainer(cont)
+}
+
+func (r *Runtime) StartContainer(containerID string) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	cont, err := r.getContainer(containerID)
+	if err != nil {
+		return err
+	}
+
+	return r.startContainer(cont)
+}
+
+func (r *Runtime) StopContainer(containerID string) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	cont, err := r.getContainer(containerID)
+	if err != nil {
+		return err
+	}
+	if cont.state == "running" || cont.state == "restarting" {
+		cont.state = "exited"
+		cont.exitCode = 0
+		r.emit(cont, "die", map[string]string{"exitCode": "0"})
+	}
+
+	return nil
+}
+
+func (r *Runtime) StopAndRemoveContainer(containerID string) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	cont, err := r.getContainer(containerID)
+	if err != nil {
+		return err
+	}
+	delete(r.containers, containerID)
+	r.emit(cont, "destroy", nil)
+	for i, id := range r.order {
+		if id == containerID {
+			r.order = append(r.order[:i], r.order[i+1:]...)
+			break
+		}
+	}
+
+	return nil
+}
+
+func (r *Runtime) ReadAllContainers() ([]types.Container, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	return r.listContainers(func(*fakeContainer) bool { return true }), nil
+}
+
+func (r *Runtime) ReadEdgeAppContainers(manifestUniqueID model.ManifestUniqueID) ([]types.Container, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	return r.listContainers(func(cont *fakeContainer) bool {
+		return cont.labels["manifestUniqueID"] == manifestUniqueID.String()
+	}), nil
+}
+
+func (r *Runtime) InspectContainer(containerID string) (types.ContainerJSON, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.reads++
+
+	cont, err := r.getContainer(containerID)
+	if err != nil {
+		return types.ContainerJSON{}, err
+	}
+
+	state := &types.ContainerState{
+		Status:     cont.state,
+		Running:    cont.state == "running",
+		Restarting: cont.state == "restarting",
+		ExitCode:   cont.exitCode,
+		OOMKilled:  cont.oomKilled,
+	}
+	if cont.healthcheck && cont.health != "" {
+		state.Health = &types.Health{Status: cont.health}
+	}
+
+	return types.ContainerJSON{
+		ContainerJSONBase: &types.ContainerJSONBase{
+			ID:           cont.id,
+			Name:         "/" + cont.name,
+			Image:        cont.imageID,
+			RestartCount: cont.restartCount,
+			State:        state,
+		},
+		Config: &container.Config{
+			Image:  cont.image,
+			Env:    append([]string(nil), cont.env...),
+			Labels: cont.labels,
+		},
+	}, nil
+}
+
+func (r *Runtime) ReadContainerStats(containerID string) (types.StatsJSON, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	cont, err := r.getContainer(containerID)
+	if err != nil {
+		return types.StatsJSON{}, err
+	}
+
+	stats := cont.stats
+	stats.ID = cont.id
+	stats.Name = "/" + cont.name
+	if stats.Read.IsZero() {
+		stats.Read = time.Now()
+	}
+
+	return stats, nil
+}
+
+func (r *Runtime) PullImage(authConfig types.AuthConfig, imageName string) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if err, found := r.pullFailures[normalizeImageName(imageName)]; found {
+		return err
+	}
+	r.addImage(imageName)
+	r.pulls = append(r.pulls, imageName)
+
+	return nil
+}
+
+func (r *Runtime) ImageExists(imageName string) (bool, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	_, found := r.images[normalizeImageName(imageName)]
+	return found, nil
+}
+
+func (r *Runtime) ImageRemove(imageID string) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	for _, cont := range r.containers {
+		if cont.imageID == imageID {
+			return fmt.Errorf("conflict: unable to remove image %s, image is being used by container %s", imageID, cont.id)
+		}
+	}
+	for name, img := range r.images {
+		if img.id == imageID {
+			delete(r.images, name)
+			return nil
+		}
+	}
+
+	return fmt.Errorf("no such image: %s", imageID)
+}
+
+func (r *Runtime) GetImagesByName(images []string) ([]types.ImageSummary, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	var summaries []types.ImageSummary
+	for _, imageName := range images {
+		if img, found := r.images[normalizeImageName(imageName)]; found {
+			summaries = append(summaries, types.ImageSummary{ID: img.id, RepoTags: []string{img.name}})
+		}
+	}
+
+	return summaries, nil
+}
+
+func (r *Runtime) CreateNetwork(name string, labels map[string]string) (string, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if name == "" {
+		return "", errors.New("failed to generate network name")
+	}
+	if len(name) > manifestNamelength {
+		name = name[:manifestNamelength]
+	}
+	r.networkCount++
+	networkName := strings.ReplaceAll(fmt.Sprintf("%s_%03d", name, r.networkCount), " ", "")
+
+	networkLabels := make(map[string]string, len(labels))
+	for key, value := range labels {
+		networkLabels[key] = value
+	}
+	r.networks[networkName] = &fakeNetwork{
+		id:     makeID("network", networkName, r.networkCount),
+		name:   networkName,
+		labels: networkLabels,
+	}
+
+	return networkName, nil
+}
+
+func (r *Runtime) ReadEdgeAppNetworks(manifestUniqueID model.ManifestUniqueID) ([]types.NetworkResource, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	var networks []types.NetworkResource
+	for _, net := range r.networks {
+		if net.labels["manifestUniqueID"] == manifestUniqueID.String() {
+			networks = append(networks, types.NetworkResource{ID: net.id, Name: net.name, Labels: net.labels})
+		}
+	}
+
+	return networks, nil
+}
+
+func (r *Runtime) ReadAllEdgeAppNetworks() ([]types.NetworkResource, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	var networks []types.NetworkResource
+	for _, net := range r.networks {
+		if _, found := net.labels["manifestUniqueID"]; found {
+			networks = append(networks, types.NetworkResource{ID: net.id, Name: net.name, Labels: net.labels})
+		}
+	}
+
+	return networks, nil
+}
+
+// NetworkPrune removes the edge app networks that no container is attached to
+func (r *Runtime) NetworkPrune(manifestUniqueID model.ManifestUniqueID) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	for name, net := range r.networks {
+		if net.labels["manifestUniqueID"] != manifestUniqueID.String() {
+			continue
+		}
+		inUse := false
+		for _, cont := range r.containers {
+			if cont.networkName == name {
+				inUse = true
+				break
+			}
+		}
+		if !inUse {
+			delete(r.networks, name)
+		}
+	}
+
+	return nil
+}
+
+func (r *Runtime) ReadEdgeAppVolumes(manifestUniqueID model.ManifestUniqueID) ([]*volume.Volume, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	var volumes []*volume.Volume
+	for _, vol := range r.volumes {
+		if vol.labels["manifestUniqueID"] != manifestUniqueID.String() {
+			continue
+		}
+		labels := make(map[string]string, len(vol.labels))
+		for key, value := range vol.labels {
+			labels[key] = value
+		}
+		volumes = append(volumes, &volume.Volume{
+			Name:      vol.name,
+			Driver:    "local",
+			Labels:    labels,
+			UsageData: &volume.UsageData{Size: vol.size, RefCount: int64(r.volumeRefCount(vol.name))},
+		})
+	}
+	sort.Slice(volumes, func(i, j int) bool { return volumes[i].Name < volumes[j].Name })
+
+	return volumes, nil
+}
+
+// RemoveEdgeAppVolumes removes the volumes of an edge app, volumes that are in use fail the removal
+func (r *Runtime) RemoveEdgeAppVolumes(manifestUniqueID model.ManifestUniqueID) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	for name, vol := range r.volumes {
+		if vol.labels["manifestUniqueID"] != manifestUniqueID.String() {
+			continue
+		}
+		if r.volumeRefCount(name) > 0 {
+			return fmt.Errorf("volume %s is in use", name)
+		}
+		delete(r.volumes, name)
+	}
+
+	return nil
+}
+
+func (r *Runtime) ReadContainerLogs(containerID string, since string, until string) ([]runtime.LogLine, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	cont, err := r.getContainer(containerID)
+	if err != nil {
+		return nil, err
+	}
+
+	sinceTime, err := parseLogTime(since)
+	if err != nil {
+		return nil, err
+	}
+	untilTime, err := parseLogTime(until)
+	if err != nil {
+		return nil, err
+	}
+
+	logLines := []runtime.LogLine{}
+	position := runtime.NewLogPosition(model.LogCursor{})
+	for _, entry := range cont.logs {
+		cursor, _ := position.Advance(entry.time)
+		if !sinceTime.IsZero() && entry.time.Before(sinceTime) {
+			continue
+		}
+		if !untilTime.IsZero() && entry.time.After(untilTime) {
+			continue
+		}
+		logLines = append(logLines, runtime.LogLine{Time: entry.time, Stream: entry.stream, Line: entry.line, Cursor: cursor})
+	}
+
+	return logLines, nil
+}
+
+// FollowContainerLogs polls the log lines of the container, like the engines it ends the stream once the container stopped
+func (r *Runtime) FollowContainerLogs(ctx context.Context, containerID string, cursor model.LogCursor) (<-chan runtime.LogLine, <-chan error) {
+	lines := make(chan runtime.LogLine)
+	errs := make(chan error, 1)
+
+	go func() {
+		defer close(lines)
+
+		position := runtime.NewLogPosition(cursor)
+		next := 0
+		stopped := false
+		for {
+			r.mutex.Lock()
+			cont, err := r.getContainer(containerID)
+			if err != nil {
+				r.mutex.Unlock()
+				errs <- err
+				return
+			}
+			entries := append([]logEntry(nil), cont.logs[next:]...)
+			running := cont.state == "running" || cont.state == "restarting"
+			r.mutex.Unlock()
+
+			next += len(entries)
+			for _, entry := range entries {
+				cursor, ok := position.Advance(entry.time)
+				if !ok {
+					continue
+				}
+				select {
+				case lines <- runtime.LogLine{Time: entry.time, Stream: entry.stream, Line: entry.line, Cursor: cursor}:
+				case <-ctx.Done():
+					return
+				}
+			}
+
+			if stopped {
+				return
+			}
+			if !running {
+				stopped = true
+				continue
+			}
+			select {
+			case <-time.After(logPollInterval):
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return lines, errs
+}
+
+func (r *Runtime) WatchEdgeAppEvents(ctx context.Context) (<-chan events.Message, <-chan error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	sub := &subscriber{
+		messages: make(chan events.Message, eventBufferSize),
+		errs:     make(chan error, 1),
+	}
+	r.subscribers[sub] = struct{}{}
+
+	go func() {
+		<-ctx.Done()
+		r.mutex.Lock()
+		defer r.mutex.Unlock()
+		if _, found := r.subscribers[sub]; found {
+			sub.errs <- ctx.Err()
+			delete(r.subscribers, sub)
+		}
+	}()
+
+	return sub.messages, sub.errs
+}
+
+func (r *Runtime) startContainer(cont *fakeContainer) error {
+	if err, found := r.startFailures[normalizeImageName(cont.image)]; found {
+		return err
+	}
+	cont.state = "running"
+	cont.exitCode = 0
+	cont.oomKilled = false
+	r.emit(cont, "start", nil)
+
+	if cont.healthcheck {
+		cont.health = types.Starting
+		if status, found := r.startHealth[normalizeImageName(cont.image)]; found {
+			cont.health = status
+			r.emit(cont, "health_status: "+status, nil)
+		}
+	}
+
+	if exitCode, found := r.startCrashes[normalizeImageName(cont.image)]; found {
+		cont.state = "exited"
+		cont.exitCode = exitCode
+		r.emit(cont, "die", map[string]string{"exitCode": strconv.Itoa(exitCode)})
+	}
+
+	return nil
+}
+
+// emit sends a container event to all subscribers. Events are dropped for subscribers that don't keep up.
+func (r *Runtime) emit(cont *fakeContainer, action string, attributes map[string]string) {
+	if _, found := cont.labels["manifestUniqueID"]; !found {
+		return
+	}
+
+	actorAttributes := map[string]string{
+		"name":  cont.name,
+		"image": cont.image,
+	}
+	for key, value := range cont.labels {
+		actorAttributes[key] = value
+	}
+	for key, value := range attributes {
+		actorAttributes[key] = value
+	}
+
+	now := time.Now()
+	msg := events.Message{
+		Type:     events.ContainerEventType,
+		Action:   action,
+		Actor:    events.Actor{ID: cont.id, Attributes: actorAttributes},
+		Time:     now.Unix(),
+		TimeNano: now.UnixNano(),
+	}
+	for sub := range r.subscribers {
+		select {
+		case sub.messages <- msg:
+		default:
+		}
+	}
+}
+
+func (r *Runtime) volumeRefCount(volumeName string) int {
+	count := 0
+	for _, cont := range r.containers {
+		for _, name := range cont.volumes {
+			if name == volumeName {
+				count++
+			}
+		}
+	}
+	return count
+}
+
+func (r *Runtime) getContainer(containerID string) (*fakeContainer, error) {
+	cont, found := r.containers[containerID]
+	if !found {
+		return nil, fmt.Errorf("no such container: %s", containerID)
+	}
+	return cont, nil
+}
+
+func (r *Runtime) listContainers(filter func(*fakeContainer) bool) []types.Container {
+	r.reads++
+	containers := []types.Container{}
+	for _, id := range r.order {
+		cont := r.containers[id]
+		if !filter(cont) {
+			continue
+		}
+		containers = append(containers, types.Container{
+			ID:      cont.id,
+			Names:   []string{"/" + cont.name},
+			Image:   cont.image,
+			ImageID: cont.imageID,
+			Labels:  cont.labels,
+			State:   cont.state,
+			Status:  containerStatus(cont),
+		})
+	}
+	return containers
+}
+
+func (r *Runtime) addImage(imageName string) {
+	name := normalizeImageName(imageName)
+	if _, found := r.images[name]; !found {
+		r.images[name] = &fakeImage{id: makeID("image", name, 0), name: name}
+	}
+}
+
+func setFailure(failures map[string]error, imageName string, err error) {
+	if err == nil {
+		delete(failures, normalizeImageName(imageName))
+	} else {
+		failures[normalizeImageName(imageName)] = err
+	}
+}
+
+func containerStatus(cont *fakeContainer) string {
+	switch cont.state {
+	case "running":
+		return "Up"
+	case "exited":
+		return fmt.Sprintf("Exited (%d)", cont.exitCode)
+	default:
+		return ioutility.FirstToUpper(cont.state)
+	}
+}
+
+// normalizeImageName adds the implicit "latest" tag, so that "name" and "name:latest" refer to the same image
+func normalizeImageName(imageName string) string {
+	if strings.LastIndex(imageName, ":") <= strings.LastIndex(imageName, "/") {
+		return imageName + ":latest"
+	}
+	return imageName
+}
+
+func makeID(kind string, name string, count int) string {
+	id := fmt.Sprintf("%x", sha256.Sum256([]byte(fmt.Sprint(kind, name, count))))
+	if kind == "image" {
+		return "sha256:" + id
+	}
+	return id
+}
+
+func parseLogTime(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	return time.Parse(time.RFC3339Nano, value)
+}
@@ -0,0 +1,301 @@
+package fake_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/weeveiot/weeve-agent/internal/manifest"
+	"github.com/weeveiot/weeve-agent/internal/model"
+	"github.com/weeveiot/weeve-agent/internal/runtime/fake"
+)
+
+var uniqueID = model.ManifestUniqueID{ID: "app"}
+
+// createContainer pulls the image and starts a container of the edge app on a new network
+func createContainer(t *testing.T, rt *fake.Runtime, name string, image string) string {
+	err := rt.PullImage(types.AuthConfig{}, image)
+	if err != nil {
+		t.Fatal(err)
+	}
+	networkName, err := rt.CreateNetwork("app network", map[string]string{"manifestUniqueID": uniqueID.String()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	containerID, err := rt.CreateAndStartContainer(manifest.ContainerConfig{
+		ContainerName: name,
+		ImageNameFull: image,
+		NetworkName:   networkName,
+		Labels:        map[string]string{"manifestUniqueID": uniqueID.String()},
+		EnvArgs:       []string{"KEY=value"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return containerID
+}
+
+func TestImages(t *testing.T) {
+	assert := assert.New(t)
+	rt := fake.NewRuntime()
+
+	pullErr := errors.New("manifest unknown")
+	rt.FailPull("weevenetwork/ingress:v1", pullErr)
+	assert.Equal(pullErr, rt.PullImage(types.AuthConfig{}, "weevenetwork/ingress:v1"))
+	rt.FailPull("weevenetwork/ingress:v1", nil)
+	assert.NoError(rt.PullImage(types.AuthConfig{}, "weevenetwork/ingress:v1"))
+	assert.Equal([]string{"weevenetwork/ingress:v1"}, rt.Pulls())
+
+	// an image without tag is the latest one
+	rt.AddImage("weevenetwork/egress")
+	exists, err := rt.ImageExists("weevenetwork/egress:latest")
+	assert.NoError(err)
+	assert.True(exists)
+
+	images, err := rt.GetImagesByName([]string{"weevenetwork/ingress:v1", "weevenetwork/egress", "unknown"})
+	assert.NoError(err)
+	if assert.Len(images, 2) {
+		assert.NoError(rt.ImageRemove(images[1].ID))
+	}
+	exists, err = rt.ImageExists("weevenetwork/egress")
+	assert.NoError(err)
+	assert.False(exists)
+	assert.Error(rt.ImageRemove(images[1].ID))
+}
+
+func TestContainerLifecycle(t *testing.T) {
+	assert := assert.New(t)
+	rt := fake.NewRuntime()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	messages, _ := rt.WatchEdgeAppEvents(ctx)
+
+	containerID := createContainer(t, rt, "ingress", "weevenetwork/ingress:v1")
+	containers, err := rt.ReadEdgeAppContainers(uniqueID)
+	assert.NoError(err)
+	if assert.Len(containers, 1) {
+		assert.Equal("/ingress", containers[0].Names[0])
+		assert.Equal("running", containers[0].State)
+	}
+	info, err := rt.InspectContainer(containerID)
+	assert.NoError(err)
+	assert.Equal([]string{"KEY=value"}, info.Config.Env)
+
+	// the image of a container can't be removed
+	images, err := rt.GetImagesByName([]string{"weevenetwork/ingress:v1"})
+	assert.NoError(err)
+	assert.Error(rt.ImageRemove(images[0].ID))
+
+	// a name is used once only
+	networks, err := rt.ReadEdgeAppNetworks(uniqueID)
+	assert.NoError(err)
+	_, err = rt.CreateAndStartContainer(manifest.ContainerConfig{ContainerName: "ingress", ImageNameFull: "weevenetwork/ingress:v1", NetworkName: networks[0].Name})
+	assert.Error(err)
+
+	assert.NoError(rt.StopContainer(containerID))
+	assert.NoError(rt.StartContainer(containerID))
+	assert.NoError(rt.CrashContainer(containerID, 2))
+	assert.NoError(rt.RestartContainer(containerID))
+	info, err = rt.InspectContainer(containerID)
+	assert.NoError(err)
+	assert.True(info.State.Running)
+	assert.Equal(1, info.RestartCount)
+
+	assert.NoError(rt.StopAndRemoveContainer(containerID))
+	_, err = rt.InspectContainer(containerID)
+	assert.Error(err)
+
+	// the network is pruned once no container is attached to it
+	assert.NoError(rt.NetworkPrune(uniqueID))
+	networks, err = rt.ReadEdgeAppNetworks(uniqueID)
+	assert.NoError(err)
+	assert.Empty(networks)
+
+	var actions []string
+	for len(messages) > 0 {
+		msg := <-messages
+		assert.Equal(containerID, msg.Actor.ID)
+		assert.Equal(uniqueID.String(), msg.Actor.Attributes["manifestUniqueID"])
+		actions = append(actions, msg.Action)
+	}
+	assert.Equal([]string{"create", "start", "die", "start", "die", "start", "destroy"}, actions)
+}
+
+func TestFailures(t *testing.T) {
+	assert := assert.New(t)
+	rt := fake.NewRuntime()
+
+	startErr := errors.New("port is already allocated")
+	rt.FailStart("weevenetwork/broken:v1", startErr)
+	err := rt.PullImage(types.AuthConfig{}, "weevenetwork/broken:v1")
+	assert.NoError(err)
+	networkName, err := rt.CreateNetwork("app", map[string]string{"manifestUniqueID": uniqueID.String()})
+	assert.NoError(err)
+	_, err = rt.CreateAndStartContainer(manifest.ContainerConfig{ContainerName: "broken", ImageNameFull: "weevenetwork/broken:v1", NetworkName: networkName})
+	assert.Equal(startErr, err)
+
+	rt.CrashOnStart("weevenetwork/crashing:v1", 3)
+	containerID := createContainer(t, rt, "crashing", "weevenetwork/crashing:v1")
+	info, err := rt.InspectContainer(containerID)
+	assert.NoError(err)
+	assert.Equal("exited", info.State.Status)
+	assert.Equal(3, info.State.ExitCode)
+
+	assert.NoError(rt.StartContainer(containerID))
+	assert.NoError(rt.OOMKillContainer(containerID))
+	info, err = rt.InspectContainer(containerID)
+	assert.NoError(err)
+	assert.True(info.State.OOMKilled)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	_, errs := rt.WatchEdgeAppEvents(ctx)
+	eventsErr := errors.New("connection reset")
+	rt.FailEvents(eventsErr)
+	assert.Equal(eventsErr, <-errs)
+}
+
+func TestHealth(t *testing.T) {
+	assert := assert.New(t)
+	rt := fake.NewRuntime()
+	rt.HealthOnStart("weevenetwork/ingress:v1", types.Unhealthy)
+	err := rt.PullImage(types.AuthConfig{}, "weevenetwork/ingress:v1")
+	assert.NoError(err)
+	networkName, err := rt.CreateNetwork("app", nil)
+	assert.NoError(err)
+
+	containerID, err := rt.CreateAndStartContainer(manifest.ContainerConfig{
+		ContainerName: "ingress",
+		ImageNameFull: "weevenetwork/ingress:v1",
+		NetworkName:   networkName,
+		Healthcheck:   &container.HealthConfig{Test: []string{"CMD", "true"}},
+	})
+	assert.NoError(err)
+	info, err := rt.InspectContainer(containerID)
+	assert.NoError(err)
+	assert.Equal(types.Unhealthy, info.State.Health.Status)
+
+	assert.NoError(rt.SetHealth(containerID, types.Healthy))
+	info, err = rt.InspectContainer(containerID)
+	assert.NoError(err)
+	assert.Equal(types.Healthy, info.State.Health.Status)
+}
+
+func TestVolumes(t *testing.T) {
+	assert := assert.New(t)
+	rt := fake.NewRuntime()
+	err := rt.PullImage(types.AuthConfig{}, "weevenetwork/ingress:v1")
+	assert.NoError(err)
+	networkName, err := rt.CreateNetwork("app", nil)
+	assert.NoError(err)
+
+	containerID, err := rt.CreateAndStartContainer(manifest.ContainerConfig{
+		ContainerName: "ingress",
+		ImageNameFull: "weevenetwork/ingress:v1",
+		NetworkName:   networkName,
+		MountConfigs: []mount.Mount{{
+			Type:          mount.TypeVolume,
+			Source:        "app_data",
+			VolumeOptions: &mount.VolumeOptions{Labels: map[string]string{"manifestUniqueID": uniqueID.String()}},
+		}},
+	})
+	assert.NoError(err)
+	assert.NoError(rt.SetVolumeSize("app_data", 1024))
+
+	volumes, err := rt.ReadEdgeAppVolumes(uniqueID)
+	assert.NoError(err)
+	if assert.Len(volumes, 1) {
+		assert.Equal(int64(1024), volumes[0].UsageData.Size)
+		assert.Equal(int64(1), volumes[0].UsageData.RefCount)
+	}
+
+	// volumes in use are kept, the others outlive their containers until they are removed
+	assert.Error(rt.RemoveEdgeAppVolumes(uniqueID))
+	assert.NoError(rt.StopAndRemoveContainer(containerID))
+	volumes, err = rt.ReadEdgeAppVolumes(uniqueID)
+	assert.NoError(err)
+	assert.Len(volumes, 1)
+	assert.NoError(rt.RemoveEdgeAppVolumes(uniqueID))
+	volumes, err = rt.ReadEdgeAppVolumes(uniqueID)
+	assert.NoError(err)
+	assert.Empty(volumes)
+}
+
+func TestLogs(t *testing.T) {
+	assert := assert.New(t)
+	rt := fake.NewRuntime()
+	containerID := createContainer(t, rt, "ingress", "weevenetwork/ingress:v1")
+
+	assert.NoError(rt.AppendLogs(containerID, "first"))
+	time.Sleep(time.Millisecond)
+	since := time.Now()
+	assert.NoError(rt.AppendErrLogs(containerID, "second"))
+
+	lines, err := rt.ReadContainerLogs(containerID, "", "")
+	assert.NoError(err)
+	if assert.Len(lines, 2) {
+		assert.Equal("first", lines[0].Line)
+		assert.Equal("stdout", lines[0].Stream)
+		assert.Equal("stderr", lines[1].Stream)
+	}
+	lines, err = rt.ReadContainerLogs(containerID, since.Format(time.RFC3339Nano), "")
+	assert.NoError(err)
+	if assert.Len(lines, 1) {
+		assert.Equal("second", lines[0].Line)
+	}
+
+	// following continues after the cursor and ends once the container stopped
+	followed, errs := rt.FollowContainerLogs(context.Background(), containerID, lines[0].Cursor)
+	assert.NoError(rt.AppendLogs(containerID, "third"))
+	assert.Equal("third", (<-followed).Line)
+	assert.NoError(rt.StopContainer(containerID))
+	_, open := <-followed
+	assert.False(open)
+	assert.Empty(errs)
+}
+
+func TestStats(t *testing.T) {
+	assert := assert.New(t)
+	rt := fake.NewRuntime()
+	containerID := createContainer(t, rt, "ingress", "weevenetwork/ingress:v1")
+
+	stats := types.StatsJSON{}
+	stats.MemoryStats.Usage = 1 << 20
+	assert.NoError(rt.SetStats(containerID, stats))
+
+	read, err := rt.ReadContainerStats(containerID)
+	assert.NoError(err)
+	assert.Equal(uint64(1<<20), read.MemoryStats.Usage)
+	assert.Equal("/ingress", read.Name)
+	assert.False(read.Read.IsZero())
+
+	// the status monitor counts on the listing and inspection of containers only
+	reads := rt.Reads()
+	_, err = rt.ReadAllContainers()
+	assert.NoError(err)
+	assert.Equal(reads+1, rt.Reads())
+}
+
+// the events of containers outside of edge apps are not reported
+func TestEventsOfEdgeAppsOnly(t *testing.T) {
+	rt := fake.NewRuntime()
+	ctx, cancel := context.WithCancel(context.Background())
+	messages, errs := rt.WatchEdgeAppEvents(ctx)
+
+	err := rt.PullImage(types.AuthConfig{}, "weevenetwork/ingress:v1")
+	assert.NoError(t, err)
+	networkName, err := rt.CreateNetwork("other", nil)
+	assert.NoError(t, err)
+	_, err = rt.CreateAndStartContainer(manifest.ContainerConfig{ContainerName: "other", ImageNameFull: "weevenetwork/ingress:v1", NetworkName: networkName})
+	assert.NoError(t, err)
+	assert.Empty(t, messages)
+
+	cancel()
+	assert.Equal(t, context.Canceled, <-errs)
+}
@@ -0,0 +1,42 @@
+package runtime
+
+import (
+	"time"
+
+	"github.com/weeveiot/weeve-agent/internal/model"
+)
+
+// LogPosition tracks the cursor of a stream of timestamped log lines. The engines return the lines from the time of
+// the cursor on, including the lines at that time that were sent before, so those are skipped.
+type LogPosition struct {
+	cursor model.LogCursor
+	seen   int // lines at the time of the cursor seen in this stream
+}
+
+func NewLogPosition(cursor model.LogCursor) *LogPosition {
+	return &LogPosition{cursor: cursor}
+}
+
+// Cursor is the position right after the last line
+func (p *LogPosition) Cursor() model.LogCursor {
+	return p.cursor
+}
+
+// Advance moves the position past a line with the timestamp. It returns the cursor right after the line and false if
+// the line was sent before.
+func (p *LogPosition) Advance(timestamp time.Time) (model.LogCursor, bool) {
+	switch {
+	case timestamp.Before(p.cursor.Time):
+		return p.cursor, false
+	case timestamp.Equal(p.cursor.Time):
+		p.seen++
+		if p.seen <= p.cursor.Skip {
+			return p.cursor, false
+		}
+		p.cursor.Skip = p.seen
+	default:
+		p.cursor = model.LogCursor{Time: timestamp, Skip: 1}
+		p.seen = 1
+	}
+	return p.cursor, true
+}
@@ -0,0 +1,75 @@
+package runtime
+
+import (
+	"context"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/events"
+	"github.com/docker/docker/api/types/volume"
+
+	"github.com/weeveiot/weeve-agent/internal/manifest"
+	"github.com/weeveiot/weeve-agent/internal/model"
+)
+
+// Runtime is the set of operations the agent needs from a container engine to run edge apps.
+// The Docker API types are used as the common data model, since the manifest is already expressed in them.
+type Runtime interface {
+	// Containers
+	CreateAndStartContainer(containerConfig manifest.ContainerConfig) (string, error)
+	StartContainer(containerID string) error
+	StopContainer(containerID string) error
+	StopAndRemoveContainer(containerID string) error
+	ReadAllContainers() ([]types.Container, error)
+	ReadEdgeAppContainers(manifestUniqueID model.ManifestUniqueID) ([]types.Container, error)
+	InspectContainer(containerID string) (types.ContainerJSON, error)
+
+	// Images
+	PullImage(authConfig types.AuthConfig, imageName string) error
+	ImageExists(imageName string) (bool, error)
+	ImageRemove(imageID string) error
+	GetImagesByName(images []string) ([]types.ImageSummary, error)
+
+	// Networks
+	CreateNetwork(name string, labels map[string]string) (string, error)
+	ReadEdgeAppNetworks(manifestUniqueID model.ManifestUniqueID) ([]types.NetworkResource, error)
+	ReadAllEdgeAppNetworks() ([]types.NetworkResource, error)
+	NetworkPrune(manifestUniqueID model.ManifestUniqueID) error
+
+	// Volumes
+	// ReadEdgeAppVolumes returns the named volumes of an edge app. The size is -1 if the engine can't tell.
+	ReadEdgeAppVolumes(manifestUniqueID model.ManifestUniqueID) ([]*volume.Volume, error)
+	RemoveEdgeAppVolumes(manifestUniqueID model.ManifestUniqueID) error
+
+	// Logs
+	// ReadContainerLogs returns the lines a container wrote between since and until (RFC 3339, both optional).
+	ReadContainerLogs(containerID string, since string, until string) ([]LogLine, error)
+	// FollowContainerLogs streams the lines a container writes after the cursor. The lines channel is closed once the
+	// container stopped and all its lines were sent, or ctx is cancelled. The error channel receives at most one error,
+	// which ended the stream early.
+	FollowContainerLogs(ctx context.Context, containerID string, cursor model.LogCursor) (<-chan LogLine, <-chan error)
+
+	// Stats
+	// ReadContainerStats returns a sample of the resource usage of a running container.
+	// The CPU usage is cumulative, so the CPU load is derived from two samples.
+	ReadContainerStats(containerID string) (types.StatsJSON, error)
+
+	// Events
+	// WatchEdgeAppEvents streams the events of all containers that belong to an edge app until ctx is cancelled.
+	// The container labels are passed in the actor attributes. The error channel receives at most one error, after which no more events are sent.
+	WatchEdgeAppEvents(ctx context.Context) (<-chan events.Message, <-chan error)
+}
+
+// LogLine is a line of the output of a container. The time is when the container engine received the line, or when
+// the agent read it if the engine doesn't timestamp the lines.
+type LogLine struct {
+	Time   time.Time
+	Stream string // Stdout or Stderr, empty if the engine doesn't tell them apart
+	Line   string
+	Cursor model.LogCursor // right after the line
+}
+
+const (
+	Stdout = "stdout"
+	Stderr = "stderr"
+)
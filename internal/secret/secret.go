@@ -127,6 +127,11 @@ func ProcessOrgPrivKeyMessage(payload []byte) error {
 		return traceutility.Wrap(err)
 	}
 
+	return SetOrgKey(orgSecretKey)
+}
+
+// SetOrgKey sets the org's AES key that the secrets are encrypted with
+func SetOrgKey(orgSecretKey []byte) error {
 	block, err := aes.NewCipher(orgSecretKey)
 	if err != nil {
 		return traceutility.Wrap(err)
@@ -143,6 +148,26 @@ func ProcessOrgPrivKeyMessage(payload []byte) error {
 	return nil
 }
 
+// HasOrgKey tells whether the org's key was set, the secrets can't be decrypted before
+func HasOrgKey() bool {
+	return decryptor != nil
+}
+
+// EncryptSecret encrypts a value with the org's key, the same way the secret env variables are encrypted
+func EncryptSecret(plaintext string) (string, error) {
+	if decryptor == nil {
+		return "", errors.New("don't have org's private key. cannot encrypt")
+	}
+	nonce := make([]byte, decryptor.NonceSize())
+	_, err := rand.Read(nonce)
+	if err != nil {
+		return "", traceutility.Wrap(err)
+	}
+
+	encBytes := decryptor.Seal(nonce, nonce, []byte(plaintext), nil)
+	return base64.StdEncoding.EncodeToString(encBytes), nil
+}
+
 func DecryptEnv(enc string) (string, error) {
 	if decryptor == nil {
 		return "", errors.New("don't have org's private key. cannot decrypt")
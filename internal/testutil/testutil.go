@@ -0,0 +1,68 @@
+// Package testutil sets up the agent for the tests of the packages that serve it, like the local API and the CLI.
+package testutil
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/weeveiot/weeve-agent/internal/edgeapp"
+	"github.com/weeveiot/weeve-agent/internal/manifest"
+	"github.com/weeveiot/weeve-agent/internal/runtime/fake"
+)
+
+// TestdataDir is the absolute path of the testdata directory, set by Main
+var TestdataDir string
+
+// Main runs the tests of the package in a temporary working directory, as the known manifests are persisted in the
+// working directory, and exits with their result. setup is called in that directory before the tests run.
+func Main(m *testing.M, setup func(workDir string)) {
+	var err error
+	TestdataDir, err = filepath.Abs("../../testdata")
+	if err != nil {
+		panic(err)
+	}
+
+	workDir, err := os.MkdirTemp("", "weeve-agent-test")
+	if err != nil {
+		panic(err)
+	}
+	err = os.Chdir(workDir)
+	if err != nil {
+		panic(err)
+	}
+	if setup != nil {
+		setup(workDir)
+	}
+
+	code := m.Run()
+	os.RemoveAll(workDir)
+	os.Exit(code)
+}
+
+// Deploy deploys the test manifest on a fresh fake runtime and removes it after the test
+func Deploy(t *testing.T) (manifest.Manifest, *fake.Runtime) {
+	rt := fake.NewRuntime()
+	edgeapp.SetRuntime(rt)
+
+	payload, err := os.ReadFile(filepath.Join(TestdataDir, "test_manifest.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	man, err := manifest.Parse(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = edgeapp.DeployEdgeApp(man)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if manifest.GetKnownManifest(man.UniqueID) != nil {
+			edgeapp.RemoveEdgeApp(man.UniqueID, nil)
+		}
+	})
+
+	return man, rt
+}
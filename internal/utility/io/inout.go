@@ -23,3 +23,41 @@ func FirstToUpper(str string) string {
 	}
 	return strings.ToUpper(string(str[0])) + str[1:]
 }
+
+// WriteFileAtomic writes the data to a temporary file next to path, syncs it and renames it to path, so that a crash
+// or a power cut leaves either the old or the new file behind, never a truncated one
+func WriteFileAtomic(path string, data []byte, perm os.FileMode) error {
+	tmpFile, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpFile.Name())
+
+	_, err = tmpFile.Write(data)
+	if err == nil {
+		err = tmpFile.Chmod(perm)
+	}
+	if err == nil {
+		err = tmpFile.Sync()
+	}
+	closeErr := tmpFile.Close()
+	if err != nil {
+		return err
+	}
+	if closeErr != nil {
+		return closeErr
+	}
+
+	err = os.Rename(tmpFile.Name(), path)
+	if err != nil {
+		return err
+	}
+
+	// the rename only survives a power cut once the directory is synced
+	dir, err := os.Open(filepath.Dir(path))
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+	return dir.Sync()
+}
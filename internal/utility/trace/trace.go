@@ -1,6 +1,7 @@
 package traceutility
 
 import (
+	"errors"
 	"fmt"
 	"runtime"
 )
@@ -13,3 +14,14 @@ func Wrap(err error) error {
 
 	return fmt.Errorf("%w\n%s", err, contextStr)
 }
+
+// Cause returns the innermost error, without the contexts that were added to it on the way up
+func Cause(err error) error {
+	for {
+		wrapped := errors.Unwrap(err)
+		if wrapped == nil {
+			return err
+		}
+		err = wrapped
+	}
+}